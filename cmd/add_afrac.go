@@ -2,16 +2,18 @@ package main
 
 import (
 	"github.com/jgbaldwinbrown/spstat/pkg"
-	"os"
 	"flag"
 	"fmt"
 )
 
 func main() {
-	inpp := flag.String("i", "", "input .gz file")
+	inpp := flag.String("i", "", "input table, plain or compressed, a comma-separated list or glob of shards, a table from compile, or - for stdin")
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
 	hitscolp := flag.String("h", "", "name of column containing hits")
 	countcolp := flag.String("c", "_", "name of column containing total count of hits and alt hits")
+	rowflags := spstat.AddRowFlags()
 	flag.Parse()
+	defer rowflags.Exit()
 	if *inpp == "" {
 		panic(fmt.Errorf("missing -i"))
 	}
@@ -22,6 +24,20 @@ func main() {
 		panic(fmt.Errorf("missing -c"))
 	}
 
-	e := spstat.RunAddAfrac(*inpp, os.Stdout, *hitscolp, *countcolp)
+	rcm := spstat.InputReadCloserMaker(*inpp)
+
+	w, e := spstat.OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	ro, e := rowflags.Start()
+	if e != nil { panic(e) }
+
+	e = rowflags.Validate(rcm)
+	if e != nil { panic(e) }
+
+	e = spstat.RunAddAfrac(rcm, w, *hitscolp, *countcolp, ro)
+	if e != nil { panic(e) }
+
+	e = w.Close()
 	if e != nil { panic(e) }
 }
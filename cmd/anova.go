@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/jgbaldwinbrown/spstat/pkg"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+func main() {
+	inpp := flag.String("i", "", "input table, plain or compressed, a comma-separated list or glob of shards, a table from compile, or - for stdin")
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
+	regionp := flag.String("region", "", "only read rows in these regions, like chr1:1000-2000, separated by semicolons")
+	chromcolp := flag.String("chromcol", spstat.DefaultChromCol, "chromosome column name for -region")
+	poscolp := flag.String("poscol", spstat.DefaultPosCol, "position column name for -region")
+	valcolp := flag.String("v", "", "value column name")
+	groupp := flag.String("group", "", "comma-separated columns to compare all of the levels of, one at a time, like tissue,indiv")
+	welchp := flag.Bool("welch", false, "also run Welch's ANOVA, which does not assume equal variances")
+	summaryp := flag.String("summary", "", "run the tests on this file from summarize or merge instead of reading -i")
+	rowflags := spstat.AddRowFlags()
+	flag.Parse()
+	defer rowflags.Exit()
+
+	if *summaryp != "" {
+		s, e := spstat.ReadSummaryPath(*summaryp)
+		if e != nil { panic(e) }
+
+		w, e := spstat.OutputWriteCloserMaker(*outp).NewWriteCloser()
+		if e != nil { panic(e) }
+
+		e = spstat.AnovaSummary(w, s, *welchp)
+		if e != nil { panic(e) }
+
+		e = w.Close()
+		if e != nil { panic(e) }
+		return
+	}
+	if *inpp == "" {
+		panic(fmt.Errorf("missing -i"))
+	}
+	if *valcolp == "" {
+		panic(fmt.Errorf("missing -v"))
+	}
+	if *groupp == "" {
+		panic(fmt.Errorf("missing -group"))
+	}
+
+	rcm, e := spstat.InputRegionReadCloserMaker(*inpp, *regionp, *chromcolp, *poscolp)
+	if e != nil { panic(e) }
+
+	w, e := spstat.OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	ro, e := rowflags.Start()
+	if e != nil { panic(e) }
+
+	e = rowflags.Validate(rcm)
+	if e != nil { panic(e) }
+
+	e = spstat.RunAnova(rcm, w, *valcolp, strings.Split(*groupp, ","), *welchp, ro)
+	if e != nil { panic(e) }
+
+	e = w.Close()
+	if e != nil { panic(e) }
+}
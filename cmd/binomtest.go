@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/jgbaldwinbrown/spstat/pkg"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+func main() {
+	inpp := flag.String("i", "", "input table, plain or compressed, a comma-separated list or glob of shards, a table from compile, or - for stdin")
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
+	regionp := flag.String("region", "", "only read rows in these regions, like chr1:1000-2000, separated by semicolons")
+	chromcolp := flag.String("chromcol", spstat.DefaultChromCol, "chromosome column name for -region")
+	poscolp := flag.String("poscol", spstat.DefaultPosCol, "position column name for -region")
+	hitscolp := flag.String("h", "", "name of column containing hits")
+	countcolp := flag.String("c", "", "name of column containing total count of hits and alt hits")
+	expectedp := flag.String("expected", "", "column of expected fractions, like the one append_expectation writes")
+	pp := flag.Float64("p", 0.5, "expected fraction without -expected")
+	bloodcolp := flag.String("bloodcol", "", "name of column marking control rows, to estimate overdispersion from for the beta-binomial test")
+	controlp := flag.String("control", "blood", "value in -bloodcol of the control rows")
+	groupp := flag.String("group", "", "comma-separated columns to test each group of, like indiv,chrom")
+	confp := flag.Float64("conf", 0.95, "confidence level of the interval for the fraction of hits")
+	rowflags := spstat.AddRowFlags()
+	flag.Parse()
+	defer rowflags.Exit()
+
+	if *inpp == "" {
+		panic(fmt.Errorf("missing -i"))
+	}
+	if *hitscolp == "" {
+		panic(fmt.Errorf("missing -h"))
+	}
+	if *countcolp == "" {
+		panic(fmt.Errorf("missing -c"))
+	}
+
+	var groups []string
+	if *groupp != "" {
+		groups = strings.Split(*groupp, ",")
+	}
+
+	rcm, e := spstat.InputRegionReadCloserMaker(*inpp, *regionp, *chromcolp, *poscolp)
+	if e != nil { panic(e) }
+
+	w, e := spstat.OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	ro, e := rowflags.Start()
+	if e != nil { panic(e) }
+
+	e = rowflags.Validate(rcm)
+	if e != nil { panic(e) }
+
+	e = spstat.RunBinomialTest(rcm, w, *hitscolp, *countcolp, *expectedp, *pp, *bloodcolp, *controlp, groups, *confp, ro)
+	if e != nil { panic(e) }
+
+	e = w.Close()
+	if e != nil { panic(e) }
+}
@@ -2,16 +2,18 @@ package main
 
 import (
 	"github.com/jgbaldwinbrown/spstat/pkg"
-	"os"
 	"flag"
 	"fmt"
 )
 
 func main() {
-	inpp := flag.String("i", "", "input .gz file")
+	inpp := flag.String("i", "", "input table, plain or compressed, a comma-separated list or glob of shards, a table from compile, or - for stdin")
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
 	valcolp := flag.String("v", "", "value column name")
 	tosubcolp := flag.String("s", "", "column to subtract")
+	rowflags := spstat.AddRowFlags()
 	flag.Parse()
+	defer rowflags.Exit()
 	if *inpp == "" {
 		panic(fmt.Errorf("missing -i"))
 	}
@@ -22,6 +24,20 @@ func main() {
 		panic(fmt.Errorf("missing -s"))
 	}
 
-	e := spstat.RunColSub(*inpp, os.Stdout, *valcolp, *tosubcolp)
+	rcm := spstat.InputReadCloserMaker(*inpp)
+
+	w, e := spstat.OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	ro, e := rowflags.Start()
+	if e != nil { panic(e) }
+
+	e = rowflags.Validate(rcm)
+	if e != nil { panic(e) }
+
+	e = spstat.RunColSub(rcm, w, *valcolp, *tosubcolp, ro)
+	if e != nil { panic(e) }
+
+	e = w.Close()
 	if e != nil { panic(e) }
 }
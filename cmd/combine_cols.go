@@ -3,16 +3,18 @@ package main
 import (
 	"strings"
 	"github.com/jgbaldwinbrown/spstat/pkg"
-	"os"
 	"flag"
 	"fmt"
 )
 
 func main() {
-	inpp := flag.String("i", "", "input .gz file")
+	inpp := flag.String("i", "", "input table, plain or compressed, a comma-separated list or glob of shards, a table from compile, or - for stdin")
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
 	colsp := flag.String("c", "", "comma-separated columns to combine")
 	sepp := flag.String("s", "_", "string to use to separate column values / names")
+	rowflags := spstat.AddRowFlags()
 	flag.Parse()
+	defer rowflags.Exit()
 	if *inpp == "" {
 		panic(fmt.Errorf("missing -i"))
 	}
@@ -25,6 +27,20 @@ func main() {
 
 	cols := strings.Split(*colsp, ",")
 
-	e := spstat.RunColCombine(*inpp, os.Stdout, cols, *sepp)
+	rcm := spstat.InputReadCloserMaker(*inpp)
+
+	w, e := spstat.OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	ro, e := rowflags.Start()
+	if e != nil { panic(e) }
+
+	e = rowflags.Validate(rcm)
+	if e != nil { panic(e) }
+
+	e = spstat.RunColCombine(rcm, w, cols, *sepp, ro)
+	if e != nil { panic(e) }
+
+	e = w.Close()
 	if e != nil { panic(e) }
 }
@@ -0,0 +1,9 @@
+package main
+
+import (
+	"github.com/jgbaldwinbrown/spstat/pkg"
+)
+
+func main() {
+	spstat.RunCompile()
+}
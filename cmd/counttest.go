@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/jgbaldwinbrown/spstat/pkg"
+	"flag"
+	"fmt"
+)
+
+func main() {
+	inpp := flag.String("i", "", "input table, plain or compressed, a comma-separated list or glob of shards, a table from compile, or - for stdin")
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
+	regionp := flag.String("region", "", "only read rows in these regions, like chr1:1000-2000, separated by semicolons")
+	chromcolp := flag.String("chromcol", spstat.DefaultChromCol, "chromosome column name for -region")
+	poscolp := flag.String("poscol", spstat.DefaultPosCol, "position column name for -region")
+	hitscolp := flag.String("h", "", "name of column containing hits")
+	countcolp := flag.String("c", "", "name of column containing total count of hits and alt hits")
+	bloodcolp := flag.String("bloodcol", "", "name of column listing control samples as \"blood\"")
+	testcolp := flag.String("testcol", "", "column to use for all test")
+	fisherp := flag.Float64("fisher-below", spstat.DefaultFisherBelow, "run Fisher's exact test on tables whose smallest expected count is below this")
+	rowflags := spstat.AddRowFlags()
+	flag.Parse()
+	defer rowflags.Exit()
+
+	if *inpp == "" {
+		panic(fmt.Errorf("missing -i"))
+	}
+	if *hitscolp == "" {
+		panic(fmt.Errorf("missing -h"))
+	}
+	if *countcolp == "" {
+		panic(fmt.Errorf("missing -c"))
+	}
+	if *bloodcolp == "" {
+		panic(fmt.Errorf("missing -bloodcol"))
+	}
+	if *testcolp == "" {
+		panic(fmt.Errorf("missing -testcol"))
+	}
+
+	rcm, e := spstat.InputRegionReadCloserMaker(*inpp, *regionp, *chromcolp, *poscolp)
+	if e != nil { panic(e) }
+
+	w, e := spstat.OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	ro, e := rowflags.Start()
+	if e != nil { panic(e) }
+
+	e = rowflags.Validate(rcm)
+	if e != nil { panic(e) }
+
+	e = spstat.RunFullCountTest(rcm, w, *hitscolp, *countcolp, *bloodcolp, *testcolp, *fisherp, ro)
+	if e != nil { panic(e) }
+
+	e = w.Close()
+	if e != nil { panic(e) }
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/jgbaldwinbrown/spstat/pkg"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+func main() {
+	inpp := flag.String("i", "", "input table, plain or compressed, a comma-separated list or glob of shards, a table from compile, or - for stdin")
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
+	regionp := flag.String("region", "", "only read rows in these regions, like chr1:1000-2000, separated by semicolons")
+	chromcolp := flag.String("chromcol", spstat.DefaultChromCol, "chromosome column name for -region")
+	poscolp := flag.String("poscol", spstat.DefaultPosCol, "position column name for -region")
+	valcolp := flag.String("v", "", "value column name")
+	factorsp := flag.String("factors", "", "comma-separated factor columns, like tissue,chrom")
+	typep := flag.Int("type", spstat.FactorialTypeII, "2 for Type II sums of squares, or 3 for Type III")
+	rowflags := spstat.AddRowFlags()
+	flag.Parse()
+	defer rowflags.Exit()
+
+	if *inpp == "" {
+		panic(fmt.Errorf("missing -i"))
+	}
+	if *valcolp == "" {
+		panic(fmt.Errorf("missing -v"))
+	}
+	if *factorsp == "" {
+		panic(fmt.Errorf("missing -factors"))
+	}
+
+	rcm, e := spstat.InputRegionReadCloserMaker(*inpp, *regionp, *chromcolp, *poscolp)
+	if e != nil { panic(e) }
+
+	w, e := spstat.OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	ro, e := rowflags.Start()
+	if e != nil { panic(e) }
+
+	e = rowflags.Validate(rcm)
+	if e != nil { panic(e) }
+
+	e = spstat.RunFactorialAnova(rcm, w, *valcolp, strings.Split(*factorsp, ","), *typep, ro)
+	if e != nil { panic(e) }
+
+	e = w.Close()
+	if e != nil { panic(e) }
+}
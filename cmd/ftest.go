@@ -2,17 +2,46 @@ package main
 
 import (
 	"github.com/jgbaldwinbrown/spstat/pkg"
-	"os"
 	"flag"
 	"fmt"
 )
 
 func main() {
-	inpp := flag.String("i", "", "input .gz file")
+	inpp := flag.String("i", "", "input table, plain or compressed, a comma-separated list or glob of shards, a table from compile, or - for stdin")
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
+	regionp := flag.String("region", "", "only read rows in these regions, like chr1:1000-2000, separated by semicolons")
+	chromcolp := flag.String("chromcol", spstat.DefaultChromCol, "chromosome column name for -region")
+	poscolp := flag.String("poscol", spstat.DefaultPosCol, "position column name for -region")
 	valcolp := flag.String("v", "", "value column name")
 	bloodcolp := flag.String("bloodcol", "", "name of column listing control samples as \"blood\"")
 	testcolp := flag.String("testcol", "", "column to use for all test")
+	summaryp := flag.String("summary", "", "run the tests on this file from summarize or merge instead of reading -i")
+	methodp := flag.String("method", spstat.FTestVariance, "f for the ratio of variances, levene for Levene's test, or brown-forsythe for Levene's test around medians")
+	rowflags := spstat.AddRowFlags()
 	flag.Parse()
+	defer rowflags.Exit()
+
+	e := spstat.CheckFTestMethod(*methodp)
+	if e != nil { panic(e) }
+
+	if *summaryp != "" {
+		if *methodp != spstat.FTestVariance {
+			panic(fmt.Errorf("-method %v needs the rows of -i, not a summary", *methodp))
+		}
+
+		s, e := spstat.ReadSummaryPath(*summaryp)
+		if e != nil { panic(e) }
+
+		w, e := spstat.OutputWriteCloserMaker(*outp).NewWriteCloser()
+		if e != nil { panic(e) }
+
+		e = spstat.FTestSummary(w, s)
+		if e != nil { panic(e) }
+
+		e = w.Close()
+		if e != nil { panic(e) }
+		return
+	}
 	if *inpp == "" {
 		panic(fmt.Errorf("missing -i"))
 	}
@@ -26,6 +55,21 @@ func main() {
 		panic(fmt.Errorf("missing -testcol"))
 	}
 
-	e := spstat.RunFullFTest(*inpp, os.Stdout, *valcolp, *bloodcolp, *testcolp)
+	rcm, e := spstat.InputRegionReadCloserMaker(*inpp, *regionp, *chromcolp, *poscolp)
+	if e != nil { panic(e) }
+
+	w, e := spstat.OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	ro, e := rowflags.Start()
+	if e != nil { panic(e) }
+
+	e = rowflags.Validate(rcm)
+	if e != nil { panic(e) }
+
+	e = spstat.RunFullFTest(rcm, w, *valcolp, *bloodcolp, *testcolp, *methodp, ro)
+	if e != nil { panic(e) }
+
+	e = w.Close()
 	if e != nil { panic(e) }
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/jgbaldwinbrown/spstat/pkg"
+	"flag"
+	"fmt"
+	"math"
+	"strings"
+)
+
+func main() {
+	inpp := flag.String("i", "", "input table, plain or compressed, a comma-separated list or glob of shards, a table from compile, or - for stdin")
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
+	regionp := flag.String("region", "", "only read rows in these regions, like chr1:1000-2000, separated by semicolons")
+	chromcolp := flag.String("chromcol", spstat.DefaultChromCol, "chromosome column name for -region")
+	poscolp := flag.String("poscol", spstat.DefaultPosCol, "position column name for -region")
+	valcolp := flag.String("v", "", "value column name")
+	groupp := flag.String("group", "", "comma-separated id columns to give a histogram for each level of, like tissue,indiv")
+	binsp := flag.Int("bins", spstat.DefaultHistogramBins, "number of bins")
+	minp := flag.Float64("min", math.NaN(), "start of the first bin, or NaN for the smallest value, found in a first pass")
+	maxp := flag.Float64("max", math.NaN(), "end of the last bin, or NaN for the largest value, found in a first pass")
+	kdep := flag.Bool("kde", false, "add a column with a Gaussian kernel density estimate at the middle of each bin")
+	bwp := flag.Float64("bw", 0, "bandwidth of the kernel density estimate, or 0 for Silverman's rule of thumb in each group")
+	rowflags := spstat.AddRowFlags()
+	flag.Parse()
+	defer rowflags.Exit()
+
+	if *inpp == "" {
+		panic(fmt.Errorf("missing -i"))
+	}
+	if *valcolp == "" {
+		panic(fmt.Errorf("missing -v"))
+	}
+	if *groupp == "" {
+		panic(fmt.Errorf("missing -group"))
+	}
+
+	rcm, e := spstat.InputRegionReadCloserMaker(*inpp, *regionp, *chromcolp, *poscolp)
+	if e != nil { panic(e) }
+
+	w, e := spstat.OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	ro, e := rowflags.Start()
+	if e != nil { panic(e) }
+
+	e = rowflags.Validate(rcm)
+	if e != nil { panic(e) }
+
+	e = spstat.RunHistograms(rcm, w, *valcolp, strings.Split(*groupp, ","), *minp, *maxp, *binsp, *kdep, *bwp, ro)
+	if e != nil { panic(e) }
+
+	e = w.Close()
+	if e != nil { panic(e) }
+}
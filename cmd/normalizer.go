@@ -3,16 +3,21 @@ package main
 import (
 	"github.com/jgbaldwinbrown/spstat/pkg"
 	"strings"
-	"os"
 	"flag"
 	"fmt"
 )
 
 func main() {
-	inpp := flag.String("i", "", "input .gz file")
+	inpp := flag.String("i", "", "input table, plain or compressed, a comma-separated list or glob of shards, a table from compile, or - for stdin")
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
+	regionp := flag.String("region", "", "only read rows in these regions, like chr1:1000-2000, separated by semicolons")
+	chromcolp := flag.String("chromcol", spstat.DefaultChromCol, "chromosome column name for -region")
+	poscolp := flag.String("poscol", spstat.DefaultPosCol, "position column name for -region")
 	valcolp := flag.String("v", "", "value column name")
 	idcolsp := flag.String("id", "", "id column names, comma-separated")
+	rowflags := spstat.AddRowFlags()
 	flag.Parse()
+	defer rowflags.Exit()
 	if *inpp == "" {
 		panic(fmt.Errorf("missing -i"))
 	}
@@ -28,6 +33,21 @@ func main() {
 		panic(fmt.Errorf("could not parse -id %v", *idcolsp))
 	}
 
-	e := spstat.Run(*inpp, os.Stdout, *valcolp, idcols)
+	rcm, e := spstat.InputRegionReadCloserMaker(*inpp, *regionp, *chromcolp, *poscolp)
+	if e != nil { panic(e) }
+
+	w, e := spstat.OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	ro, e := rowflags.Start()
+	if e != nil { panic(e) }
+
+	e = rowflags.Validate(rcm)
+	if e != nil { panic(e) }
+
+	e = spstat.Run(rcm, w, *valcolp, idcols, ro)
+	if e != nil { panic(e) }
+
+	e = w.Close()
 	if e != nil { panic(e) }
 }
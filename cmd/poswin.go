@@ -2,16 +2,18 @@ package main
 
 import (
 	"github.com/jgbaldwinbrown/spstat/pkg"
-	"os"
 	"flag"
 	"fmt"
 )
 
 func main() {
-	inpp := flag.String("i", "", "input .gz file")
+	inpp := flag.String("i", "", "input table, plain or compressed, a comma-separated list or glob of shards, a table from compile, or - for stdin")
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
 	colp := flag.String("c", "", "column to window")
 	winsizep := flag.Int("w", 1, "Size of tiled windows to generate")
+	rowflags := spstat.AddRowFlags()
 	flag.Parse()
+	defer rowflags.Exit()
 	if *inpp == "" {
 		panic(fmt.Errorf("missing -i"))
 	}
@@ -19,6 +21,20 @@ func main() {
 		panic(fmt.Errorf("missing -c"))
 	}
 
-	e := spstat.RunPosWin(*inpp, os.Stdout, *colp, *winsizep)
+	rcm := spstat.InputReadCloserMaker(*inpp)
+
+	w, e := spstat.OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	ro, e := rowflags.Start()
+	if e != nil { panic(e) }
+
+	e = rowflags.Validate(rcm)
+	if e != nil { panic(e) }
+
+	e = spstat.RunPosWin(rcm, w, *colp, *winsizep, ro)
+	if e != nil { panic(e) }
+
+	e = w.Close()
 	if e != nil { panic(e) }
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/jgbaldwinbrown/spstat/pkg"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+func main() {
+	inpp := flag.String("i", "", "input table, plain or compressed, a comma-separated list or glob of shards, a table from compile, or - for stdin")
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
+	regionp := flag.String("region", "", "only read rows in these regions, like chr1:1000-2000, separated by semicolons")
+	chromcolp := flag.String("chromcol", spstat.DefaultChromCol, "chromosome column name for -region")
+	poscolp := flag.String("poscol", spstat.DefaultPosCol, "position column name for -region")
+	valcolp := flag.String("v", "", "value column name")
+	groupp := flag.String("group", "", "comma-separated id columns to give quantiles for each level of, like tissue,indiv_chrom_tissue")
+	qp := flag.String("q", spstat.DefaultQuantiles, "comma-separated quantiles to print, between 0 and 1")
+	errp := flag.Float64("error", spstat.DefaultQuantileError, "largest rank error allowed with 99% confidence, as a fraction of each group's count; smaller uses more memory")
+	rowflags := spstat.AddRowFlags()
+	flag.Parse()
+	defer rowflags.Exit()
+
+	if *inpp == "" {
+		panic(fmt.Errorf("missing -i"))
+	}
+	if *valcolp == "" {
+		panic(fmt.Errorf("missing -v"))
+	}
+	if *groupp == "" {
+		panic(fmt.Errorf("missing -group"))
+	}
+
+	qs, e := spstat.ParseQuantiles(*qp)
+	if e != nil { panic(e) }
+
+	rcm, e := spstat.InputRegionReadCloserMaker(*inpp, *regionp, *chromcolp, *poscolp)
+	if e != nil { panic(e) }
+
+	w, e := spstat.OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	ro, e := rowflags.Start()
+	if e != nil { panic(e) }
+
+	e = rowflags.Validate(rcm)
+	if e != nil { panic(e) }
+
+	e = spstat.RunQuantiles(rcm, w, *valcolp, strings.Split(*groupp, ","), qs, *errp, ro)
+	if e != nil { panic(e) }
+
+	e = w.Close()
+	if e != nil { panic(e) }
+}
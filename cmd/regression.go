@@ -2,16 +2,26 @@ package main
 
 import (
 	"github.com/jgbaldwinbrown/spstat/pkg"
-	"os"
 	"flag"
 	"fmt"
 )
 
 func main() {
-	inpp := flag.String("i", "", "input .gz file")
+	inpp := flag.String("i", "", "input table, plain or compressed, a comma-separated list or glob of shards, a table from compile, or - for stdin")
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
+	regionp := flag.String("region", "", "only read rows in these regions, like chr1:1000-2000, separated by semicolons")
+	chromcolp := flag.String("chromcol", spstat.DefaultChromCol, "chromosome column name for -region")
+	poscolp := flag.String("poscol", spstat.DefaultPosCol, "position column name for -region")
 	valcolp := flag.String("v", "", "value column name")
 	indepcolp := flag.String("indep", "", "independent predictor column name")
+	summaryp := flag.String("summary", "", "use the linear model in this file from summarize or merge; without -i, just write its coefficients")
+	rowflags := spstat.AddRowFlags()
 	flag.Parse()
+	defer rowflags.Exit()
+	if *summaryp != "" {
+		runSummary(*summaryp, *inpp, *outp, *regionp, *chromcolp, *poscolp, rowflags)
+		return
+	}
 	if *inpp == "" {
 		panic(fmt.Errorf("missing -i"))
 	}
@@ -22,6 +32,53 @@ func main() {
 		panic(fmt.Errorf("missing -indep"))
 	}
 
-	e := spstat.RunLinearModel(*inpp, os.Stdout, *valcolp, *indepcolp)
+	rcm, e := spstat.InputRegionReadCloserMaker(*inpp, *regionp, *chromcolp, *poscolp)
+	if e != nil { panic(e) }
+
+	w, e := spstat.OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	ro, e := rowflags.Start()
+	if e != nil { panic(e) }
+
+	e = rowflags.Validate(rcm)
+	if e != nil { panic(e) }
+
+	e = spstat.RunLinearModel(rcm, w, *valcolp, *indepcolp, ro)
+	if e != nil { panic(e) }
+
+	e = w.Close()
+	if e != nil { panic(e) }
+}
+
+func runSummary(summaryp, inpp, outp, regionp, chromcolp, poscolp string, rowflags *spstat.RowFlags) {
+	s, e := spstat.ReadSummaryPath(summaryp)
+	if e != nil { panic(e) }
+
+	w, e := spstat.OutputWriteCloserMaker(outp).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	if inpp == "" {
+		e = spstat.LinearModelSummary(w, s)
+		if e != nil { panic(e) }
+
+		e = w.Close()
+		if e != nil { panic(e) }
+		return
+	}
+
+	rcm, e := spstat.InputRegionReadCloserMaker(inpp, regionp, chromcolp, poscolp)
+	if e != nil { panic(e) }
+
+	ro, e := rowflags.Start()
+	if e != nil { panic(e) }
+
+	e = rowflags.Validate(rcm)
+	if e != nil { panic(e) }
+
+	e = spstat.RunLinearModelFromSummary(rcm, w, s, ro)
+	if e != nil { panic(e) }
+
+	e = w.Close()
 	if e != nil { panic(e) }
 }
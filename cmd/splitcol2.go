@@ -1,20 +1,33 @@
 package main
 
 import (
+	"github.com/jgbaldwinbrown/spstat/pkg"
 	"encoding/csv"
+	"flag"
 	"os"
 	"io"
 	"strings"
 )
 
 func main() {
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
+	flag.Parse()
+
+	w, e := spstat.OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+	defer func() {
+		if e := w.Close(); e != nil {
+			panic(e)
+		}
+	}()
+
 	cr := csv.NewReader(os.Stdin)
 	cr.Comma = rune('\t')
 	cr.LazyQuotes = true
 	cr.FieldsPerRecord = -1
 	cr.ReuseRecord = true
 
-	cw := csv.NewWriter(os.Stdout)
+	cw := csv.NewWriter(w)
 	cw.Comma = rune('\t')
 	defer cw.Flush()
 
@@ -2,30 +2,98 @@ package main
 
 import (
 	"github.com/jgbaldwinbrown/spstat/pkg"
-	"os"
 	"flag"
 	"fmt"
+	"strings"
 )
 
 func main() {
-	inpp := flag.String("i", "", "input .gz file")
+	inpp := flag.String("i", "", "input table, plain or compressed, a comma-separated list or glob of shards, a table from compile, or - for stdin")
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
+	regionp := flag.String("region", "", "only read rows in these regions, like chr1:1000-2000, separated by semicolons")
+	chromcolp := flag.String("chromcol", spstat.DefaultChromCol, "chromosome column name for -region")
+	poscolp := flag.String("poscol", spstat.DefaultPosCol, "position column name for -region")
 	valcolp := flag.String("v", "", "value column name")
 	bloodcolp := flag.String("bloodcol", "", "name of column listing control samples as \"blood\"")
 	testcolp := flag.String("testcol", "", "column to use for all test")
+	summaryp := flag.String("summary", "", "run the tests on this file from summarize or merge instead of reading -i")
+	methodp := flag.String("method", spstat.TTestWelch, "welch, student to pool the variances of the two groups, paired to pair blood and -exp rows by -key, or one-sample to compare values with -expected or -mu")
+	altp := flag.String("alternative", spstat.TwoSided, "two-sided, or less or greater for a one-sided test of the control mean minus the test mean")
+	confp := flag.Float64("conf", 0.95, "confidence level of the interval for the difference in means")
+	keyp := flag.String("key", "", "comma-separated columns that pair rows for -method paired, like indiv,pos")
+	groupp := flag.String("group", "", "comma-separated columns to run a paired or one-sample test for each group of, like indiv,chrom")
+	expp := flag.String("exp", "sperm", "tissue in -bloodcol to pair with blood for -method paired")
+	maxpendingp := flag.Int("max-pending", spstat.DefaultPairOptions().MaxPending, "unpaired keys to hold in memory before -method paired spills to temporary files")
+	expectedp := flag.String("expected", "", "column of expected values, like the one append_expectation writes, for -method one-sample")
+	mup := flag.Float64("mu", 0, "expected mean for -method one-sample without -expected")
+	rowflags := spstat.AddRowFlags()
 	flag.Parse()
+	defer rowflags.Exit()
+
+	opts, e := spstat.NewTTestOptions(*methodp, *altp, *confp)
+	if e != nil { panic(e) }
+
+	if *summaryp != "" {
+		s, e := spstat.ReadSummaryPath(*summaryp)
+		if e != nil { panic(e) }
+
+		w, e := spstat.OutputWriteCloserMaker(*outp).NewWriteCloser()
+		if e != nil { panic(e) }
+
+		e = spstat.TTestSummary(w, s, opts)
+		if e != nil { panic(e) }
+
+		e = w.Close()
+		if e != nil { panic(e) }
+		return
+	}
 	if *inpp == "" {
 		panic(fmt.Errorf("missing -i"))
 	}
 	if *valcolp == "" {
 		panic(fmt.Errorf("missing -v"))
 	}
-	if *bloodcolp == "" {
+	onesample := opts.Method == spstat.TTestOneSample
+	if !onesample && *bloodcolp == "" {
 		panic(fmt.Errorf("missing -bloodcol"))
 	}
-	if *testcolp == "" {
+	paired := opts.Method == spstat.TTestPaired
+	if paired && *keyp == "" {
+		panic(fmt.Errorf("missing -key"))
+	}
+	if !paired && !onesample && *testcolp == "" {
 		panic(fmt.Errorf("missing -testcol"))
 	}
 
-	e := spstat.RunFullTTest(*inpp, os.Stdout, *valcolp, *bloodcolp, *testcolp)
+	rcm, e := spstat.InputRegionReadCloserMaker(*inpp, *regionp, *chromcolp, *poscolp)
+	if e != nil { panic(e) }
+
+	w, e := spstat.OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	ro, e := rowflags.Start()
+	if e != nil { panic(e) }
+
+	e = rowflags.Validate(rcm)
+	if e != nil { panic(e) }
+
+	if paired {
+		ro.Pairing.MaxPending = *maxpendingp
+		e = spstat.RunPairedTTest(rcm, w, *valcolp, *bloodcolp, "blood", *expp, splitCols(*keyp), splitCols(*groupp), opts, ro)
+	} else if onesample {
+		e = spstat.RunOneSampleTTest(rcm, w, *valcolp, *expectedp, *mup, splitCols(*groupp), opts, ro)
+	} else {
+		e = spstat.RunFullTTest(rcm, w, *valcolp, *bloodcolp, *testcolp, opts, ro)
+	}
+	if e != nil { panic(e) }
+
+	e = w.Close()
 	if e != nil { panic(e) }
 }
+
+func splitCols(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
@@ -4,6 +4,8 @@ go 1.18
 
 require (
 	github.com/jgbaldwinbrown/csvh v0.1.5
+	github.com/klauspost/compress v1.16.7
+	github.com/ulikunitz/xz v0.5.12
 	gonum.org/v1/gonum v0.12.0
 )
 
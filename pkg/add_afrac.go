@@ -1,7 +1,6 @@
 package spstat
 
 import (
-	"strconv"
 	"fmt"
 	"io"
 	"encoding/csv"
@@ -10,15 +9,15 @@ import (
 
 // Given a set of strings representing columns in one row, calculate hits and
 // counts from the hit column and count column, then return hits / counts
-func AddAfracOne(line []string, hitcol, countcol int) (float64, error) {
+func AddAfracOne(line []string, hitcol, countcol int, values *ValueParser) (float64, error) {
 	h := handle("CombineOne: %w")
 
-	if len(line) <= hitcol { return 0, h(fmt.Errorf("line too short")) }
-	hits, e := strconv.ParseFloat(line[hitcol], 64)
+	if len(line) <= hitcol { return 0, h(ErrShortLine) }
+	hits, e := values.Parse(line[hitcol])
 	if e != nil { return 0, h(e) }
 
-	if len(line) <= countcol { return 0, h(fmt.Errorf("line too short")) }
-	count, e := strconv.ParseFloat(line[countcol], 64)
+	if len(line) <= countcol { return 0, h(ErrShortLine) }
+	count, e := values.Parse(line[countcol])
 	if e != nil { return 0, h(e) }
 
 	return hits / count, nil
@@ -27,7 +26,7 @@ func AddAfracOne(line []string, hitcol, countcol int) (float64, error) {
 // Take a ReadCloserMaker and a hit column and a count column. For each line in
 // the input stream, get hit and count from the hitcol and countcol, then
 // append hit/count to the row, forming a new column
-func AddAfrac(rcm ReadCloserMaker, w io.Writer, hitcol, countcol int) error {
+func AddAfrac(rcm ReadCloserMaker, w io.Writer, hitcol, countcol int, ro *RowOptions) error {
 	h := handle("AddAfrac: %w")
 
 	r, e := rcm.NewReadCloser()
@@ -45,32 +44,51 @@ func AddAfrac(rcm ReadCloserMaker, w io.Writer, hitcol, countcol int) error {
 	e = cw.Write(line)
 	if e != nil { return h(e) }
 
+	rows := ro.Stage("AddAfrac")
+	defer rows.Done()
+
 	for line, e = cr.Read(); e != io.EOF; line, e = cr.Read() {
 		if e != nil { return h(e) }
 
-		combined, e := AddAfracOne(line, hitcol, countcol)
-		if e != nil { continue }
+		combined, e := AddAfracOne(line, hitcol, countcol, ro.Values)
+		if e != nil {
+			if e := rows.RejectCsvErr(e, cr, line); e != nil { return h(e) }
+			continue
+		}
+		rows.Keep()
 		line = append(line, fmt.Sprint(combined))
 		e = cw.Write(line)
-		if e != nil { continue }
+		if e != nil { return h(e) }
 	}
 
+	cw.Flush()
+	if e := cw.Error(); e != nil { return h(e) }
 	return nil
 }
 
+// Find the hit column and the count column in the header of rcm
+func HitCountCols(rcm ReadCloserMaker, hitcolname, countcolname string) (hitcol, countcol int, err error) {
+	h := handle("HitCountCols: %w")
+
+	hitcol, e := ValCol(rcm, hitcolname)
+	if e != nil { return 0, 0, h(e) }
+
+	countcol, e = ValCol(rcm, countcolname)
+	if e != nil { return 0, 0, h(e) }
+
+	return hitcol, countcol, nil
+}
+
 // Given an input ReadCloserMaker that can be run multiple times, and provides
 // a tab-separated table with a header line, identify the hit column and count
 // column, then run AddAfrac on it and write the output to 'w'
-func RunAddAfrac(rcm ReadCloserMaker, w io.Writer, hitcolname, countcolname string) error {
+func RunAddAfrac(rcm ReadCloserMaker, w io.Writer, hitcolname, countcolname string, ro *RowOptions) error {
 	h := handle("RunAddAfrac: %w")
 
-	hitcol, e := ValCol(rcm, hitcolname)
-	if e != nil { return h(e) }
-
-	countcol, e := ValCol(rcm, countcolname)
+	hitcol, countcol, e := HitCountCols(rcm, hitcolname, countcolname)
 	if e != nil { return h(e) }
 
-	e = AddAfrac(rcm, w, hitcol, countcol)
+	e = AddAfrac(rcm, w, hitcol, countcol, ro)
 	if e != nil { return h(e) }
 
 	return nil
@@ -113,6 +113,7 @@ func RunAddIdCols() {
 	keyColp := flag.Int("key", -1, "Key column in data file")
 	idValColsp := flag.String("valcols", "", "comma-separated columns in ID file to add")
 	idValNamesp := flag.String("valnames", "", "comma-separated names of columns to add")
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
 	flag.Parse()
 
 	if *ipathp == "" {
@@ -136,11 +137,17 @@ func RunAddIdCols() {
 		panic(e)
 	}
 
-	infoMap, e := GetInfoMap(MaybeGzPath(*ipathp), *idKeyColp)
+	infoMap, e := GetInfoMap(AutoDecompressPath(*ipathp), *idKeyColp)
 	if e != nil {
 		panic(e)
 	}
 
-	e = AddIdCols(os.Stdin, os.Stdout, *keyColp, strings.Split(*idValNamesp, ","), valcols, infoMap)
+	w, e := OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	e = AddIdCols(os.Stdin, w, *keyColp, strings.Split(*idValNamesp, ","), valcols, infoMap)
+	if e != nil { panic(e) }
+
+	e = w.Close()
 	if e != nil { panic(e) }
 }
@@ -77,6 +77,7 @@ func RunAddPlate() {
 	headerp := flag.Bool("h", false, "Table contains a header")
 	colp := flag.Int("c", -1, "column to use as indiv identities")
 	identp := flag.String("i", "", "path to identities table")
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
 	flag.Parse()
 
 	if *colp == -1 {
@@ -86,7 +87,17 @@ func RunAddPlate() {
 		panic(errors.New("missing -i"))
 	}
 
-	e := AssignPlate(os.Stdin, os.Stdout, MaybeGzPath(*identp), *colp, *headerp)
+	w, e := OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil {
+		panic(e)
+	}
+
+	e = AssignPlate(os.Stdin, w, AutoDecompressPath(*identp), *colp, *headerp)
+	if e != nil {
+		panic(e)
+	}
+
+	e = w.Close()
 	if e != nil {
 		panic(e)
 	}
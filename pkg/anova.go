@@ -0,0 +1,196 @@
+package spstat
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// The tests that anova writes for each id column
+const (
+	// The one-way ANOVA F test of equal means
+	AnovaF = "anova"
+	// Bartlett's test of equal variances
+	AnovaBartlett = "bartlett"
+	// Welch's ANOVA, which does not assume equal variances
+	AnovaWelch = "welch"
+)
+
+// One test across all of the levels of an id column. SSBetween and SSWithin
+// are only set for AnovaF, and Df2 is NaN for AnovaBartlett, whose statistic
+// is chi-square.
+type AnovaResult struct {
+	Column string
+	Test string
+	Levels int
+	Count float64
+	SSBetween float64
+	SSWithin float64
+	Stat float64
+	Df1 float64
+	Df2 float64
+	P float64
+}
+
+// The moments of each level of tsum with at least min values, in sorted
+// order
+func anovaLevels(tsum *TSummary, min float64) []*Moments {
+	var levels []*Moments
+	for _, name := range sortedGroups(tsum.Counts) {
+		m := tsum.MomentsOf(name)
+		if m.Count() >= min {
+			levels = append(levels, m)
+		}
+	}
+	return levels
+}
+
+// The total count and the mean of levels together
+func grandMean(levels []*Moments) (n, mean float64) {
+	sum := 0.0
+	for _, m := range levels {
+		n += m.Count()
+		sum += m.Count() * m.Mean()
+	}
+	return n, sum / n
+}
+
+// The one-way ANOVA of the levels of tsum
+func OneWayAnova(tsum *TSummary) AnovaResult {
+	levels := anovaLevels(tsum, 1)
+	n, mean := grandMean(levels)
+	k := float64(len(levels))
+
+	r := AnovaResult{Column: tsum.ColName, Test: AnovaF, Levels: len(levels), Count: n}
+	for _, m := range levels {
+		d := m.Mean() - mean
+		r.SSBetween += m.Count() * d * d
+		r.SSWithin += m.Count() * m.PopVar()
+	}
+	r.Df1, r.Df2 = k - 1, n - k
+	r.Stat = (r.SSBetween / r.Df1) / (r.SSWithin / r.Df2)
+	r.P = FSurvival(r.Stat, r.Df1, r.Df2)
+	return r
+}
+
+// Bartlett's test of equal variances across the levels of tsum with at
+// least two values
+func BartlettTest(tsum *TSummary) AnovaResult {
+	levels := anovaLevels(tsum, 2)
+	n, _ := grandMean(levels)
+	k := float64(len(levels))
+
+	pooled, sumlog, suminv := 0.0, 0.0, 0.0
+	for _, m := range levels {
+		df := m.Count() - 1
+		pooled += df * m.SampleVar()
+		sumlog += df * math.Log(m.SampleVar())
+		suminv += 1 / df
+	}
+	pooled /= n - k
+
+	num := (n - k) * math.Log(pooled) - sumlog
+	den := 1 + (suminv - 1 / (n - k)) / (3 * (k - 1))
+
+	r := AnovaResult{Column: tsum.ColName, Test: AnovaBartlett, Levels: len(levels), Count: n}
+	r.SSBetween, r.SSWithin = math.NaN(), math.NaN()
+	r.Stat = num / den
+	r.Df1, r.Df2 = k - 1, math.NaN()
+	r.P = math.NaN()
+	if !BadDF(r.Df1) && !math.IsNaN(r.Stat) {
+		r.P = distuv.ChiSquared{K: r.Df1}.Survival(r.Stat)
+	}
+	return r
+}
+
+// Welch's ANOVA across the levels of tsum with at least two values, which
+// weights each level by its count over its variance
+func WelchAnova(tsum *TSummary) AnovaResult {
+	levels := anovaLevels(tsum, 2)
+	n, _ := grandMean(levels)
+	k := float64(len(levels))
+
+	wsum, wmean := 0.0, 0.0
+	for _, m := range levels {
+		w := m.Count() / m.SampleVar()
+		wsum += w
+		wmean += w * m.Mean()
+	}
+	wmean /= wsum
+
+	between, tmp := 0.0, 0.0
+	for _, m := range levels {
+		w := m.Count() / m.SampleVar()
+		d := m.Mean() - wmean
+		between += w * d * d
+		u := 1 - w / wsum
+		tmp += u * u / (m.Count() - 1)
+	}
+
+	r := AnovaResult{Column: tsum.ColName, Test: AnovaWelch, Levels: len(levels), Count: n}
+	r.SSBetween, r.SSWithin = math.NaN(), math.NaN()
+	r.Stat = (between / (k - 1)) / (1 + 2 * (k - 2) * tmp / (k * k - 1))
+	r.Df1, r.Df2 = k - 1, (k * k - 1) / (3 * tmp)
+	r.P = FSurvival(r.Stat, r.Df1, r.Df2)
+	return r
+}
+
+// Write one AnovaResult as a tab-separated line
+func WriteAnovaResult(w io.Writer, r AnovaResult) error {
+	_, e := fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+		r.Column, r.Test, r.Levels, r.Count,
+		r.SSBetween, r.SSWithin,
+		r.Stat, r.Df1, r.Df2, r.P,
+	)
+	return e
+}
+
+// Write the ANOVA and Bartlett's test, and Welch's ANOVA if welch is set,
+// for each of tsums
+func Anovas(w io.Writer, tsums []*TSummary, welch bool) error {
+	h := handle("Anovas: %w")
+
+	for _, tsum := range tsums {
+		results := []AnovaResult{OneWayAnova(tsum), BartlettTest(tsum)}
+		if welch {
+			results = append(results, WelchAnova(tsum))
+		}
+		for _, r := range results {
+			if e := WriteAnovaResult(w, r); e != nil { return h(e) }
+		}
+	}
+	return nil
+}
+
+// Run Anovas on the levels of each of idcolsnames
+func RunAnova(rcm ReadCloserMaker, w io.Writer, valcolname string, idcolsnames []string, welch bool, ro *RowOptions) error {
+	h := handle("RunAnova: %w")
+
+	if len(idcolsnames) == 0 {
+		return h(fmt.Errorf("no id columns"))
+	}
+
+	valcol, e := ValCol(rcm, valcolname)
+	if e != nil { return h(e) }
+
+	idcols, e := IdCols(rcm, idcolsnames)
+	if e != nil { return h(e) }
+
+	tsums, _, e := CalcTSummary(rcm, valcol, idcolsnames, idcols, 0, 0, ro)
+	if e != nil { return h(e) }
+
+	if e := Anovas(w, tsums, welch); e != nil { return h(e) }
+	return nil
+}
+
+// Run Anovas on every set of a SummaryGroups summary
+func AnovaSummary(w io.Writer, s *Summary, welch bool) error {
+	h := handle("AnovaSummary: %w")
+
+	if s.Kind != SummaryGroups {
+		return h(fmt.Errorf("need a %v summary, not %v", SummaryGroups, s.Kind))
+	}
+	if e := Anovas(w, s.Sets, welch); e != nil { return h(e) }
+	return nil
+}
@@ -0,0 +1,73 @@
+package spstat
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// R's PlantGrowth data
+var plantGrowth = map[string][]float64{
+	"ctrl": []float64{4.17, 5.58, 5.18, 6.11, 4.50, 4.61, 5.17, 4.53, 5.33, 5.14},
+	"trt1": []float64{4.81, 4.17, 4.41, 3.59, 5.87, 3.83, 6.03, 4.89, 4.32, 4.69},
+	"trt2": []float64{6.31, 5.12, 5.54, 5.50, 5.37, 5.29, 4.92, 6.15, 5.80, 5.26},
+}
+
+func TestAnova(t *testing.T) {
+	tsum := NewTSummary()
+	tsum.ColName = "group"
+	for group, vals := range plantGrowth {
+		for _, v := range vals {
+			tsum.Add(v, group)
+		}
+	}
+
+	// From R: anova(lm(weight ~ group, PlantGrowth)), bartlett.test and
+	// oneway.test
+	wants := []AnovaResult{
+		{"group", AnovaF, 3, 30, 3.76634, 10.49209, 4.846088, 2, 27, 0.01590996},
+		{"group", AnovaBartlett, 3, 30, math.NaN(), math.NaN(), 2.878574, 2, math.NaN(), 0.2370968},
+		{"group", AnovaWelch, 3, 30, math.NaN(), math.NaN(), 5.180972, 2, 17.12842, 0.01739282},
+	}
+	gots := []AnovaResult{OneWayAnova(tsum), BartlettTest(tsum), WelchAnova(tsum)}
+
+	same := func(a, b float64) bool {
+		return (math.IsNaN(a) && math.IsNaN(b)) || closeTo(a, b, 1e-6)
+	}
+	for i, want := range wants {
+		got := gots[i]
+		if got.Column != want.Column || got.Test != want.Test || got.Levels != want.Levels || got.Count != want.Count ||
+			!same(got.SSBetween, want.SSBetween) || !same(got.SSWithin, want.SSWithin) ||
+			!same(got.Stat, want.Stat) || !same(got.Df1, want.Df1) || !same(got.Df2, want.Df2) || !same(got.P, want.P) {
+			t.Errorf("%v: got %+v; want %+v", want.Test, got, want)
+		}
+	}
+}
+
+func TestRunAnova(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("group\tvalue\n")
+	for _, group := range []string{"ctrl", "trt1", "trt2"} {
+		for _, v := range plantGrowth[group] {
+			fmt.Fprintf(&b, "%v\t%v\n", group, v)
+		}
+	}
+	b.WriteString("trt2\tNA\n")
+	rcm := stringTable(b.String())
+
+	var outs []string
+	serialAndParallel(t, func(ro *RowOptions) {
+		var out bytes.Buffer
+		if e := RunAnova(rcm, &out, "value", []string{"group"}, false, ro); e != nil { t.Fatal(e) }
+		outs = append(outs, out.String())
+	})
+
+	for _, out := range outs {
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[0], "group\tanova\t3\t30\t") || !strings.HasPrefix(lines[1], "group\tbartlett\t3\t30\t") {
+			t.Errorf("output %q", out)
+		}
+	}
+}
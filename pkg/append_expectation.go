@@ -1,12 +1,9 @@
 package spstat
 
 import (
-	"log"
 	"errors"
 	"flag"
-	"os"
 	"encoding/csv"
-	"bufio"
 	"io"
 	"github.com/jgbaldwinbrown/csvh"
 )
@@ -49,7 +46,7 @@ func Expectation(t bool, sex, experiment, tissue, chrom string) string {
 	return FExpectation(sex, experiment, tissue, chrom)
 }
 
-func LinearModelAppendExpectation(rcm ReadCloserMaker, w io.Writer, t bool, sexcol, experimentcol, tissuecol, chromcol int, header bool) (err error) {
+func LinearModelAppendExpectation(rcm ReadCloserMaker, w io.Writer, t bool, sexcol, experimentcol, tissuecol, chromcol int, header bool, ro *RowOptions) (err error) {
 	h := handle("LinearModelAppendExpectations: %w")
 
 	r, e := rcm.NewReadCloser()
@@ -68,32 +65,35 @@ func LinearModelAppendExpectation(rcm ReadCloserMaker, w io.Writer, t bool, sexc
 		if e != nil { return h(e) }
 	}
 
+	rows := ro.Stage("LinearModelAppendExpectation")
+	defer rows.Done()
+
 	for line, e := cr.Read(); e != io.EOF; line, e = cr.Read() {
 		if e != nil { return h(e) }
 
-		if len(line) <= sexcol { continue }
+		if len(line) <= sexcol || len(line) <= experimentcol || len(line) <= tissuecol || len(line) <= chromcol {
+			if e := rows.RejectCsv(ShortLine, cr, line); e != nil { return h(e) }
+			continue
+		}
+		rows.Keep()
 		sex := line[sexcol]
-
-		if len(line) <= experimentcol { continue }
 		experiment := line[experimentcol]
-
-		if len(line) <= tissuecol { continue }
 		tissue := line[tissuecol]
-
-		if len(line) <= chromcol { continue }
 		chrom := line[chromcol]
 
 		expect := Expectation(t, sex, experiment, tissue, chrom)
 		line = append(line, expect)
 		e = cw.Write(line)
-		if e != nil { continue }
+		if e != nil { return h(e) }
 	}
 
+	cw.Flush()
+	if e := cw.Error(); e != nil { return h(e) }
 	return nil
 }
 
 // Wrapper that does the entire linear model expectation appending pipeline.
-func FullAppendExpectation(rcm ReadCloserMaker, w io.Writer, t bool, sexcolname, experimentcolname, tissuecolname, chromcolname string) error {
+func FullAppendExpectation(rcm ReadCloserMaker, w io.Writer, t bool, sexcolname, experimentcolname, tissuecolname, chromcolname string, ro *RowOptions) error {
 	h := handle("RunLinearModel: %w")
 
 	sexcol, e := ValCol(rcm, sexcolname)
@@ -108,7 +108,7 @@ func FullAppendExpectation(rcm ReadCloserMaker, w io.Writer, t bool, sexcolname,
 	chromcol, e := ValCol(rcm, chromcolname)
 	if e != nil { return h(e) }
 
-	e = LinearModelAppendExpectation(rcm, w, t, sexcol, experimentcol, tissuecol, chromcol, true)
+	e = LinearModelAppendExpectation(rcm, w, t, sexcol, experimentcol, tissuecol, chromcol, true, ro)
 	if e != nil { return h(e) }
 
 	return nil
@@ -118,6 +118,7 @@ type appendExpectationFlags struct {
 	Path string
 	ResultFile bool
 	T bool
+	OutPath string
 }
 
 // Wrapper that runs FullAppendExpectation or LinearModelExpectation on the command line
@@ -125,30 +126,44 @@ func RunAppendExpectation() {
 	h := handle("RunAppendExpectation: %w")
 
 	var f appendExpectationFlags
-	flag.StringVar(&f.Path, "i", "", "inpath")
+	flag.StringVar(&f.Path, "i", "", "inpath, or - for stdin")
 	flag.BoolVar(&f.ResultFile, "r", false, "interpret input file as a results file, not a data file")
 	flag.BoolVar(&f.T, "t", false, "Append t test expectations")
+	flag.StringVar(&f.OutPath, "o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
+	rowflags := AddRowFlags()
 	flag.Parse()
+	defer rowflags.Exit()
 	if f.Path == "" {
-		log.Fatal(errors.New("missing -i"))
+		panic(errors.New("missing -i"))
 	}
 
-	stdout := bufio.NewWriter(os.Stdout)
+	stdout, e := OutputWriteCloserMaker(f.OutPath).NewWriteCloser()
+	if e != nil {
+		panic(h(e))
+	}
+	ro, e := rowflags.Start()
+	if e != nil {
+		panic(h(e))
+	}
+	rcm := InputReadCloserMaker(f.Path)
+	if e := rowflags.Validate(rcm); e != nil {
+		panic(h(e))
+	}
 	defer func() {
-		if e := stdout.Flush(); e != nil {
+		if e := stdout.Close(); e != nil {
 			panic(h(e))
 		}
 	}()
 
 	if !f.ResultFile {
-		e := FullAppendExpectation(MaybeGzPath(f.Path), stdout, f.T, "sex", "experiment", "tissue", "chrom")
+		e := FullAppendExpectation(rcm, stdout, f.T, "sex", "experiment", "tissue", "chrom", ro)
 		if e != nil {
-			log.Fatal(h(e))
+			panic(h(e))
 		}
 	} else {
-		e := LinearModelAppendExpectation(MaybeGzPath(f.Path), stdout, f.T, 18, 17, 3, -1, false)
+		e := LinearModelAppendExpectation(rcm, stdout, f.T, 18, 17, 3, -1, false, ro)
 		if e != nil {
-			log.Fatal(h(e))
+			panic(h(e))
 		}
 	}
 }
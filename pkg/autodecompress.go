@@ -0,0 +1,158 @@
+package spstat
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"os"
+	"runtime"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// A compression format, as identified by the magic bytes at the start of a
+// stream
+type Compression int
+
+const (
+	Uncompressed Compression = iota
+	Gzip
+	Bzip2
+	Xz
+	Zstd
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	// After the block size digit, a bzip2 stream starts with a block, or
+	// ends at once if it is empty
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2EndMagic = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+	xzMagic = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// The number of bytes DetectCompression needs to see to identify any format
+const MagicLen = 10
+
+func (c Compression) String() string {
+	switch c {
+	case Gzip: return "gzip"
+	case Bzip2: return "bzip2"
+	case Xz: return "xz"
+	case Zstd: return "zstd"
+	}
+	return "uncompressed"
+}
+
+// Identify the compression format from the first bytes of a stream. Streams
+// that match no known format are Uncompressed.
+func DetectCompression(magic []byte) Compression {
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic): return Gzip
+	case isBzip2(magic): return Bzip2
+	case bytes.HasPrefix(magic, xzMagic): return Xz
+	case bytes.HasPrefix(magic, zstdMagic): return Zstd
+	}
+	return Uncompressed
+}
+
+// Whether magic starts with "BZh", a block size digit from 1 to 9, and the
+// magic of a first block or of the end of an empty stream, so that a plain
+// table whose first field happens to start with "BZh" is not taken for bzip2
+func isBzip2(magic []byte) bool {
+	if len(magic) < MagicLen || !bytes.HasPrefix(magic, bzip2Magic) {
+		return false
+	}
+	if magic[3] < '1' || magic[3] > '9' {
+		return false
+	}
+	return bytes.Equal(magic[4:10], bzip2BlockMagic) || bytes.Equal(magic[4:10], bzip2EndMagic)
+}
+
+// An io.Reader with a list of Closers to close, in order, when it is closed
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m multiCloser) Close() error {
+	var err error
+	for _, c := range m.closers {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+type zstdCloser struct {
+	*zstd.Decoder
+}
+
+func (z zstdCloser) Close() error {
+	z.Decoder.Close()
+	return nil
+}
+
+// Peek at the start of r, then wrap it in the matching decompressor. BGZF
+// input is decompressed in parallel with a BgzfReader. Closing the result
+// does not close r.
+func AutoDecompress(r io.Reader) (io.ReadCloser, error) {
+	return autoDecompressThreads(r, 0)
+}
+
+// Like AutoDecompress, but with a set number of threads for BGZF input; 0
+// means runtime.GOMAXPROCS.
+func autoDecompressThreads(r io.Reader, threads int) (io.ReadCloser, error) {
+	h := handle("AutoDecompress: %w")
+
+	br := bufio.NewReader(r)
+	magic, e := br.Peek(bgzfHeaderLen)
+	if e != nil && e != io.EOF && e != bufio.ErrBufferFull { return nil, h(e) }
+
+	switch DetectCompression(magic) {
+	case Gzip:
+		if IsBgzf(magic) {
+			if threads < 1 {
+				threads = runtime.GOMAXPROCS(0)
+			}
+			return NewBgzfReader(br, threads), nil
+		}
+		gr, e := gzip.NewReader(br)
+		if e != nil { return nil, h(e) }
+		return gr, nil
+	case Bzip2:
+		return ReadCloser{bzip2.NewReader(br)}, nil
+	case Xz:
+		xr, e := xz.NewReader(br)
+		if e != nil { return nil, h(e) }
+		return ReadCloser{xr}, nil
+	case Zstd:
+		zr, e := zstd.NewReader(br)
+		if e != nil { return nil, h(e) }
+		return zstdCloser{zr}, nil
+	}
+	return ReadCloser{br}, nil
+}
+
+// A path to open, decompressing it if its first bytes identify it as gzip,
+// bzip2, xz, or zstd. The file name does not matter.
+type AutoDecompressPath string
+
+func (p AutoDecompressPath) NewReadCloser() (io.ReadCloser, error) {
+	h := handle("AutoDecompressPath.NewReadCloser: %w")
+
+	f, e := os.Open(string(p))
+	if e != nil { return nil, h(e) }
+
+	dr, e := AutoDecompress(f)
+	if e != nil {
+		f.Close()
+		return nil, h(e)
+	}
+	return multiCloser{dr, []io.Closer{dr, f}}, nil
+}
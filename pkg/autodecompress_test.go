@@ -0,0 +1,181 @@
+package spstat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// "chrom\tpos\nchr1\t1\n" as the bzip2 command writes it; the standard
+// library has no bzip2 writer
+var bzip2Table = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x14, 0x1c,
+	0xf9, 0x0d, 0x00, 0x00, 0x03, 0xc9, 0x80, 0x00, 0x30, 0x20, 0x00, 0x08,
+	0x42, 0xd8, 0x00, 0x20, 0x00, 0x31, 0x00, 0x30, 0x1a, 0x0c, 0x86, 0x94,
+	0x9d, 0x32, 0x86, 0x0e, 0x2d, 0x30, 0x7c, 0x5d, 0xc9, 0x14, 0xe1, 0x42,
+	0x40, 0x50, 0x73, 0xe4, 0x34,
+}
+
+// An empty stream as the bzip2 command writes it
+var bzip2Empty = []byte{0x42, 0x5a, 0x68, 0x39, 0x17, 0x72, 0x45, 0x38, 0x50, 0x90, 0x00, 0x00, 0x00, 0x00}
+
+func gzipBytes(t *testing.T, in []byte) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, e := w.Write(in); e != nil { t.Fatal(e) }
+	if e := w.Close(); e != nil { t.Fatal(e) }
+	return b.Bytes()
+}
+
+func bgzfBytes(t *testing.T, in []byte) []byte {
+	var b bytes.Buffer
+	w := NewBgzfWriter(&b, 2)
+	if _, e := w.Write(in); e != nil { t.Fatal(e) }
+	if e := w.Close(); e != nil { t.Fatal(e) }
+	return b.Bytes()
+}
+
+func xzBytes(t *testing.T, in []byte) []byte {
+	var b bytes.Buffer
+	w, e := xz.NewWriter(&b)
+	if e != nil { t.Fatal(e) }
+	if _, e := w.Write(in); e != nil { t.Fatal(e) }
+	if e := w.Close(); e != nil { t.Fatal(e) }
+	return b.Bytes()
+}
+
+func zstdBytes(t *testing.T, in []byte) []byte {
+	var b bytes.Buffer
+	w, e := zstd.NewWriter(&b)
+	if e != nil { t.Fatal(e) }
+	if _, e := w.Write(in); e != nil { t.Fatal(e) }
+	if e := w.Close(); e != nil { t.Fatal(e) }
+	return b.Bytes()
+}
+
+func TestAutoDecompress(t *testing.T) {
+	table := testTable(5000)
+	cases := []struct {
+		name string
+		comp []byte
+		format Compression
+		want []byte
+	}{
+		{"uncompressed", table, Uncompressed, table},
+		{"empty", nil, Uncompressed, nil},
+		{"BZh header", []byte("BZh\tpos\nchr1\t1\n"), Uncompressed, []byte("BZh\tpos\nchr1\t1\n")},
+		{"BZh9 header", []byte("BZh9\tpos\nchr1\t1\n"), Uncompressed, []byte("BZh9\tpos\nchr1\t1\n")},
+		{"gzip", gzipBytes(t, table), Gzip, table},
+		{"bgzf", bgzfBytes(t, table), Gzip, table},
+		{"bzip2", bzip2Table, Bzip2, []byte("chrom\tpos\nchr1\t1\n")},
+		{"empty bzip2", bzip2Empty, Bzip2, nil},
+		{"xz", xzBytes(t, table), Xz, table},
+		{"zstd", zstdBytes(t, table), Zstd, table},
+	}
+
+	dir := t.TempDir()
+	for _, c := range cases {
+		if got := DetectCompression(c.comp); got != c.format {
+			t.Errorf("%v: detected %v, want %v", c.name, got, c.format)
+		}
+
+		r, e := AutoDecompress(bytes.NewReader(c.comp))
+		if e != nil { t.Fatalf("%v: %v", c.name, e) }
+		out, e := io.ReadAll(r)
+		if e != nil { t.Fatalf("%v: %v", c.name, e) }
+		if e := r.Close(); e != nil { t.Errorf("%v: %v", c.name, e) }
+		if !bytes.Equal(out, c.want) {
+			t.Errorf("%v: read %v bytes, want %v", c.name, len(out), len(c.want))
+		}
+
+		// The file name does not matter
+		path := filepath.Join(dir, "table.tsv")
+		if e := os.WriteFile(path, c.comp, 0644); e != nil { t.Fatal(e) }
+		out, e = readAll(AutoDecompressPath(path))
+		if e != nil { t.Fatalf("%v: %v", c.name, e) }
+		if !bytes.Equal(out, c.want) {
+			t.Errorf("%v: read %v bytes from a path, want %v", c.name, len(out), len(c.want))
+		}
+	}
+}
+
+func readAll(rcm ReadCloserMaker) ([]byte, error) {
+	r, e := rcm.NewReadCloser()
+	if e != nil { return nil, e }
+	out, e := io.ReadAll(r)
+	if e != nil {
+		r.Close()
+		return nil, e
+	}
+	return out, r.Close()
+}
+
+func TestSpool(t *testing.T) {
+	table := testTable(20000)
+	cases := []struct {
+		name string
+		in []byte
+		gz bool
+		decompress bool
+	}{
+		{"plain", table, false, false},
+		{"gzip spool", table, true, false},
+		{"decompress", gzipBytes(t, table), false, true},
+		{"decompress into gzip spool", zstdBytes(t, table), true, true},
+	}
+
+	for _, c := range cases {
+		s := NewSpool(bytes.NewReader(c.in), t.TempDir(), c.gz)
+		s.Decompress = c.decompress
+		for pass := 0; pass < 2; pass++ {
+			out, e := readAll(s)
+			if e != nil { t.Fatalf("%v: %v", c.name, e) }
+			if !bytes.Equal(out, table) {
+				t.Errorf("%v: pass %v read %v bytes, want %v", c.name, pass, len(out), len(table))
+			}
+		}
+
+		// Readers opened together are independent
+		r1, e := s.NewReadCloser()
+		if e != nil { t.Fatal(e) }
+		r2, e := s.NewReadCloser()
+		if e != nil { t.Fatal(e) }
+		b1 := make([]byte, 100)
+		if _, e := io.ReadFull(r1, b1); e != nil { t.Fatal(e) }
+		b2, e := io.ReadAll(r2)
+		if e != nil { t.Fatal(e) }
+		if !bytes.Equal(b1, table[:100]) || !bytes.Equal(b2, table) {
+			t.Errorf("%v: concurrent readers interfere", c.name)
+		}
+		r1.Close()
+		r2.Close()
+
+		if e := s.Close(); e != nil { t.Error(e) }
+	}
+}
+
+func TestInputStdin(t *testing.T) {
+	table := testTable(1000)
+	path := filepath.Join(t.TempDir(), "stdin")
+	if e := os.WriteFile(path, gzipBytes(t, table), 0644); e != nil { t.Fatal(e) }
+
+	f, e := os.Open(path)
+	if e != nil { t.Fatal(e) }
+	defer f.Close()
+	defer func(stdin *os.File) { os.Stdin = stdin }(os.Stdin)
+	os.Stdin = f
+
+	rcm := InputReadCloserMaker("-")
+	for pass := 0; pass < 2; pass++ {
+		out, e := readAll(rcm)
+		if e != nil { t.Fatal(e) }
+		if !bytes.Equal(out, table) {
+			t.Errorf("pass %v read %v bytes from stdin, want %v", pass, len(out), len(table))
+		}
+	}
+}
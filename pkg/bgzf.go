@@ -0,0 +1,310 @@
+package spstat
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// Length of a BGZF block header, up to and including the BSIZE field of the
+// "BC" extra subfield
+const bgzfHeaderLen = 18
+
+// Length of the CRC32 and ISIZE fields at the end of every gzip member
+const bgzfFooterLen = 8
+
+var ErrNotBgzf = errors.New("not a BGZF block")
+
+// Check whether the start of a stream is a BGZF block header: a gzip header
+// with the FEXTRA flag set and a "BC" subfield at the start of the extra
+// field.
+func IsBgzf(magic []byte) bool {
+	return len(magic) >= bgzfHeaderLen &&
+		magic[0] == 0x1f && magic[1] == 0x8b && magic[2] == 8 && magic[3]&4 != 0 &&
+		magic[12] == 'B' && magic[13] == 'C' && magic[14] == 2 && magic[15] == 0
+}
+
+// Resize b to length n, reallocating only if it is too small
+func growBytes(b []byte, n int) []byte {
+	if cap(b) < n {
+		return make([]byte, n)
+	}
+	return b[:n]
+}
+
+// Read one whole compressed BGZF block, header to footer, from r into buf.
+// Returns io.EOF if r ends cleanly between blocks.
+func ReadBgzfBlock(r io.Reader, buf []byte) ([]byte, error) {
+	h := handle("ReadBgzfBlock: %w")
+
+	buf = growBytes(buf, bgzfHeaderLen)
+	_, e := io.ReadFull(r, buf)
+	if e == io.EOF { return buf[:0], io.EOF }
+	if e != nil { return nil, h(e) }
+	if !IsBgzf(buf) { return nil, h(ErrNotBgzf) }
+
+	bsize := int(binary.LittleEndian.Uint16(buf[16:18])) + 1
+	if bsize < bgzfHeaderLen + bgzfFooterLen {
+		return nil, h(fmt.Errorf("block size %v too small", bsize))
+	}
+
+	full := growBytes(buf, bsize)
+	copy(full, buf[:bgzfHeaderLen])
+	_, e = io.ReadFull(r, full[bgzfHeaderLen:])
+	if e == io.EOF { e = io.ErrUnexpectedEOF }
+	if e != nil { return nil, h(e) }
+	return full, nil
+}
+
+// Reusable state for decompressing BGZF blocks on one goroutine
+type bgzfInflater struct {
+	br bytes.Reader
+	fr io.ReadCloser
+}
+
+// Decompress one block read by ReadBgzfBlock into out, checking its length
+// and CRC.
+func (f *bgzfInflater) inflate(block []byte, out []byte) ([]byte, error) {
+	h := handle("inflate: %w")
+
+	xlen := int(binary.LittleEndian.Uint16(block[10:12]))
+	start := 12 + xlen
+	end := len(block) - bgzfFooterLen
+	if start > end { return nil, h(fmt.Errorf("extra field length %v overruns block of %v bytes", xlen, len(block))) }
+
+	crc := binary.LittleEndian.Uint32(block[end:])
+	isize := int(binary.LittleEndian.Uint32(block[end+4:]))
+
+	f.br.Reset(block[start:end])
+	if f.fr == nil {
+		f.fr = flate.NewReader(&f.br)
+	} else {
+		e := f.fr.(flate.Resetter).Reset(&f.br, nil)
+		if e != nil { return nil, h(e) }
+	}
+
+	out = growBytes(out, isize)
+	_, e := io.ReadFull(f.fr, out)
+	if e != nil { return nil, h(e) }
+	if crc32.ChecksumIEEE(out) != crc {
+		return nil, h(fmt.Errorf("CRC mismatch"))
+	}
+	return out, nil
+}
+
+// One block, from reading through decompression
+type bgzfJob struct {
+	offset int64
+	raw []byte
+	out []byte
+	err error
+	done chan struct{}
+}
+
+// An io.ReadCloser that decompresses a BGZF stream. One goroutine reads
+// compressed blocks in order, a pool of goroutines decompresses them, and
+// Read returns the decompressed bytes in the original order.
+type BgzfReader struct {
+	order chan *bgzfJob
+	quit chan struct{}
+	closeOnce sync.Once
+	running sync.WaitGroup
+	jobs sync.Pool
+
+	cur *bgzfJob
+	buf []byte
+	err error
+}
+
+// Start decompressing the BGZF stream r on threads goroutines.
+func NewBgzfReader(r io.Reader, threads int) *BgzfReader {
+	return newBgzfReaderAt(r, threads, 0)
+}
+
+// Like NewBgzfReader, for a stream that starts at the compressed offset
+// offset of its file, so that block offsets are reported correctly.
+func newBgzfReaderAt(r io.Reader, threads int, offset int64) *BgzfReader {
+	if threads < 1 {
+		threads = 1
+	}
+
+	b := &BgzfReader{
+		order: make(chan *bgzfJob, threads * 4),
+		quit: make(chan struct{}),
+	}
+	b.jobs.New = func() any { return new(bgzfJob) }
+
+	work := make(chan *bgzfJob, threads * 4)
+	b.running.Add(threads + 1)
+	for i := 0; i < threads; i++ {
+		go b.worker(work)
+	}
+	go b.readBlocks(r, work, offset)
+
+	return b
+}
+
+// Decompress the blocks in work until it closes, skipping them once the
+// reader is closed
+func (b *BgzfReader) worker(work <-chan *bgzfJob) {
+	defer b.running.Done()
+
+	var f bgzfInflater
+	for j := range work {
+		select {
+		case <-b.quit:
+			j.err = io.ErrClosedPipe
+		default:
+			j.out, j.err = f.inflate(j.raw, j.out)
+		}
+		close(j.done)
+	}
+}
+
+// Queue every block in r for decompression, and queue the same blocks in
+// order for Read. Stops at the end of r, on an error, or on Close.
+func (b *BgzfReader) readBlocks(r io.Reader, work chan<- *bgzfJob, offset int64) {
+	defer b.running.Done()
+	defer close(work)
+	defer close(b.order)
+
+	for {
+		j := b.jobs.Get().(*bgzfJob)
+		j.done = make(chan struct{})
+		j.offset = offset
+		j.raw, j.err = ReadBgzfBlock(r, j.raw)
+		offset += int64(len(j.raw))
+
+		if j.err != nil {
+			close(j.done)
+			select {
+			case b.order <- j:
+			case <-b.quit:
+			}
+			return
+		}
+
+		select {
+		case b.order <- j:
+		case <-b.quit:
+			return
+		}
+		work <- j
+	}
+}
+
+// Move on to the next decompressed block, making it b.cur. Its data is in
+// b.buf.
+func (b *BgzfReader) nextBlock() error {
+	if b.err != nil {
+		return b.err
+	}
+	if b.cur != nil {
+		b.jobs.Put(b.cur)
+		b.cur = nil
+	}
+
+	j, ok := <-b.order
+	if !ok {
+		b.err = io.EOF
+		return b.err
+	}
+	<-j.done
+	if j.err != nil {
+		b.err = j.err
+		return b.err
+	}
+	b.cur = j
+	b.buf = j.out
+	return nil
+}
+
+func (b *BgzfReader) Read(p []byte) (int, error) {
+	for len(b.buf) == 0 {
+		if e := b.nextBlock(); e != nil {
+			return 0, e
+		}
+	}
+
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	return n, nil
+}
+
+// The BGZF virtual offset of the next byte Read will return: the compressed
+// offset of its block shifted left 16 bits, plus its offset in the block.
+// Only meaningful while the current block has bytes left.
+func (b *BgzfReader) VirtualOffset() int64 {
+	if b.cur == nil {
+		return 0
+	}
+	return b.cur.offset << 16 | int64(len(b.cur.out) - len(b.buf))
+}
+
+// Stop the background goroutines and wait for them to finish, so that the
+// underlying reader can be closed safely afterward. Does not close the
+// underlying reader.
+func (b *BgzfReader) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.quit)
+		b.running.Wait()
+	})
+	return nil
+}
+
+// A path to a BGZF file, decompressed on Threads goroutines (0 means
+// runtime.GOMAXPROCS). Files that turn out not to be BGZF are read with
+// AutoDecompress instead, so ordinary gzip files still work.
+type BgzfPath struct {
+	Path string
+	Threads int
+}
+
+func (p BgzfPath) NewReadCloser() (io.ReadCloser, error) {
+	h := handle("BgzfPath.NewReadCloser: %w")
+
+	f, e := os.Open(p.Path)
+	if e != nil { return nil, h(e) }
+
+	dr, e := autoDecompressThreads(f, p.Threads)
+	if e != nil {
+		f.Close()
+		return nil, h(e)
+	}
+	return multiCloser{dr, []io.Closer{dr, f}}, nil
+}
+
+// Open the BGZF file at path, positioned at the virtual offset voff.
+func OpenBgzfAt(path string, voff int64, threads int) (io.ReadCloser, error) {
+	h := handle("OpenBgzfAt: %w")
+
+	f, e := os.Open(path)
+	if e != nil { return nil, h(e) }
+
+	block := voff >> 16
+	_, e = f.Seek(block, io.SeekStart)
+	if e != nil {
+		f.Close()
+		return nil, h(e)
+	}
+
+	if threads < 1 {
+		threads = runtime.GOMAXPROCS(0)
+	}
+	br := newBgzfReaderAt(bufio.NewReaderSize(f, 1 << 16), threads, block)
+	_, e = io.CopyN(io.Discard, br, voff & 0xffff)
+	if e != nil {
+		br.Close()
+		f.Close()
+		return nil, h(e)
+	}
+	return multiCloser{br, []io.Closer{br, f}}, nil
+}
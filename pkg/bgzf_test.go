@@ -0,0 +1,134 @@
+package spstat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func testTable(rows int) []byte {
+	var b strings.Builder
+	b.WriteString("pos\ttissue\tvalue\n")
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&b, "%v\tblood\t%v\n", i, float64(i % 97) / 97.0)
+	}
+	return []byte(b.String())
+}
+
+func TestBgzfRoundTrip(t *testing.T) {
+	in := testTable(200000)
+
+	var comp bytes.Buffer
+	w := NewBgzfWriter(&comp, 4)
+	if _, e := w.Write(in); e != nil { t.Fatal(e) }
+	if e := w.Close(); e != nil { t.Fatal(e) }
+
+	if !IsBgzf(comp.Bytes()) {
+		t.Fatal("output is not BGZF")
+	}
+	if !bytes.HasSuffix(comp.Bytes(), bgzfEOF) {
+		t.Error("output does not end with the BGZF EOF block")
+	}
+
+	r, e := AutoDecompress(bytes.NewReader(comp.Bytes()))
+	if e != nil { t.Fatal(e) }
+	defer r.Close()
+	out, e := io.ReadAll(r)
+	if e != nil { t.Fatal(e) }
+
+	if !bytes.Equal(in, out) {
+		t.Errorf("BGZF round trip changed %v bytes into %v bytes", len(in), len(out))
+	}
+}
+
+func TestParallelGzRoundTrip(t *testing.T) {
+	in := testTable(200000)
+
+	var comp bytes.Buffer
+	w := NewParallelGzWriter(&comp, 4)
+	if _, e := w.Write(in); e != nil { t.Fatal(e) }
+	if e := w.Close(); e != nil { t.Fatal(e) }
+
+	r, e := gzip.NewReader(&comp)
+	if e != nil { t.Fatal(e) }
+	out, e := io.ReadAll(r)
+	if e != nil { t.Fatal(e) }
+
+	if !bytes.Equal(in, out) {
+		t.Errorf("gzip round trip changed %v bytes into %v bytes", len(in), len(out))
+	}
+}
+
+func TestBgzfPath(t *testing.T) {
+	in := testTable(100000)
+	dir := t.TempDir()
+
+	// Plain gzip is not BGZF, so BgzfPath falls back to AutoDecompress
+	cases := []struct {
+		name string
+		comp []byte
+		bgzf bool
+	}{
+		{"bgzf", bgzfBytes(t, in), true},
+		{"gzip", gzipBytes(t, in), false},
+	}
+	for _, c := range cases {
+		if IsBgzf(c.comp) != c.bgzf {
+			t.Errorf("%v: IsBgzf %v", c.name, !c.bgzf)
+		}
+		path := filepath.Join(dir, c.name)
+		if e := os.WriteFile(path, c.comp, 0644); e != nil { t.Fatal(e) }
+
+		for _, threads := range []int{1, 4} {
+			out, e := readAll(BgzfPath{Path: path, Threads: threads})
+			if e != nil { t.Fatalf("%v: %v", c.name, e) }
+			if !bytes.Equal(in, out) {
+				t.Errorf("%v with %v threads: read %v bytes, want %v", c.name, threads, len(out), len(in))
+			}
+		}
+	}
+}
+
+func TestBgzfReaderCloseEarly(t *testing.T) {
+	comp := bgzfBytes(t, testTable(200000))
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 20; i++ {
+		r := NewBgzfReader(bytes.NewReader(comp), 4)
+		buf := make([]byte, 1000)
+		if _, e := io.ReadFull(r, buf); e != nil { t.Fatal(e) }
+		if e := r.Close(); e != nil { t.Fatal(e) }
+		if e := r.Close(); e != nil { t.Fatal(e) }
+	}
+
+	// Close waits for the reader and workers, so none are left behind
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("%v goroutines before, %v after closing", before, after)
+	}
+}
+
+func TestBlockWriterCloseTwice(t *testing.T) {
+	for _, bgzf := range []bool{false, true} {
+		var comp bytes.Buffer
+		w := newBlockWriter(&comp, 2, bgzfBlockDataLen, bgzf)
+		if _, e := w.Write(testTable(100)); e != nil { t.Fatal(e) }
+		if e := w.Close(); e != nil { t.Fatal(e) }
+		size := comp.Len()
+
+		if e := w.Close(); e != nil {
+			t.Errorf("second Close: %v", e)
+		}
+		if comp.Len() != size {
+			t.Errorf("second Close wrote %v more bytes", comp.Len() - size)
+		}
+		if _, e := w.Write([]byte("x")); e == nil {
+			t.Errorf("Write after Close succeeded")
+		}
+	}
+}
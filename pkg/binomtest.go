@@ -0,0 +1,547 @@
+package spstat
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"gonum.org/v1/gonum/mathext"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// The tests of hits against an expected fraction: the exact binomial test,
+// and the score test of beta-binomial rows
+const (
+	BinomialTest = "binomial"
+	BetaBinomialTest = "beta-binomial"
+)
+
+// The summed hits and counts of a group, and its count weighted expected
+// hits
+type BinomialSummary struct {
+	Hits float64
+	Count float64
+	ExpectedHits float64
+}
+
+// Add one row of hits out of count with expected fraction p
+func (s *BinomialSummary) Add(hits, count, p float64) {
+	s.Hits += hits
+	s.Count += count
+	s.ExpectedHits += count * p
+}
+
+// Add all of the rows in o to s
+func (s *BinomialSummary) Merge(o *BinomialSummary) {
+	s.Hits += o.Hits
+	s.Count += o.Count
+	s.ExpectedHits += o.ExpectedHits
+}
+
+// The expected fraction of hits, weighted by count
+func (s *BinomialSummary) Expected() float64 {
+	return s.ExpectedHits / s.Count
+}
+
+// The beta-binomial distribution of hits out of a count, with mean fraction
+// p and intra-class correlation rho. It is kept as r = rho / (1 - rho), so
+// that each product in its probabilities is a rising factorial of p + j×r,
+// and rho 0 is the binomial.
+type betaBinomial struct {
+	p float64
+	r float64
+}
+
+func newBetaBinomial(p, rho float64) betaBinomial {
+	r := rho / (1 - rho)
+	if math.IsInf(r, 1) {
+		r = math.MaxFloat64
+	}
+	return betaBinomial{p: p, r: r}
+}
+
+// The sum of 1 / (q + j×r) for j from 0 below k
+func (d betaBinomial) harmonic(q, k float64) float64 {
+	sum := 0.0
+	for j := 0.0; j < k; j++ {
+		sum += 1 / (q + j * d.r)
+	}
+	return sum
+}
+
+// The sum of log(q + j×r) for j from 0 below k
+func (d betaBinomial) logRising(q, k float64) float64 {
+	sum := 0.0
+	for j := 0.0; j < k; j++ {
+		sum += math.Log(q + j * d.r)
+	}
+	return sum
+}
+
+// The log probability of hits out of count
+func (d betaBinomial) LogProb(hits, count float64) float64 {
+	return lchoose(count, hits) + d.logRising(d.p, hits) + d.logRising(1 - d.p, count - hits) - d.logRising(1, count)
+}
+
+// The derivative of LogProb by the mean fraction p, with rho held fixed
+func (d betaBinomial) Score(hits, count float64) float64 {
+	return d.harmonic(d.p, hits) - d.harmonic(1 - d.p, count - hits)
+}
+
+// The Fisher information about p in a row of count: the expected square of
+// its Score, summed over every number of hits
+func (d betaBinomial) Info(count float64) float64 {
+	n := int(count)
+	// Running sums of the terms of harmonic and logRising, for the hits
+	// and for the misses
+	h1, h0 := make([]float64, n + 1), make([]float64, n + 1)
+	l1, l0 := make([]float64, n + 1), make([]float64, n + 1)
+	for j := 0; j < n; j++ {
+		h1[j + 1] = h1[j] + 1 / (d.p + float64(j) * d.r)
+		h0[j + 1] = h0[j] + 1 / (1 - d.p + float64(j) * d.r)
+		l1[j + 1] = l1[j] + math.Log(d.p + float64(j) * d.r)
+		l0[j + 1] = l0[j] + math.Log(1 - d.p + float64(j) * d.r)
+	}
+	lall := d.logRising(1, count)
+
+	info := 0.0
+	for x := 0; x <= n; x++ {
+		lp := lchoose(count, float64(x)) + l1[x] + l0[n - x] - lall
+		score := h1[x] - h0[n - x]
+		info += math.Exp(lp) * score * score
+	}
+	return info
+}
+
+// The score test of a group of beta-binomial rows against their expected
+// fractions: the summed Score of the rows, and their summed Info, for a
+// shift of every row's mean fraction away from its expectation
+type BetaBinomialSummary struct {
+	Score float64
+	Info float64
+}
+
+// Add one row's score and information
+func (s *BetaBinomialSummary) Add(score, info float64) {
+	s.Score += score
+	s.Info += info
+}
+
+// Add all of the rows in o to s
+func (s *BetaBinomialSummary) Merge(o *BetaBinomialSummary) {
+	s.Score += o.Score
+	s.Info += o.Info
+}
+
+// A method of moments estimate of the intra-class correlation of
+// beta-binomial rows with known expected fractions. Each row's squared
+// Pearson residual has expectation 1 + (count - 1) × rho, so rho is the
+// summed excess of the residuals over the summed count minus one.
+type Overdispersion struct {
+	Excess float64
+	Trials float64
+}
+
+// Add one row of hits out of count with expected fraction p. Rows with a
+// count below 2, or an expected fraction of 0 or 1, say nothing about rho.
+func (o *Overdispersion) Add(hits, count, p float64) {
+	if count < 2 || !(p > 0 && p < 1) {
+		return
+	}
+	d := hits - count * p
+	o.Excess += d * d / (count * p * (1 - p)) - 1
+	o.Trials += count - 1
+}
+
+// Add all of the rows in oo to o
+func (o *Overdispersion) Merge(oo *Overdispersion) {
+	o.Excess += oo.Excess
+	o.Trials += oo.Trials
+}
+
+// The estimate of rho, between 0 and 1, or NaN with no rows to estimate it
+func (o *Overdispersion) Rho() float64 {
+	if o.Trials == 0 {
+		return math.NaN()
+	}
+	return math.Max(0, math.Min(1, o.Excess / o.Trials))
+}
+
+func binomLogProb(k, n, p float64) float64 {
+	return lchoose(n, k) + k * math.Log(p) + (n - k) * math.Log1p(-p)
+}
+
+// The probability of k or fewer hits out of n
+func binomCDF(k, n, p float64) float64 {
+	if k < 0 {
+		return 0
+	}
+	if k >= n {
+		return 1
+	}
+	return mathext.RegIncBeta(n - k, k + 1, 1 - p)
+}
+
+// The probability of k or more hits out of n
+func binomSurvival(k, n, p float64) float64 {
+	if k <= 0 {
+		return 1
+	}
+	if k > n {
+		return 0
+	}
+	return mathext.RegIncBeta(k, n - k + 1, p)
+}
+
+// The two-sided P value of the exact binomial test of x hits out of n
+// against fraction p, rounded to whole counts: the probability of the
+// counts no more likely than x, as R's binom.test gives. The tail away from
+// x is found by bisection, so large counts are cheap.
+func BinomialTestP(x, n, p float64) float64 {
+	x, n = math.Round(x), math.Round(n)
+	switch {
+	case p == 0:
+		if x == 0 {
+			return 1
+		}
+		return 0
+	case p == 1:
+		if x == n {
+			return 1
+		}
+		return 0
+	}
+
+	m := n * p
+	if x == m {
+		return 1
+	}
+	limit := binomLogProb(x, n, p) + math.Log(1 + 1e-7)
+
+	if x < m {
+		// The probabilities fall from ceil(m) up, so find the first count
+		// there that is no more likely than x
+		lo, hi := math.Ceil(m), n + 1
+		for lo < hi {
+			mid := math.Floor((lo + hi) / 2)
+			if binomLogProb(mid, n, p) <= limit {
+				hi = mid
+			} else {
+				lo = mid + 1
+			}
+		}
+		return math.Min(1, binomCDF(x, n, p) + binomSurvival(lo, n, p))
+	}
+
+	// The probabilities rise up to floor(m), so find the last count there
+	// that is no more likely than x
+	lo, hi := -1.0, math.Floor(m)
+	for lo < hi {
+		mid := math.Ceil((lo + hi) / 2)
+		if binomLogProb(mid, n, p) <= limit {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return math.Min(1, binomCDF(lo, n, p) + binomSurvival(x, n, p))
+}
+
+// The Clopper–Pearson interval for the fraction of x hits out of n, with
+// confidence conf
+func ClopperPearson(x, n, conf float64) (low, high float64) {
+	alpha := (1 - conf) / 2
+	low, high = 0, 1
+	if x > 0 {
+		low = mathext.InvRegIncBeta(x, n - x + 1, alpha)
+	}
+	if x < n {
+		high = mathext.InvRegIncBeta(x + 1, n - x, 1 - alpha)
+	}
+	return low, high
+}
+
+// The test of one group's hits against its expected fraction. Rho is the
+// overdispersion the test allowed for, 0 for the plain binomial test.
+type BinomialResult struct {
+	Group string
+	Test string
+	Hits float64
+	Count float64
+	Expected float64
+	Estimate float64
+	Low float64
+	High float64
+	P float64
+	Rho float64
+}
+
+// Test the summed hits of group against its expected fraction with the
+// exact binomial test, with confidence conf
+func CalcBinomialTest(group string, s *BinomialSummary, conf float64) BinomialResult {
+	r := BinomialResult{Group: group, Test: BinomialTest, Hits: s.Hits, Count: s.Count}
+	r.Expected = s.Expected()
+	r.Estimate = s.Hits / s.Count
+
+	x, n := math.Round(s.Hits), math.Round(s.Count)
+	r.Low, r.High = ClopperPearson(x, n, conf)
+	r.P = BinomialTestP(x, n, r.Expected)
+	return r
+}
+
+// Test the rows of group, beta-binomial with intra-class correlation rho,
+// against their expected fractions. The shift d of every row's mean
+// fraction from its expectation is tested with the score test, which is
+// normal with variance b.Info when d is 0. The estimate is the expected
+// fraction plus the one-step estimate of d, b.Score / b.Info, and the
+// interval is that estimate plus or minus the normal quantile of conf over
+// the square root of b.Info, clipped to 0 and 1.
+func CalcBetaBinomialTest(group string, s *BinomialSummary, b *BetaBinomialSummary, rho, conf float64) BinomialResult {
+	r := BinomialResult{Group: group, Test: BetaBinomialTest, Hits: s.Hits, Count: s.Count, Rho: rho}
+	r.Expected = s.Expected()
+
+	se := 1 / math.Sqrt(b.Info)
+	r.Estimate = math.Max(0, math.Min(1, r.Expected + b.Score / b.Info))
+	z := distuv.UnitNormal.Quantile(1 - (1 - conf) / 2)
+	r.Low = math.Max(0, r.Estimate - z * se)
+	r.High = math.Min(1, r.Estimate + z * se)
+	r.P = 2 * distuv.UnitNormal.Survival(math.Abs(b.Score * se))
+	return r
+}
+
+// Write one BinomialResult as a tab-separated line
+func WriteBinomialResult(w io.Writer, r BinomialResult) error {
+	_, e := fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+		r.Group, r.Test, r.Hits, r.Count, r.Expected, r.Estimate,
+		r.Low, r.High, r.P, r.Rho,
+	)
+	return e
+}
+
+// The hits and counts of each group, with their expected fractions from a
+// column, or a constant if there is no column, and the Overdispersion of
+// the control rows
+type binomAccumulator struct {
+	hitcol int
+	countcol int
+	expcol int
+	p float64
+	groupcols []int
+	controlcol int
+	controlLevel string
+	maxcol int
+	groups map[string]*BinomialSummary
+	control Overdispersion
+}
+
+func newBinomAccumulator(hitcol, countcol, expcol int, p float64, groupcols []int, controlcol int, control string) *binomAccumulator {
+	maxcol := hitcol
+	for _, col := range append([]int{countcol, expcol, controlcol}, groupcols...) {
+		if col > maxcol {
+			maxcol = col
+		}
+	}
+	return &binomAccumulator{
+		hitcol: hitcol,
+		countcol: countcol,
+		expcol: expcol,
+		p: p,
+		groupcols: groupcols,
+		controlcol: controlcol,
+		controlLevel: control,
+		maxcol: maxcol,
+		groups: map[string]*BinomialSummary{},
+	}
+}
+
+// Parse the hits, count and expected fraction of a row, rejecting it if
+// any is missing or out of range
+func (a *binomAccumulator) parse(rows *RowStage, cr *csv.Reader, line []string) (hits, count, expected float64, ok bool, err error) {
+	if len(line) <= a.maxcol {
+		return 0, 0, 0, false, rows.RejectCsv(ShortLine, cr, line)
+	}
+
+	hits, ok, e := rows.CsvFloat(cr, line, a.hitcol)
+	if e != nil || !ok { return 0, 0, 0, false, e }
+	count, ok, e = rows.CsvFloat(cr, line, a.countcol)
+	if e != nil || !ok { return 0, 0, 0, false, e }
+	if hits < 0 || hits > count {
+		return 0, 0, 0, false, rows.RejectCsv(BadCount, cr, line)
+	}
+
+	expected = a.p
+	if a.expcol >= 0 {
+		expected, ok, e = rows.CsvFloat(cr, line, a.expcol)
+		if e != nil || !ok { return 0, 0, 0, false, e }
+		if expected < 0 || expected > 1 {
+			return 0, 0, 0, false, rows.RejectCsv(BadFraction, cr, line)
+		}
+	}
+	rows.Keep()
+	return hits, count, expected, true, nil
+}
+
+func (a *binomAccumulator) Row(rows *RowStage, cr *csv.Reader, line []string) error {
+	hits, count, expected, ok, e := a.parse(rows, cr, line)
+	if e != nil || !ok { return e }
+
+	group := groupKey(line, a.groupcols)
+	s, ok := a.groups[group]
+	if !ok {
+		s = &BinomialSummary{}
+		a.groups[group] = s
+	}
+	s.Add(hits, count, expected)
+
+	if a.controlcol >= 0 && line[a.controlcol] == a.controlLevel {
+		a.control.Add(hits, count, expected)
+	}
+	return nil
+}
+
+func (a *binomAccumulator) Empty() RowAccumulator {
+	out := *a
+	out.groups = map[string]*BinomialSummary{}
+	out.control = Overdispersion{}
+	return &out
+}
+
+func (a *binomAccumulator) Merge(o RowAccumulator) error {
+	oa := o.(*binomAccumulator)
+	for group, os := range oa.groups {
+		s, ok := a.groups[group]
+		if !ok {
+			s = &BinomialSummary{}
+			a.groups[group] = s
+		}
+		s.Merge(os)
+	}
+	a.control.Merge(&oa.control)
+	return nil
+}
+
+// The BetaBinomialSummary of each group, for rows with intra-class
+// correlation rho. Rows with a count of 0, or an expected fraction of 0 or
+// 1, say nothing about a shift in the fraction and are left out. The
+// information of each count and expected fraction is found once.
+type betaBinomAccumulator struct {
+	cols *binomAccumulator
+	rho float64
+	groups map[string]*BetaBinomialSummary
+	info map[[2]float64]float64
+}
+
+func newBetaBinomAccumulator(cols *binomAccumulator, rho float64) *betaBinomAccumulator {
+	return &betaBinomAccumulator{
+		cols: cols,
+		rho: rho,
+		groups: map[string]*BetaBinomialSummary{},
+		info: map[[2]float64]float64{},
+	}
+}
+
+func (a *betaBinomAccumulator) Row(rows *RowStage, cr *csv.Reader, line []string) error {
+	hits, count, expected, ok, e := a.cols.parse(rows, cr, line)
+	if e != nil || !ok { return e }
+
+	group := groupKey(line, a.cols.groupcols)
+	s, ok := a.groups[group]
+	if !ok {
+		s = &BetaBinomialSummary{}
+		a.groups[group] = s
+	}
+	hits, count = math.Round(hits), math.Round(count)
+	if count == 0 || !(expected > 0 && expected < 1) {
+		return nil
+	}
+
+	d := newBetaBinomial(expected, a.rho)
+	key := [2]float64{count, expected}
+	info, ok := a.info[key]
+	if !ok {
+		info = d.Info(count)
+		a.info[key] = info
+	}
+	s.Add(d.Score(hits, count), info)
+	return nil
+}
+
+func (a *betaBinomAccumulator) Empty() RowAccumulator {
+	return newBetaBinomAccumulator(a.cols, a.rho)
+}
+
+func (a *betaBinomAccumulator) Merge(o RowAccumulator) error {
+	for group, os := range o.(*betaBinomAccumulator).groups {
+		s, ok := a.groups[group]
+		if !ok {
+			s = &BetaBinomialSummary{}
+			a.groups[group] = s
+		}
+		s.Merge(os)
+	}
+	return nil
+}
+
+// Sum hitcolname and countcolname for each group of groupcolnames, or "all"
+// if there are none, and test each sum against the count weighted mean of
+// expcolname, or against p if expcolname is "". If controlcolname is not "",
+// each group also gets a BetaBinomialTest, with the overdispersion estimated
+// from the rows that are control in controlcolname, which takes a second
+// pass.
+func RunBinomialTest(rcm ReadCloserMaker, w io.Writer, hitcolname, countcolname, expcolname string, p float64, controlcolname, control string, groupcolnames []string, conf float64, ro *RowOptions) error {
+	h := handle("RunBinomialTest: %w")
+
+	if !(conf > 0 && conf < 1) {
+		return h(fmt.Errorf("conf %v is not between 0 and 1", conf))
+	}
+
+	hitcol, countcol, e := HitCountCols(rcm, hitcolname, countcolname)
+	if e != nil { return h(e) }
+
+	expcol := -1
+	if expcolname != "" {
+		expcol, e = ValCol(rcm, expcolname)
+		if e != nil { return h(e) }
+	} else if !(p >= 0 && p <= 1) {
+		return h(fmt.Errorf("expected fraction %v is not between 0 and 1", p))
+	}
+
+	controlcol := -1
+	if controlcolname != "" {
+		controlcol, e = ValCol(rcm, controlcolname)
+		if e != nil { return h(e) }
+	}
+
+	groupcols, e := IdCols(rcm, groupcolnames)
+	if e != nil { return h(e) }
+
+	acc := newBinomAccumulator(hitcol, countcol, expcol, p, groupcols, controlcol, control)
+	if e := rowPass(rcm, "BinomialTest", acc, ro); e != nil { return h(e) }
+
+	rho := acc.control.Rho()
+	var bacc *betaBinomAccumulator
+	if controlcol >= 0 {
+		if math.IsNaN(rho) {
+			return h(fmt.Errorf("no %q rows in %v with a count of at least 2 to estimate overdispersion from", control, controlcolname))
+		}
+		bacc = newBetaBinomAccumulator(acc, rho)
+		if e := rowPass(rcm, "BetaBinomialTest", bacc, ro); e != nil { return h(e) }
+	}
+
+	counts := map[string]float64{}
+	for group, s := range acc.groups {
+		counts[group] = s.Count
+	}
+	for _, group := range sortedGroups(counts) {
+		name := groupName(group)
+		if len(groupcols) == 0 {
+			name = "all"
+		}
+		s := acc.groups[group]
+		if e := WriteBinomialResult(w, CalcBinomialTest(name, s, conf)); e != nil { return h(e) }
+		if bacc != nil {
+			if e := WriteBinomialResult(w, CalcBetaBinomialTest(name, s, bacc.groups[group], rho, conf)); e != nil { return h(e) }
+		}
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package spstat
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The two-sided binomial P value by summing every count no more likely
+// than x
+func bruteBinomialP(x, n, p float64) float64 {
+	limit := binomLogProb(x, n, p) + math.Log(1 + 1e-7)
+	sum := 0.0
+	for k := 0.0; k <= n; k++ {
+		if lp := binomLogProb(k, n, p); lp <= limit {
+			sum += math.Exp(lp)
+		}
+	}
+	return math.Min(1, sum)
+}
+
+func TestBinomialTestP(t *testing.T) {
+	// From R's binom.test(c(682, 243), p = 3/4)
+	if got := BinomialTestP(682, 925, 0.75); !closeTo(got, 0.3825, 1e-4) {
+		t.Errorf("p %v, want 0.3825", got)
+	}
+	low, high := ClopperPearson(682, 925, 0.95)
+	if !closeTo(low, 0.7076683, 1e-6) || !closeTo(high, 0.7654066, 1e-6) {
+		t.Errorf("interval %v to %v, want 0.7076683 to 0.7654066", low, high)
+	}
+
+	for _, c := range [][3]float64{{7, 20, 0.5}, {13, 20, 0.5}, {3, 40, 0.2}, {15, 40, 0.2}, {8, 40, 0.2}, {0, 12, 0.3}, {12, 12, 0.3}, {510, 1000, 0.52}} {
+		got, want := BinomialTestP(c[0], c[1], c[2]), bruteBinomialP(c[0], c[1], c[2])
+		if !closeTo(got, want, 1e-10) {
+			t.Errorf("%v: p %v, want %v", c, got, want)
+		}
+	}
+
+	if low, high := ClopperPearson(0, 10, 0.95); low != 0 || !closeTo(high, 1 - math.Pow(0.025, 0.1), 1e-10) {
+		t.Errorf("interval of 0 of 10 %v to %v", low, high)
+	}
+}
+
+func TestOverdispersion(t *testing.T) {
+	// Beta-binomial rows of 20 from a Pólya urn with alpha = beta = 4.5, so
+	// rho = 1 / (alpha + beta + 1) = 0.1
+	r := rand.New(rand.NewSource(1))
+	var o Overdispersion
+	for i := 0; i < 20000; i++ {
+		a, b := 4.5, 4.5
+		hits := 0.0
+		for j := 0; j < 20; j++ {
+			if r.Float64() < a / (a + b) {
+				hits++
+				a++
+			} else {
+				b++
+			}
+		}
+		o.Add(hits, 20, 0.5)
+	}
+	if rho := o.Rho(); !closeTo(rho, 0.1, 0.01) {
+		t.Errorf("rho %v, want 0.1", rho)
+	}
+
+	var none Overdispersion
+	none.Add(1, 1, 0.5)
+	if !math.IsNaN(none.Rho()) {
+		t.Errorf("rho of no rows %v", none.Rho())
+	}
+}
+
+// The beta-binomial log probability from log gamma functions
+func betaBinomialLogProb(x, n, p, rho float64) float64 {
+	theta := (1 - rho) / rho
+	a, b := p * theta, (1 - p) * theta
+	lbeta := func(a, b float64) float64 {
+		la, _ := math.Lgamma(a)
+		lb, _ := math.Lgamma(b)
+		lab, _ := math.Lgamma(a + b)
+		return la + lb - lab
+	}
+	return lchoose(n, x) + lbeta(x + a, n - x + b) - lbeta(a, b)
+}
+
+func TestBetaBinomial(t *testing.T) {
+	for _, c := range [][3]float64{{10, 0.5, 0.1}, {25, 0.55, 0.3}, {7, 0.2, 0.02}, {40, 0.58, 0.6}} {
+		n, p, rho := c[0], c[1], c[2]
+		d := newBetaBinomial(p, rho)
+		total, mean, info := 0.0, 0.0, 0.0
+		for x := 0.0; x <= n; x++ {
+			lp := d.LogProb(x, n)
+			if want := betaBinomialLogProb(x, n, p, rho); !closeTo(lp, want, 1e-9) {
+				t.Errorf("%v: log probability of %v %v, want %v", c, x, lp, want)
+			}
+			step := 1e-6
+			deriv := (betaBinomialLogProb(x, n, p + step, rho) - betaBinomialLogProb(x, n, p - step, rho)) / (2 * step)
+			score := d.Score(x, n)
+			if !closeTo(score, deriv, 1e-5 * math.Max(1, math.Abs(deriv))) {
+				t.Errorf("%v: score of %v %v, want %v", c, x, score, deriv)
+			}
+			total += math.Exp(lp)
+			mean += math.Exp(lp) * score
+			info += math.Exp(lp) * score * score
+		}
+		if !closeTo(total, 1, 1e-12) || !closeTo(mean, 0, 1e-9) || !closeTo(d.Info(n), info, 1e-9 * info) {
+			t.Errorf("%v: total %v, mean score %v, information %v, want %v", c, total, mean, d.Info(n), info)
+		}
+		// The information falls from the binomial's as rho grows
+		if binom := n / (p * (1 - p)); !(d.Info(n) < binom) {
+			t.Errorf("%v: information %v, binomial %v", c, d.Info(n), binom)
+		}
+	}
+
+	// rho 0 is the binomial
+	d := newBetaBinomial(0.3, 0)
+	if !closeTo(d.Score(5, 10), (5 - 3) / 0.21, 1e-12) || !closeTo(d.Info(10), 10 / 0.21, 1e-9) || !closeTo(d.LogProb(5, 10), binomLogProb(5, 10, 0.3), 1e-12) {
+		t.Errorf("rho 0: score %v, information %v", d.Score(5, 10), d.Info(10))
+	}
+}
+
+// Groups of beta-binomial rows that follow their expectations: the
+// beta-binomial test rejects about as often as it should, and the binomial
+// test far more often
+func TestBetaBinomialTestSize(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	rho := 0.1
+	var control Overdispersion
+	type row struct{ hits, count, p float64 }
+	groups := make([][]row, 400)
+	for i := range groups {
+		for j := 0; j < 50; j++ {
+			count, p := float64(5 + r.Intn(30)), 0.5 + 0.08 * float64(j % 2)
+			// A Pólya urn with a + b = (1 - rho) / rho
+			a, b := p * (1 - rho) / rho, (1 - p) * (1 - rho) / rho
+			hits := 0.0
+			for k := 0.0; k < count; k++ {
+				if r.Float64() < a / (a + b) {
+					hits++
+					a++
+				} else {
+					b++
+				}
+			}
+			groups[i] = append(groups[i], row{hits, count, p})
+			control.Add(hits, count, p)
+		}
+	}
+	est := control.Rho()
+	if !closeTo(est, rho, 0.02) {
+		t.Errorf("rho %v, want %v", est, rho)
+	}
+
+	binomial, beta := 0, 0
+	for i, g := range groups {
+		var s BinomialSummary
+		var b BetaBinomialSummary
+		for _, row := range g {
+			s.Add(row.hits, row.count, row.p)
+			d := newBetaBinomial(row.p, est)
+			b.Add(d.Score(row.hits, row.count), d.Info(row.count))
+		}
+		if CalcBinomialTest("g", &s, 0.95).P < 0.05 {
+			binomial++
+		}
+		r := CalcBetaBinomialTest("g", &s, &b, est, 0.95)
+		if r.P < 0.05 {
+			beta++
+		}
+		if !(r.Low <= r.Estimate && r.Estimate <= r.High) {
+			t.Errorf("group %v: estimate %v outside %v to %v", i, r.Estimate, r.Low, r.High)
+		}
+	}
+	if beta < 8 || beta > 36 || binomial < 2 * beta {
+		t.Errorf("%v of 400 groups rejected by the beta-binomial test, %v by the binomial", beta, binomial)
+	}
+}
+
+func TestRunBinomialTest(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("tissue\tindiv\thits\tcount\texpected\n")
+	for i := 0; i < 400; i++ {
+		tissue, hits := "blood", 2 + 6 * (i % 2)
+		if i % 4 >= 2 {
+			tissue, hits = "sperm", 6
+		}
+		fmt.Fprintf(&b, "%v\ti%v\t%v\t10\t0.5\n", tissue, i % 3, hits)
+	}
+	b.WriteString("sperm\ti0\t5\t10\tNA\n")
+	b.WriteString("sperm\ti0\t5\t10\t1.5\n")
+	rcm := stringTable(b.String())
+
+	var outs []string
+	serial, parallel := serialAndParallel(t, func(ro *RowOptions) {
+		var out bytes.Buffer
+		if e := RunBinomialTest(rcm, &out, "hits", "count", "expected", 0, "tissue", "blood", []string{"tissue"}, 0.95, ro); e != nil { t.Fatal(e) }
+		outs = append(outs, out.String())
+	})
+	if serial != parallel || !strings.Contains(serial, "fraction not between 0 and 1") || !strings.Contains(serial, "missing value") || !strings.Contains(serial, "BetaBinomialTest\t") {
+		t.Errorf("rejects %q and %q", serial, parallel)
+	}
+	// rho may differ in its last digits, and the beta-binomial test with it
+	trimRho := func(out string) string {
+		return regexp.MustCompile(`beta-binomial\t.*\n`).ReplaceAllString(out, "beta-binomial\n")
+	}
+	if trimRho(outs[0]) != trimRho(outs[1]) {
+		t.Errorf("serial and parallel results differ:\n%v\n%v", outs[0], outs[1])
+	}
+
+	lines := strings.Split(strings.TrimSpace(outs[0]), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("output %q", outs[0])
+	}
+	var fields [][]float64
+	for i, line := range lines {
+		f := strings.Split(line, "\t")
+		if want := []string{"blood", "sperm"}[i / 2]; f[0] != want || f[1] != []string{"binomial", "beta-binomial"}[i % 2] {
+			t.Errorf("line %v %q", i, line)
+		}
+		var vals []float64
+		for _, v := range f[2:] {
+			x, _ := strconv.ParseFloat(v, 64)
+			vals = append(vals, x)
+		}
+		fields = append(fields, vals)
+	}
+	// Columns from 2: hits, count, expected, estimate, low, high, p, rho.
+	// Each blood row is 3 hits from its expectation of 5, a squared
+	// residual of 3.6, so rho is 2.6 / 9.
+	rho := 2.6 / 9
+	if fields[2][0] != 1200 || fields[2][1] != 2000 || fields[2][3] != 0.6 || fields[2][7] != 0 || !closeTo(fields[3][7], rho, 1e-12) {
+		t.Errorf("sperm binomial %v, beta-binomial %v", fields[2], fields[3])
+	}
+	if !closeTo(fields[2][6], BinomialTestP(1200, 2000, 0.5), 1e-12) {
+		t.Errorf("binomial p %v", fields[2][6])
+	}
+
+	// The blood rows' scores cancel, and the sperm rows are 200 rows of 6
+	// of 10
+	if fields[1][3] != 0.5 || fields[1][6] != 1 {
+		t.Errorf("blood beta-binomial %v", fields[1])
+	}
+	step := 1e-6
+	score := 200 * (betaBinomialLogProb(6, 10, 0.5 + step, rho) - betaBinomialLogProb(6, 10, 0.5 - step, rho)) / (2 * step)
+	info := 0.0
+	for x := 0.0; x <= 10; x++ {
+		s := (betaBinomialLogProb(x, 10, 0.5 + step, rho) - betaBinomialLogProb(x, 10, 0.5 - step, rho)) / (2 * step)
+		info += 200 * math.Exp(betaBinomialLogProb(x, 10, 0.5, rho)) * s * s
+	}
+	se := 1 / math.Sqrt(info)
+	wantp := 2 * (1 - 0.5 * math.Erfc(-score * se / math.Sqrt2))
+	if beta := fields[3]; !closeTo(beta[6], wantp, 1e-6 * wantp) || !closeTo(beta[3], 0.5 + score / info, 1e-7) || !closeTo(beta[5] - beta[4], 2 * 1.959964 * se, 1e-6) {
+		t.Errorf("sperm beta-binomial %v, want p %v, estimate %v", beta, wantp, 0.5 + score / info)
+	}
+	if !(fields[3][6] > fields[2][6]) {
+		t.Errorf("beta-binomial p %v not above binomial p %v", fields[3][6], fields[2][6])
+	}
+
+	// The control rows can be marked with any value
+	ro := DefaultRowOptions()
+	relabeled := stringTable(strings.ReplaceAll(string(rcm), "blood", "ctl"))
+	var out bytes.Buffer
+	if e := RunBinomialTest(relabeled, &out, "hits", "count", "expected", 0, "tissue", "ctl", []string{"tissue"}, 0.95, ro); e != nil { t.Fatal(e) }
+	if out.String() != strings.ReplaceAll(outs[0], "blood", "ctl") {
+		t.Errorf("control ctl:\n%v", out.String())
+	}
+	if e := RunBinomialTest(relabeled, &out, "hits", "count", "expected", 0, "tissue", "blood", []string{"tissue"}, 0.95, ro); e == nil || !strings.Contains(e.Error(), `no "blood" rows in tissue`) {
+		t.Errorf("no control rows: %v", e)
+	}
+
+	// Groups whose values joined by "_" match are still apart
+	collide := stringTable("g1\tg2\thits\tcount\na_b\tc\t1\t10\na\tb_c\t9\t10\n")
+	out.Reset()
+	if e := RunBinomialTest(collide, &out, "hits", "count", "", 0.5, "", "", []string{"g1", "g2"}, 0.95, ro); e != nil { t.Fatal(e) }
+	lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "a_b_c\tbinomial\t9\t") || !strings.HasPrefix(lines[1], "a_b_c\tbinomial\t1\t") {
+		t.Errorf("colliding groups:\n%v", out.String())
+	}
+}
@@ -2,29 +2,28 @@ package spstat
 
 import (
 	"github.com/jgbaldwinbrown/csvh"
-	"fmt"
-	"strconv"
+	"math"
 	"io"
 	"encoding/csv"
 )
 
 // Return line[valcol] - line[tosubcol].
-func SubOne(line []string, valcol, tosubcol int) (float64, error) {
+func SubOne(line []string, valcol, tosubcol int, values *ValueParser) (float64, error) {
 	h := handle("SubOne: %w")
 
-	if len(line) <= valcol { return 0, h(fmt.Errorf("line too short")) }
-	val, e := strconv.ParseFloat(line[valcol], 64)
+	if len(line) <= valcol { return 0, h(ErrShortLine) }
+	val, e := values.Parse(line[valcol])
 	if e != nil { return 0, h(e) }
 
-	if len(line) <= tosubcol { return 0, h(fmt.Errorf("line too short")) }
-	tosub, e := strconv.ParseFloat(line[tosubcol], 64)
+	if len(line) <= tosubcol { return 0, h(ErrShortLine) }
+	tosub, e := values.Parse(line[tosubcol])
 	if e != nil { return 0, h(e) }
 
 	return val - tosub, nil
 }
 
 // For a tab separated table, append a new column containing line[valcol] - line[tosubcol].
-func ColSub(rcm ReadCloserMaker, w io.Writer, valcol, tosubcol int) error {
+func ColSub(rcm ReadCloserMaker, w io.Writer, valcol, tosubcol int, ro *RowOptions) error {
 	h := handle("RunColSub: %w")
 
 	r, e := rcm.NewReadCloser()
@@ -42,21 +41,33 @@ func ColSub(rcm ReadCloserMaker, w io.Writer, valcol, tosubcol int) error {
 	e = cw.Write(line)
 	if e != nil { return h(e) }
 
+	rows := ro.Stage("ColSub")
+	defer rows.Done()
+
 	for line, e := cr.Read(); e != io.EOF; line, e = cr.Read() {
 		if e != nil { return h(e) }
 
-		subbed, e := SubOne(line, valcol, tosubcol)
-		if e != nil { continue }
-		line = append(line, fmt.Sprintf("%f", subbed))
+		subbed, e := SubOne(line, valcol, tosubcol, ro.Values)
+		if e != nil && !isMissingValueError(e) {
+			if e := rows.RejectCsvErr(e, cr, line); e != nil { return h(e) }
+			continue
+		}
+		rows.Keep()
+		if e != nil {
+			subbed = math.NaN()
+		}
+		line = append(line, ro.Values.Format(subbed, formatFixed))
 		e = cw.Write(line)
-		if e != nil { continue }
+		if e != nil { return h(e) }
 	}
 
+	cw.Flush()
+	if e := cw.Error(); e != nil { return h(e) }
 	return nil
 }
 
 // Run the whole column subtraction pipeline.
-func RunColSub(rcm ReadCloserMaker, w io.Writer, valcolname, tosubcolname string) error {
+func RunColSub(rcm ReadCloserMaker, w io.Writer, valcolname, tosubcolname string, ro *RowOptions) error {
 	h := handle("RunColSub: %w")
 
 	valcol, e := ValCol(rcm, valcolname)
@@ -65,7 +76,7 @@ func RunColSub(rcm ReadCloserMaker, w io.Writer, valcolname, tosubcolname string
 	tosubcol, e := ValCol(rcm, tosubcolname)
 	if e != nil { return h(e) }
 
-	e = ColSub(rcm, w, valcol, tosubcol)
+	e = ColSub(rcm, w, valcol, tosubcol, ro)
 	if e != nil { return h(e) }
 
 	return nil
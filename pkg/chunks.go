@@ -0,0 +1,246 @@
+package spstat
+
+import (
+	"github.com/jgbaldwinbrown/csvh"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// How passes over text tables are split among goroutines
+type ChunkOptions struct {
+	// Goroutines parsing rows: 1 reads serially, and 0 means one per core
+	Threads int
+	// Bytes of rows in each chunk, rounded up to the end of a line
+	Size int
+}
+
+// Serial passes, split into chunks of 4 MiB if Threads is raised
+func DefaultChunkOptions() ChunkOptions {
+	return ChunkOptions{Threads: 1, Size: 4 << 20}
+}
+
+func (o *ChunkOptions) threads() int {
+	if o.Threads < 1 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return o.Threads
+}
+
+// Check whether passes should be split among goroutines
+func (o *ChunkOptions) Parallel() bool {
+	return o.threads() > 1
+}
+
+// The statistics gathered, or the output written, by one pass over the rows
+// of a table. The rows can be split into chunks, each gathered separately
+// and then merged in order.
+type RowAccumulator interface {
+	// Add one row, counting it in rows
+	Row(rows *RowStage, cr *csv.Reader, line []string) error
+	// An empty accumulator for the same columns
+	Empty() RowAccumulator
+	// Add the rows of o, which came from Empty, to this accumulator
+	Merge(o RowAccumulator) error
+}
+
+// An accumulator that counts its rows in stages of its own, rather than the
+// stage of the pass. addChunk adds the counts of c, from Empty, to its
+// stages, given that c's chunk starts after line offset.
+type stagedAccumulator interface {
+	addChunk(c RowAccumulator, offset int) error
+}
+
+// An accumulator whose statistics depend on random coins, like quantile
+// sketches. seedChunk seeds it, from Empty, for chunk n of the pass, counting
+// from 1, so that each chunk flips its own coins but a run is repeatable
+// however many threads read it.
+type seededAccumulator interface {
+	seedChunk(n int)
+}
+
+// Add every row left in cr to acc
+func eachRow(cr *csv.Reader, rows *RowStage, acc RowAccumulator) error {
+	for line, e := cr.Read(); e != io.EOF; line, e = cr.Read() {
+		if e != nil { return e }
+		if e := acc.Row(rows, cr, line); e != nil { return e }
+	}
+	return nil
+}
+
+// Add every row of rcm after the header to acc, counting rows under stage of
+// ro. If ro.Chunks.Parallel(), the rows are split into chunks of about
+// ro.Chunks.Size bytes, each chunk is added to its own empty accumulator on
+// one of ro.Chunks.Threads goroutines, and the chunks are merged into acc in
+// order. Rejected rows are reported in order with their true line numbers
+// either way.
+func rowPass(rcm ReadCloserMaker, stage string, acc RowAccumulator, ro *RowOptions) error {
+	h := handle("rowPass: %w")
+
+	r, e := rcm.NewReadCloser()
+	if e != nil { return h(e) }
+	defer r.Close()
+
+	rows := ro.Stage(stage)
+	defer rows.Done()
+
+	if !ro.Chunks.Parallel() {
+		cr := csvh.CsvIn(r)
+		if e := skipHeader(cr); e != nil { return h(e) }
+		if e := eachRow(cr, rows, acc); e != nil { return h(e) }
+		return nil
+	}
+
+	if e := chunkPass(r, rows, acc, ro.Chunks); e != nil { return h(e) }
+	return nil
+}
+
+// One chunk of whole rows, from reading through accumulating
+type rowChunk struct {
+	data []byte
+	acc RowAccumulator
+	rows *RowStage
+	lines int
+	err error
+	done chan struct{}
+}
+
+// Accumulate the rows of one chunk
+func (c *rowChunk) run() {
+	defer close(c.done)
+
+	c.lines = bytes.Count(c.data, []byte{'\n'})
+	if len(c.data) > 0 && c.data[len(c.data)-1] != '\n' {
+		c.lines++
+	}
+
+	cr := csvh.CsvIn(bytes.NewReader(c.data))
+	c.err = eachRow(cr, c.rows, c.acc)
+}
+
+// Split r, after its header, into chunks on one goroutine, accumulate them
+// on a pool of others, and merge them into acc in order on this one.
+func chunkPass(r io.Reader, rows *RowStage, acc RowAccumulator, o ChunkOptions) error {
+	threads := o.threads()
+	order := make(chan *rowChunk, threads * 2)
+	work := make(chan *rowChunk, threads * 2)
+	quit := make(chan struct{})
+	defer close(quit)
+
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range work {
+				c.run()
+			}
+		}()
+	}
+
+	go func() {
+		defer close(order)
+		defer close(work)
+		n := 0
+		e := splitChunks(r, o.Size, func(data []byte) bool {
+			n++
+			c := &rowChunk{data: data, acc: acc.Empty(), rows: rows.chunkStage(), done: make(chan struct{})}
+			if sa, ok := c.acc.(seededAccumulator); ok {
+				sa.seedChunk(n)
+			}
+			select {
+			case work <- c:
+			case <-quit:
+				return false
+			}
+			select {
+			case order <- c:
+			case <-quit:
+				return false
+			}
+			return true
+		})
+		if e != nil {
+			c := &rowChunk{err: e, done: make(chan struct{})}
+			close(c.done)
+			select {
+			case order <- c:
+			case <-quit:
+			}
+		}
+	}()
+
+	// The header is line 1
+	offset := 1
+	for c := range order {
+		<-c.done
+		if e := rows.addChunk(c.rows, offset); e != nil { return e }
+		if sa, ok := acc.(stagedAccumulator); ok {
+			if e := sa.addChunk(c.acc, offset); e != nil { return e }
+		}
+		if c.err != nil { return chunkError(c.err, rows.counts.Stage, offset) }
+		if e := acc.Merge(c.acc); e != nil { return e }
+		offset += c.lines
+	}
+	wg.Wait()
+	return nil
+}
+
+// Give the error from a chunk that starts after line offset its true line
+// number
+func chunkError(e error, stage string, offset int) error {
+	var held *heldError
+	if errors.As(e, &held) {
+		return fmt.Errorf("%v: line %v: %w", stage, offset + held.line, held.err)
+	}
+	var pe *csv.ParseError
+	if errors.As(e, &pe) {
+		shifted := *pe
+		shifted.StartLine += offset
+		shifted.Line += offset
+		return &shifted
+	}
+	return e
+}
+
+// Skip the header line of r, then call send with each chunk of whole lines
+// of at least size bytes, until r ends or send returns false. The last chunk
+// may be shorter, and may not end with a newline.
+func splitChunks(r io.Reader, size int, send func([]byte) bool) error {
+	br := bufio.NewReaderSize(r, 1 << 16)
+	if _, e := br.ReadSlice('\n'); e != nil {
+		for e == bufio.ErrBufferFull {
+			_, e = br.ReadSlice('\n')
+		}
+		if e == io.EOF { return nil }
+		if e != nil { return e }
+	}
+
+	var carry []byte
+	for {
+		buf := make([]byte, len(carry) + size)
+		copy(buf, carry)
+		n, e := io.ReadFull(br, buf[len(carry):])
+		buf = buf[:len(carry) + n]
+		if e == io.EOF || e == io.ErrUnexpectedEOF {
+			if len(buf) > 0 {
+				send(buf)
+			}
+			return nil
+		}
+		if e != nil { return e }
+
+		cut := bytes.LastIndexByte(buf, '\n') + 1
+		if cut == 0 {
+			carry = buf
+			continue
+		}
+		carry = append([]byte(nil), buf[cut:]...)
+		if !send(buf[:cut]) { return nil }
+	}
+}
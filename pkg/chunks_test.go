@@ -0,0 +1,112 @@
+package spstat
+
+import (
+	"bytes"
+	"io"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// A ReadCloserMaker for a table held in memory
+type stringTable string
+
+func (s stringTable) NewReadCloser() (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(string(s))), nil
+}
+
+func chunkTestTable() stringTable {
+	var b strings.Builder
+	b.WriteString("tissue\tgroup\tvalue\tx\n")
+	for i := 0; i < 2000; i++ {
+		tissue := "blood"
+		if i % 3 == 0 {
+			tissue = "sperm"
+		}
+		value := fmt.Sprint(float64(i % 17) / 17)
+		if i % 101 == 0 {
+			value = "oops"
+		} else if i % 151 == 0 {
+			value = "NA"
+		}
+		fmt.Fprintf(&b, "%v\tg%v\t%v\t%v\n", tissue, i % 7, value, i % 23)
+	}
+	return stringTable(b.String())
+}
+
+// Run f serially, then in small chunks on several goroutines, and return the
+// rejected rows written each time
+func serialAndParallel(t *testing.T, f func(ro *RowOptions)) (serial, parallel string) {
+	for i, threads := range []int{1, 4} {
+		var b bytes.Buffer
+		ro := DefaultRowOptions()
+		ro.Rows.Rejects = &b
+		ro.Chunks.Threads = threads
+		ro.Chunks.Size = 512
+		f(ro)
+		if e := ro.Rows.Flush(); e != nil { t.Fatal(e) }
+		if i == 0 {
+			serial = b.String()
+		} else {
+			parallel = b.String()
+		}
+	}
+	return serial, parallel
+}
+
+func TestChunksMatchSerial(t *testing.T) {
+	rcm := chunkTestTable()
+
+	var tsums [2][]*TSummary
+	var means [2][]*NamedValSet
+	var models [2]*LinearModeler
+	run := 0
+	serial, parallel := serialAndParallel(t, func(ro *RowOptions) {
+		var e error
+		tsums[run], _, e = CalcTSummary(rcm, 2, []string{"tissue", "group"}, []int{0, 1}, 0, 1, ro)
+		if e != nil { t.Fatal(e) }
+		means[run], e = CalcMeans(rcm, 2, []string{"group"}, []int{1}, ro)
+		if e != nil { t.Fatal(e) }
+		models[run], e = FitLinearModel(rcm, 2, 3, ro)
+		if e != nil { t.Fatal(e) }
+		run++
+	})
+
+	if serial != parallel {
+		t.Errorf("rejects differ:\n%v\n%v", serial, parallel)
+	}
+	if !strings.Contains(serial, "CalcTSummary\t103\tunparseable value") {
+		t.Errorf("missing reject of line 103:\n%v", serial)
+	}
+
+	for i, tsum := range tsums[0] {
+		for id, count := range tsum.Counts {
+			p := tsums[1][i]
+			if p.Counts[id] != count || !closeTo(p.Mean(id), tsum.Mean(id), 1e-12) || !closeTo(p.SampleVar(id), tsum.SampleVar(id), 1e-12) {
+				t.Errorf("%v %v: parallel %v, %v, %v != serial %v, %v, %v", tsum.ColName, id,
+					p.Counts[id], p.Mean(id), p.SampleVar(id), count, tsum.Mean(id), tsum.SampleVar(id))
+			}
+		}
+	}
+	for id, sum := range means[0][0].Sums {
+		if !closeTo(means[1][0].Sums[id], sum, 1e-12) || means[1][0].Counts[id] != means[0][0].Counts[id] {
+			t.Errorf("means %v differ", id)
+		}
+	}
+	m0, b0 := models[0].MB()
+	m1, b1 := models[1].MB()
+	if models[0].Count != models[1].Count || !closeTo(m1, m0, 1e-12) || !closeTo(b1, b0, 1e-12) {
+		t.Errorf("linear models differ: %v %v", *models[0], *models[1])
+	}
+}
+
+func TestChunksStrict(t *testing.T) {
+	ro := DefaultRowOptions()
+	ro.Rows.Strict = true
+	ro.Chunks.Threads = 4
+	ro.Chunks.Size = 512
+	_, e := FitLinearModel(chunkTestTable(), 2, 3, ro)
+	if e == nil || !strings.Contains(e.Error(), "line 2:") {
+		t.Errorf("strict error %v, want one at line 2", e)
+	}
+}
@@ -0,0 +1,643 @@
+package spstat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"github.com/jgbaldwinbrown/csvh"
+)
+
+// The columnar cache format. A file starts with ColumnarMagic and a version
+// byte, then the header: the number of columns, the number of header names,
+// and the name, type and float format of each column. Rows follow in groups.
+// Each group starts with its row count (0 ends the file) and a flag for
+// ragged rows, followed by the width of every row if the flag is set, then
+// each column in turn: 8 bytes per row for int and float columns, or a
+// dictionary of the group's distinct strings followed by one code per row
+// for string columns. Numbers are little-endian; counts, lengths and codes
+// are uvarints.
+var ColumnarMagic = []byte("SPCOL\x00")
+
+const columnarVersion = 1
+
+// Rows per group in a compiled table
+const ColumnarGroupRows = 1 << 16
+
+// The storage type of a column in a compiled table
+type ColumnType byte
+
+const (
+	ColumnString ColumnType = iota
+	ColumnInt
+	ColumnFloat
+)
+
+func (t ColumnType) String() string {
+	switch t {
+	case ColumnInt: return "int"
+	case ColumnFloat: return "float"
+	}
+	return "string"
+}
+
+// Which types every value of a column so far could be stored as, without
+// changing its text
+type columnGuess struct {
+	seen bool
+	isInt bool
+	isFloatG bool
+	isFloatF bool
+}
+
+func (g *columnGuess) add(s string) {
+	if !g.seen {
+		*g = columnGuess{true, true, true, true}
+	}
+	if g.isInt {
+		i, e := strconv.ParseInt(s, 10, 64)
+		g.isInt = e == nil && strconv.FormatInt(i, 10) == s
+	}
+	if g.isFloatG || g.isFloatF {
+		f, e := strconv.ParseFloat(s, 64)
+		g.isFloatG = g.isFloatG && e == nil && strconv.FormatFloat(f, 'g', -1, 64) == s
+		g.isFloatF = g.isFloatF && e == nil && strconv.FormatFloat(f, 'f', -1, 64) == s
+	}
+}
+
+// The type to store a column as, and its float format ('g' or 'f')
+func (g *columnGuess) typ() (ColumnType, byte) {
+	switch {
+	case !g.seen: return ColumnString, 0
+	case g.isInt: return ColumnInt, 0
+	case g.isFloatG: return ColumnFloat, 'g'
+	case g.isFloatF: return ColumnFloat, 'f'
+	}
+	return ColumnString, 0
+}
+
+// Read the whole table once to find its header, its widest row, and the
+// narrowest type that can hold each column exactly. A column's type is not
+// known until its last row, so this pass cannot be folded into the one that
+// writes the rows.
+func inferColumnTypes(rcm ReadCloserMaker) (names []string, types []ColumnType, formats []byte, err error) {
+	h := handle("inferColumnTypes: %w")
+
+	r, e := rcm.NewReadCloser()
+	if e != nil { return nil, nil, nil, h(e) }
+	defer r.Close()
+	cr := csvh.CsvIn(r)
+
+	line, e := cr.Read()
+	if e != nil { return nil, nil, nil, h(e) }
+	names = append([]string{}, line...)
+
+	var guesses []columnGuess
+	for line, e := cr.Read(); e != io.EOF; line, e = cr.Read() {
+		if e != nil { return nil, nil, nil, h(e) }
+		for len(guesses) < len(line) {
+			guesses = append(guesses, columnGuess{})
+		}
+		for i, field := range line {
+			guesses[i].add(field)
+		}
+	}
+
+	for len(guesses) < len(names) {
+		guesses = append(guesses, columnGuess{})
+	}
+	for i := range guesses {
+		t, f := guesses[i].typ()
+		types = append(types, t)
+		formats = append(formats, f)
+	}
+	return names, types, formats, nil
+}
+
+// Accumulates rows and writes them out in groups
+type columnarWriter struct {
+	w *bufio.Writer
+	types []ColumnType
+
+	rows int
+	widths []int
+	ragged bool
+	floats [][]float64
+	ints [][]int64
+	codes [][]uint32
+	dicts []map[string]uint32
+	dictLists [][]string
+
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (c *columnarWriter) uvarint(x uint64) error {
+	n := binary.PutUvarint(c.scratch[:], x)
+	_, e := c.w.Write(c.scratch[:n])
+	return e
+}
+
+func (c *columnarWriter) uint64le(x uint64) error {
+	binary.LittleEndian.PutUint64(c.scratch[:8], x)
+	_, e := c.w.Write(c.scratch[:8])
+	return e
+}
+
+func (c *columnarWriter) str(s string) error {
+	if e := c.uvarint(uint64(len(s))); e != nil {
+		return e
+	}
+	_, e := c.w.WriteString(s)
+	return e
+}
+
+func (c *columnarWriter) writeHeader(names []string, types []ColumnType, formats []byte) error {
+	if _, e := c.w.Write(ColumnarMagic); e != nil { return e }
+	if e := c.w.WriteByte(columnarVersion); e != nil { return e }
+	if e := c.uvarint(uint64(len(types))); e != nil { return e }
+	if e := c.uvarint(uint64(len(names))); e != nil { return e }
+	for i, t := range types {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		if e := c.str(name); e != nil { return e }
+		if e := c.w.WriteByte(byte(t)); e != nil { return e }
+		if e := c.w.WriteByte(formats[i]); e != nil { return e }
+	}
+	return nil
+}
+
+func (c *columnarWriter) reset() {
+	c.rows = 0
+	c.widths = c.widths[:0]
+	c.ragged = false
+	for i := range c.types {
+		c.floats[i] = c.floats[i][:0]
+		c.ints[i] = c.ints[i][:0]
+		c.codes[i] = c.codes[i][:0]
+		c.dicts[i] = map[string]uint32{}
+		c.dictLists[i] = c.dictLists[i][:0]
+	}
+}
+
+// Add one row. Types must already be known to fit every field.
+func (c *columnarWriter) add(line []string) error {
+	if len(line) > len(c.types) {
+		return fmt.Errorf("row of %v fields is wider than the %v columns found", len(line), len(c.types))
+	}
+	if len(line) != len(c.types) {
+		c.ragged = true
+	}
+	c.widths = append(c.widths, len(line))
+
+	for i, t := range c.types {
+		field := ""
+		if i < len(line) {
+			field = line[i]
+		}
+		switch t {
+		case ColumnInt:
+			v, _ := strconv.ParseInt(field, 10, 64)
+			c.ints[i] = append(c.ints[i], v)
+		case ColumnFloat:
+			v, _ := strconv.ParseFloat(field, 64)
+			c.floats[i] = append(c.floats[i], v)
+		default:
+			code, ok := c.dicts[i][field]
+			if !ok {
+				code = uint32(len(c.dictLists[i]))
+				c.dicts[i][field] = code
+				c.dictLists[i] = append(c.dictLists[i], field)
+			}
+			c.codes[i] = append(c.codes[i], code)
+		}
+	}
+
+	c.rows++
+	if c.rows >= ColumnarGroupRows {
+		return c.flush()
+	}
+	return nil
+}
+
+// Write out the current group, if it has any rows
+func (c *columnarWriter) flush() error {
+	if c.rows == 0 {
+		return nil
+	}
+	if e := c.uvarint(uint64(c.rows)); e != nil { return e }
+
+	if !c.ragged {
+		if e := c.w.WriteByte(0); e != nil { return e }
+	} else {
+		if e := c.w.WriteByte(1); e != nil { return e }
+		for _, w := range c.widths {
+			if e := c.uvarint(uint64(w)); e != nil { return e }
+		}
+	}
+
+	for i, t := range c.types {
+		switch t {
+		case ColumnInt:
+			for _, v := range c.ints[i] {
+				if e := c.uint64le(uint64(v)); e != nil { return e }
+			}
+		case ColumnFloat:
+			for _, v := range c.floats[i] {
+				if e := c.uint64le(math.Float64bits(v)); e != nil { return e }
+			}
+		default:
+			if e := c.uvarint(uint64(len(c.dictLists[i]))); e != nil { return e }
+			for _, s := range c.dictLists[i] {
+				if e := c.str(s); e != nil { return e }
+			}
+			for _, code := range c.codes[i] {
+				if e := c.uvarint(uint64(code)); e != nil { return e }
+			}
+		}
+	}
+
+	c.reset()
+	return nil
+}
+
+// Compile a tab-separated table into the columnar cache format. The table is
+// read twice: once to choose column types, and once to write the rows. A
+// compressed table is decompressed both times, and a one-shot stream such as
+// a Spool of stdin is first copied whole to a temporary file, so compiling
+// from stdin needs disk space for the decompressed table.
+func Compile(rcm ReadCloserMaker, w io.Writer) error {
+	h := handle("Compile: %w")
+
+	names, types, formats, e := inferColumnTypes(rcm)
+	if e != nil { return h(e) }
+
+	c := &columnarWriter{
+		w: bufio.NewWriter(w),
+		types: types,
+		floats: make([][]float64, len(types)),
+		ints: make([][]int64, len(types)),
+		codes: make([][]uint32, len(types)),
+		dicts: make([]map[string]uint32, len(types)),
+		dictLists: make([][]string, len(types)),
+	}
+	c.reset()
+
+	e = c.writeHeader(names, types, formats)
+	if e != nil { return h(e) }
+
+	r, e := rcm.NewReadCloser()
+	if e != nil { return h(e) }
+	defer r.Close()
+	cr := csvh.CsvIn(r)
+
+	_, e = cr.Read()
+	if e != nil { return h(e) }
+
+	for line, e := cr.Read(); e != io.EOF; line, e = cr.Read() {
+		if e != nil { return h(e) }
+		if e := c.add(line); e != nil { return h(e) }
+	}
+
+	if e := c.flush(); e != nil { return h(e) }
+	if e := c.uvarint(0); e != nil { return h(e) }
+	if e := c.w.Flush(); e != nil { return h(e) }
+	return nil
+}
+
+// One column of a ColumnarBatch. Only the slice matching Type is filled.
+type ColumnarColumn struct {
+	Type ColumnType
+	Format byte
+	Floats []float64
+	Ints []int64
+	Codes []uint32
+	Dict []string
+
+	dictFloats []float64
+	dictErrs []error
+}
+
+// The value in row i as text, exactly as it appeared in the original table
+func (c *ColumnarColumn) String(i int) string {
+	switch c.Type {
+	case ColumnInt: return strconv.FormatInt(c.Ints[i], 10)
+	case ColumnFloat: return strconv.FormatFloat(c.Floats[i], c.Format, -1, 64)
+	}
+	return c.Dict[c.Codes[i]]
+}
+
+// The value in row i as a float64, with the same result as values.Parse on
+// its text. String columns are parsed once per dictionary entry, so a column
+// must always be read with the same parser.
+func (c *ColumnarColumn) Float(i int, values *ValueParser) (float64, error) {
+	switch c.Type {
+	case ColumnInt: return float64(c.Ints[i]), nil
+	case ColumnFloat: return values.Check(c.Floats[i])
+	}
+	if c.dictFloats == nil {
+		c.dictFloats = make([]float64, len(c.Dict))
+		c.dictErrs = make([]error, len(c.Dict))
+		for j, s := range c.Dict {
+			c.dictFloats[j], c.dictErrs[j] = values.Parse(s)
+		}
+	}
+	code := c.Codes[i]
+	return c.dictFloats[code], c.dictErrs[code]
+}
+
+// One group of rows from a compiled table, stored by column
+type ColumnarBatch struct {
+	Rows int
+	// The number of fields in each row, or nil if every row is full width
+	Widths []int
+	Cols []ColumnarColumn
+}
+
+// The number of fields in row i
+func (b *ColumnarBatch) Width(i int) int {
+	if b.Widths == nil {
+		return len(b.Cols)
+	}
+	return b.Widths[i]
+}
+
+// The text fields of row i
+func (b *ColumnarBatch) Fields(i int) []string {
+	fields := make([]string, b.Width(i))
+	for j := range fields {
+		fields[j] = b.Cols[j].String(i)
+	}
+	return fields
+}
+
+// Reads a compiled table one group at a time
+type ColumnarReader struct {
+	// Column names from the original header line
+	Names []string
+	Types []ColumnType
+
+	r *bufio.Reader
+	c io.Closer
+	batch ColumnarBatch
+	done bool
+}
+
+func readUvarint(r *bufio.Reader) (int, error) {
+	x, e := binary.ReadUvarint(r)
+	if e == io.EOF {
+		e = io.ErrUnexpectedEOF
+	}
+	return int(x), e
+}
+
+func readColumnarString(r *bufio.Reader, buf []byte) (string, []byte, error) {
+	n, e := readUvarint(r)
+	if e != nil { return "", buf, e }
+	buf = growBytes(buf, n)
+	_, e = io.ReadFull(r, buf)
+	if e == io.EOF { e = io.ErrUnexpectedEOF }
+	return string(buf), buf, e
+}
+
+// Start reading a compiled table from rc, which is closed along with the
+// ColumnarReader.
+func NewColumnarReader(rc io.ReadCloser) (*ColumnarReader, error) {
+	h := handle("NewColumnarReader: %w")
+
+	c := &ColumnarReader{r: bufio.NewReader(rc), c: rc}
+
+	magic := make([]byte, len(ColumnarMagic) + 1)
+	_, e := io.ReadFull(c.r, magic)
+	if e != nil { return nil, h(e) }
+	if !bytes.Equal(magic[:len(ColumnarMagic)], ColumnarMagic) {
+		return nil, h(fmt.Errorf("not a compiled table"))
+	}
+	if magic[len(ColumnarMagic)] != columnarVersion {
+		return nil, h(fmt.Errorf("unsupported version %v", magic[len(ColumnarMagic)]))
+	}
+
+	ncols, e := readUvarint(c.r)
+	if e != nil { return nil, h(e) }
+	nnames, e := readUvarint(c.r)
+	if e != nil { return nil, h(e) }
+
+	var buf []byte
+	c.batch.Cols = make([]ColumnarColumn, ncols)
+	for i := 0; i < ncols; i++ {
+		var name string
+		name, buf, e = readColumnarString(c.r, buf)
+		if e != nil { return nil, h(e) }
+		if i < nnames {
+			c.Names = append(c.Names, name)
+		}
+
+		t, e := c.r.ReadByte()
+		if e != nil { return nil, h(e) }
+		f, e := c.r.ReadByte()
+		if e != nil { return nil, h(e) }
+		c.Types = append(c.Types, ColumnType(t))
+		c.batch.Cols[i].Type = ColumnType(t)
+		c.batch.Cols[i].Format = f
+	}
+
+	return c, nil
+}
+
+// Read the next group of rows. The batch is reused by the next call to Next.
+// Returns io.EOF after the last group.
+func (c *ColumnarReader) Next() (*ColumnarBatch, error) {
+	h := handle("ColumnarReader.Next: %w")
+	if c.done { return nil, io.EOF }
+
+	rows, e := readUvarint(c.r)
+	if e != nil { return nil, h(e) }
+	if rows == 0 {
+		c.done = true
+		return nil, io.EOF
+	}
+
+	b := &c.batch
+	b.Rows = rows
+
+	ragged, e := c.r.ReadByte()
+	if e != nil { return nil, h(e) }
+	b.Widths = nil
+	if ragged != 0 {
+		b.Widths = make([]int, rows)
+		for i := range b.Widths {
+			b.Widths[i], e = readUvarint(c.r)
+			if e != nil { return nil, h(e) }
+		}
+	}
+
+	var word [8]byte
+	var buf []byte
+	for i := range b.Cols {
+		col := &b.Cols[i]
+		switch col.Type {
+		case ColumnInt:
+			col.Ints = col.Ints[:0]
+			for j := 0; j < rows; j++ {
+				if _, e := io.ReadFull(c.r, word[:]); e != nil { return nil, h(e) }
+				col.Ints = append(col.Ints, int64(binary.LittleEndian.Uint64(word[:])))
+			}
+		case ColumnFloat:
+			col.Floats = col.Floats[:0]
+			for j := 0; j < rows; j++ {
+				if _, e := io.ReadFull(c.r, word[:]); e != nil { return nil, h(e) }
+				col.Floats = append(col.Floats, math.Float64frombits(binary.LittleEndian.Uint64(word[:])))
+			}
+		default:
+			ndict, e := readUvarint(c.r)
+			if e != nil { return nil, h(e) }
+			col.Dict = col.Dict[:0]
+			col.dictFloats = nil
+			col.dictErrs = nil
+			for j := 0; j < ndict; j++ {
+				var s string
+				s, buf, e = readColumnarString(c.r, buf)
+				if e != nil { return nil, h(e) }
+				col.Dict = append(col.Dict, s)
+			}
+			col.Codes = col.Codes[:0]
+			for j := 0; j < rows; j++ {
+				code, e := readUvarint(c.r)
+				if e != nil { return nil, h(e) }
+				if code >= ndict { return nil, h(fmt.Errorf("code %v outside dictionary of %v", code, ndict)) }
+				col.Codes = append(col.Codes, uint32(code))
+			}
+		}
+	}
+
+	return b, nil
+}
+
+func (c *ColumnarReader) Close() error {
+	return c.c.Close()
+}
+
+// Like a ReadCloserMaker, but opens a compiled table for reading by column
+type ColumnarReaderMaker interface {
+	NewColumnarReader() (*ColumnarReader, error)
+}
+
+// A path to a compiled table, optionally compressed. It can be read by
+// column with NewColumnarReader, or as tab-separated text with
+// NewReadCloser, so it works anywhere a ReadCloserMaker does.
+type ColumnarPath string
+
+func (p ColumnarPath) NewColumnarReader() (*ColumnarReader, error) {
+	h := handle("ColumnarPath.NewColumnarReader: %w")
+
+	r, e := AutoDecompressPath(p).NewReadCloser()
+	if e != nil { return nil, h(e) }
+
+	c, e := NewColumnarReader(r)
+	if e != nil {
+		r.Close()
+		return nil, h(e)
+	}
+	return c, nil
+}
+
+// Open the table as tab-separated text, decoded on a background goroutine
+func (p ColumnarPath) NewReadCloser() (io.ReadCloser, error) {
+	h := handle("ColumnarPath.NewReadCloser: %w")
+
+	c, e := p.NewColumnarReader()
+	if e != nil { return nil, h(e) }
+
+	pr, pw := io.Pipe()
+	go func() {
+		e := WriteColumnarText(c, pw)
+		c.Close()
+		pw.CloseWithError(e)
+	}()
+	return pr, nil
+}
+
+// Write a compiled table as tab-separated text, header first.
+func WriteColumnarText(c *ColumnarReader, w io.Writer) error {
+	h := handle("WriteColumnarText: %w")
+
+	cw := csvh.CsvOut(w)
+
+	e := cw.Write(c.Names)
+	if e != nil { return h(e) }
+
+	var line []string
+	for b, e := c.Next(); e != io.EOF; b, e = c.Next() {
+		if e != nil { return h(e) }
+
+		for i := 0; i < b.Rows; i++ {
+			line = line[:0]
+			for j := 0; j < b.Width(i); j++ {
+				line = append(line, b.Cols[j].String(i))
+			}
+			if e := cw.Write(line); e != nil { return h(e) }
+		}
+	}
+
+	cw.Flush()
+	if e := cw.Error(); e != nil { return h(e) }
+	return nil
+}
+
+// Call f on every group of rows in a compiled table
+func EachColumnarBatch(cm ColumnarReaderMaker, f func(b *ColumnarBatch) error) error {
+	h := handle("EachColumnarBatch: %w")
+
+	c, e := cm.NewColumnarReader()
+	if e != nil { return h(e) }
+	defer c.Close()
+
+	for b, e := c.Next(); e != io.EOF; b, e = c.Next() {
+		if e != nil { return h(e) }
+		if e := f(b); e != nil { return h(e) }
+	}
+	return nil
+}
+
+// Check whether the file at path is a compiled table, compressed or not
+func IsColumnarPath(path string) bool {
+	r, e := AutoDecompressPath(path).NewReadCloser()
+	if e != nil {
+		return false
+	}
+	defer r.Close()
+
+	magic := make([]byte, len(ColumnarMagic))
+	_, e = io.ReadFull(r, magic)
+	return e == nil && bytes.Equal(magic, ColumnarMagic)
+}
+
+type compileFlags struct {
+	Path string
+	OutPath string
+}
+
+// Compile a table into the columnar cache format on the command line
+func RunCompile() {
+	var f compileFlags
+	flag.StringVar(&f.Path, "i", "", "input table, plain or compressed, a comma-separated list or glob of shards, or - for stdin, which is copied to a temporary file to be read twice")
+	flag.StringVar(&f.OutPath, "o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
+	flag.Parse()
+	if f.Path == "" {
+		panic(fmt.Errorf("missing -i"))
+	}
+
+	w, e := OutputWriteCloserMaker(f.OutPath).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	e = Compile(InputReadCloserMaker(f.Path), w)
+	if e != nil { panic(e) }
+
+	e = w.Close()
+	if e != nil { panic(e) }
+}
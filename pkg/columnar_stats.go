@@ -0,0 +1,219 @@
+package spstat
+
+// Summary passes over compiled tables. Each function gives the same result
+// as its text counterpart, but reads decoded columns instead of parsing text.
+
+// Like RowStage.CsvFloat, for row i of a compiled batch. lineno is the line
+// the row came from in the original table.
+func (s *RowStage) ColumnarFloat(b *ColumnarBatch, i, col, lineno int) (val float64, ok bool, err error) {
+	if b.Width(i) <= col {
+		return 0, false, s.Reject(ShortLine, lineno, b.Fields(i))
+	}
+	val, e := b.Cols[col].Float(i, s.values)
+	if e != nil {
+		return 0, false, s.RejectErr(e, lineno, b.Fields(i))
+	}
+	return val, true, nil
+}
+
+// CalcTSummary for a compiled table
+func CalcTSummaryColumnar(cm ColumnarReaderMaker, valcol int, idcolsnames []string, idcols []int, controlsetidx, testsetidx int, ro *RowOptions) ([]*TSummary, []TTestSet, error) {
+	h := handle("CalcTSummaryColumnar: %w")
+
+	var tsums []*TSummary
+	for i, idcol := range idcols {
+		tsum := NewTSummary()
+		tsum.ColName = idcolsnames[i]
+		tsum.Idx = idcol
+		tsums = append(tsums, tsum)
+	}
+
+	rows := ro.Stage("CalcTSummary")
+	defer rows.Done()
+	lineno := 1
+
+	e := EachColumnarBatch(cm, func(b *ColumnarBatch) error {
+		for i := 0; i < b.Rows; i++ {
+			lineno++
+			val, ok, e := rows.ColumnarFloat(b, i, valcol, lineno)
+			if e != nil { return e }
+			if !ok { continue }
+			rows.Keep()
+
+			width := b.Width(i)
+			for _, tsum := range tsums {
+				if width <= tsum.Idx { continue }
+				tsum.Add(val, b.Cols[tsum.Idx].String(i))
+			}
+		}
+		return nil
+	})
+	if e != nil { return tsums, nil, h(e) }
+
+	return tsums, TTestSets(tsums, idcolsnames, controlsetidx, testsetidx), nil
+}
+
+// CalcMeans for a compiled table
+func CalcMeansColumnar(cm ColumnarReaderMaker, valcol int, idnames []string, idcols []int, ro *RowOptions) ([]*NamedValSet, error) {
+	h := handle("CalcMeansColumnar: %w")
+
+	sets := []*NamedValSet{}
+	for i, name := range idnames {
+		s := NewNamedValSet()
+		s.ColName = name
+		s.Idx = idcols[i]
+		sets = append(sets, s)
+	}
+
+	rows := ro.Stage("CalcMeans")
+	defer rows.Done()
+	lineno := 1
+
+	e := EachColumnarBatch(cm, func(b *ColumnarBatch) error {
+		for i := 0; i < b.Rows; i++ {
+			lineno++
+			val, ok, e := rows.ColumnarFloat(b, i, valcol, lineno)
+			if e != nil { return e }
+			if !ok { continue }
+			rows.Keep()
+
+			width := b.Width(i)
+			for _, set := range sets {
+				if width <= set.Idx { continue }
+				set.Add(val, b.Cols[set.Idx].String(i))
+			}
+		}
+		return nil
+	})
+	if e != nil { return sets, h(e) }
+
+	return sets, nil
+}
+
+// CalcSerialMean for a compiled table
+func CalcSerialMeanColumnar(cm ColumnarReaderMaker, valcol int, means []*NamedValSet, idname string, idcol int, ro *RowOptions) (*NamedValSet, error) {
+	h := handle("CalcSerialMeanColumnar: %w")
+
+	s := NewNamedValSet()
+	s.ColName = idname
+	s.Idx = idcol
+
+	rows := ro.Stage("CalcSerialMean(" + idname + ")")
+	defer rows.Done()
+	lineno := 1
+
+	e := EachColumnarBatch(cm, func(b *ColumnarBatch) error {
+	lines:
+		for i := 0; i < b.Rows; i++ {
+			lineno++
+			val, ok, e := rows.ColumnarFloat(b, i, valcol, lineno)
+			if e != nil { return e }
+			if !ok { continue }
+
+			width := b.Width(i)
+			if width <= s.Idx {
+				if e := rows.Reject(ShortLine, lineno, b.Fields(i)); e != nil { return e }
+				continue
+			}
+
+			resid := val
+			for _, mean := range means {
+				reason := ShortLine
+				if width > mean.Idx {
+					id := b.Cols[mean.Idx].String(i)
+					if mean.Counts[id] != 0 {
+						resid -= mean.Mean(id)
+						continue
+					}
+					reason = MissingKey
+				}
+				if e := rows.Reject(reason, lineno, b.Fields(i)); e != nil { return e }
+				continue lines
+			}
+			rows.Keep()
+			s.Add(resid, b.Cols[s.Idx].String(i))
+		}
+		return nil
+	})
+	if e != nil { return s, h(e) }
+
+	return s, nil
+}
+
+// CalcFullColTSummary for a compiled table
+func CalcFullColTSummaryColumnar(cm ColumnarReaderMaker, cols []int, ro *RowOptions) ([]*TSummary, error) {
+	h := handle("CalcFullColTSummaryColumnar: %w")
+
+	var tsums []*TSummary
+	for _, col := range cols {
+		tsum := NewTSummary()
+		tsum.Idx = col
+		tsums = append(tsums, tsum)
+	}
+
+	rows := ro.Stage("CalcFullColTSummary")
+	defer rows.Done()
+	lineno := 1
+
+	e := EachColumnarBatch(cm, func(b *ColumnarBatch) error {
+		for i := 0; i < b.Rows; i++ {
+			lineno++
+			width := b.Width(i)
+			var valerr error
+			used := false
+			for _, tsum := range tsums {
+				val, e := float64(0), ErrShortLine
+				if width > tsum.Idx {
+					val, e = b.Cols[tsum.Idx].Float(i, rows.values)
+				}
+				if e != nil {
+					if valerr == nil { valerr = e }
+					continue
+				}
+				tsum.Add(val, "")
+				used = true
+			}
+
+			if used {
+				rows.Keep()
+			} else if e := rows.RejectErr(valerr, lineno, b.Fields(i)); e != nil {
+				return e
+			}
+		}
+		return nil
+	})
+	if e != nil { return tsums, h(e) }
+
+	return tsums, nil
+}
+
+// FitLinearModel for a compiled table
+func FitLinearModelColumnar(cm ColumnarReaderMaker, valcol, indepcol int, ro *RowOptions) (*LinearModeler, error) {
+	h := handle("FitLinearModelColumnar: %w")
+
+	l := &LinearModeler{}
+
+	rows := ro.Stage("FitLinearModel")
+	defer rows.Done()
+	lineno := 1
+
+	e := EachColumnarBatch(cm, func(batch *ColumnarBatch) error {
+		for i := 0; i < batch.Rows; i++ {
+			lineno++
+			val, ok, e := rows.ColumnarFloat(batch, i, valcol, lineno)
+			if e != nil { return e }
+			if !ok { continue }
+
+			indep, ok, e := rows.ColumnarFloat(batch, i, indepcol, lineno)
+			if e != nil { return e }
+			if !ok { continue }
+
+			rows.Keep()
+			l.Add(val, indep)
+		}
+		return nil
+	})
+	if e != nil { return nil, h(e) }
+
+	return l, nil
+}
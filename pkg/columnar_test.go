@@ -0,0 +1,164 @@
+package spstat
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// A table with an int, a float, a dictionary column and a float column with
+// missing values, long enough for two groups, and one ragged row
+func columnarTestTable() []byte {
+	var b strings.Builder
+	b.WriteString("pos\ttissue\tvalue\tnorm\n")
+	for i := 0; i < ColumnarGroupRows + 1000; i++ {
+		tissue := "blood"
+		if i % 3 == 0 {
+			tissue = "sperm"
+		}
+		norm := fmt.Sprint(float64(i % 13) / 4)
+		switch i % 50 {
+		case 7:
+			norm = "NA"
+		case 8:
+			norm = ""
+		}
+		fmt.Fprintf(&b, "%v\t%v\t%v\t%v\n", i - 500, tissue, float64(i % 17) / 17, norm)
+	}
+	b.WriteString("-7\tblood\n")
+	return []byte(b.String())
+}
+
+func compileTestTable(t *testing.T, dir string, table []byte) string {
+	in := filepath.Join(dir, "table.tsv")
+	if e := os.WriteFile(in, table, 0644); e != nil { t.Fatal(e) }
+
+	var out bytes.Buffer
+	if e := Compile(AutoDecompressPath(in), &out); e != nil { t.Fatal(e) }
+	path := filepath.Join(dir, "table.spcol")
+	if e := os.WriteFile(path, out.Bytes(), 0644); e != nil { t.Fatal(e) }
+	return path
+}
+
+func TestColumnarRoundTrip(t *testing.T) {
+	table := columnarTestTable()
+	path := compileTestTable(t, t.TempDir(), table)
+
+	c, e := ColumnarPath(path).NewColumnarReader()
+	if e != nil { t.Fatal(e) }
+	wantTypes := []ColumnType{ColumnInt, ColumnString, ColumnFloat, ColumnString}
+	if fmt.Sprint(c.Names) != "[pos tissue value norm]" || fmt.Sprint(c.Types) != fmt.Sprint(wantTypes) {
+		t.Errorf("names %v, types %v", c.Names, c.Types)
+	}
+
+	// Values from the columns match parsing their text, missing ones
+	// included
+	values := DefaultValueParser()
+	groups, rows, missing := 0, 0, 0
+	e = EachColumnarBatch(ColumnarPath(path), func(b *ColumnarBatch) error {
+		groups++
+		for i := 0; i < b.Rows; i++ {
+			for j := 0; j < b.Width(i); j++ {
+				got, gerr := b.Cols[j].Float(i, values)
+				want, werr := values.Parse(b.Cols[j].String(i))
+				if (gerr == nil) != (werr == nil) || (gerr == nil && got != want) {
+					t.Fatalf("row %v column %v: %v, %v, want %v, %v", rows, j, got, gerr, want, werr)
+				}
+				if j == 3 && gerr != nil {
+					missing++
+				}
+			}
+			rows++
+		}
+		return nil
+	})
+	c.Close()
+	if e != nil { t.Fatal(e) }
+	wantMissing := 0
+	for i := 0; i < ColumnarGroupRows + 1000; i++ {
+		if i % 50 == 7 || i % 50 == 8 {
+			wantMissing++
+		}
+	}
+	if groups != 2 || rows != ColumnarGroupRows + 1001 || missing != wantMissing {
+		t.Errorf("%v groups, %v rows, %v missing", groups, rows, missing)
+	}
+
+	// The text output pass gives back the original table
+	out, e := readAll(ColumnarPath(path))
+	if e != nil { t.Fatal(e) }
+	if !bytes.Equal(out, table) {
+		t.Errorf("text of compiled table differs from the original")
+	}
+}
+
+func TestColumnarMatchesText(t *testing.T) {
+	ro := DefaultRowOptions()
+	dir := t.TempDir()
+	table := columnarTestTable()
+	path := compileTestTable(t, dir, table)
+	text := filepath.Join(dir, "table.tsv")
+
+	var outs []string
+	for _, rcm := range []ReadCloserMaker{AutoDecompressPath(text), ColumnarPath(path)} {
+		var out bytes.Buffer
+		if e := RunFullTTest(rcm, &out, "norm", "tissue", "tissue", DefaultTTestOptions(), ro); e != nil { t.Fatal(e) }
+		// ttest writes its groups in map order
+		lines := strings.Split(out.String(), "\n")
+		sort.Strings(lines)
+		outs = append(outs, strings.Join(lines, "\n"))
+	}
+	if outs[0] != outs[1] || outs[0] == "" {
+		t.Errorf("text and compiled t tests differ:\n%v\n%v", outs[0], outs[1])
+	}
+}
+
+func TestIsColumnarPath(t *testing.T) {
+	dir := t.TempDir()
+	table := columnarTestTable()
+	path := compileTestTable(t, dir, table)
+	compiled, e := os.ReadFile(path)
+	if e != nil { t.Fatal(e) }
+
+	cases := []struct {
+		name string
+		data []byte
+		columnar bool
+	}{
+		{"table.tsv", table, false},
+		{"table.tsv.gz", gzipBytes(t, table), false},
+		{"table.tsv.bgz", bgzfBytes(t, table), false},
+		{"short.tsv", []byte("SPC"), false},
+		{"empty.tsv", nil, false},
+		{"compiled", compiled, true},
+		{"compiled.gz", gzipBytes(t, compiled), true},
+	}
+	for _, c := range cases {
+		p := filepath.Join(dir, "detect_" + c.name)
+		if e := os.WriteFile(p, c.data, 0644); e != nil { t.Fatal(e) }
+		if got := IsColumnarPath(p); got != c.columnar {
+			t.Errorf("%v: IsColumnarPath %v", c.name, got)
+		}
+		_, isColumnar := InputReadCloserMaker(p).(ColumnarPath)
+		if isColumnar != c.columnar {
+			t.Errorf("%v: InputReadCloserMaker gave %T", c.name, InputReadCloserMaker(p))
+		}
+
+		// Either way the input reads as the same text
+		if c.columnar {
+			out, e := readAll(InputReadCloserMaker(p))
+			if e != nil { t.Fatal(e) }
+			if !bytes.Equal(out, table) {
+				t.Errorf("%v: text differs from the original", c.name)
+			}
+		}
+	}
+
+	if IsColumnarPath(filepath.Join(dir, "missing")) {
+		t.Errorf("missing file taken for a compiled table")
+	}
+}
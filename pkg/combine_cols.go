@@ -3,7 +3,6 @@ package spstat
 import (
 	"github.com/jgbaldwinbrown/csvh"
 	"strings"
-	"fmt"
 	"io"
 	"encoding/csv"
 )
@@ -16,7 +15,7 @@ func CombineOne(line []string, cols []int, sep string) (string, error) {
 	tocombine := make([]string, 0, len(cols))
 
 	for _, col := range cols {
-		if len(line) <= col { return "", h(fmt.Errorf("line too short")) }
+		if len(line) <= col { return "", h(ErrShortLine) }
 		tocombine = append(tocombine, strings.ReplaceAll(line[col], sep, "."))
 	}
 
@@ -25,7 +24,7 @@ func CombineOne(line []string, cols []int, sep string) (string, error) {
 
 // Take a tab-separated table and a function that reads the header and finds
 // the correct columns to combine. Combine those columns with sep, then append to the existing line.
-func ColCombine(rcm ReadCloserMaker, w io.Writer, colsf func([]string, []int) ([]int, error), sep string) error {
+func ColCombine(rcm ReadCloserMaker, w io.Writer, colsf func([]string, []int) ([]int, error), sep string, ro *RowOptions) error {
 	h := handle("ColCombine: %w")
 
 	r, e := rcm.NewReadCloser()
@@ -46,26 +45,35 @@ func ColCombine(rcm ReadCloserMaker, w io.Writer, colsf func([]string, []int) ([
 		return h(e)
 	}
 
+	rows := ro.Stage("ColCombine")
+	defer rows.Done()
+
 	for ; e != io.EOF; line, e = cr.Read() {
 		if e != nil { return h(e) }
 
 		combined, e := CombineOne(line, cols, sep)
-		if e != nil { continue }
+		if e != nil {
+			if e := rows.RejectCsvErr(e, cr, line); e != nil { return h(e) }
+			continue
+		}
+		rows.Keep()
 		line = append(line, combined)
 		e = cw.Write(line)
-		if e != nil { continue }
+		if e != nil { return h(e) }
 	}
 
+	cw.Flush()
+	if e := cw.Error(); e != nil { return h(e) }
 	return nil
 }
 
 // Run ColCombine on the named columns
-func RunColCombine(rcm ReadCloserMaker, w io.Writer, colnames []string, sep string) error {
+func RunColCombine(rcm ReadCloserMaker, w io.Writer, colnames []string, sep string, ro *RowOptions) error {
 	h := handle("RunColCombine: %w")
 
 	colsf := NamedColsFunc(colnames)
 
-	if e := ColCombine(rcm, w, colsf, sep); e != nil {
+	if e := ColCombine(rcm, w, colsf, sep, ro); e != nil {
 		return h(e)
 	}
 
@@ -0,0 +1,263 @@
+package spstat
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// Run Fisher's exact test when the smallest expected count of a table is
+// below this, unless another limit is asked for
+const DefaultFisherBelow = 5.0
+
+// The summed hits and counts of each named category in a particular column
+type CountSet struct {
+	ColName string
+	Idx int
+	Hits map[string]float64
+	Counts map[string]float64
+}
+
+func NewCountSet(colname string, idx int) *CountSet {
+	return &CountSet{ColName: colname, Idx: idx, Hits: map[string]float64{}, Counts: map[string]float64{}}
+}
+
+// Add the hits and count of one row to id
+func (s *CountSet) Add(hits, count float64, id string) {
+	s.Hits[id] += hits
+	s.Counts[id] += count
+}
+
+// Add all of the hits and counts in o to s
+func (s *CountSet) Merge(o *CountSet) {
+	for id, count := range o.Counts {
+		s.Add(o.Hits[id], count, id)
+	}
+}
+
+// The summed count minus the summed hits of id
+func (s *CountSet) Misses(id string) float64 {
+	return s.Counts[id] - s.Hits[id]
+}
+
+// A CountSet of the hit and count columns for each id column, gathered row
+// by row
+type countAccumulator struct {
+	hitcol int
+	countcol int
+	sets []*CountSet
+}
+
+func newCountAccumulator(hitcol, countcol int, idcolsnames []string, idcols []int) *countAccumulator {
+	acc := &countAccumulator{hitcol: hitcol, countcol: countcol}
+	for i, idcol := range idcols {
+		acc.sets = append(acc.sets, NewCountSet(idcolsnames[i], idcol))
+	}
+	return acc
+}
+
+func (a *countAccumulator) Row(rows *RowStage, cr *csv.Reader, line []string) error {
+	hits, ok, e := rows.CsvFloat(cr, line, a.hitcol)
+	if e != nil || !ok { return e }
+	count, ok, e := rows.CsvFloat(cr, line, a.countcol)
+	if e != nil || !ok { return e }
+	if hits < 0 || hits > count {
+		return rows.RejectCsv(BadCount, cr, line)
+	}
+	rows.Keep()
+
+	for _, set := range a.sets {
+		if len(line) <= set.Idx { continue }
+		set.Add(hits, count, line[set.Idx])
+	}
+	return nil
+}
+
+func (a *countAccumulator) Empty() RowAccumulator {
+	out := &countAccumulator{hitcol: a.hitcol, countcol: a.countcol}
+	for _, set := range a.sets {
+		out.sets = append(out.sets, NewCountSet(set.ColName, set.Idx))
+	}
+	return out
+}
+
+func (a *countAccumulator) Merge(o RowAccumulator) error {
+	for i, set := range o.(*countAccumulator).sets {
+		a.sets[i].Merge(set)
+	}
+	return nil
+}
+
+// The expected counts of the 2×2 table with rows a, b and c, d if the rows
+// have the same proportions
+func expected2x2(a, b, c, d float64) [4]float64 {
+	n := a + b + c + d
+	r1, r2 := a + b, c + d
+	c1, c2 := a + c, b + d
+	return [4]float64{r1 * c1 / n, r1 * c2 / n, r2 * c1 / n, r2 * c2 / n}
+}
+
+// Pearson's chi-square statistic of the 2×2 table with rows a, b and c, d,
+// without a continuity correction
+func ChiSquare2x2(a, b, c, d float64) float64 {
+	expected := expected2x2(a, b, c, d)
+	x := 0.0
+	for i, o := range [4]float64{a, b, c, d} {
+		x += (o - expected[i]) * (o - expected[i]) / expected[i]
+	}
+	return x
+}
+
+// The G statistic, twice the log likelihood ratio, of the 2×2 table with
+// rows a, b and c, d
+func GTest2x2(a, b, c, d float64) float64 {
+	expected := expected2x2(a, b, c, d)
+	g := 0.0
+	for i, o := range [4]float64{a, b, c, d} {
+		if o == 0 { continue }
+		g += o * math.Log(o / expected[i])
+	}
+	return 2 * g
+}
+
+// The upper tail of the chi-square distribution with one degree of freedom
+func chiSquare1P(x float64) float64 {
+	if math.IsNaN(x) {
+		return math.NaN()
+	}
+	return distuv.ChiSquared{K: 1}.Survival(x)
+}
+
+func lchoose(n, k float64) float64 {
+	a, _ := math.Lgamma(n + 1)
+	b, _ := math.Lgamma(k + 1)
+	c, _ := math.Lgamma(n - k + 1)
+	return a - b - c
+}
+
+// The two-sided P value of Fisher's exact test of the 2×2 table with rows
+// a, b and c, d, rounded to whole counts: the sum of the probabilities of the
+// tables with the same margins that are no more likely than this one, as R's
+// fisher.test gives
+func FisherExact2x2(a, b, c, d float64) float64 {
+	a, b, c, d = math.Round(a), math.Round(b), math.Round(c), math.Round(d)
+	r1, r2, c1 := a + b, c + d, a + c
+	n := r1 + r2
+
+	logp := func(x float64) float64 {
+		return lchoose(r1, x) + lchoose(r2, c1 - x) - lchoose(n, c1)
+	}
+	observed := logp(a)
+
+	p := 0.0
+	for x := math.Max(0, c1 - r2); x <= math.Min(r1, c1); x++ {
+		if lp := logp(x); lp <= observed + 1e-7 {
+			p += math.Exp(lp)
+		}
+	}
+	return math.Min(p, 1)
+}
+
+// The tests of one 2×2 table of hits and misses, control above experimental.
+// FisherP is NaN unless the table's smallest expected count was small enough
+// to run Fisher's exact test.
+type CountTestResult struct {
+	Name1 string
+	Name2 string
+	Hits1 float64
+	Misses1 float64
+	Hits2 float64
+	Misses2 float64
+	Chisq float64
+	ChisqP float64
+	G float64
+	GP float64
+	FisherP float64
+}
+
+// Test the 2×2 table of hits and misses of two groups, with Fisher's exact
+// test if the smallest expected count is below fisherbelow
+func CalcCountTest(name1, name2 string, hits1, misses1, hits2, misses2, fisherbelow float64) CountTestResult {
+	r := CountTestResult{Name1: name1, Name2: name2, Hits1: hits1, Misses1: misses1, Hits2: hits2, Misses2: misses2}
+	r.Chisq = ChiSquare2x2(hits1, misses1, hits2, misses2)
+	r.ChisqP = chiSquare1P(r.Chisq)
+	r.G = GTest2x2(hits1, misses1, hits2, misses2)
+	r.GP = chiSquare1P(r.G)
+
+	r.FisherP = math.NaN()
+	least := math.Inf(1)
+	for _, e := range expected2x2(hits1, misses1, hits2, misses2) {
+		least = math.Min(least, e)
+	}
+	if !(least >= fisherbelow) {
+		r.FisherP = FisherExact2x2(hits1, misses1, hits2, misses2)
+	}
+	return r
+}
+
+// Write one CountTestResult as a tab-separated line, with the fraction of
+// hits in each group after the counts
+func WriteCountTestResult(w io.Writer, r CountTestResult) error {
+	_, e := fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+		r.Name1, r.Name2,
+		r.Hits1, r.Misses1, r.Hits2, r.Misses2,
+		r.Hits1 / (r.Hits1 + r.Misses1), r.Hits2 / (r.Hits2 + r.Misses2),
+		r.Chisq, r.ChisqP, r.G, r.GP, r.FisherP,
+	)
+	return e
+}
+
+// The CountSet associated with the test item specified here
+func countSetsSet(sets []*CountSet, item TTestItem) *CountSet {
+	for _, set := range sets {
+		if set.ColName == item.ColName {
+			return set
+		}
+	}
+	panic(fmt.Errorf("countSetsSet: missing set %v", item))
+}
+
+// Run CalcCountTest on each of testsets, in order of the experimental group
+func CountTests(w io.Writer, sets []*CountSet, testsets []TTestSet, fisherbelow float64) error {
+	sorted := append([]TTestSet(nil), testsets...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Exp.Val < sorted[j].Exp.Val
+	})
+
+	for _, tset := range sorted {
+		s1, s2 := countSetsSet(sets, tset.Control), countSetsSet(sets, tset.Exp)
+		name1, name2 := tset.Control.Val, tset.Exp.Val
+		r := CalcCountTest(name1, name2, s1.Hits[name1], s1.Misses(name1), s2.Hits[name2], s2.Misses(name2), fisherbelow)
+		if e := WriteCountTestResult(w, r); e != nil {
+			return fmt.Errorf("CountTests: %w", e)
+		}
+	}
+	return nil
+}
+
+// Sum the hits and counts of "blood" in the control column and of each
+// group in the test column in one pass, and test each group against blood
+func RunCountTest(rcm ReadCloserMaker, w io.Writer, hitcolname, countcolname string, idcolsnames []string, controlsetidx, testsetidx int, fisherbelow float64, ro *RowOptions) error {
+	h := handle("RunCountTest: %w")
+
+	hitcol, countcol, e := HitCountCols(rcm, hitcolname, countcolname)
+	if e != nil { return h(e) }
+
+	idcols, e := IdCols(rcm, idcolsnames)
+	if e != nil { return h(e) }
+
+	acc := newCountAccumulator(hitcol, countcol, idcolsnames, idcols)
+	if e := rowPass(rcm, "CalcCounts", acc, ro); e != nil { return h(e) }
+
+	testsets := testSetsOf(acc.sets[testsetidx].Counts, idcolsnames, controlsetidx, testsetidx)
+	if e := CountTests(w, acc.sets, testsets, fisherbelow); e != nil { return h(e) }
+	return nil
+}
+
+// Same as RunCountTest, but with controlsetidx and testsetidx set to 0 and 1
+func RunFullCountTest(rcm ReadCloserMaker, w io.Writer, hitcolname, countcolname, bloodcolname, testcolname string, fisherbelow float64, ro *RowOptions) error {
+	return RunCountTest(rcm, w, hitcolname, countcolname, []string{bloodcolname, testcolname}, 0, 1, fisherbelow, ro)
+}
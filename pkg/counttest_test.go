@@ -0,0 +1,84 @@
+package spstat
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCountTests2x2(t *testing.T) {
+	// The shortcut formulas for a 2×2 table
+	a, b, c, d := 12.0, 5.0, 7.0, 9.0
+	n, r1, r2, c1, c2 := a + b + c + d, a + b, c + d, a + c, b + d
+	chisq := n * (a * d - b * c) * (a * d - b * c) / (r1 * r2 * c1 * c2)
+	xlogx := func(x float64) float64 { return x * math.Log(x) }
+	g := 2 * (xlogx(a) + xlogx(b) + xlogx(c) + xlogx(d) - xlogx(r1) - xlogx(r2) - xlogx(c1) - xlogx(c2) + xlogx(n))
+
+	if got := ChiSquare2x2(a, b, c, d); !closeTo(got, chisq, 1e-12) {
+		t.Errorf("chi-square %v, want %v", got, chisq)
+	}
+	if got := GTest2x2(a, b, c, d); !closeTo(got, g, 1e-12) {
+		t.Errorf("G %v, want %v", got, g)
+	}
+
+	// From R's fisher.test
+	fishers := []struct {
+		a, b, c, d float64
+		p float64
+	}{
+		{3, 1, 1, 3, 0.4857143},
+		{1, 9, 11, 3, 0.002759456},
+		{0, 10, 0, 7, 1},
+	}
+	for _, f := range fishers {
+		if got := FisherExact2x2(f.a, f.b, f.c, f.d); !closeTo(got, f.p, 1e-6) {
+			t.Errorf("Fisher's exact test of %v: %v, want %v", f, got, f.p)
+		}
+	}
+
+	small := CalcCountTest("blood", "x", 3, 1, 1, 3, DefaultFisherBelow)
+	large := CalcCountTest("blood", "x", 300, 100, 100, 300, DefaultFisherBelow)
+	if !closeTo(small.FisherP, 0.4857143, 1e-6) || !math.IsNaN(large.FisherP) || !closeTo(large.ChisqP, chiSquare1P(large.Chisq), 1e-12) {
+		t.Errorf("small %+v, large %+v", small, large)
+	}
+}
+
+func TestRunCountTest(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("tissue\tindiv\thits\tcount\n")
+	for i := 0; i < 300; i++ {
+		tissue, indiv := "blood", fmt.Sprintf("i%v_blood", i % 3)
+		if i % 2 == 1 {
+			tissue, indiv = "sperm", fmt.Sprintf("i%v_sperm", i % 3)
+		}
+		fmt.Fprintf(&b, "%v\t%v\t%v\t%v\n", tissue, indiv, i % 4, 3 + i % 2)
+	}
+	b.WriteString("sperm\ti0_sperm\t5\t4\n")
+	b.WriteString("sperm\ti0_sperm\tNA\t4\n")
+	rcm := stringTable(b.String())
+
+	var outs []string
+	serial, parallel := serialAndParallel(t, func(ro *RowOptions) {
+		var out bytes.Buffer
+		if e := RunFullCountTest(rcm, &out, "hits", "count", "tissue", "indiv", DefaultFisherBelow, ro); e != nil { t.Fatal(e) }
+		outs = append(outs, out.String())
+	})
+	if serial != parallel || !strings.Contains(serial, "hits not between 0 and count") {
+		t.Errorf("rejects %q and %q", serial, parallel)
+	}
+	if outs[0] != outs[1] {
+		t.Errorf("serial and parallel results differ:\n%v\n%v", outs[0], outs[1])
+	}
+
+	lines := strings.Split(strings.TrimSpace(outs[0]), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("output %q", outs[0])
+	}
+	// blood has hits 0 and 2 in turn over 150 rows of count 3
+	f := strings.Split(lines[0], "\t")
+	if f[0] != "blood" || f[1] != "i0_blood" || f[2] != "150" || f[3] != "300" || f[4] != "50" || f[5] != "100" {
+		t.Errorf("first line %q", lines[0])
+	}
+}
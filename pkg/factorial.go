@@ -0,0 +1,352 @@
+package spstat
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"gonum.org/v1/gonum/mat"
+)
+
+// The Moments of the values in one cell of a factorial design: the rows
+// with one combination of the levels of the factors
+type FactorialCell struct {
+	Levels []string
+	Moments Moments
+}
+
+// The cells of a factorial design, gathered row by row
+type factorialAccumulator struct {
+	valcol int
+	factorcols []int
+	maxcol int
+	cells map[string]*FactorialCell
+}
+
+func newFactorialAccumulator(valcol int, factorcols []int) *factorialAccumulator {
+	maxcol := valcol
+	for _, col := range factorcols {
+		if col > maxcol {
+			maxcol = col
+		}
+	}
+	return &factorialAccumulator{
+		valcol: valcol,
+		factorcols: factorcols,
+		maxcol: maxcol,
+		cells: map[string]*FactorialCell{},
+	}
+}
+
+func (a *factorialAccumulator) Row(rows *RowStage, cr *csv.Reader, line []string) error {
+	if len(line) <= a.maxcol {
+		return rows.RejectCsv(ShortLine, cr, line)
+	}
+
+	val, ok, e := rows.CsvFloat(cr, line, a.valcol)
+	if e != nil || !ok { return e }
+	rows.Keep()
+
+	key := joinFields(line, a.factorcols, "\x00")
+	c, ok := a.cells[key]
+	if !ok {
+		c = &FactorialCell{}
+		for _, col := range a.factorcols {
+			c.Levels = append(c.Levels, line[col])
+		}
+		a.cells[key] = c
+	}
+	c.Moments.Add(val)
+	return nil
+}
+
+func (a *factorialAccumulator) Empty() RowAccumulator {
+	out := *a
+	out.cells = map[string]*FactorialCell{}
+	return &out
+}
+
+func (a *factorialAccumulator) Merge(o RowAccumulator) error {
+	for key, oc := range o.(*factorialAccumulator).cells {
+		if c, ok := a.cells[key]; ok {
+			c.Moments.Merge(&oc.Moments)
+		} else {
+			a.cells[key] = oc
+		}
+	}
+	return nil
+}
+
+// The kinds of sums of squares for an unbalanced design
+const (
+	// Each term after the terms that do not contain it
+	FactorialTypeII = 2
+	// Each term after all of the others, with sum-to-zero contrasts
+	FactorialTypeIII = 3
+)
+
+// One line of a factorial ANOVA table. The residual line has no F or P.
+type FactorialTerm struct {
+	Term string
+	Df float64
+	SS float64
+	MS float64
+	F float64
+	P float64
+}
+
+// Every interaction of k factors, as lists of factor indices, in order of
+// size and then of the factors
+func factorialTerms(k int) [][]int {
+	var terms [][]int
+	for mask := 1; mask < 1 << k; mask++ {
+		var term []int
+		for f := 0; f < k; f++ {
+			if mask & (1 << f) != 0 {
+				term = append(term, f)
+			}
+		}
+		terms = append(terms, term)
+	}
+	sort.SliceStable(terms, func(i, j int) bool {
+		return len(terms[i]) < len(terms[j])
+	})
+	return terms
+}
+
+// Whether term a contains all of the factors of term b, and more
+func termContains(a, b []int) bool {
+	if len(a) <= len(b) {
+		return false
+	}
+	in := map[int]bool{}
+	for _, f := range a {
+		in[f] = true
+	}
+	for _, f := range b {
+		if !in[f] {
+			return false
+		}
+	}
+	return true
+}
+
+// A factorial design made from its cells, ready to fit models of the cell
+// means weighted by the cell counts
+type factorialDesign struct {
+	nlevels []int
+	cells [][]int
+	y []float64
+	w []float64
+}
+
+func newFactorialDesign(nfactors int, cells []*FactorialCell) *factorialDesign {
+	d := &factorialDesign{}
+	index := make([]map[string]int, nfactors)
+	for f := range index {
+		var levels []string
+		seen := map[string]bool{}
+		for _, c := range cells {
+			if !seen[c.Levels[f]] {
+				seen[c.Levels[f]] = true
+				levels = append(levels, c.Levels[f])
+			}
+		}
+		sort.Strings(levels)
+		index[f] = map[string]int{}
+		for i, level := range levels {
+			index[f][level] = i
+		}
+		d.nlevels = append(d.nlevels, len(levels))
+	}
+
+	for _, c := range cells {
+		var idx []int
+		for f, level := range c.Levels {
+			idx = append(idx, index[f][level])
+		}
+		d.cells = append(d.cells, idx)
+		sw := math.Sqrt(c.Moments.Count())
+		d.w = append(d.w, sw)
+		d.y = append(d.y, sw * c.Moments.Mean())
+	}
+	return d
+}
+
+// The sum-to-zero contrast columns of term for one cell: the product of the
+// contrasts of each factor, where the last level of a factor is -1 in every
+// column and each other level is 1 in its own
+func (d *factorialDesign) termRow(term []int, cell []int) []float64 {
+	row := []float64{1}
+	for _, f := range term {
+		var next []float64
+		for _, v := range row {
+			for j := 0; j < d.nlevels[f] - 1; j++ {
+				switch cell[f] {
+				case j:
+					next = append(next, v)
+				case d.nlevels[f] - 1:
+					next = append(next, -v)
+				default:
+					next = append(next, 0)
+				}
+			}
+		}
+		row = next
+	}
+	return row
+}
+
+// The weighted residual sum of squares of the cell means around the model
+// with an intercept and terms, and the rank of the model
+func (d *factorialDesign) rss(terms [][]int) (float64, int) {
+	var data []float64
+	ncols := 0
+	for i, cell := range d.cells {
+		row := []float64{1}
+		for _, term := range terms {
+			row = append(row, d.termRow(term, cell)...)
+		}
+		for _, v := range row {
+			data = append(data, v * d.w[i])
+		}
+		ncols = len(row)
+	}
+	x := mat.NewDense(len(d.cells), ncols, data)
+
+	var svd mat.SVD
+	if !svd.Factorize(x, mat.SVDThin) {
+		return math.NaN(), 0
+	}
+	vals := svd.Values(nil)
+	tol := vals[0] * 1e-9
+	var u mat.Dense
+	svd.UTo(&u)
+
+	resid := append([]float64(nil), d.y...)
+	rank := 0
+	for j, s := range vals {
+		if s <= tol { continue }
+		rank++
+		col := mat.Col(nil, j, &u)
+		dot := 0.0
+		for i, v := range col {
+			dot += v * d.y[i]
+		}
+		for i, v := range col {
+			resid[i] -= dot * v
+		}
+	}
+
+	rss := 0.0
+	for _, r := range resid {
+		rss += r * r
+	}
+	return rss, rank
+}
+
+// The ANOVA table of a full factorial design in factornames from its cells,
+// with Type II or Type III sums of squares: a line for each main effect and
+// interaction, then one for the residuals
+func FactorialAnova(factornames []string, cells []*FactorialCell, sstype int) ([]FactorialTerm, error) {
+	if sstype != FactorialTypeII && sstype != FactorialTypeIII {
+		return nil, fmt.Errorf("FactorialAnova: sum of squares type %v is not 2 or 3", sstype)
+	}
+	if len(cells) == 0 {
+		return nil, fmt.Errorf("FactorialAnova: no values")
+	}
+
+	n, sse := 0.0, 0.0
+	for _, c := range cells {
+		n += c.Moments.Count()
+		sse += c.Moments.Count() * c.Moments.PopVar()
+	}
+	dfe := n - float64(len(cells))
+	mse := sse / dfe
+
+	d := newFactorialDesign(len(factornames), cells)
+	terms := factorialTerms(len(factornames))
+	fullrss, fullrank := d.rss(terms)
+
+	var out []FactorialTerm
+	for i, term := range terms {
+		var base [][]int
+		for j, other := range terms {
+			if j == i { continue }
+			if sstype == FactorialTypeII && termContains(other, term) { continue }
+			base = append(base, other)
+		}
+
+		baserss, baserank := d.rss(base)
+		withrss, withrank := fullrss, fullrank
+		if sstype == FactorialTypeII {
+			withrss, withrank = d.rss(append(base, term))
+		}
+
+		var names []string
+		for _, f := range term {
+			names = append(names, factornames[f])
+		}
+		t := FactorialTerm{Term: strings.Join(names, ":")}
+		t.Df = float64(withrank - baserank)
+		if t.Df == 0 {
+			// The other terms explain everything this one could
+			t.MS, t.F, t.P = math.NaN(), math.NaN(), math.NaN()
+			out = append(out, t)
+			continue
+		}
+		t.SS = math.Max(baserss - withrss, 0)
+		t.MS = t.SS / t.Df
+		t.F = t.MS / mse
+		t.P = FSurvival(t.F, t.Df, dfe)
+		out = append(out, t)
+	}
+
+	out = append(out, FactorialTerm{"Residuals", dfe, sse, mse, math.NaN(), math.NaN()})
+	return out, nil
+}
+
+// Write one FactorialTerm as a tab-separated line
+func WriteFactorialTerm(w io.Writer, t FactorialTerm) error {
+	_, e := fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", t.Term, t.Df, t.SS, t.MS, t.F, t.P)
+	return e
+}
+
+// Read the cells of valcolname in every combination of the levels of
+// factorcolnames in one pass, and write their factorial ANOVA table
+func RunFactorialAnova(rcm ReadCloserMaker, w io.Writer, valcolname string, factorcolnames []string, sstype int, ro *RowOptions) error {
+	h := handle("RunFactorialAnova: %w")
+
+	if len(factorcolnames) == 0 {
+		return h(fmt.Errorf("no factors"))
+	}
+
+	valcol, e := ValCol(rcm, valcolname)
+	if e != nil { return h(e) }
+
+	factorcols, e := IdCols(rcm, factorcolnames)
+	if e != nil { return h(e) }
+
+	acc := newFactorialAccumulator(valcol, factorcols)
+	if e := rowPass(rcm, "FactorialAnova", acc, ro); e != nil { return h(e) }
+
+	var keys []string
+	for key, _ := range acc.cells {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var cells []*FactorialCell
+	for _, key := range keys {
+		cells = append(cells, acc.cells[key])
+	}
+
+	table, e := FactorialAnova(factorcolnames, cells, sstype)
+	if e != nil { return h(e) }
+
+	for _, t := range table {
+		if e := WriteFactorialTerm(w, t); e != nil { return h(e) }
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package spstat
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"gonum.org/v1/gonum/mat"
+)
+
+// An unbalanced two by three design
+var factorialRows = []struct {
+	a string
+	b string
+	y float64
+}{
+	{"x", "p", 4.1}, {"x", "p", 5.3}, {"x", "p", 3.9},
+	{"x", "q", 6.2}, {"x", "q", 7.0},
+	{"x", "r", 5.5}, {"x", "r", 6.1}, {"x", "r", 4.8}, {"x", "r", 5.9},
+	{"y", "p", 5.0}, {"y", "p", 6.4},
+	{"y", "q", 9.1}, {"y", "q", 8.3}, {"y", "q", 8.8}, {"y", "q", 9.6}, {"y", "q", 7.9},
+	{"y", "r", 6.0}, {"y", "r", 7.2}, {"y", "r", 6.6},
+}
+
+// The residual sum of squares of a least squares fit of the rows, with
+// columns giving each row's predictors
+func rowRSS(t *testing.T, columns func(a, b string) []float64) float64 {
+	var data, ys []float64
+	ncols := 0
+	for _, r := range factorialRows {
+		row := columns(r.a, r.b)
+		data = append(data, row...)
+		ys = append(ys, r.y)
+		ncols = len(row)
+	}
+	x := mat.NewDense(len(factorialRows), ncols, data)
+	y := mat.NewVecDense(len(ys), ys)
+
+	var beta, fit mat.VecDense
+	if e := beta.SolveVec(x, y); e != nil { t.Fatal(e) }
+	fit.MulVec(x, &beta)
+	rss := 0.0
+	for i, v := range ys {
+		d := v - fit.AtVec(i)
+		rss += d * d
+	}
+	return rss
+}
+
+func dummy(level string, levels ...string) []float64 {
+	var out []float64
+	for _, l := range levels {
+		if level == l {
+			out = append(out, 1)
+		} else {
+			out = append(out, 0)
+		}
+	}
+	return out
+}
+
+func sumCoded(level string, levels ...string) []float64 {
+	if level == levels[len(levels) - 1] {
+		out := make([]float64, len(levels) - 1)
+		for i := range out {
+			out[i] = -1
+		}
+		return out
+	}
+	return dummy(level, levels[:len(levels) - 1]...)
+}
+
+func factorialCells() []*FactorialCell {
+	cells := map[string]*FactorialCell{}
+	var out []*FactorialCell
+	for _, r := range factorialRows {
+		c, ok := cells[r.a + r.b]
+		if !ok {
+			c = &FactorialCell{Levels: []string{r.a, r.b}}
+			cells[r.a + r.b] = c
+			out = append(out, c)
+		}
+		c.Moments.Add(r.y)
+	}
+	return out
+}
+
+func TestFactorialAnova(t *testing.T) {
+	one := func(a, b string) []float64 { return []float64{1} }
+	ta := func(a, b string) []float64 { return dummy(a, "y") }
+	tb := func(a, b string) []float64 { return dummy(b, "q", "r") }
+	sa := func(a, b string) []float64 { return sumCoded(a, "x", "y") }
+	sb := func(a, b string) []float64 { return sumCoded(b, "p", "q", "r") }
+	cat := func(fs ...func(a, b string) []float64) func(a, b string) []float64 {
+		return func(a, b string) []float64 {
+			var row []float64
+			for _, f := range fs {
+				row = append(row, f(a, b)...)
+			}
+			return row
+		}
+	}
+	sab := func(a, b string) []float64 {
+		var row []float64
+		for _, va := range sa(a, b) {
+			for _, vb := range sb(a, b) {
+				row = append(row, va * vb)
+			}
+		}
+		return row
+	}
+
+	rssFull := rowRSS(t, cat(one, sa, sb, sab))
+	rssA := rowRSS(t, cat(one, ta))
+	rssB := rowRSS(t, cat(one, tb))
+	rssAB := rowRSS(t, cat(one, ta, tb))
+
+	wants := map[int][]float64{
+		FactorialTypeII: []float64{rssB - rssAB, rssA - rssAB, rssAB - rssFull},
+		FactorialTypeIII: []float64{
+			rowRSS(t, cat(one, sb, sab)) - rssFull,
+			rowRSS(t, cat(one, sa, sab)) - rssFull,
+			rssAB - rssFull,
+		},
+	}
+	dfs := []float64{1, 2, 2}
+
+	for sstype, want := range wants {
+		table, e := FactorialAnova([]string{"a", "b"}, factorialCells(), sstype)
+		if e != nil { t.Fatal(e) }
+		if len(table) != 4 {
+			t.Fatalf("type %v: table %v", sstype, table)
+		}
+
+		for i, name := range []string{"a", "b", "a:b"} {
+			got := table[i]
+			if got.Term != name || got.Df != dfs[i] || !closeTo(got.SS, want[i], 1e-9) {
+				t.Errorf("type %v: %v df %v ss %v; want %v df %v ss %v", sstype, got.Term, got.Df, got.SS, name, dfs[i], want[i])
+			}
+			f := (want[i] / dfs[i]) / (rssFull / 13)
+			if !closeTo(got.F, f, 1e-9) || !closeTo(got.P, FSurvival(f, dfs[i], 13), 1e-9) {
+				t.Errorf("type %v: %v F %v p %v; want %v, %v", sstype, got.Term, got.F, got.P, f, FSurvival(f, dfs[i], 13))
+			}
+		}
+		resid := table[3]
+		if resid.Term != "Residuals" || resid.Df != 13 || !closeTo(resid.SS, rssFull, 1e-9) {
+			t.Errorf("type %v: residuals %+v; want df 13 ss %v", sstype, resid, rssFull)
+		}
+	}
+}
+
+func TestFactorialAnovaOneWay(t *testing.T) {
+	var cells []*FactorialCell
+	tsum := NewTSummary()
+	for group, vals := range plantGrowth {
+		c := &FactorialCell{Levels: []string{group}}
+		for _, v := range vals {
+			c.Moments.Add(v)
+			tsum.Add(v, group)
+		}
+		cells = append(cells, c)
+	}
+
+	table, e := FactorialAnova([]string{"group"}, cells, FactorialTypeIII)
+	if e != nil { t.Fatal(e) }
+	want := OneWayAnova(tsum)
+	if !closeTo(table[0].SS, want.SSBetween, 1e-9) || !closeTo(table[0].F, want.Stat, 1e-9) || !closeTo(table[0].P, want.P, 1e-9) {
+		t.Errorf("got %+v; want %+v", table[0], want)
+	}
+}
+
+func TestRunFactorialAnova(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("a\tb\ty\n")
+	for _, r := range factorialRows {
+		fmt.Fprintf(&b, "%v\t%v\t%v\n", r.a, r.b, r.y)
+	}
+	b.WriteString("x\n")
+	rcm := stringTable(b.String())
+
+	var outs []string
+	serial, parallel := serialAndParallel(t, func(ro *RowOptions) {
+		var out bytes.Buffer
+		if e := RunFactorialAnova(rcm, &out, "y", []string{"a", "b"}, FactorialTypeII, ro); e != nil { t.Fatal(e) }
+		outs = append(outs, out.String())
+	})
+
+	if serial != parallel || !strings.Contains(serial, "short line") {
+		t.Errorf("rejects %q and %q", serial, parallel)
+	}
+	for _, out := range outs {
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		if len(lines) != 4 || !strings.HasPrefix(lines[2], "a:b\t2\t") || !strings.HasPrefix(lines[3], "Residuals\t13\t") {
+			t.Errorf("output %q", out)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"io"
 	"fmt"
 	"gonum.org/v1/gonum/stat/distuv"
+	"gonum.org/v1/gonum/mathext"
 	"math"
 )
 
@@ -30,6 +31,18 @@ func FTestP(f, df1, df2 float64) float64 {
 	return 2 * cdf
 }
 
+// The upper tail of the F distribution, computed directly from the
+// incomplete beta function so that it stays accurate for large f
+func FSurvival(f, df1, df2 float64) float64 {
+	if BadDF(df1) || BadDF(df2) || math.IsNaN(f) {
+		return math.NaN()
+	}
+	if f <= 0 {
+		return 1
+	}
+	return mathext.RegIncBeta(df2 / 2, df1 / 2, df2 / (df2 + df1 * f))
+}
+
 // Calculate the degrees of freedom in an F test.
 func FTestDf(ts1 *TSummary, id1 string, ts2 *TSummary, id2 string) (df1, df2 float64) {
 	return ts1.Counts[id1] - 1, ts2.Counts[id2] - 1
@@ -43,8 +56,8 @@ func FTest(w io.Writer, tsums []*TSummary, testset TTestSet) error {
 	mean1 := tsums[i1].Mean(name1)
 	mean2 := tsums[i2].Mean(name2)
 
-	sd1 := tsums[i1].Sd(name1)
-	sd2 := tsums[i2].Sd(name2)
+	sd1 := tsums[i1].SampleSd(name1)
+	sd2 := tsums[i2].SampleSd(name2)
 
 	count1 := tsums[i1].Counts[name1]
 	count2 := tsums[i2].Counts[name2]
@@ -70,17 +83,29 @@ func FTests(w io.Writer, tsums []*TSummary, testsets []TTestSet) error {
 	return nil
 }
 
-// Run the whole FTest pipeline
-func RunFTest(rcm ReadCloserMaker, w io.Writer, valcolname string, idcolsnames []string, controlsetidx, testsetidx int) error {
+// Run the whole FTest pipeline, testing equal variance by method, one of
+// FTestVariance, FTestLevene and FTestBrownForsythe
+func RunFTest(rcm ReadCloserMaker, w io.Writer, valcolname string, idcolsnames []string, controlsetidx, testsetidx int, method string, ro *RowOptions) error {
 	h := handle("Run: %w")
 
+	if e := CheckFTestMethod(method); e != nil { return h(e) }
+
 	valcol, e := ValCol(rcm, valcolname)
 	if e != nil { return h(e) }
 
 	idcols, e := IdCols(rcm, idcolsnames)
 	if e != nil { return h(e) }
 
-	tsummaries, testsets, e := CalcTSummary(rcm, valcol, idcolsnames, idcols, controlsetidx, testsetidx)
+	if method != FTestVariance {
+		tsummaries, devs, testsets, e := CalcDeviations(rcm, method, valcol, idcolsnames, idcols, controlsetidx, testsetidx, ro)
+		if e != nil { return h(e) }
+
+		e = LeveneTests(w, tsummaries, devs, testsets)
+		if e != nil { return h(e) }
+		return nil
+	}
+
+	tsummaries, testsets, e := CalcTSummary(rcm, valcol, idcolsnames, idcols, controlsetidx, testsetidx, ro)
 	if e != nil { return h(e) }
 
 	e = FTests(w, tsummaries, testsets)
@@ -90,7 +115,7 @@ func RunFTest(rcm ReadCloserMaker, w io.Writer, valcolname string, idcolsnames [
 }
 
 // Same as RunFTest, but with controlsetidx and testsetidx set to 0 and 1
-func RunFullFTest(rcm ReadCloserMaker, w io.Writer, valcolname, bloodcolname, testcolname string) error {
+func RunFullFTest(rcm ReadCloserMaker, w io.Writer, valcolname, bloodcolname, testcolname string, method string, ro *RowOptions) error {
 	idcolsnames := []string{bloodcolname, testcolname}
-	return RunFTest(rcm, w, valcolname, idcolsnames, 0, 1)
+	return RunFTest(rcm, w, valcolname, idcolsnames, 0, 1, method, ro)
 }
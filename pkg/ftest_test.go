@@ -86,6 +86,7 @@ const ftinNoNaN = `pos	indiv	hits	althits	count	tissue	chrom	gc	sample	bloodmean
 `
 
 func TestRunFTest(t *testing.T) {
+	ro := DefaultRowOptions()
 	h := handle("Run: %w")
 
 	cr := csv.NewReader(strings.NewReader(ftin))
@@ -97,7 +98,7 @@ func TestRunFTest(t *testing.T) {
 	controlsetidx := 0
 	testsetidx := 1
 
-	tsummaries, testsets, e := CalcTSummaryFromCsvReader(cr, valcol, idcolsnames, idcols, controlsetidx, testsetidx)
+	tsummaries, testsets, e := CalcTSummaryFromCsvReader(cr, valcol, idcolsnames, idcols, controlsetidx, testsetidx, ro)
 	if e != nil { panic(h(e)) }
 
 	var b strings.Builder
@@ -115,6 +116,7 @@ func TestRunFTest(t *testing.T) {
 }
 
 func TestRunFTestNoNaN(t *testing.T) {
+	ro := DefaultRowOptions()
 	h := handle("Run: %w")
 
 	cr := csv.NewReader(strings.NewReader(ftinNoNaN))
@@ -126,7 +128,7 @@ func TestRunFTestNoNaN(t *testing.T) {
 	controlsetidx := 0
 	testsetidx := 1
 
-	tsummaries, testsets, e := CalcTSummaryFromCsvReader(cr, valcol, idcolsnames, idcols, controlsetidx, testsetidx)
+	tsummaries, testsets, e := CalcTSummaryFromCsvReader(cr, valcol, idcolsnames, idcols, controlsetidx, testsetidx, ro)
 	if e != nil { panic(h(e)) }
 
 	var b strings.Builder
@@ -0,0 +1,339 @@
+package spstat
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+)
+
+// The number of bins in a histogram unless another is asked for
+const DefaultHistogramBins = 50
+
+// Counts of values in equal bins from Min to Max, with the values below and
+// above the range counted apart, and the Moments of all of the values. Two
+// histograms with the same bins can be merged.
+type Histogram struct {
+	Min float64
+	Max float64
+	Counts []float64
+	Below float64
+	Above float64
+	Moments Moments
+}
+
+// An empty histogram of bins bins from min to max. If min and max are equal,
+// the range is widened by 0.5 on each side.
+func NewHistogram(min, max float64, bins int) *Histogram {
+	if min == max {
+		min, max = min - 0.5, max + 0.5
+	}
+	return &Histogram{Min: min, Max: max, Counts: make([]float64, bins)}
+}
+
+// The width of each bin
+func (h *Histogram) Width() float64 {
+	return (h.Max - h.Min) / float64(len(h.Counts))
+}
+
+// The lower edge of bin i
+func (h *Histogram) BinStart(i int) float64 {
+	return h.Min + float64(i) * h.Width()
+}
+
+// The upper edge of bin i
+func (h *Histogram) BinEnd(i int) float64 {
+	if i == len(h.Counts) - 1 {
+		return h.Max
+	}
+	return h.BinStart(i + 1)
+}
+
+// Add one value. Max falls in the last bin.
+func (h *Histogram) Add(v float64) {
+	h.Moments.Add(v)
+	switch {
+	case v < h.Min:
+		h.Below++
+	case v > h.Max:
+		h.Above++
+	default:
+		i := int((v - h.Min) / h.Width())
+		if i >= len(h.Counts) {
+			i = len(h.Counts) - 1
+		}
+		h.Counts[i]++
+	}
+}
+
+// Add all of the values in o, which must have the same bins, to h
+func (h *Histogram) Merge(o *Histogram) {
+	for i, c := range o.Counts {
+		h.Counts[i] += c
+	}
+	h.Below += o.Below
+	h.Above += o.Above
+	h.Moments.Merge(&o.Moments)
+}
+
+// The number of values added, in range or not
+func (h *Histogram) Count() float64 {
+	return h.Moments.Count()
+}
+
+// The density of bin i: its share of all of the values over its width
+func (h *Histogram) Density(i int) float64 {
+	return h.Counts[i] / (h.Count() * h.Width())
+}
+
+// The value at quantile q, interpolated within the bin it falls in
+func (h *Histogram) Quantile(q float64) float64 {
+	target := q * h.Count() - h.Below
+	if target <= 0 {
+		return h.Min
+	}
+	for i, c := range h.Counts {
+		if target <= c {
+			return h.BinStart(i) + h.Width() * target / c
+		}
+		target -= c
+	}
+	return h.Max
+}
+
+// The bandwidth of a Gaussian kernel density estimate by Silverman's rule
+// of thumb, as R's bw.nrd0 gives: 0.9 times the smaller of the standard
+// deviation and the interquartile range over 1.34, times the count to the
+// -1/5
+func (h *Histogram) Bandwidth() float64 {
+	spread := math.Sqrt(h.Moments.SampleVar())
+	if iqr := (h.Quantile(0.75) - h.Quantile(0.25)) / 1.34; iqr > 0 && iqr < spread {
+		spread = iqr
+	}
+	if !(spread > 0) {
+		spread = h.Width()
+	}
+	return 0.9 * spread * math.Pow(h.Count(), -0.2)
+}
+
+// The Gaussian kernel density estimate at x with bandwidth bw, from the
+// values binned at the centers of their bins
+func (h *Histogram) KDE(x, bw float64) float64 {
+	sum := 0.0
+	for i, c := range h.Counts {
+		if c == 0 { continue }
+		z := (x - (h.BinStart(i) + h.BinEnd(i)) / 2) / bw
+		sum += c * math.Exp(-z * z / 2)
+	}
+	return sum / (h.Count() * bw * math.Sqrt(2 * math.Pi))
+}
+
+// A Histogram of the values in each named category in a particular column,
+// all with the same bins
+type NamedHistogramSet struct {
+	ColName string
+	Idx int
+	Min float64
+	Max float64
+	Bins int
+	Histograms map[string]*Histogram
+}
+
+// An empty set of histograms of bins bins from min to max
+func NewNamedHistogramSet(colname string, idx int, min, max float64, bins int) *NamedHistogramSet {
+	return &NamedHistogramSet{ColName: colname, Idx: idx, Min: min, Max: max, Bins: bins, Histograms: map[string]*Histogram{}}
+}
+
+// Add a value to the histogram of id
+func (s *NamedHistogramSet) Add(val float64, id string) {
+	h, ok := s.Histograms[id]
+	if !ok {
+		h = NewHistogram(s.Min, s.Max, s.Bins)
+		s.Histograms[id] = h
+	}
+	h.Add(val)
+}
+
+// Add all of the values in o to s
+func (s *NamedHistogramSet) Merge(o *NamedHistogramSet) {
+	for id, oh := range o.Histograms {
+		if h, ok := s.Histograms[id]; ok {
+			h.Merge(oh)
+		} else {
+			s.Histograms[id] = oh
+		}
+	}
+}
+
+// A NamedHistogramSet of the value column for each id column, gathered row
+// by row
+type histogramAccumulator struct {
+	valcol int
+	sets []*NamedHistogramSet
+}
+
+func newHistogramAccumulator(valcol int, idcolsnames []string, idcols []int, min, max float64, bins int) *histogramAccumulator {
+	acc := &histogramAccumulator{valcol: valcol}
+	for i, idcol := range idcols {
+		acc.sets = append(acc.sets, NewNamedHistogramSet(idcolsnames[i], idcol, min, max, bins))
+	}
+	return acc
+}
+
+func (a *histogramAccumulator) Row(rows *RowStage, cr *csv.Reader, line []string) error {
+	val, ok, e := rows.CsvFloat(cr, line, a.valcol)
+	if e != nil || !ok { return e }
+	rows.Keep()
+
+	for _, set := range a.sets {
+		if len(line) <= set.Idx { continue }
+		set.Add(val, line[set.Idx])
+	}
+	return nil
+}
+
+func (a *histogramAccumulator) Empty() RowAccumulator {
+	out := &histogramAccumulator{valcol: a.valcol}
+	for _, set := range a.sets {
+		out.sets = append(out.sets, NewNamedHistogramSet(set.ColName, set.Idx, set.Min, set.Max, set.Bins))
+	}
+	return out
+}
+
+func (a *histogramAccumulator) Merge(o RowAccumulator) error {
+	for i, set := range o.(*histogramAccumulator).sets {
+		a.sets[i].Merge(set)
+	}
+	return nil
+}
+
+// The smallest and largest values of a column
+type rangeAccumulator struct {
+	valcol int
+	count float64
+	min float64
+	max float64
+}
+
+func (a *rangeAccumulator) Row(rows *RowStage, cr *csv.Reader, line []string) error {
+	val, ok, e := rows.CsvFloat(cr, line, a.valcol)
+	if e != nil || !ok { return e }
+	rows.Keep()
+
+	if a.count == 0 || val < a.min {
+		a.min = val
+	}
+	if a.count == 0 || val > a.max {
+		a.max = val
+	}
+	a.count++
+	return nil
+}
+
+func (a *rangeAccumulator) Empty() RowAccumulator {
+	return &rangeAccumulator{valcol: a.valcol}
+}
+
+func (a *rangeAccumulator) Merge(o RowAccumulator) error {
+	oa := o.(*rangeAccumulator)
+	if oa.count == 0 {
+		return nil
+	}
+	if a.count == 0 || oa.min < a.min {
+		a.min = oa.min
+	}
+	if a.count == 0 || oa.max > a.max {
+		a.max = oa.max
+	}
+	a.count += oa.count
+	return nil
+}
+
+// Write each bin of each group of each of sets, one per line, with the
+// columns column, group, bin start, bin end, count and density, and, if kde
+// is set, the kernel density estimate at the middle of the bin with
+// bandwidth bw, or each group's Bandwidth if bw is 0
+func WriteHistograms(w io.Writer, sets []*NamedHistogramSet, kde bool, bw float64) error {
+	for _, set := range sets {
+		counts := map[string]float64{}
+		for id, h := range set.Histograms {
+			counts[id] = h.Count()
+		}
+
+		for _, name := range sortedGroups(counts) {
+			h := set.Histograms[name]
+			groupbw := bw
+			if groupbw == 0 {
+				groupbw = h.Bandwidth()
+			}
+
+			for i, c := range h.Counts {
+				_, e := fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v",
+					set.ColName, name, h.BinStart(i), h.BinEnd(i), c, h.Density(i),
+				)
+				if e == nil && kde {
+					_, e = fmt.Fprintf(w, "\t%v", h.KDE((h.BinStart(i) + h.BinEnd(i)) / 2, groupbw))
+				}
+				if e == nil {
+					_, e = fmt.Fprintf(w, "\n")
+				}
+				if e != nil { return fmt.Errorf("WriteHistograms: %w", e) }
+			}
+		}
+	}
+	return nil
+}
+
+// Gather a NamedHistogramSet of valcol for each of idcols, with bins bins
+// from min to max. If either of min and max is NaN, a first pass finds it
+// from the smallest or largest value.
+func CalcHistograms(rcm ReadCloserMaker, valcol int, idcolsnames []string, idcols []int, min, max float64, bins int, ro *RowOptions) ([]*NamedHistogramSet, error) {
+	h := handle("CalcHistograms: %w")
+
+	if bins < 1 {
+		return nil, h(fmt.Errorf("%v bins", bins))
+	}
+
+	if math.IsNaN(min) || math.IsNaN(max) {
+		racc := &rangeAccumulator{valcol: valcol}
+		if e := rowPass(rcm, "HistogramRange", racc, ro); e != nil { return nil, h(e) }
+		if racc.count == 0 {
+			return nil, h(fmt.Errorf("no values to find the range of"))
+		}
+		if math.IsNaN(min) {
+			min = racc.min
+		}
+		if math.IsNaN(max) {
+			max = racc.max
+		}
+	}
+	if !(min <= max) || math.IsInf(min, 0) || math.IsInf(max, 0) {
+		return nil, h(fmt.Errorf("bad range %v to %v", min, max))
+	}
+
+	acc := newHistogramAccumulator(valcol, idcolsnames, idcols, min, max, bins)
+	if e := rowPass(rcm, "CalcHistograms", acc, ro); e != nil { return nil, h(e) }
+	return acc.sets, nil
+}
+
+// Write histograms of valcolname for each group of each of idcolsnames, as
+// CalcHistograms and WriteHistograms do
+func RunHistograms(rcm ReadCloserMaker, w io.Writer, valcolname string, idcolsnames []string, min, max float64, bins int, kde bool, bw float64, ro *RowOptions) error {
+	h := handle("RunHistograms: %w")
+
+	if !(bw >= 0) {
+		return h(fmt.Errorf("bandwidth %v is negative", bw))
+	}
+
+	valcol, e := ValCol(rcm, valcolname)
+	if e != nil { return h(e) }
+
+	idcols, e := IdCols(rcm, idcolsnames)
+	if e != nil { return h(e) }
+
+	sets, e := CalcHistograms(rcm, valcol, idcolsnames, idcols, min, max, bins, ro)
+	if e != nil { return h(e) }
+
+	if e := WriteHistograms(w, sets, kde, bw); e != nil { return h(e) }
+	return nil
+}
@@ -0,0 +1,101 @@
+package spstat
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram(0, 1, 4)
+	parts := []*Histogram{NewHistogram(0, 1, 4), NewHistogram(0, 1, 4)}
+	for i, v := range []float64{-1, 0, 0.1, 0.25, 0.3, 0.6, 0.99, 1, 2, 2} {
+		h.Add(v)
+		parts[i % 2].Add(v)
+	}
+	parts[0].Merge(parts[1])
+
+	for _, got := range []*Histogram{h, parts[0]} {
+		if fmt.Sprint(got.Counts) != "[2 2 1 2]" || got.Below != 1 || got.Above != 2 || got.Count() != 10 {
+			t.Errorf("counts %v, below %v, above %v, count %v", got.Counts, got.Below, got.Above, got.Count())
+		}
+		if got.BinStart(1) != 0.25 || got.BinEnd(3) != 1 || got.Density(0) != 2 / (10 * 0.25) {
+			t.Errorf("bin 1 starts at %v, bin 3 ends at %v, density %v", got.BinStart(1), got.BinEnd(3), got.Density(0))
+		}
+	}
+
+	same := NewHistogram(3, 3, 2)
+	same.Add(3)
+	if same.Min != 2.5 || same.Max != 3.5 || same.Counts[1] != 1 {
+		t.Errorf("histogram of one value: %+v", same)
+	}
+}
+
+func TestHistogramKDE(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	vals := make([]float64, 20000)
+	h := NewHistogram(-6, 6, 600)
+	for i := range vals {
+		vals[i] = r.NormFloat64()
+		h.Add(vals[i])
+	}
+	sort.Float64s(vals)
+
+	// Silverman's rule from the raw values
+	var m Moments
+	for _, v := range vals {
+		m.Add(v)
+	}
+	iqr := vals[15000] - vals[5000]
+	want := 0.9 * math.Min(math.Sqrt(m.SampleVar()), iqr / 1.34) * math.Pow(20000, -0.2)
+	bw := h.Bandwidth()
+	if !closeTo(bw, want, 0.01) {
+		t.Errorf("bandwidth %v, want %v", bw, want)
+	}
+
+	// The estimate integrates to one and is close to the normal density
+	total := 0.0
+	for i := range h.Counts {
+		total += h.KDE((h.BinStart(i) + h.BinEnd(i)) / 2, bw) * h.Width()
+	}
+	if !closeTo(total, 1, 1e-3) {
+		t.Errorf("estimate integrates to %v", total)
+	}
+	if d := h.KDE(0, bw); math.Abs(d - 1 / math.Sqrt(2 * math.Pi)) > 0.02 {
+		t.Errorf("estimate at 0 is %v", d)
+	}
+}
+
+func TestRunHistograms(t *testing.T) {
+	ro := DefaultRowOptions()
+	rcm := chunkTestTable()
+
+	var outs []string
+	serial, parallel := serialAndParallel(t, func(ro *RowOptions) {
+		var out bytes.Buffer
+		if e := RunHistograms(rcm, &out, "value", []string{"tissue"}, math.NaN(), math.NaN(), 4, false, 0, ro); e != nil { t.Fatal(e) }
+		outs = append(outs, out.String())
+	})
+	if serial != parallel {
+		t.Errorf("rejects differ:\n%v\n%v", serial, parallel)
+	}
+	if outs[0] != outs[1] {
+		t.Errorf("serial and parallel histograms differ:\n%v\n%v", outs[0], outs[1])
+	}
+
+	lines := strings.Split(strings.TrimSpace(outs[0]), "\n")
+	if len(lines) != 8 || !strings.HasPrefix(lines[0], "tissue\tblood\t0\t0.23529411764705882\t") || !strings.HasPrefix(lines[7], "tissue\tsperm\t0.7058823529411764\t0.9411764705882353\t") {
+		t.Errorf("output %q", outs[0])
+	}
+
+	var withkde bytes.Buffer
+	if e := RunHistograms(rcm, &withkde, "value", []string{"group"}, 0, 1, 10, true, 0.1, ro); e != nil { t.Fatal(e) }
+	lines = strings.Split(strings.TrimSpace(withkde.String()), "\n")
+	if len(lines) != 70 || len(strings.Split(lines[0], "\t")) != 7 {
+		t.Errorf("%v lines, first %q", len(lines), lines[0])
+	}
+}
@@ -0,0 +1,273 @@
+package spstat
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"hash/fnv"
+)
+
+// The accuracy of a QuantileSketch unless another is asked for
+const DefaultSketchK = 200
+
+// The rank error of a QuantileSketch with accuracy k: with 99% confidence,
+// the rank of the value the sketch gives for any quantile q is within this
+// fraction of all values of q. This is the bound of the KLL paper as fit to
+// simulations by the DataSketches library, which this sketch's compaction
+// policy follows; about 1.3% for DefaultSketchK.
+func SketchRankError(k int) float64 {
+	return 2.296 / math.Pow(float64(k), 0.9723)
+}
+
+// The confidence with which a QuantileSketch's quantiles are within its
+// rank error. The error is a probabilistic bound, not a guarantee: about one
+// quantile in a hundred may fall outside it.
+const SketchConfidence = 0.99
+
+// The smallest k whose rank error is at most eps, which must be positive
+func SketchK(eps float64) int {
+	k := int(math.Ceil(math.Pow(2.296 / eps, 1 / 0.9723)))
+	if k < minSketchK {
+		return minSketchK
+	}
+	return k
+}
+
+// The smallest level capacity, and so the smallest k
+const minSketchK = 8
+
+// A KLL sketch of a stream of values, for medians and other quantiles in a
+// fixed amount of memory. Values are kept in levels of compactors: a full
+// level is sorted and every other value, starting at random, moves up a
+// level, where it stands for twice as many values. Until the first
+// compaction the sketch holds every value and its quantiles are exact. Two
+// sketches with the same k can be merged, so partial sketches from shards or
+// threads can be combined.
+type QuantileSketch struct {
+	k int
+	levels [][]float64
+	size int
+	limit int
+	count float64
+	min float64
+	max float64
+	rng uint64
+}
+
+// A seed for the sketch of the values of key in chunk n of a table, or in
+// the whole table if n is 0: a hash of both, scrambled by the splitmix64
+// finalizer, so a run gives the same quantiles whatever else it runs beside.
+// Sketches merged together must not flip the same coins, or their
+// compactions would all keep the same side, so each chunk's sketch of a key
+// has its own seed.
+func SketchSeed(key string, n int) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(key))
+	z := f.Sum64() + uint64(n + 1) * 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// An empty sketch whose coins start from seed, as from SketchSeed. Larger k
+// is more accurate and uses more memory; see SketchRankError.
+func NewQuantileSketch(k int, seed uint64) *QuantileSketch {
+	if k < minSketchK {
+		k = minSketchK
+	}
+	// The coins never leave a seed of 0
+	if seed == 0 {
+		seed = 0x9e3779b97f4a7c15
+	}
+	s := &QuantileSketch{k: k, rng: seed}
+	s.grow()
+	return s
+}
+
+// The number of values level h of a sketch with this many levels can hold
+func (s *QuantileSketch) capacity(h int) int {
+	depth := len(s.levels) - 1 - h
+	c := int(math.Ceil(float64(s.k) * math.Pow(2.0 / 3.0, float64(depth))))
+	if c < minSketchK {
+		return minSketchK
+	}
+	return c
+}
+
+// Add a level on top, and find the number of values the sketch can now hold
+func (s *QuantileSketch) grow() {
+	s.levels = append(s.levels, nil)
+	s.limit = 0
+	for h := range s.levels {
+		s.limit += s.capacity(h)
+	}
+}
+
+// A random bit, from a xorshift generator, so a sketch's coins depend only on
+// its seed
+func (s *QuantileSketch) coin() int {
+	s.rng ^= s.rng << 13
+	s.rng ^= s.rng >> 7
+	s.rng ^= s.rng << 17
+	return int(s.rng & 1)
+}
+
+// Add one value. NaNs are ignored.
+func (s *QuantileSketch) Add(x float64) {
+	if math.IsNaN(x) {
+		return
+	}
+	if s.count == 0 || x < s.min {
+		s.min = x
+	}
+	if s.count == 0 || x > s.max {
+		s.max = x
+	}
+	s.count++
+	s.levels[0] = append(s.levels[0], x)
+	s.size++
+	s.compress()
+}
+
+// Add all of the values in o to s. Both must have the same k.
+func (s *QuantileSketch) Merge(o *QuantileSketch) error {
+	if s.k != o.k {
+		return fmt.Errorf("QuantileSketch.Merge: cannot merge sketch with k %v into sketch with k %v", o.k, s.k)
+	}
+	if o.count == 0 {
+		return nil
+	}
+	if s.count == 0 || o.min < s.min {
+		s.min = o.min
+	}
+	if s.count == 0 || o.max > s.max {
+		s.max = o.max
+	}
+	s.count += o.count
+	for h, level := range o.levels {
+		for len(s.levels) <= h {
+			s.grow()
+		}
+		s.levels[h] = append(s.levels[h], level...)
+		s.size += len(level)
+	}
+	s.compress()
+	return nil
+}
+
+// Compact full levels until the sketch fits
+func (s *QuantileSketch) compress() {
+	for s.size >= s.limit {
+		for h := range s.levels {
+			if len(s.levels[h]) >= s.capacity(h) {
+				s.compact(h)
+				break
+			}
+		}
+	}
+}
+
+// Move every other value of level h up a level. If the level has an odd
+// number of values, its smallest stays behind.
+func (s *QuantileSketch) compact(h int) {
+	if h + 1 == len(s.levels) {
+		s.grow()
+	}
+	level := s.levels[h]
+	sort.Float64s(level)
+
+	odd := len(level) % 2
+	for i := odd + s.coin(); i < len(level); i += 2 {
+		s.levels[h+1] = append(s.levels[h+1], level[i])
+	}
+	s.size -= (len(level) - odd) / 2
+	s.levels[h] = level[:odd]
+}
+
+// The number of values added
+func (s *QuantileSketch) Count() float64 {
+	return s.count
+}
+
+// Whether the sketch still holds every value, so its quantiles are exact
+func (s *QuantileSketch) Exact() bool {
+	return len(s.levels) == 1
+}
+
+// The rank error of the sketch's quantiles: 0 while it is exact, and
+// SketchRankError after
+func (s *QuantileSketch) RankError() float64 {
+	if s.Exact() {
+		return 0
+	}
+	return SketchRankError(s.k)
+}
+
+// The confidence that the sketch's quantiles are within RankError: 1 while
+// it is exact, and SketchConfidence after
+func (s *QuantileSketch) Confidence() float64 {
+	if s.Exact() {
+		return 1
+	}
+	return SketchConfidence
+}
+
+// The value at quantile q, between 0 and 1, or NaN if there are no values.
+// While the sketch is exact, this interpolates between the values around q
+// as R's quantile does by default, so the median of an even number of
+// values is the mean of the middle two.
+func (s *QuantileSketch) Quantile(q float64) float64 {
+	if s.count == 0 || math.IsNaN(q) {
+		return math.NaN()
+	}
+	if q <= 0 {
+		return s.min
+	}
+	if q >= 1 {
+		return s.max
+	}
+
+	if s.Exact() {
+		vals := append([]float64(nil), s.levels[0]...)
+		sort.Float64s(vals)
+		pos := q * float64(len(vals) - 1)
+		lo := int(math.Floor(pos))
+		if lo + 1 >= len(vals) {
+			return vals[lo]
+		}
+		frac := pos - float64(lo)
+		return vals[lo] + frac * (vals[lo+1] - vals[lo])
+	}
+
+	type weighted struct {
+		val float64
+		weight float64
+	}
+	var items []weighted
+	total := 0.0
+	for h, level := range s.levels {
+		w := math.Ldexp(1, h)
+		for _, v := range level {
+			items = append(items, weighted{v, w})
+			total += w
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].val < items[j].val
+	})
+
+	target := q * total
+	cum := 0.0
+	for _, it := range items {
+		cum += it.weight
+		if cum >= target {
+			return it.val
+		}
+	}
+	return s.max
+}
+
+// The median, or NaN if there are no values
+func (s *QuantileSketch) Median() float64 {
+	return s.Quantile(0.5)
+}
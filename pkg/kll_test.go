@@ -0,0 +1,85 @@
+package spstat
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestQuantileSketch(t *testing.T) {
+	small := NewQuantileSketch(DefaultSketchK, SketchSeed("small", 0))
+	for _, v := range []float64{5, 1, 4, 2} {
+		small.Add(v)
+	}
+	if !small.Exact() || small.Median() != 3 || small.Quantile(0.25) != 1.75 {
+		t.Errorf("exact median %v, first quartile %v", small.Median(), small.Quantile(0.25))
+	}
+
+	r := rand.New(rand.NewSource(1))
+	n := 200000
+	vals := make([]float64, n)
+	all := NewQuantileSketch(DefaultSketchK, SketchSeed("all", 0))
+	parts := []*QuantileSketch{}
+	for i := 0; i < 4; i++ {
+		parts = append(parts, NewQuantileSketch(DefaultSketchK, SketchSeed("all", i + 1)))
+	}
+	for i := range vals {
+		vals[i] = r.ExpFloat64()
+		all.Add(vals[i])
+		parts[i % 4].Add(vals[i])
+	}
+	merged := parts[0]
+	for _, p := range parts[1:] {
+		if p.rng == merged.rng {
+			t.Errorf("sketches share seed %x", p.rng)
+		}
+		if e := merged.Merge(p); e != nil { t.Fatal(e) }
+	}
+	sort.Float64s(vals)
+
+	for _, s := range []*QuantileSketch{all, merged} {
+		if s.Count() != float64(n) || s.Exact() {
+			t.Errorf("count %v, exact %v", s.Count(), s.Exact())
+		}
+		for _, q := range []float64{0.01, 0.25, 0.5, 0.75, 0.99} {
+			rank := float64(sort.SearchFloat64s(vals, s.Quantile(q))) / float64(n)
+			if rank - q > 0.02 || q - rank > 0.02 {
+				t.Errorf("quantile %v has rank %v", q, rank)
+			}
+		}
+		if s.Quantile(0) != vals[0] || s.Quantile(1) != vals[n-1] {
+			t.Errorf("min %v, max %v", s.Quantile(0), s.Quantile(1))
+		}
+	}
+}
+
+func TestSketchK(t *testing.T) {
+	for _, eps := range []float64{0.1, 0.01, 0.0133, 0.001} {
+		k := SketchK(eps)
+		if SketchRankError(k) > eps || (k > minSketchK && SketchRankError(k - 1) <= eps) {
+			t.Errorf("SketchK(%v) = %v, with rank error %v", eps, k, SketchRankError(k))
+		}
+	}
+	if SketchK(0.9) != minSketchK {
+		t.Errorf("SketchK(0.9) = %v, want %v", SketchK(0.9), minSketchK)
+	}
+}
+
+func TestQuantileSketchMergeK(t *testing.T) {
+	a, b := NewQuantileSketch(DefaultSketchK, 1), NewQuantileSketch(DefaultSketchK / 2, 2)
+	a.Add(1)
+	b.Add(2)
+	if e := a.Merge(b); e == nil {
+		t.Errorf("merged sketches with k %v and %v", a.k, b.k)
+	}
+	if a.Count() != 1 || a.Merge(NewQuantileSketch(DefaultSketchK, 3)) != nil {
+		t.Errorf("count %v after a failed merge", a.Count())
+	}
+
+	set, other := NewNamedQuantileSet("v", 0, DefaultSketchK), NewNamedQuantileSet("v", 0, 50)
+	set.Add(1, "x")
+	other.Add(2, "x")
+	if e := set.Merge(other); e == nil {
+		t.Errorf("merged quantile sets with different k")
+	}
+}
@@ -0,0 +1,239 @@
+package spstat
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+)
+
+// The tests of equal variance that ftest can run
+const (
+	// The ratio of the sample variances
+	FTestVariance = "f"
+	// Levene's test: an ANOVA of the distances of the values from their
+	// group's mean
+	FTestLevene = "levene"
+	// The Brown–Forsythe test: Levene's test with distances from the
+	// group's median, which is robust to skewed values
+	FTestBrownForsythe = "brown-forsythe"
+)
+
+// Check that method is one of the ftest methods
+func CheckFTestMethod(method string) error {
+	switch method {
+	case FTestVariance, FTestLevene, FTestBrownForsythe:
+		return nil
+	}
+	return fmt.Errorf("ftest method %q is not %v, %v or %v", method, FTestVariance, FTestLevene, FTestBrownForsythe)
+}
+
+// The TSummary and a NamedQuantileSet of the value column for each id
+// column, gathered row by row, for the medians of the Brown–Forsythe test
+type medianAccumulator struct {
+	ts *tsummaryAccumulator
+	qs *quantileAccumulator
+}
+
+func newMedianAccumulator(valcol int, idcolsnames []string, idcols []int) *medianAccumulator {
+	return &medianAccumulator{
+		ts: newTSummaryAccumulator(valcol, idcolsnames, idcols),
+		qs: newQuantileAccumulator(valcol, idcolsnames, idcols, DefaultSketchK),
+	}
+}
+
+func (a *medianAccumulator) Row(rows *RowStage, cr *csv.Reader, line []string) error {
+	val, ok, e := rows.CsvFloat(cr, line, a.ts.valcol)
+	if e != nil || !ok { return e }
+	rows.Keep()
+
+	for i, tsum := range a.ts.tsums {
+		if len(line) <= tsum.Idx { continue }
+		tsum.Add(val, line[tsum.Idx])
+		a.qs.sets[i].Add(val, line[tsum.Idx])
+	}
+	return nil
+}
+
+func (a *medianAccumulator) Empty() RowAccumulator {
+	return &medianAccumulator{
+		ts: a.ts.Empty().(*tsummaryAccumulator),
+		qs: a.qs.Empty().(*quantileAccumulator),
+	}
+}
+
+func (a *medianAccumulator) seedChunk(n int) {
+	a.qs.seedChunk(n)
+}
+
+func (a *medianAccumulator) Merge(o RowAccumulator) error {
+	oa := o.(*medianAccumulator)
+	if e := a.ts.Merge(oa.ts); e != nil { return e }
+	return a.qs.Merge(oa.qs)
+}
+
+// The median of each group, in the order of the id columns
+func (a *medianAccumulator) medians() []map[string]float64 {
+	var centers []map[string]float64
+	for _, set := range a.qs.sets {
+		m := map[string]float64{}
+		for id, _ := range set.Sketches {
+			m[id] = set.Median(id)
+		}
+		centers = append(centers, m)
+	}
+	return centers
+}
+
+// The mean of each group of each TSummary
+func tsummaryMeans(tsums []*TSummary) []map[string]float64 {
+	var centers []map[string]float64
+	for _, tsum := range tsums {
+		m := map[string]float64{}
+		for id, _ := range tsum.Moments {
+			m[id] = tsum.Mean(id)
+		}
+		centers = append(centers, m)
+	}
+	return centers
+}
+
+// Moments of the absolute deviations of the value column from the center of
+// each group of each id column
+type deviationAccumulator struct {
+	valcol int
+	idcols []int
+	centers []map[string]float64
+	devs []map[string]*Moments
+}
+
+func newDeviationAccumulator(valcol int, idcols []int, centers []map[string]float64) *deviationAccumulator {
+	a := &deviationAccumulator{valcol: valcol, idcols: idcols, centers: centers}
+	a.devs = make([]map[string]*Moments, len(idcols))
+	for i := range a.devs {
+		a.devs[i] = map[string]*Moments{}
+	}
+	return a
+}
+
+func (a *deviationAccumulator) Row(rows *RowStage, cr *csv.Reader, line []string) error {
+	val, ok, e := rows.CsvFloat(cr, line, a.valcol)
+	if e != nil || !ok { return e }
+	rows.Keep()
+
+	for i, idcol := range a.idcols {
+		if len(line) <= idcol { continue }
+		id := line[idcol]
+		center, ok := a.centers[i][id]
+		if !ok { continue }
+
+		m, ok := a.devs[i][id]
+		if !ok {
+			m = &Moments{}
+			a.devs[i][id] = m
+		}
+		m.Add(math.Abs(val - center))
+	}
+	return nil
+}
+
+func (a *deviationAccumulator) Empty() RowAccumulator {
+	return newDeviationAccumulator(a.valcol, a.idcols, a.centers)
+}
+
+func (a *deviationAccumulator) Merge(o RowAccumulator) error {
+	for i, devs := range o.(*deviationAccumulator).devs {
+		for id, om := range devs {
+			m, ok := a.devs[i][id]
+			if !ok {
+				m = &Moments{}
+				a.devs[i][id] = m
+			}
+			m.Merge(om)
+		}
+	}
+	return nil
+}
+
+// Levene's W for two groups, given the moments of each group's absolute
+// deviations from its center, and its degrees of freedom
+func LeveneCore(dev1, dev2 *Moments) (w, df1, df2 float64) {
+	n1, n2 := dev1.Count(), dev2.Count()
+	n := n1 + n2
+	mean1, mean2 := dev1.Mean(), dev2.Mean()
+	mean := (n1 * mean1 + n2 * mean2) / n
+
+	between := n1 * (mean1 - mean) * (mean1 - mean) + n2 * (mean2 - mean) * (mean2 - mean)
+	within := dev1.PopVar() * n1 + dev2.PopVar() * n2
+	df1, df2 = 1, n - 2
+	return (between / df1) / (within / df2), df1, df2
+}
+
+// The P value of a Levene or Brown–Forsythe W: the upper tail of F
+func LeveneP(w, df1, df2 float64) float64 {
+	return FSurvival(w, df1, df2)
+}
+
+// Calculate a Levene or Brown–Forsythe test for one TTestSet from the
+// deviations of devs, the deviation moments in the order of tsums. Print to
+// w in the same columns as FTest, with W in place of F.
+func LeveneTest(w io.Writer, tsums []*TSummary, devs []map[string]*Moments, testset TTestSet) error {
+	i1, name1 := TsumsSet(tsums, testset.Control)
+	i2, name2 := TsumsSet(tsums, testset.Exp)
+
+	dev1, ok := devs[i1][name1]
+	if !ok { dev1 = &Moments{} }
+	dev2, ok := devs[i2][name2]
+	if !ok { dev2 = &Moments{} }
+
+	lw, df1, df2 := LeveneCore(dev1, dev2)
+	p := LeveneP(lw, df1, df2)
+
+	_, e := fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+		name1, name2,
+		tsums[i1].Counts[name1], tsums[i2].Counts[name2],
+		tsums[i1].Mean(name1), tsums[i2].Mean(name2),
+		tsums[i1].SampleSd(name1), tsums[i2].SampleSd(name2),
+		lw, df1, df2, p,
+	)
+	return e
+}
+
+// Run LeveneTest on each of testsets
+func LeveneTests(w io.Writer, tsums []*TSummary, devs []map[string]*Moments, testsets []TTestSet) error {
+	for _, tset := range testsets {
+		if e := LeveneTest(w, tsums, devs, tset); e != nil {
+			return fmt.Errorf("LeveneTests: %w", e)
+		}
+	}
+	return nil
+}
+
+// Gather the TSummaries and group centers in one pass, then the absolute
+// deviations from the centers in a second: means for FTestLevene, and
+// medians from a QuantileSketch for FTestBrownForsythe
+func CalcDeviations(rcm ReadCloserMaker, method string, valcol int, idcolsnames []string, idcols []int, controlsetidx, testsetidx int, ro *RowOptions) ([]*TSummary, []map[string]*Moments, []TTestSet, error) {
+	h := handle("CalcDeviations: %w")
+
+	var tsums []*TSummary
+	var centers []map[string]float64
+	switch method {
+	case FTestLevene:
+		var e error
+		tsums, _, e = CalcTSummary(rcm, valcol, idcolsnames, idcols, controlsetidx, testsetidx, ro)
+		if e != nil { return nil, nil, nil, h(e) }
+		centers = tsummaryMeans(tsums)
+	case FTestBrownForsythe:
+		acc := newMedianAccumulator(valcol, idcolsnames, idcols)
+		if e := rowPass(rcm, "CalcMedians", acc, ro); e != nil { return nil, nil, nil, h(e) }
+		tsums = acc.ts.tsums
+		centers = acc.medians()
+	default:
+		return nil, nil, nil, h(fmt.Errorf("method %q has no centers", method))
+	}
+
+	acc := newDeviationAccumulator(valcol, idcols, centers)
+	if e := rowPass(rcm, "CalcDeviations", acc, ro); e != nil { return nil, nil, nil, h(e) }
+
+	return tsums, acc.devs, TTestSets(tsums, idcolsnames, controlsetidx, testsetidx), nil
+}
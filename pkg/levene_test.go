@@ -0,0 +1,79 @@
+package spstat
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Student's t test on the absolute deviations of a and b from their centers
+func deviationTTest(a, b []float64, center func([]float64) float64) TTestResult {
+	var m1, m2 Moments
+	ca, cb := center(a), center(b)
+	for _, v := range a {
+		m1.Add(math.Abs(v - ca))
+	}
+	for _, v := range b {
+		m2.Add(math.Abs(v - cb))
+	}
+	opts := DefaultTTestOptions()
+	opts.Method = TTestStudent
+	return CalcTTest("a", "b", m1.Count(), m2.Count(), m1.Mean(), m2.Mean(), math.Sqrt(m1.SampleVar()), math.Sqrt(m2.SampleVar()), opts)
+}
+
+func mean(vals []float64) float64 {
+	var m Moments
+	for _, v := range vals {
+		m.Add(v)
+	}
+	return m.Mean()
+}
+
+func median(vals []float64) float64 {
+	s := append([]float64(nil), vals...)
+	sort.Float64s(s)
+	n := len(s)
+	return (s[(n-1)/2] + s[n/2]) / 2
+}
+
+func TestLevene(t *testing.T) {
+	ro := DefaultRowOptions()
+
+	var b strings.Builder
+	b.WriteString("tissue\ttest\tvalue\n")
+	for _, v := range sleep1 {
+		fmt.Fprintf(&b, "blood\tb\t%v\n", v)
+	}
+	for _, v := range sleep2 {
+		fmt.Fprintf(&b, "sperm\ts\t%v\n", v)
+	}
+	b.WriteString("sperm\ts\t7.5\n")
+	rcm := stringTable(b.String())
+	sperm := append(append([]float64(nil), sleep2...), 7.5)
+
+	// With two groups, W is the square of Student's t on the deviations
+	centers := map[string]func([]float64) float64{FTestLevene: mean, FTestBrownForsythe: median}
+	for method, center := range centers {
+		var out bytes.Buffer
+		if e := RunFTest(rcm, &out, "value", []string{"tissue", "test"}, 0, 1, method, ro); e != nil { t.Fatal(e) }
+
+		rs, e := ReadFTestResults(&out)
+		if e != nil { t.Fatal(e) }
+
+		want := deviationTTest(sleep1, sperm, center)
+		found := false
+		for _, r := range rs {
+			if r.Name2 != "s" { continue }
+			found = true
+			if !closeTo(r.F, want.T * want.T, 1e-12) || r.Df1 != 1 || r.Df2 != 19 || !closeTo(r.P, want.P, 1e-9) {
+				t.Errorf("%v: W %v, df %v, %v, p %v; want %v, 1, 19, %v", method, r.F, r.Df1, r.Df2, r.P, want.T * want.T, want.P)
+			}
+		}
+		if !found {
+			t.Errorf("%v: no test of s in %v", method, rs)
+		}
+	}
+}
@@ -2,13 +2,14 @@ package spstat
 
 import (
 	"github.com/jgbaldwinbrown/csvh"
+	"encoding/csv"
 	"fmt"
 	"io"
 )
 
 // Generate a TSummary for each specified column, with transformFunc used to
 // convert the strings in the column into expectations.
-func CalcFullColTSummaryTransform(rcm ReadCloserMaker, cols []int, transformFunc func(string) float64) ([]*TSummary, error) {
+func CalcFullColTSummaryTransform(rcm ReadCloserMaker, cols []int, transformFunc func(string) float64, ro *RowOptions) ([]*TSummary, error) {
 	h := handle("CalcTSummaryTransform: %w")
 
 	var tsums []*TSummary
@@ -23,10 +24,14 @@ func CalcFullColTSummaryTransform(rcm ReadCloserMaker, cols []int, transformFunc
 	defer r.Close()
 	cr := csvh.CsvIn(r)
 
+	if e := skipHeader(cr); e != nil { return tsums, h(e) }
+	rows := ro.Stage("CalcFullColTSummaryTransform")
+	defer rows.Done()
+
 	for line, e := cr.Read(); e != io.EOF; line, e = cr.Read() {
 		if e != nil { return tsums, h(e) }
 
-
+		used := false
 		for i, tsum := range tsums {
 			valcol := cols[i]
 			if len(line) <= valcol { continue }
@@ -34,24 +39,57 @@ func CalcFullColTSummaryTransform(rcm ReadCloserMaker, cols []int, transformFunc
 
 			if len(line) <= tsum.Idx { continue }
 			tsum.Add(val, "")
+			used = true
+		}
+
+		if used {
+			rows.Keep()
+		} else if e := rows.RejectCsv(ShortLine, cr, line); e != nil {
+			return tsums, h(e)
 		}
 	}
 
 	return tsums, nil
 }
 
-// Calculate a linear model fitting valcol ~ indepcol, and using transformFunc on valcol
-func LinearModelTransform(rcm ReadCloserMaker, valcol, indepcol int, transformFunc(func(string)float64)) (m, b float64, err error) {
-	h := handle("LinearModel: %w")
+// A LinearModeler of transform of the value column against the independent
+// column, gathered row by row
+type transformLinearAccumulator struct {
+	linearAccumulator
+	transform func(string) float64
+}
+
+func (a *transformLinearAccumulator) Row(rows *RowStage, cr *csv.Reader, line []string) error {
+	if len(line) <= a.valcol {
+		return rows.RejectCsv(ShortLine, cr, line)
+	}
+
+	indep, ok, e := rows.CsvFloat(cr, line, a.indepcol)
+	if e != nil || !ok { return e }
+
+	rows.Keep()
+	a.l.Add(a.transform(line[a.valcol]), indep)
+	return nil
+}
+
+func (a *transformLinearAccumulator) Empty() RowAccumulator {
+	return &transformLinearAccumulator{*a.linearAccumulator.Empty().(*linearAccumulator), a.transform}
+}
+
+func (a *transformLinearAccumulator) Merge(o RowAccumulator) error {
+	a.l.Merge(o.(*transformLinearAccumulator).l)
+	return nil
+}
+
+// Calculate a linear model fitting valcol ~ indepcol, and using transformFunc
+// on valcol, in one pass
+func LinearModelTransform(rcm ReadCloserMaker, valcol, indepcol int, transformFunc(func(string)float64), ro *RowOptions) (m, b float64, err error) {
+	h := handle("LinearModelTransform: %w")
 
-	valtsums, e := CalcFullColTSummaryTransform(rcm, []int{valcol}, transformFunc)
-	indeptsums, e := CalcFullColTSummary(rcm, []int{indepcol})
-	if e != nil { return 0, 0, h(e) }
-	vmean := valtsums[0].Mean("")
-	imean := indeptsums[0].Mean("")
+	acc := &transformLinearAccumulator{linearAccumulator{valcol: valcol, indepcol: indepcol, l: &LinearModeler{}}, transformFunc}
+	if e := rowPass(rcm, "LinearModelTransform", acc, ro); e != nil { return 0, 0, h(e) }
 
-	m, b, e = LinearModelCore(rcm, valcol, indepcol, vmean, imean)
-	if e != nil { return 0, 0, h(e) }
+	m, b = acc.l.MB()
 	return m, b, nil
 }
 
@@ -65,7 +103,7 @@ func ChrToExpectation(chr string) float64 {
 }
 
 // Run the whole linear model coverage pipeline
-func RunLinearModelCoverage(rcm ReadCloserMaker, w io.Writer, valcolname, indepcolname string) error {
+func RunLinearModelCoverage(rcm ReadCloserMaker, w io.Writer, valcolname, indepcolname string, ro *RowOptions) error {
 	h := handle("RunLinearModel: %w")
 
 	valcol, e := ValCol(rcm, valcolname)
@@ -74,7 +112,7 @@ func RunLinearModelCoverage(rcm ReadCloserMaker, w io.Writer, valcolname, indepc
 	indepcol, e := ValCol(rcm, indepcolname)
 	if e != nil { return h(e) }
 
-	m, b, e := LinearModelTransform(rcm, valcol, indepcol, ChrToExpectation)
+	m, b, e := LinearModelTransform(rcm, valcol, indepcol, ChrToExpectation, ro)
 	if e != nil { return h(e) }
 
 	fmt.Fprintf(w, "allchromtotals\t%v\t%v\n", b, m)
@@ -0,0 +1,127 @@
+package spstat
+
+import (
+	"math"
+)
+
+// A running sum with Neumaier compensation, so that adding many small terms
+// to a large total does not lose them
+type compensatedSum struct {
+	sum float64
+	c float64
+}
+
+func (s *compensatedSum) Add(v float64) {
+	t := s.sum + v
+	if math.Abs(s.sum) >= math.Abs(v) {
+		s.c += (s.sum - t) + v
+	} else {
+		s.c += (v - t) + s.sum
+	}
+	s.sum = t
+}
+
+func (s *compensatedSum) Value() float64 {
+	return s.sum + s.c
+}
+
+// The count, mean, and second to fourth central moments of a set of values,
+// kept with Welford's updates so that they stay accurate over billions of
+// values close to one another. Two Moments can be merged exactly with Chan's
+// formulas, so partial results from shards or threads can be combined. The
+// zero value is empty and ready to use.
+type Moments struct {
+	n float64
+	mean compensatedSum
+	m2 compensatedSum
+	m3 float64
+	m4 float64
+}
+
+// Add one value
+func (m *Moments) Add(x float64) {
+	n1 := m.n
+	m.n++
+	n := m.n
+
+	delta := x - m.mean.Value()
+	dn := delta / n
+	dn2 := dn * dn
+	term1 := delta * dn * n1
+	m2 := m.m2.Value()
+
+	m.mean.Add(dn)
+	m.m4 += term1 * dn2 * (n * n - 3 * n + 3) + 6 * dn2 * m2 - 4 * dn * m.m3
+	m.m3 += term1 * dn * (n - 2) - 3 * dn * m2
+	m.m2.Add(term1)
+}
+
+// Add all of the values in o to m
+func (m *Moments) Merge(o *Moments) {
+	if o.n == 0 {
+		return
+	}
+	if m.n == 0 {
+		*m = *o
+		return
+	}
+
+	na, nb := m.n, o.n
+	n := na + nb
+	d := o.mean.Value() - m.mean.Value()
+	d2 := d * d
+	m2a, m2b := m.m2.Value(), o.m2.Value()
+
+	m.m4 += o.m4 +
+		d2 * d2 * na * nb * (na * na - na * nb + nb * nb) / (n * n * n) +
+		6 * d2 * (na * na * m2b + nb * nb * m2a) / (n * n) +
+		4 * d * (na * o.m3 - nb * m.m3) / n
+	m.m3 += o.m3 +
+		d2 * d * na * nb * (na - nb) / (n * n) +
+		3 * d * (na * m2b - nb * m2a) / n
+	m.m2.Add(m2b)
+	m.m2.Add(d2 * na * nb / n)
+	m.mean.Add(d * nb / n)
+	m.n = n
+}
+
+// The number of values added
+func (m *Moments) Count() float64 {
+	return m.n
+}
+
+// The mean, or NaN if there are no values
+func (m *Moments) Mean() float64 {
+	if m.n == 0 {
+		return math.NaN()
+	}
+	return m.mean.Value()
+}
+
+// The population variance, dividing by n
+func (m *Moments) PopVar() float64 {
+	if m.n == 0 {
+		return math.NaN()
+	}
+	return m.m2.Value() / m.n
+}
+
+// The sample variance, dividing by n - 1
+func (m *Moments) SampleVar() float64 {
+	if m.n < 2 {
+		return math.NaN()
+	}
+	return m.m2.Value() / (m.n - 1)
+}
+
+// The population skewness, g1
+func (m *Moments) Skew() float64 {
+	m2 := m.m2.Value()
+	return math.Sqrt(m.n) * m.m3 / math.Pow(m2, 1.5)
+}
+
+// The population excess kurtosis, g2
+func (m *Moments) Kurtosis() float64 {
+	m2 := m.m2.Value()
+	return m.n * m.m4 / (m2 * m2) - 3
+}
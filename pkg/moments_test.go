@@ -0,0 +1,79 @@
+package spstat
+
+import (
+	"math"
+	"testing"
+)
+
+func closeTo(a, b, tol float64) bool {
+	return math.Abs(a - b) <= tol * (1 + math.Abs(b))
+}
+
+func TestMoments(t *testing.T) {
+	vals := []float64{2, 4, 4, 4, 5, 5, 7, 9, 1, 12}
+
+	var m Moments
+	for _, v := range vals {
+		m.Add(v)
+	}
+
+	var mean, m2, m3, m4 float64
+	for _, v := range vals {
+		mean += v
+	}
+	mean /= float64(len(vals))
+	for _, v := range vals {
+		d := v - mean
+		m2 += d * d
+		m3 += d * d * d
+		m4 += d * d * d * d
+	}
+	n := float64(len(vals))
+
+	checks := []struct {
+		name string
+		got, want float64
+	}{
+		{"mean", m.Mean(), mean},
+		{"pop var", m.PopVar(), m2 / n},
+		{"sample var", m.SampleVar(), m2 / (n - 1)},
+		{"skew", m.Skew(), math.Sqrt(n) * m3 / math.Pow(m2, 1.5)},
+		{"kurtosis", m.Kurtosis(), n * m4 / (m2 * m2) - 3},
+	}
+	for _, c := range checks {
+		if !closeTo(c.got, c.want, 1e-12) {
+			t.Errorf("%v: %v != %v", c.name, c.got, c.want)
+		}
+	}
+
+	for split := 0; split <= len(vals); split++ {
+		var a, b Moments
+		for _, v := range vals[:split] {
+			a.Add(v)
+		}
+		for _, v := range vals[split:] {
+			b.Add(v)
+		}
+		a.Merge(&b)
+		if a.Count() != m.Count() ||
+			!closeTo(a.Mean(), m.Mean(), 1e-12) ||
+			!closeTo(a.PopVar(), m.PopVar(), 1e-12) ||
+			!closeTo(a.Skew(), m.Skew(), 1e-10) ||
+			!closeTo(a.Kurtosis(), m.Kurtosis(), 1e-10) {
+			t.Errorf("split %v: merged %v != %v", split, a, m)
+		}
+	}
+}
+
+func TestMomentsOffset(t *testing.T) {
+	var m Moments
+	for i := 0; i < 1000000; i++ {
+		m.Add(1e9 + float64(i % 2))
+	}
+	if !closeTo(m.PopVar(), 0.25, 1e-9) {
+		t.Errorf("variance %v != 0.25", m.PopVar())
+	}
+	if !closeTo(m.Mean(), 1e9 + 0.5, 1e-15) {
+		t.Errorf("mean %v != %v", m.Mean(), 1e9 + 0.5)
+	}
+}
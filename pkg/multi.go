@@ -0,0 +1,187 @@
+package spstat
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Several tables, each one a shard of one logical table, such as one file per
+// plate or per chromosome. Each entry in Paths is a path or a glob. The
+// shards are read in order as one table with the header of the first shard.
+// Every shard must have the same column names, though not necessarily in the
+// same order; columns are rearranged to match the first shard. Each shard can
+// be compressed differently, or compiled.
+type MultiPath struct {
+	Paths []string
+}
+
+// Interpret a comma-separated list of paths and globs
+func NewMultiPath(spec string) MultiPath {
+	return MultiPath{strings.Split(spec, ",")}
+}
+
+// Check whether a path from the command line names several shards rather
+// than one file
+func IsMultiPath(path string) bool {
+	if _, e := os.Stat(path); e == nil {
+		return false
+	}
+	return strings.ContainsAny(path, ",*?[")
+}
+
+// Expand the globs in m.Paths. Each glob must match at least one file, and
+// its matches are sorted by name.
+func (m MultiPath) Shards() ([]string, error) {
+	var shards []string
+	for _, p := range m.Paths {
+		if p == "" { continue }
+		matches, e := filepath.Glob(p)
+		if e != nil { return nil, fmt.Errorf("MultiPath.Shards: %w", e) }
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("MultiPath.Shards: no files match %v", p)
+		}
+		shards = append(shards, matches...)
+	}
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("MultiPath.Shards: no shards")
+	}
+	return shards, nil
+}
+
+func (m MultiPath) NewReadCloser() (io.ReadCloser, error) {
+	shards, e := m.Shards()
+	if e != nil { return nil, fmt.Errorf("MultiPath.NewReadCloser: %w", e) }
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeShards(pw, shards))
+	}()
+	return pr, nil
+}
+
+// Read one header line and split it into column names
+func readHeaderLine(br *bufio.Reader) (string, []string, error) {
+	line, e := br.ReadString('\n')
+	if e != nil && !(e == io.EOF && len(line) > 0) {
+		return "", nil, e
+	}
+	return line, splitTsvLine([]byte(line), nil), nil
+}
+
+// For each column of the first header, find the same column in header.
+func shardColumnOrder(first, header []string) ([]int, error) {
+	if len(first) != len(header) {
+		return nil, fmt.Errorf("%v columns where the first shard has %v", len(header), len(first))
+	}
+	idx := map[string]int{}
+	for i, name := range header {
+		if _, ok := idx[name]; ok {
+			return nil, fmt.Errorf("repeated column %v", name)
+		}
+		idx[name] = i
+	}
+
+	order := make([]int, len(first))
+	for i, name := range first {
+		j, ok := idx[name]
+		if !ok {
+			return nil, fmt.Errorf("missing column %v", name)
+		}
+		order[i] = j
+	}
+	return order, nil
+}
+
+func inOrder(order []int) bool {
+	for i, j := range order {
+		if i != j {
+			return false
+		}
+	}
+	return true
+}
+
+// Write the header of the first shard, then the rows of every shard with
+// their columns in the order of the first.
+func writeShards(w io.Writer, shards []string) error {
+	h := handle("writeShards: %w")
+
+	lw := &lineEndWriter{w: w, last: '\n'}
+	var first []string
+	for i, shard := range shards {
+		r, e := InputReadCloserMaker(shard).NewReadCloser()
+		if e != nil { return h(e) }
+
+		e = writeShard(lw, r, &first, i == 0)
+		r.Close()
+		if e != nil { return h(fmt.Errorf("%v: %w", shard, e)) }
+
+		if lw.last != '\n' {
+			if _, e := lw.Write([]byte{'\n'}); e != nil { return h(e) }
+		}
+	}
+	return nil
+}
+
+// Remembers the last byte written, so that a shard missing its final newline
+// can be ended before the next one starts
+type lineEndWriter struct {
+	w io.Writer
+	last byte
+}
+
+func (l *lineEndWriter) Write(p []byte) (int, error) {
+	n, e := l.w.Write(p)
+	if n > 0 {
+		l.last = p[n-1]
+	}
+	return n, e
+}
+
+func writeShard(w io.Writer, r io.Reader, first *[]string, isFirst bool) error {
+	br := bufio.NewReader(r)
+	line, header, e := readHeaderLine(br)
+	if e != nil { return e }
+
+	if isFirst {
+		*first = header
+		if _, e := shardColumnOrder(header, header); e != nil { return e }
+		_, e = io.WriteString(w, line)
+		if e != nil { return e }
+		_, e = io.Copy(w, br)
+		return e
+	}
+
+	order, e := shardColumnOrder(*first, header)
+	if e != nil { return e }
+	if inOrder(order) {
+		_, e = io.Copy(w, br)
+		return e
+	}
+
+	bw := bufio.NewWriter(w)
+	var fields, out []string
+	for {
+		line, e := br.ReadBytes('\n')
+		if len(line) > 0 {
+			fields = splitTsvLine(line, fields)
+			out = out[:0]
+			for _, j := range order {
+				if j < len(fields) {
+					out = append(out, fields[j])
+				} else {
+					out = append(out, "")
+				}
+			}
+			if _, e := bw.WriteString(strings.Join(out, "\t")); e != nil { return e }
+			if e := bw.WriteByte('\n'); e != nil { return e }
+		}
+		if e == io.EOF { break }
+		if e != nil { return e }
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,90 @@
+package spstat
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMultiPath(t *testing.T) {
+	ro := DefaultRowOptions()
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if e := os.WriteFile(path, data, 0644); e != nil { t.Fatal(e) }
+		return path
+	}
+
+	// The same three columns in different orders, compressions and line
+	// endings
+	write("a_1.tsv", []byte("chrom\tpos\tvalue\nchr1\t1\t0.5\nchr1\t2\t0.25"))
+	write("a_2.tsv.gz", gzipBytes(t, []byte("value\tchrom\tpos\n0.75\tchr2\t3\n1\tchr2\t4\n")))
+	write("a_3.bgz", bgzfBytes(t, []byte("pos\tvalue\tchrom\n5\t0.125\tchr3")))
+	write("a_4.zst", zstdBytes(t, []byte("chrom\tpos\tvalue\nchr4\t6\t2\n")))
+	write("b.tsv.xz", xzBytes(t, []byte("pos\tchrom\tvalue\n7\tchr5\t3\n8\tchr5\n")))
+	compiled := filepath.Join(dir, "c.spcol")
+	var comp bytes.Buffer
+	if e := Compile(stringTable("chrom\tvalue\tpos\nchr6\t4.5\t9\n"), &comp); e != nil { t.Fatal(e) }
+	write("c.spcol", comp.Bytes())
+
+	want := "chrom\tpos\tvalue\n" +
+		"chr1\t1\t0.5\nchr1\t2\t0.25\n" +
+		"chr2\t3\t0.75\nchr2\t4\t1\n" +
+		"chr3\t5\t0.125\n" +
+		"chr4\t6\t2\n" +
+		"chr5\t7\t3\nchr5\t8\t\n" +
+		"chr6\t9\t4.5\n"
+
+	spec := filepath.Join(dir, "a_*") + "," + filepath.Join(dir, "b.tsv.xz") + "," + compiled
+	if !IsMultiPath(spec) || IsMultiPath(compiled) {
+		t.Errorf("IsMultiPath wrong")
+	}
+	rcm := InputReadCloserMaker(spec)
+	if _, ok := rcm.(MultiPath); !ok {
+		t.Fatalf("%v opened as %T", spec, rcm)
+	}
+	for pass := 0; pass < 2; pass++ {
+		got, e := readAll(rcm)
+		if e != nil { t.Fatal(e) }
+		if string(got) != want {
+			t.Errorf("pass %v read\n%q, want\n%q", pass, got, want)
+		}
+	}
+
+	// Analyses see one table
+	var out bytes.Buffer
+	if e := RunQuantiles(rcm, &out, "value", []string{"chrom"}, []float64{0.5}, 0.01, ro); e != nil { t.Fatal(e) }
+	if n := strings.Count(out.String(), "\n"); n != 6 {
+		t.Errorf("quantiles of %v groups:\n%v", n, out.String())
+	}
+}
+
+func TestMultiPathErrors(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "1.tsv")
+	if e := os.WriteFile(first, []byte("chrom\tpos\nchr1\t1\n"), 0644); e != nil { t.Fatal(e) }
+
+	cases := []struct {
+		name string
+		data string
+		err string
+	}{
+		{"missing", "chrom\tstart\nchr1\t1\n", "missing column pos"},
+		{"extra", "chrom\tpos\tvalue\nchr1\t1\t2\n", "3 columns where the first shard has 2"},
+		{"repeated", "pos\tpos\n1\t1\n", "repeated column pos"},
+	}
+	for _, c := range cases {
+		second := filepath.Join(dir, c.name + ".tsv")
+		if e := os.WriteFile(second, []byte(c.data), 0644); e != nil { t.Fatal(e) }
+		_, e := readAll(NewMultiPath(first + "," + second))
+		if e == nil || !strings.Contains(e.Error(), c.err) || !strings.Contains(e.Error(), second) {
+			t.Errorf("%v: error %v", c.name, e)
+		}
+	}
+
+	if _, e := readAll(NewMultiPath(first + "," + filepath.Join(dir, "none_*"))); e == nil || !strings.Contains(e.Error(), "no files match") {
+		t.Errorf("unmatched glob: error %v", e)
+	}
+}
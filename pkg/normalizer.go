@@ -3,7 +3,6 @@ package spstat
 import (
 	"github.com/jgbaldwinbrown/csvh"
 	"math"
-	"strconv"
 	"encoding/csv"
 	"io"
 	"fmt"
@@ -65,6 +64,20 @@ func NamedColsFunc(names []string) func(line []string, outbuf []int) ([]int, err
 	}
 }
 
+// Peek in rcm and return the column names in its header
+func ReadHeader(rcm ReadCloserMaker) ([]string, error) {
+	h := handle("ReadHeader: %w")
+
+	r, e := rcm.NewReadCloser()
+	if e != nil { return nil, h(e) }
+	defer r.Close()
+	cr := csvh.CsvIn(r)
+
+	line, e := cr.Read()
+	if e != nil { return nil, h(e) }
+	return append([]string(nil), line...), nil
+}
+
 // Peek in rcm and report the column numbers associated with "names"
 func NamedCols(rcm ReadCloserMaker, names []string) ([]int, error) {
 	h := handle("NamedCols: %w")
@@ -137,36 +150,60 @@ func NewNamedValSet() *NamedValSet {
 }
 
 // Calculate means for the values in column "valcol", separately for each column and name specified by idcols and idnames.
-func CalcMeans(rcm ReadCloserMaker, valcol int, idnames []string, idcols []int) ([]*NamedValSet, error) {
+func CalcMeans(rcm ReadCloserMaker, valcol int, idnames []string, idcols []int, ro *RowOptions) ([]*NamedValSet, error) {
 	h := handle("CalcMeans: %w")
 
-	r, e := rcm.NewReadCloser()
-	if e != nil { return nil, h(e) }
-	defer r.Close()
-	cr := csvh.CsvIn(r)
+	if cm, ok := rcm.(ColumnarReaderMaker); ok {
+		return CalcMeansColumnar(cm, valcol, idnames, idcols, ro)
+	}
 
-	sets := []*NamedValSet{}
+	acc := &meansAccumulator{valcol: valcol}
 	for i, name := range idnames {
 		s := NewNamedValSet()
 		s.ColName = name
 		s.Idx = idcols[i]
-		sets = append(sets, s)
+		acc.sets = append(acc.sets, s)
 	}
 
-	for line, e := cr.Read(); e != io.EOF; line, e = cr.Read() {
-		if e != nil { return sets, h(e) }
+	if e := rowPass(rcm, "CalcMeans", acc, ro); e != nil { return acc.sets, h(e) }
 
-		if len(line) <= valcol { continue }
-		val, e := strconv.ParseFloat(line[valcol], 64)
-		if e != nil { continue }
+	return acc.sets, nil
+}
 
-		for _, set := range sets {
-			if len(line) <= set.Idx { continue }
-			set.Add(val, line[set.Idx])
-		}
+// A NamedValSet of the value column for each id column, gathered row by row
+type meansAccumulator struct {
+	valcol int
+	sets []*NamedValSet
+}
+
+func (a *meansAccumulator) Row(rows *RowStage, cr *csv.Reader, line []string) error {
+	val, ok, e := rows.CsvFloat(cr, line, a.valcol)
+	if e != nil || !ok { return e }
+	rows.Keep()
+
+	for _, set := range a.sets {
+		if len(line) <= set.Idx { continue }
+		set.Add(val, line[set.Idx])
 	}
+	return nil
+}
 
-	return sets, nil
+func (a *meansAccumulator) Empty() RowAccumulator {
+	out := &meansAccumulator{valcol: a.valcol}
+	for _, set := range a.sets {
+		s := NewNamedValSet()
+		s.ColName = set.ColName
+		s.Idx = set.Idx
+		out.sets = append(out.sets, s)
+	}
+	return out
+}
+
+func (a *meansAccumulator) Merge(o RowAccumulator) error {
+	for i, set := range o.(*meansAccumulator).sets {
+		a.sets[i].Merge(set)
+	}
+	return nil
 }
 
 // Add a value to the associated ID
@@ -177,6 +214,14 @@ func (s *NamedValSet) Add(val float64, id string) {
 	}
 }
 
+// Add the sums and counts in o to s
+func (s *NamedValSet) Merge(o *NamedValSet) {
+	for id, count := range o.Counts {
+		s.Sums[id] += o.Sums[id]
+		s.Counts[id] += count
+	}
+}
+
 // Assuming means have been calculated for each of the named val sets in means, calculate the residual of val after subtracting all of those means, then add that to s.
 func (s *NamedValSet) AddResid(val float64, line []string, means []*NamedValSet, id string) {
 	resid := val
@@ -186,10 +231,25 @@ func (s *NamedValSet) AddResid(val float64, line []string, means []*NamedValSet,
 	s.Add(resid, id)
 }
 
+// Like AddResid, but report rows that are too short for the columns in
+// means, or whose ids have no mean, instead of adding NaN.
+func (s *NamedValSet) CheckAddResid(val float64, line []string, means []*NamedValSet, id string) error {
+	for _, mean := range means {
+		if len(line) <= mean.Idx { return ErrShortLine }
+		if mean.Counts[line[mean.Idx]] == 0 { return ErrMissingKey }
+	}
+	s.AddResid(val, line, means, id)
+	return nil
+}
+
 // Open up rcm, and for each value in valcol, add the residual after subtracting all means in "means" to the new NamedValSet
-func CalcSerialMean(rcm ReadCloserMaker, valcol int, means []*NamedValSet, idname string, idcol int) (*NamedValSet, error) {
+func CalcSerialMean(rcm ReadCloserMaker, valcol int, means []*NamedValSet, idname string, idcol int, ro *RowOptions) (*NamedValSet, error) {
 	h := handle("CalcSerialMean: %w")
 
+	if cm, ok := rcm.(ColumnarReaderMaker); ok {
+		return CalcSerialMeanColumnar(cm, valcol, means, idname, idcol, ro)
+	}
+
 	r, e := rcm.NewReadCloser()
 	if e != nil { return nil, h(e) }
 	defer r.Close()
@@ -199,27 +259,38 @@ func CalcSerialMean(rcm ReadCloserMaker, valcol int, means []*NamedValSet, idnam
 	s.ColName = idname
 	s.Idx = idcol
 
+	if e := skipHeader(cr); e != nil { return s, h(e) }
+	rows := ro.Stage("CalcSerialMean(" + idname + ")")
+	defer rows.Done()
+
 	for line, e := cr.Read(); e != io.EOF; line, e = cr.Read() {
 		if e != nil { return s, h(e) }
 
-		if len(line) <= valcol { continue }
-		val, e := strconv.ParseFloat(line[valcol], 64)
-		if e != nil { continue }
+		val, ok, e := rows.CsvFloat(cr, line, valcol)
+		if e != nil { return s, h(e) }
+		if !ok { continue }
+		if len(line) <= s.Idx {
+			if e := rows.RejectCsv(ShortLine, cr, line); e != nil { return s, h(e) }
+			continue
+		}
 
-		if len(line) <= s.Idx { continue }
-		s.AddResid(val, line, means, line[s.Idx])
+		if e := s.CheckAddResid(val, line, means, line[s.Idx]); e != nil {
+			if e := rows.RejectCsvErr(e, cr, line); e != nil { return s, h(e) }
+			continue
+		}
+		rows.Keep()
 	}
 
 	return s, nil
 }
 
 // Do CalcSerialMean, but for each id set
-func CalcSerialMeans(rcm ReadCloserMaker, valcol int, idnames []string, idcols []int) ([]*NamedValSet, error) {
+func CalcSerialMeans(rcm ReadCloserMaker, valcol int, idnames []string, idcols []int, ro *RowOptions) ([]*NamedValSet, error) {
 	h := handle("CalcSerialMeans: %w")
 
 	var means []*NamedValSet
 	for i, name := range idnames {
-		mean, e := CalcSerialMean(rcm, valcol, means, name, idcols[i])
+		mean, e := CalcSerialMean(rcm, valcol, means, name, idcols[i], ro)
 		if e != nil { return nil, h(e) }
 		means = append(means, mean)
 	}
@@ -227,24 +298,24 @@ func CalcSerialMeans(rcm ReadCloserMaker, valcol int, idnames []string, idcols [
 }
 
 // Normalize (for each mean, subtract mean) for just one value in valcol
-func NormOne(line []string, valcol int, means []*NamedValSet) (float64, error) {
+func NormOne(line []string, valcol int, means []*NamedValSet, values *ValueParser) (float64, error) {
 	h := handle("NormOne: %w")
 
-	if len(line) <= valcol { return 0, h(fmt.Errorf("line too short")) }
-	val, e := strconv.ParseFloat(line[valcol], 64)
+	if len(line) <= valcol { return 0, h(ErrShortLine) }
+	val, e := values.Parse(line[valcol])
 	if e != nil { return 0, h(e) }
 
 	resid := val
 
 	for _, mean := range means {
-		if len(line) <= mean.Idx { return 0, h(fmt.Errorf("line too short")) }
+		if len(line) <= mean.Idx { return 0, h(ErrShortLine) }
 		resid -= mean.Mean(line[mean.Idx])
 	}
 	return resid, nil
 }
 
 // Do mean residual normalization for a whole file
-func Norm(rcm ReadCloserMaker, w io.Writer, valcol int, means []*NamedValSet) error {
+func Norm(rcm ReadCloserMaker, w io.Writer, valcol int, means []*NamedValSet, ro *RowOptions) error {
 	h := handle("Norm: %w")
 
 	r, e := rcm.NewReadCloser()
@@ -262,21 +333,33 @@ func Norm(rcm ReadCloserMaker, w io.Writer, valcol int, means []*NamedValSet) er
 	e = cw.Write(line)
 	if e != nil { return h(e) }
 
+	rows := ro.Stage("Norm")
+	defer rows.Done()
+
 	for line, e := cr.Read(); e != io.EOF; line, e = cr.Read() {
 		if e != nil { return h(e) }
 
-		norm, e := NormOne(line, valcol, means)
-		if e != nil { continue }
-		line = append(line, fmt.Sprintf("%f", norm))
+		norm, e := NormOne(line, valcol, means, ro.Values)
+		if e != nil && !isMissingValueError(e) {
+			if e := rows.RejectCsvErr(e, cr, line); e != nil { return h(e) }
+			continue
+		}
+		rows.Keep()
+		if e != nil {
+			norm = math.NaN()
+		}
+		line = append(line, ro.Values.Format(norm, formatFixed))
 		e = cw.Write(line)
-		if e != nil { continue }
+		if e != nil { return h(e) }
 	}
 
+	cw.Flush()
+	if e := cw.Error(); e != nil { return h(e) }
 	return nil
 }
 
 // Given a value column and a set of id columns to normalize by, go through the table and do residual normalization for all IDs.
-func Run(rcm ReadCloserMaker, w io.Writer, valcolname string, idcolsnames []string) error {
+func Run(rcm ReadCloserMaker, w io.Writer, valcolname string, idcolsnames []string, ro *RowOptions) error {
 	h := handle("Run: %w")
 
 	valcol, e := ValCol(rcm, valcolname)
@@ -285,10 +368,10 @@ func Run(rcm ReadCloserMaker, w io.Writer, valcolname string, idcolsnames []stri
 	idcols, e := IdCols(rcm, idcolsnames)
 	if e != nil { return h(e) }
 
-	means, e := CalcSerialMeans(rcm, valcol, idcolsnames, idcols)
+	means, e := CalcSerialMeans(rcm, valcol, idcolsnames, idcols, ro)
 	if e != nil { return h(e) }
 
-	e = Norm(rcm, w, valcol, means)
+	e = Norm(rcm, w, valcol, means, ro)
 	if e != nil { return h(e) }
 
 	return nil
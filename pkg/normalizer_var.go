@@ -2,27 +2,27 @@ package spstat
 
 import (
 	"github.com/jgbaldwinbrown/csvh"
-	"os"
 	"flag"
-	"strconv"
+	"math"
 	"encoding/csv"
 	"io"
 	"fmt"
 )
 
-func NormVarOne(line []string, valcol int, tsum *TSummary) (float64, error) {
+func NormVarOne(line []string, valcol int, tsum *TSummary, values *ValueParser) (float64, error) {
 	h := handle("NormOne: %w")
 
-	if len(line) <= valcol { return 0, h(fmt.Errorf("line too short")) }
-	val, e := strconv.ParseFloat(line[valcol], 64)
+	if len(line) <= valcol { return 0, h(ErrShortLine) }
+	val, e := values.Parse(line[valcol])
 	if e != nil { return 0, h(e) }
+	if len(line) <= tsum.Idx { return 0, h(ErrShortLine) }
 
 	resid := (val - tsum.Mean(line[tsum.Idx])) / tsum.Sd(line[tsum.Idx])
 
 	return resid, nil
 }
 
-func NormVar(rcm ReadCloserMaker, w io.Writer, valcol int, tsum *TSummary) error {
+func NormVar(rcm ReadCloserMaker, w io.Writer, valcol int, tsum *TSummary, ro *RowOptions) error {
 	h := handle("Norm: %w")
 
 	r, e := rcm.NewReadCloser()
@@ -40,20 +40,32 @@ func NormVar(rcm ReadCloserMaker, w io.Writer, valcol int, tsum *TSummary) error
 	e = cw.Write(line)
 	if e != nil { return h(e) }
 
+	rows := ro.Stage("NormVar")
+	defer rows.Done()
+
 	for line, e := cr.Read(); e != io.EOF; line, e = cr.Read() {
 		if e != nil { return h(e) }
 
-		norm, e := NormVarOne(line, valcol, tsum)
-		if e != nil { continue }
-		line = append(line, fmt.Sprintf("%f", norm))
+		norm, e := NormVarOne(line, valcol, tsum, ro.Values)
+		if e != nil && !isMissingValueError(e) {
+			if e := rows.RejectCsvErr(e, cr, line); e != nil { return h(e) }
+			continue
+		}
+		rows.Keep()
+		if e != nil {
+			norm = math.NaN()
+		}
+		line = append(line, ro.Values.Format(norm, formatFixed))
 		e = cw.Write(line)
-		if e != nil { continue }
+		if e != nil { return h(e) }
 	}
 
+	cw.Flush()
+	if e := cw.Error(); e != nil { return h(e) }
 	return nil
 }
 
-func RunNormVar(rcm ReadCloserMaker, w io.Writer, valcolname string, idcolname string) error {
+func RunNormVar(rcm ReadCloserMaker, w io.Writer, valcolname string, idcolname string, ro *RowOptions) error {
 	h := handle("RunNormVar: Step: %v; %w")
 
 	valcol, e := ValCol(rcm, valcolname)
@@ -62,7 +74,7 @@ func RunNormVar(rcm ReadCloserMaker, w io.Writer, valcolname string, idcolname s
 	idcol, e := ValCol(rcm, idcolname)
 	if e != nil { return h("idcol", e) }
 
-	tsums, _, e := CalcTSummary(rcm, valcol, []string{idcolname}, []int{idcol}, 0, 0)
+	tsums, _, e := CalcTSummary(rcm, valcol, []string{idcolname}, []int{idcol}, 0, 0, ro)
 	if e != nil { return h("tsums", e) }
 	tsum := tsums[0]
 
@@ -71,17 +83,23 @@ func RunNormVar(rcm ReadCloserMaker, w io.Writer, valcolname string, idcolname s
 	// 	fmt.Printf("name: %v; mean: %v; var: %v; sd: %v\n", name, tsum.Mean(name), tsum.Var(name), tsum.Sd(name))
 	// }
 
-	e = NormVar(rcm, w, valcol, tsum)
+	e = NormVar(rcm, w, valcol, tsum, ro)
 	if e != nil { return h("normvar", e) }
 
 	return nil
 }
 
 func RunFullNormVar() {
-	inpp := flag.String("i", "", "input .gz file")
+	inpp := flag.String("i", "", "input table, plain or compressed, a comma-separated list or glob of shards, a table from compile, or - for stdin")
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
+	regionp := flag.String("region", "", "only read rows in these regions, like chr1:1000-2000, separated by semicolons")
+	chromcolp := flag.String("chromcol", DefaultChromCol, "chromosome column name for -region")
+	poscolp := flag.String("poscol", DefaultPosCol, "position column name for -region")
 	valcolp := flag.String("v", "", "value column name")
 	idcolp := flag.String("id", "", "id column name")
+	rowflags := AddRowFlags()
 	flag.Parse()
+	defer rowflags.Exit()
 	if *inpp == "" {
 		panic(fmt.Errorf("missing -i"))
 	}
@@ -92,6 +110,21 @@ func RunFullNormVar() {
 		panic(fmt.Errorf("missing -id"))
 	}
 
-	e := RunNormVar(MaybeGzPath(*inpp), os.Stdout, *valcolp, *idcolp)
+	rcm, e := InputRegionReadCloserMaker(*inpp, *regionp, *chromcolp, *poscolp)
+	if e != nil { panic(e) }
+
+	w, e := OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	ro, e := rowflags.Start()
+	if e != nil { panic(e) }
+
+	e = rowflags.Validate(rcm)
+	if e != nil { panic(e) }
+
+	e = RunNormVar(rcm, w, *valcolp, *idcolp, ro)
+	if e != nil { panic(e) }
+
+	e = w.Close()
 	if e != nil { panic(e) }
 }
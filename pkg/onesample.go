@@ -0,0 +1,130 @@
+package spstat
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Add all of the pairs in o to s
+func (s *PairedSummary) Merge(o *PairedSummary) {
+	s.Control.Merge(&o.Control)
+	s.Exp.Merge(&o.Exp)
+	s.Diff.Merge(&o.Diff)
+}
+
+// The values of each group, paired with their expected values from a
+// column, or with a constant if there is no column
+type oneSampleAccumulator struct {
+	valcol int
+	expcol int
+	mu float64
+	groupcols []int
+	maxcol int
+	groups map[string]*PairedSummary
+}
+
+func newOneSampleAccumulator(valcol, expcol int, mu float64, groupcols []int) *oneSampleAccumulator {
+	maxcol := valcol
+	for _, col := range append([]int{expcol}, groupcols...) {
+		if col > maxcol {
+			maxcol = col
+		}
+	}
+	return &oneSampleAccumulator{
+		valcol: valcol,
+		expcol: expcol,
+		mu: mu,
+		groupcols: groupcols,
+		maxcol: maxcol,
+		groups: map[string]*PairedSummary{},
+	}
+}
+
+func (a *oneSampleAccumulator) Row(rows *RowStage, cr *csv.Reader, line []string) error {
+	if len(line) <= a.maxcol {
+		return rows.RejectCsv(ShortLine, cr, line)
+	}
+
+	val, ok, e := rows.CsvFloat(cr, line, a.valcol)
+	if e != nil || !ok { return e }
+
+	expected := a.mu
+	if a.expcol >= 0 {
+		expected, ok, e = rows.CsvFloat(cr, line, a.expcol)
+		if e != nil || !ok { return e }
+	}
+	rows.Keep()
+
+	group := groupKey(line, a.groupcols)
+	s, ok := a.groups[group]
+	if !ok {
+		s = &PairedSummary{}
+		a.groups[group] = s
+	}
+	s.Add(val, expected)
+	return nil
+}
+
+func (a *oneSampleAccumulator) Empty() RowAccumulator {
+	out := *a
+	out.groups = map[string]*PairedSummary{}
+	return &out
+}
+
+func (a *oneSampleAccumulator) Merge(o RowAccumulator) error {
+	for group, os := range o.(*oneSampleAccumulator).groups {
+		s, ok := a.groups[group]
+		if !ok {
+			s = &PairedSummary{}
+			a.groups[group] = s
+		}
+		s.Merge(os)
+	}
+	return nil
+}
+
+// Run a one-sample t test of valcolname minus expcolname against zero for
+// each group of values of groupcolnames, or of valcolname against mu if
+// expcolname is "". Each result's first name is expcolname or mu, and its
+// second is the group, or "all" if there are no group columns; Diff is the
+// mean difference from the expectation.
+func RunOneSampleTTest(rcm ReadCloserMaker, w io.Writer, valcolname, expcolname string, mu float64, groupcolnames []string, opts TTestOptions, ro *RowOptions) error {
+	h := handle("RunOneSampleTTest: %w")
+
+	if e := opts.Validate(); e != nil { return h(e) }
+
+	valcol, e := ValCol(rcm, valcolname)
+	if e != nil { return h(e) }
+
+	expcol := -1
+	name1 := fmt.Sprint(mu)
+	if expcolname != "" {
+		expcol, e = ValCol(rcm, expcolname)
+		if e != nil { return h(e) }
+		name1 = expcolname
+	}
+
+	groupcols, e := IdCols(rcm, groupcolnames)
+	if e != nil { return h(e) }
+
+	acc := newOneSampleAccumulator(valcol, expcol, mu, groupcols)
+	if e := rowPass(rcm, "OneSampleTTest", acc, ro); e != nil { return h(e) }
+
+	var groups []string
+	for group, _ := range acc.groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		name2 := groupName(group)
+		if len(groupcols) == 0 {
+			name2 = "all"
+		}
+		r := CalcPairedTTest(name1, name2, acc.groups[group], opts)
+		if e := WriteTTestResult(w, r); e != nil { return h(e) }
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package spstat
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestOneSampleTTest(t *testing.T) {
+	ro := DefaultRowOptions()
+
+	var b strings.Builder
+	b.WriteString("value\texpected\tchrom\n")
+	for i := range sleep1 {
+		fmt.Fprintf(&b, "%v\t%v\tchr1\n", sleep1[i], sleep2[i])
+	}
+	b.WriteString("1\t\tchr1\n")
+	rcm := stringTable(b.String())
+
+	opts, e := NewTTestOptions(TTestOneSample, "", 0)
+	if e != nil { t.Fatal(e) }
+
+	// Expected values from R's t.test(sleep1), and from t.test(sleep1 -
+	// sleep2), which is the paired test
+	var out bytes.Buffer
+	if e := RunOneSampleTTest(rcm, &out, "value", "", 0, nil, opts, ro); e != nil { t.Fatal(e) }
+	if e := RunOneSampleTTest(rcm, &out, "value", "expected", 0, []string{"chrom"}, opts, ro); e != nil { t.Fatal(e) }
+
+	rs, e := ReadTTestResults(&out)
+	if e != nil { t.Fatal(e) }
+	if len(rs) != 2 {
+		t.Fatalf("%v results; want 2", len(rs))
+	}
+
+	mu := rs[0]
+	if mu.Name1 != "0" || mu.Name2 != "all" || mu.Count1 != 11 || mu.Df != 10 {
+		t.Errorf("against mu: %v", mu)
+	}
+
+	exp := rs[1]
+	if exp.Name1 != "expected" || exp.Name2 != "chr1" || exp.Count1 != 10 {
+		t.Errorf("against expected: %v", exp)
+	}
+	if !closeTo(exp.T, -4.062128, 1e-6) || !closeTo(exp.P, 0.002832890, 1e-6) || !closeTo(exp.Diff, -1.58, 1e-12) {
+		t.Errorf("against expected: t %v, p %v, diff %v", exp.T, exp.P, exp.Diff)
+	}
+	if !closeTo(exp.Low, -2.4598858, 1e-6) || !closeTo(exp.High, -0.7001142, 1e-6) {
+		t.Errorf("against expected: interval %v, %v", exp.Low, exp.High)
+	}
+
+	// Groups whose values joined by "_" match are still apart
+	collide := stringTable("g1\tg2\tvalue\na_b\tc\t1\na_b\tc\t2\na\tb_c\t5\na\tb_c\t7\na\tb_c\t9\n")
+	out.Reset()
+	if e := RunOneSampleTTest(collide, &out, "value", "", 0, []string{"g1", "g2"}, opts, ro); e != nil { t.Fatal(e) }
+	rs, e = ReadTTestResults(&out)
+	if e != nil { t.Fatal(e) }
+	if len(rs) != 2 || rs[0].Name2 != "a_b_c" || rs[1].Name2 != "a_b_c" || rs[0].Count1 + rs[1].Count1 != 5 || rs[0].Count1 == rs[1].Count1 {
+		t.Errorf("colliding groups: %v", rs)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"io"
 	"encoding/csv"
 	"os"
+	"flag"
 	"strings"
 )
 
@@ -36,6 +37,15 @@ func Reformat(r io.Reader, w io.Writer) error {
 
 // Run Reformat on the command line
 func RunReformat() {
-	e := Reformat(os.Stdin, os.Stdout)
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
+	flag.Parse()
+
+	w, e := OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	e = Reformat(os.Stdin, w)
+	if e != nil { panic(e) }
+
+	e = w.Close()
 	if e != nil { panic(e) }
 }
@@ -0,0 +1,444 @@
+package spstat
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// How paired t tests join the rows of the two tissues
+type PairOptions struct {
+	// Keys left unpaired in memory before the join spills to temporary
+	// files
+	MaxPending int
+	// Temporary files the join spills to, each joined in memory in turn
+	Partitions int
+	// Directory for the temporary files (default os.TempDir())
+	Dir string
+}
+
+// Up to 4Mi unpaired keys in memory, spilling to 64 files in os.TempDir()
+func DefaultPairOptions() PairOptions {
+	return PairOptions{MaxPending: 1 << 22, Partitions: 64}
+}
+
+// The paired values of one group of a paired t test
+type PairedSummary struct {
+	Control Moments
+	Exp Moments
+	Diff Moments
+}
+
+// Add one pair
+func (s *PairedSummary) Add(control, exp float64) {
+	s.Control.Add(control)
+	s.Exp.Add(exp)
+	s.Diff.Add(control - exp)
+}
+
+// Run a paired t test on the differences, control minus experimental, in s
+func CalcPairedTTest(name1, name2 string, s *PairedSummary, opts TTestOptions) TTestResult {
+	n := s.Diff.Count()
+	r := TTestResult{
+		Name1: name1, Name2: name2,
+		Count1: n, Count2: n,
+		Mean1: s.Control.Mean(), Mean2: s.Exp.Mean(),
+		Sd1: math.Sqrt(s.Control.SampleVar()), Sd2: math.Sqrt(s.Exp.SampleVar()),
+		Df: n - 1,
+		Diff: s.Diff.Mean(),
+	}
+
+	se := math.Sqrt(s.Diff.SampleVar() / n)
+	r.T = r.Diff / se
+	r.P = TTestPAlt(r.T, r.Df, opts.Alternative)
+	r.Low, r.High = TTestInterval(r.Diff, se, r.Df, opts)
+	return r
+}
+
+const (
+	pairControl = 0
+	pairExp = 1
+)
+
+type pairValue struct {
+	val float64
+	line int
+}
+
+// A row of the control or experimental tissue waiting to be paired. Key is
+// the row's group and key columns, so only rows of the same group pair.
+type pairEntry struct {
+	key string
+	group string
+	side int
+	pairValue
+}
+
+// The values of one key not yet paired, in the order they were found. Only
+// one side has values at a time.
+type pairPending struct {
+	group string
+	sides [2][]pairValue
+}
+
+// Pairs the control and experimental rows of a table by their key columns,
+// on unsorted input, with a hash join. A key's rows pair in the order they
+// are found. Chunks of a parallel pass only collect their rows, which are
+// paired when the chunks are merged in order, so a parallel pass pairs
+// exactly as a serial one does. If more than pairing.MaxPending keys are
+// waiting for a pair, every row from then on is spilled to a temporary
+// file chosen by its key, and each file is joined on its own at the end.
+type pairAccumulator struct {
+	valcol int
+	tissuecol int
+	keycols []int
+	groupcols []int
+	maxcol int
+	control string
+	exp string
+
+	chunk bool
+	entries []pairEntry
+
+	pending map[string]*pairPending
+	groups map[string]*PairedSummary
+	join *RowStage
+	pairing PairOptions
+	spill []*os.File
+	spillw []*bufio.Writer
+	joining bool
+	unpaired []pairEntry
+}
+
+func newPairAccumulator(valcol, tissuecol int, keycols, groupcols []int, control, exp string, ro *RowOptions) *pairAccumulator {
+	maxcol := tissuecol
+	for _, cols := range [][]int{keycols, groupcols} {
+		for _, col := range cols {
+			if col > maxcol {
+				maxcol = col
+			}
+		}
+	}
+
+	return &pairAccumulator{
+		valcol: valcol,
+		tissuecol: tissuecol,
+		keycols: keycols,
+		groupcols: groupcols,
+		maxcol: maxcol,
+		control: control,
+		exp: exp,
+		pending: map[string]*pairPending{},
+		groups: map[string]*PairedSummary{},
+		join: ro.Stage("PairRows"),
+		pairing: ro.Pairing,
+	}
+}
+
+func joinFields(line []string, cols []int, sep string) string {
+	fields := make([]string, 0, len(cols))
+	for _, col := range cols {
+		fields = append(fields, line[col])
+	}
+	return strings.Join(fields, sep)
+}
+
+// The key of the group of a row, its values in cols joined by NULs so that
+// no two groups share one
+func groupKey(line []string, cols []int) string {
+	return joinFields(line, cols, "\x00")
+}
+
+// The name of a group key, its values joined by "_"
+func groupName(key string) string {
+	return strings.ReplaceAll(key, "\x00", "_")
+}
+
+func (a *pairAccumulator) Row(rows *RowStage, cr *csv.Reader, line []string) error {
+	if len(line) <= a.maxcol {
+		return rows.RejectCsv(ShortLine, cr, line)
+	}
+
+	side := pairControl
+	switch line[a.tissuecol] {
+	case a.control:
+	case a.exp:
+		side = pairExp
+	default:
+		rows.Keep()
+		return nil
+	}
+
+	val, ok, e := rows.CsvFloat(cr, line, a.valcol)
+	if e != nil || !ok { return e }
+	rows.Keep()
+
+	group := groupKey(line, a.groupcols)
+	en := pairEntry{
+		key: group + "\x00" + groupKey(line, a.keycols),
+		group: group,
+		side: side,
+		pairValue: pairValue{val, csvLine(cr, line)},
+	}
+	if a.chunk {
+		a.entries = append(a.entries, en)
+		return nil
+	}
+	return a.add(en)
+}
+
+func (a *pairAccumulator) Empty() RowAccumulator {
+	return &pairAccumulator{
+		valcol: a.valcol,
+		tissuecol: a.tissuecol,
+		keycols: a.keycols,
+		groupcols: a.groupcols,
+		maxcol: a.maxcol,
+		control: a.control,
+		exp: a.exp,
+		chunk: true,
+	}
+}
+
+func (a *pairAccumulator) Merge(o RowAccumulator) error {
+	for _, en := range o.(*pairAccumulator).entries {
+		if e := a.add(en); e != nil { return e }
+	}
+	return nil
+}
+
+// Give the rows of a chunk their true line numbers
+func (a *pairAccumulator) addChunk(c RowAccumulator, offset int) error {
+	entries := c.(*pairAccumulator).entries
+	for i := range entries {
+		entries[i].line += offset
+	}
+	return nil
+}
+
+// Pair en with the first waiting row of the other side of its key, or leave
+// it waiting
+func (a *pairAccumulator) add(en pairEntry) error {
+	if a.spill != nil {
+		return a.spillEntry(en)
+	}
+
+	p, ok := a.pending[en.key]
+	if !ok {
+		p = &pairPending{group: en.group}
+		a.pending[en.key] = p
+	}
+
+	other := 1 - en.side
+	if len(p.sides[other]) == 0 {
+		p.sides[en.side] = append(p.sides[en.side], en.pairValue)
+		if len(a.pending) > a.pairing.MaxPending && a.pairing.MaxPending > 0 && !a.joining {
+			return a.startSpill()
+		}
+		return nil
+	}
+
+	waiting := p.sides[other][0]
+	p.sides[other] = p.sides[other][1:]
+	if len(p.sides[other]) == 0 {
+		delete(a.pending, en.key)
+	}
+
+	control, exp := waiting.val, en.val
+	if en.side == pairControl {
+		control, exp = en.val, waiting.val
+	}
+
+	s, ok := a.groups[en.group]
+	if !ok {
+		s = &PairedSummary{}
+		a.groups[en.group] = s
+	}
+	s.Add(control, exp)
+	a.join.Keep()
+	a.join.Keep()
+	return nil
+}
+
+// Move every waiting row to the spill files, and spill every row from now on
+func (a *pairAccumulator) startSpill() error {
+	h := handle("startSpill: %w")
+
+	for i := 0; i < a.pairing.Partitions || i == 0; i++ {
+		f, e := os.CreateTemp(a.pairing.Dir, "spstat_pairs_*")
+		if e != nil { return h(e) }
+		os.Remove(f.Name())
+		a.spill = append(a.spill, f)
+		a.spillw = append(a.spillw, bufio.NewWriter(f))
+	}
+
+	pending := a.pending
+	a.pending = map[string]*pairPending{}
+	for key, p := range pending {
+		for side, vals := range p.sides {
+			for _, v := range vals {
+				if e := a.spillEntry(pairEntry{key, p.group, side, v}); e != nil { return h(e) }
+			}
+		}
+	}
+	return nil
+}
+
+func (a *pairAccumulator) spillEntry(en pairEntry) error {
+	hash := fnv.New32a()
+	hash.Write([]byte(en.key))
+	w := a.spillw[int(hash.Sum32() % uint32(len(a.spillw)))]
+
+	if e := writeIndexString(w, en.key); e != nil { return e }
+	if e := writeIndexString(w, en.group); e != nil { return e }
+	if e := w.WriteByte(byte(en.side)); e != nil { return e }
+	if e := writeFloat(w, en.val); e != nil { return e }
+	return writeUvarint(w, uint64(en.line))
+}
+
+func readPairEntry(r *bufio.Reader, buf []byte) (pairEntry, []byte, error) {
+	var en pairEntry
+	var e error
+	if en.key, buf, e = readColumnarString(r, buf); e != nil { return en, buf, e }
+	if en.group, buf, e = readColumnarString(r, buf); e != nil { return en, buf, e }
+	side, e := r.ReadByte()
+	if e != nil { return en, buf, e }
+	en.side = int(side)
+	if en.val, e = readFloat(r); e != nil { return en, buf, e }
+	en.line, e = readUvarint(r)
+	return en, buf, e
+}
+
+// Move the rows still waiting for a pair to unpaired
+func (a *pairAccumulator) collectUnpaired() {
+	for key, p := range a.pending {
+		for side, vals := range p.sides {
+			for _, v := range vals {
+				a.unpaired = append(a.unpaired, pairEntry{key, p.group, side, v})
+			}
+		}
+	}
+	a.pending = map[string]*pairPending{}
+}
+
+// Give up on the join, removing any spill files
+func (a *pairAccumulator) close() {
+	for _, f := range a.spill {
+		f.Close()
+	}
+	a.spill, a.spillw = nil, nil
+	a.join.Done()
+}
+
+// Join each spill file, if any, then reject the rows that found no pair, in
+// the order they appear in the table
+func (a *pairAccumulator) finish() error {
+	h := handle("pairAccumulator.finish: %w")
+	defer a.join.Done()
+
+	files, writers := a.spill, a.spillw
+	a.spill, a.spillw = nil, nil
+	a.joining = true
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	a.collectUnpaired()
+	var buf []byte
+	for i, f := range files {
+		if e := writers[i].Flush(); e != nil { return h(e) }
+		if _, e := f.Seek(0, io.SeekStart); e != nil { return h(e) }
+
+		br := bufio.NewReader(f)
+		for {
+			if _, e := br.Peek(1); e == io.EOF {
+				break
+			}
+			var en pairEntry
+			var e error
+			en, buf, e = readPairEntry(br, buf)
+			if e != nil { return h(e) }
+			if e := a.add(en); e != nil { return h(e) }
+		}
+		a.collectUnpaired()
+	}
+
+	sort.Slice(a.unpaired, func(i, j int) bool {
+		return a.unpaired[i].line < a.unpaired[j].line
+	})
+	for _, en := range a.unpaired {
+		tissue := a.control
+		if en.side == pairExp {
+			tissue = a.exp
+		}
+		key := strings.TrimPrefix(en.key, en.group + "\x00")
+		row := append([]string{tissue, groupName(en.group)}, strings.Split(key, "\x00")...)
+		if e := a.join.Reject(MissingKey, en.line, row); e != nil { return h(e) }
+	}
+	return nil
+}
+
+// The name of the experimental tissue in one group, like the names in an
+// indiv_chrom_tissue column
+func pairedName(group, exp string) string {
+	if group == "" {
+		return exp
+	}
+	return group + "_" + exp
+}
+
+// Write a paired t test for each group, in order of name
+func (a *pairAccumulator) WriteTTests(w io.Writer, opts TTestOptions) error {
+	var names []string
+	for group, _ := range a.groups {
+		names = append(names, group)
+	}
+	sort.Strings(names)
+
+	for _, group := range names {
+		r := CalcPairedTTest(a.control, pairedName(groupName(group), a.exp), a.groups[group], opts)
+		if e := WriteTTestResult(w, r); e != nil { return fmt.Errorf("WriteTTests: %w", e) }
+	}
+	return nil
+}
+
+// Run a paired t test of valcolname between the control and exp tissues in
+// tissuecolname, for each group of values of groupcolnames. Rows pair when
+// they have the same values in groupcolnames and keycolnames, and need not
+// be sorted. Rows of the two tissues that find no pair are rejected as
+// missing keys.
+func RunPairedTTest(rcm ReadCloserMaker, w io.Writer, valcolname, tissuecolname, control, exp string, keycolnames, groupcolnames []string, opts TTestOptions, ro *RowOptions) error {
+	h := handle("RunPairedTTest: %w")
+
+	if e := opts.Validate(); e != nil { return h(e) }
+	if len(keycolnames) == 0 {
+		return h(fmt.Errorf("no key columns to pair rows by"))
+	}
+
+	cols, e := NamedCols(rcm, []string{valcolname, tissuecolname})
+	if e != nil { return h(e) }
+
+	keycols, e := IdCols(rcm, keycolnames)
+	if e != nil { return h(e) }
+
+	groupcols, e := IdCols(rcm, groupcolnames)
+	if e != nil { return h(e) }
+
+	acc := newPairAccumulator(cols[0], cols[1], keycols, groupcols, control, exp, ro)
+	if e := rowPass(rcm, "PairedTTest", acc, ro); e != nil {
+		acc.close()
+		return h(e)
+	}
+	if e := acc.finish(); e != nil { return h(e) }
+
+	if e := acc.WriteTTests(w, opts); e != nil { return h(e) }
+	return nil
+}
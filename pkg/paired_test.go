@@ -0,0 +1,137 @@
+package spstat
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// The sleep data as a table of pairs, out of order, with one blood row that
+// has no pair
+func pairedTestTable() stringTable {
+	var b strings.Builder
+	b.WriteString("id\ttissue\tvalue\tchrom\n")
+	for i := len(sleep1) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "%v\tsperm\t%v\tchr1\n", i, sleep2[i])
+	}
+	b.WriteString("10\tblood\t1.5\tchr1\n")
+	b.WriteString("3\tliver\t9\tchr1\n")
+	for i := range sleep1 {
+		fmt.Fprintf(&b, "%v\tblood\t%v\tchr1\n", i, sleep1[i])
+	}
+	return stringTable(b.String())
+}
+
+func TestPairedTTest(t *testing.T) {
+	ro := DefaultRowOptions()
+	opts := DefaultTTestOptions()
+	opts.Method = TTestPaired
+
+	var out bytes.Buffer
+	e := RunPairedTTest(pairedTestTable(), &out, "value", "tissue", "blood", "sperm", []string{"id"}, []string{"chrom"}, opts, ro)
+	if e != nil { t.Fatal(e) }
+
+	rs, e := ReadTTestResults(&out)
+	if e != nil { t.Fatal(e) }
+	if len(rs) != 1 {
+		t.Fatalf("%v results; want 1", len(rs))
+	}
+	r := rs[0]
+
+	// Expected values from R's t.test(sleep1, sleep2, paired = TRUE)
+	if r.Name1 != "blood" || r.Name2 != "chr1_sperm" || r.Count1 != 10 {
+		t.Errorf("names %v, %v, count %v", r.Name1, r.Name2, r.Count1)
+	}
+	if !closeTo(r.T, -4.062128, 1e-6) || r.Df != 9 || !closeTo(r.P, 0.002832890, 1e-6) {
+		t.Errorf("t %v, df %v, p %v", r.T, r.Df, r.P)
+	}
+	if !closeTo(r.Low, -2.4598858, 1e-6) || !closeTo(r.High, -0.7001142, 1e-6) {
+		t.Errorf("interval %v, %v", r.Low, r.High)
+	}
+
+	counts := ro.Rows.Counts()
+	if len(counts) != 2 || counts[1].Stage != "PairRows" || counts[1].Kept() != 20 || counts[1].Rejected[MissingKey] != 1 {
+		t.Errorf("counts %v", counts)
+	}
+}
+
+func TestPairedTTestGroups(t *testing.T) {
+	ro := DefaultRowOptions()
+	opts := DefaultTTestOptions()
+	opts.Method = TTestPaired
+
+	// Groups whose values joined by "_" match are still apart
+	var b strings.Builder
+	b.WriteString("g1\tg2\tid\trep\ttissue\tvalue\n")
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(&b, "a_b\tc\t%v\tr\tblood\t%v\na_b\tc\t%v\tr\tsperm\t%v\n", i, i, i, i * i)
+		fmt.Fprintf(&b, "a\tb_c\t%v\tr\tblood\t%v\na\tb_c\t%v\tr\tsperm\t%v\n", i, i, i, 2 * i + 1)
+	}
+	b.WriteString("a\tb_c\t3\tr\tsperm\t1\n")
+
+	var out bytes.Buffer
+	e := RunPairedTTest(stringTable(b.String()), &out, "value", "tissue", "blood", "sperm", []string{"id", "rep"}, []string{"g1", "g2"}, opts, ro)
+	if e != nil { t.Fatal(e) }
+
+	rs, e := ReadTTestResults(&out)
+	if e != nil { t.Fatal(e) }
+	if len(rs) != 2 || rs[0].Name2 != "a_b_c_sperm" || rs[1].Name2 != "a_b_c_sperm" || rs[0].Count1 != 3 || rs[1].Count1 != 3 || rs[0].Diff == rs[1].Diff {
+		t.Errorf("results %v", rs)
+	}
+	if counts := ro.Rows.Counts(); counts[1].Rejected[MissingKey] != 1 {
+		t.Errorf("counts %v", counts)
+	}
+}
+
+func TestPairedTTestSpill(t *testing.T) {
+	opts := DefaultTTestOptions()
+	opts.Method = TTestPaired
+
+	// Pair blood and sperm rows of the same group by x
+	rcm := chunkTestTable()
+	run := func(ro *RowOptions) string {
+		var out bytes.Buffer
+		e := RunPairedTTest(rcm, &out, "value", "tissue", "blood", "sperm", []string{"x"}, []string{"group"}, opts, ro)
+		if e != nil { t.Fatal(e) }
+		return out.String()
+	}
+
+	var outs []string
+	serial, parallel := serialAndParallel(t, func(ro *RowOptions) {
+		outs = append(outs, run(ro))
+	})
+	if parallel != serial {
+		t.Errorf("parallel rejects:\n%v\nserial rejects:\n%v", parallel, serial)
+	}
+
+	spilled, _ := serialAndParallel(t, func(ro *RowOptions) {
+		ro.Pairing.MaxPending = 3
+		ro.Pairing.Partitions = 4
+		outs = append(outs, run(ro))
+	})
+	if spilled != serial {
+		t.Errorf("spilled rejects:\n%v\nserial rejects:\n%v", spilled, serial)
+	}
+
+	// The spilled runs pair rows in another order, so their sums may round
+	// differently
+	first, e := ReadTTestResults(strings.NewReader(outs[0]))
+	if e != nil { t.Fatal(e) }
+	for i, out := range outs {
+		rs, e := ReadTTestResults(strings.NewReader(out))
+		if e != nil { t.Fatal(e) }
+		if len(rs) != len(first) || len(rs) != 7 {
+			t.Fatalf("run %v: %v results; want %v", i, len(rs), 7)
+		}
+		for j, r := range rs {
+			f := first[j]
+			if r.Name2 != f.Name2 || r.Count1 != f.Count1 || !closeTo(r.T, f.T, 1e-12) || !closeTo(r.Diff, f.Diff, 1e-12) {
+				t.Errorf("run %v: %v; want %v", i, r, f)
+			}
+		}
+	}
+	if !strings.Contains(serial, "PairRows\t") {
+		t.Errorf("no unpaired rows rejected:\n%v", serial)
+	}
+}
@@ -0,0 +1,810 @@
+package spstat
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// What an analysis needs from one pass over a table. Analyses that need
+// accumulators with the same Key in the same pass share one, so Key must
+// name everything that New depends on.
+type PassNeed struct {
+	Key string
+	// The stage its rows are counted under
+	Stage string
+	New func() (RowAccumulator, error)
+}
+
+// One analysis in a Plan. Passes says how many passes over the table it
+// needs; Need is called before each of them, with the table's header, and
+// Done after each with the filled accumulator. Need for one pass is only
+// called once every earlier pass is done, so it may use their results. The
+// analysis writes its output from Done after its last pass.
+type Analysis interface {
+	Name() string
+	Passes() int
+	Need(pass int, header []string) (PassNeed, error)
+	Done(pass int, acc RowAccumulator) error
+}
+
+// A set of analyses of one table, run together. Every row of each pass is
+// fed to all of the accumulators that need it, so the table is read as many
+// times as the analysis with the most passes needs, and not once per
+// analysis.
+type Plan struct {
+	Input ReadCloserMaker
+	Analyses []Analysis
+}
+
+// The accumulators for one pass, and the analyses that use each
+type planPass struct {
+	keys []string
+	needs map[string]PassNeed
+	users map[string][]Analysis
+}
+
+// The number of passes over the table the plan needs
+func (p *Plan) Passes() int {
+	n := 0
+	for _, a := range p.Analyses {
+		if a.Passes() > n {
+			n = a.Passes()
+		}
+	}
+	return n
+}
+
+func (p *Plan) pass(i int, header []string) (*planPass, error) {
+	pp := &planPass{needs: map[string]PassNeed{}, users: map[string][]Analysis{}}
+	for _, a := range p.Analyses {
+		if i >= a.Passes() { continue }
+		need, e := a.Need(i, header)
+		if e != nil { return nil, fmt.Errorf("%v: %w", a.Name(), e) }
+		if _, ok := pp.needs[need.Key]; !ok {
+			pp.keys = append(pp.keys, need.Key)
+			pp.needs[need.Key] = need
+		}
+		pp.users[need.Key] = append(pp.users[need.Key], a)
+	}
+	return pp, nil
+}
+
+// Run every pass of the plan, then write each analysis's output
+func (p *Plan) Run(ro *RowOptions) error {
+	h := handle("Plan.Run: %w")
+
+	header, e := ReadHeader(p.Input)
+	if e != nil { return h(e) }
+
+	for i := 0; i < p.Passes(); i++ {
+		pp, e := p.pass(i, header)
+		if e != nil { return h(e) }
+
+		fused := &fusedAccumulator{}
+		for _, key := range pp.keys {
+			need := pp.needs[key]
+			acc, e := need.New()
+			if e != nil { return h(fmt.Errorf("%v: %w", key, e)) }
+			fused.accs = append(fused.accs, acc)
+			fused.stages = append(fused.stages, ro.Stage(need.Stage))
+		}
+
+		e = rowPass(p.Input, fmt.Sprintf("Pass %v", i+1), fused, ro)
+		fused.done()
+		if e != nil { return h(e) }
+
+		for j, key := range pp.keys {
+			for _, a := range pp.users[key] {
+				if e := a.Done(i, fused.accs[j]); e != nil { return h(fmt.Errorf("%v: %w", a.Name(), e)) }
+			}
+		}
+	}
+	return nil
+}
+
+// Write which accumulators the first pass of the plan fills, and for which
+// analyses, then which analyses need each later pass. Only the table's
+// header is read, so the accumulators of later passes, which may depend on
+// the results of earlier ones, are not listed.
+func (p *Plan) Describe(w io.Writer) error {
+	h := handle("Plan.Describe: %w")
+
+	header, e := ReadHeader(p.Input)
+	if e != nil { return h(e) }
+
+	pp, e := p.pass(0, header)
+	if e != nil { return h(e) }
+
+	if _, e := fmt.Fprintln(w, "pass 1:"); e != nil { return h(e) }
+	for _, key := range pp.keys {
+		var names []string
+		for _, a := range pp.users[key] {
+			names = append(names, a.Name())
+		}
+		if _, e := fmt.Fprintf(w, "\t%v: %v\n", key, strings.Join(names, ", ")); e != nil { return h(e) }
+	}
+
+	for i := 1; i < p.Passes(); i++ {
+		if _, e := fmt.Fprintf(w, "pass %v:\n", i+1); e != nil { return h(e) }
+		for _, a := range p.Analyses {
+			if i >= a.Passes() { continue }
+			if _, e := fmt.Fprintf(w, "\t%v\n", a.Name()); e != nil { return h(e) }
+		}
+	}
+	return nil
+}
+
+// Several accumulators fed the same rows in one pass, each counting rows in
+// its own stage. The pass's own stage counts every row read.
+type fusedAccumulator struct {
+	accs []RowAccumulator
+	stages []*RowStage
+}
+
+func (f *fusedAccumulator) Row(rows *RowStage, cr *csv.Reader, line []string) error {
+	rows.Keep()
+	for i, acc := range f.accs {
+		if e := acc.Row(f.stages[i], cr, line); e != nil { return e }
+	}
+	return nil
+}
+
+func (f *fusedAccumulator) Empty() RowAccumulator {
+	out := &fusedAccumulator{}
+	for i, acc := range f.accs {
+		out.accs = append(out.accs, acc.Empty())
+		out.stages = append(out.stages, f.stages[i].chunkStage())
+	}
+	return out
+}
+
+func (f *fusedAccumulator) seedChunk(n int) {
+	for _, acc := range f.accs {
+		if sa, ok := acc.(seededAccumulator); ok {
+			sa.seedChunk(n)
+		}
+	}
+}
+
+func (f *fusedAccumulator) Merge(o RowAccumulator) error {
+	for i, acc := range o.(*fusedAccumulator).accs {
+		if e := f.accs[i].Merge(acc); e != nil { return e }
+	}
+	return nil
+}
+
+func (f *fusedAccumulator) addChunk(c RowAccumulator, offset int) error {
+	for i, stage := range c.(*fusedAccumulator).stages {
+		if e := f.stages[i].addChunk(stage, offset); e != nil { return e }
+	}
+	return nil
+}
+
+func (f *fusedAccumulator) done() {
+	for _, stage := range f.stages {
+		stage.Done()
+	}
+}
+
+// Find the index of each of names in header
+func headerCols(header []string, names ...string) ([]int, error) {
+	return NamedColsFunc(names)(header, nil)
+}
+
+// Gathers a TSummary of ValCol in each group of each of Groups, for the
+// analyses that need one
+type groupsAnalysis struct {
+	ValCol string
+	Groups []string
+	tsums []*TSummary
+}
+
+func (g *groupsAnalysis) Passes() int {
+	return 1
+}
+
+func (g *groupsAnalysis) Need(pass int, header []string) (PassNeed, error) {
+	need := PassNeed{
+		Key: fmt.Sprintf("CalcTSummary(%v by %v)", g.ValCol, strings.Join(g.Groups, ",")),
+		Stage: "CalcTSummary",
+	}
+	cols, e := headerCols(header, append([]string{g.ValCol}, g.Groups...)...)
+	if e != nil { return need, e }
+
+	need.New = func() (RowAccumulator, error) {
+		return newTSummaryAccumulator(cols[0], g.Groups, cols[1:]), nil
+	}
+	return need, nil
+}
+
+func (g *groupsAnalysis) done(acc RowAccumulator) {
+	g.tsums = acc.(*tsummaryAccumulator).tsums
+}
+
+// Open path, or stdout if it is empty, and call write with it
+func writeOutput(path string, write func(io.Writer) error) error {
+	w, e := OutputWriteCloserMaker(path).NewWriteCloser()
+	if e != nil { return e }
+	if e := write(w); e != nil {
+		w.Close()
+		return e
+	}
+	return w.Close()
+}
+
+// t tests of ValCol between "blood" in the first of Groups, the control
+// column, and each group in the second, the test column, as written by ttest
+type TTestAnalysis struct {
+	groupsAnalysis
+	Options TTestOptions
+	Output string
+}
+
+func NewTTestAnalysis(valcolname, controlcolname, testcolname, output string) *TTestAnalysis {
+	return &TTestAnalysis{groupsAnalysis{ValCol: valcolname, Groups: []string{controlcolname, testcolname}}, DefaultTTestOptions(), output}
+}
+
+func (t *TTestAnalysis) Name() string {
+	return "ttest > " + outputName(t.Output)
+}
+
+func (t *TTestAnalysis) Done(pass int, acc RowAccumulator) error {
+	t.done(acc)
+	return writeOutput(t.Output, func(w io.Writer) error {
+		return TTests(w, t.tsums, TTestSets(t.tsums, t.Groups, 0, 1), t.Options)
+	})
+}
+
+// F tests of ValCol between "blood" in the first of Groups, the control
+// column, and each group in the second, the test column, as written by
+// ftest. Method is FTestVariance, or FTestLevene or FTestBrownForsythe,
+// which take a second pass for the deviations from the centers found in the
+// first.
+type FTestAnalysis struct {
+	groupsAnalysis
+	Method string
+	Output string
+	centers []map[string]float64
+}
+
+func NewFTestAnalysis(valcolname, controlcolname, testcolname, output string) *FTestAnalysis {
+	return &FTestAnalysis{groupsAnalysis: groupsAnalysis{ValCol: valcolname, Groups: []string{controlcolname, testcolname}}, Method: FTestVariance, Output: output}
+}
+
+func (f *FTestAnalysis) Name() string {
+	if f.Method != FTestVariance {
+		return "ftest " + f.Method + " > " + outputName(f.Output)
+	}
+	return "ftest > " + outputName(f.Output)
+}
+
+func (f *FTestAnalysis) Passes() int {
+	if f.Method != FTestVariance {
+		return 2
+	}
+	return 1
+}
+
+func (f *FTestAnalysis) Need(pass int, header []string) (PassNeed, error) {
+	if pass == 0 && f.Method != FTestBrownForsythe {
+		return f.groupsAnalysis.Need(pass, header)
+	}
+
+	cols, e := headerCols(header, append([]string{f.ValCol}, f.Groups...)...)
+	if e != nil { return PassNeed{}, e }
+
+	if pass == 0 {
+		return PassNeed{
+			Key: fmt.Sprintf("CalcMedians(%v by %v)", f.ValCol, strings.Join(f.Groups, ",")),
+			Stage: "CalcMedians",
+			New: func() (RowAccumulator, error) {
+				return newMedianAccumulator(cols[0], f.Groups, cols[1:]), nil
+			},
+		}, nil
+	}
+	return PassNeed{
+		Key: fmt.Sprintf("CalcDeviations(%v by %v from %v)", f.ValCol, strings.Join(f.Groups, ","), f.Method),
+		Stage: "CalcDeviations",
+		New: func() (RowAccumulator, error) {
+			return newDeviationAccumulator(cols[0], cols[1:], f.centers), nil
+		},
+	}, nil
+}
+
+func (f *FTestAnalysis) Done(pass int, acc RowAccumulator) error {
+	switch {
+	case pass == 0 && f.Method == FTestBrownForsythe:
+		macc := acc.(*medianAccumulator)
+		f.tsums = macc.ts.tsums
+		f.centers = macc.medians()
+		return nil
+	case pass == 0 && f.Method == FTestLevene:
+		f.done(acc)
+		f.centers = tsummaryMeans(f.tsums)
+		return nil
+	case pass == 1:
+		devs := acc.(*deviationAccumulator).devs
+		return writeOutput(f.Output, func(w io.Writer) error {
+			return LeveneTests(w, f.tsums, devs, TTestSets(f.tsums, f.Groups, 0, 1))
+		})
+	}
+
+	f.done(acc)
+	return writeOutput(f.Output, func(w io.Writer) error {
+		return FTests(w, f.tsums, TTestSets(f.tsums, f.Groups, 0, 1))
+	})
+}
+
+// A one-way ANOVA and Bartlett's test across the levels of each of Groups,
+// and Welch's ANOVA if Welch is set, as written by anova
+type AnovaAnalysis struct {
+	groupsAnalysis
+	Welch bool
+	Output string
+}
+
+func (a *AnovaAnalysis) Name() string {
+	return "anova > " + outputName(a.Output)
+}
+
+func (a *AnovaAnalysis) Done(pass int, acc RowAccumulator) error {
+	a.done(acc)
+	return writeOutput(a.Output, func(w io.Writer) error {
+		return Anovas(w, a.tsums, a.Welch)
+	})
+}
+
+// Quantiles of ValCol in each group of each of Groups within rank error
+// RankError, as written by quantiles
+type QuantilesAnalysis struct {
+	ValCol string
+	Groups []string
+	Quantiles []float64
+	RankError float64
+	Output string
+}
+
+func (q *QuantilesAnalysis) Name() string {
+	return "quantiles > " + outputName(q.Output)
+}
+
+func (q *QuantilesAnalysis) Passes() int {
+	return 1
+}
+
+func (q *QuantilesAnalysis) Need(pass int, header []string) (PassNeed, error) {
+	k := SketchK(q.RankError)
+	need := PassNeed{
+		Key: fmt.Sprintf("CalcQuantiles(%v by %v, k %v)", q.ValCol, strings.Join(q.Groups, ","), k),
+		Stage: "CalcQuantiles",
+	}
+	cols, e := headerCols(header, append([]string{q.ValCol}, q.Groups...)...)
+	if e != nil { return need, e }
+
+	need.New = func() (RowAccumulator, error) {
+		return newQuantileAccumulator(cols[0], q.Groups, cols[1:], k), nil
+	}
+	return need, nil
+}
+
+func (q *QuantilesAnalysis) Done(pass int, acc RowAccumulator) error {
+	return writeOutput(q.Output, func(w io.Writer) error {
+		return WriteQuantiles(w, acc.(*quantileAccumulator).sets, q.Quantiles)
+	})
+}
+
+// The count and mean of ValCol in each group of each of Groups, written as a
+// table with the columns column, group, count and mean
+type MeansAnalysis struct {
+	ValCol string
+	Groups []string
+	Output string
+}
+
+func (m *MeansAnalysis) Name() string {
+	return "means > " + outputName(m.Output)
+}
+
+func (m *MeansAnalysis) Passes() int {
+	return 1
+}
+
+func (m *MeansAnalysis) Need(pass int, header []string) (PassNeed, error) {
+	need := PassNeed{
+		Key: fmt.Sprintf("CalcMeans(%v by %v)", m.ValCol, strings.Join(m.Groups, ",")),
+		Stage: "CalcMeans",
+	}
+	cols, e := headerCols(header, append([]string{m.ValCol}, m.Groups...)...)
+	if e != nil { return need, e }
+
+	need.New = func() (RowAccumulator, error) {
+		acc := &meansAccumulator{valcol: cols[0]}
+		for i, name := range m.Groups {
+			s := NewNamedValSet()
+			s.ColName = name
+			s.Idx = cols[i+1]
+			acc.sets = append(acc.sets, s)
+		}
+		return acc, nil
+	}
+	return need, nil
+}
+
+func (m *MeansAnalysis) Done(pass int, acc RowAccumulator) error {
+	sets := acc.(*meansAccumulator).sets
+	return writeOutput(m.Output, func(w io.Writer) error {
+		return WriteMeans(w, sets)
+	})
+}
+
+// Write the count and mean of each group in sets, with a header
+func WriteMeans(w io.Writer, sets []*NamedValSet) error {
+	h := handle("WriteMeans: %w")
+
+	if _, e := fmt.Fprintln(w, "column\tgroup\tcount\tmean"); e != nil { return h(e) }
+	for _, s := range sets {
+		for _, id := range sortedGroups(s.Counts) {
+			_, e := fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", s.ColName, id, s.Counts[id], s.Mean(id))
+			if e != nil { return h(e) }
+		}
+	}
+	return nil
+}
+
+// A linear model of ValCol ~ IndepCol, with the table and its residuals
+// written as by regression. The model is fit in the first pass, and the
+// residuals written in the second.
+type RegressionAnalysis struct {
+	ValCol string
+	IndepCol string
+	Output string
+	Model *LinearModeler
+	w io.WriteCloser
+}
+
+func (r *RegressionAnalysis) Name() string {
+	return "regression > " + outputName(r.Output)
+}
+
+func (r *RegressionAnalysis) Passes() int {
+	return 2
+}
+
+func (r *RegressionAnalysis) Need(pass int, header []string) (PassNeed, error) {
+	cols, e := headerCols(header, r.ValCol, r.IndepCol)
+	if e != nil { return PassNeed{}, e }
+
+	if pass == 0 {
+		return linearNeed(r.ValCol, r.IndepCol, cols), nil
+	}
+
+	m, b := r.Model.MB()
+	return PassNeed{
+		Key: fmt.Sprintf("LinearModelResiduals(%v ~ %v > %v)", r.ValCol, r.IndepCol, outputName(r.Output)),
+		Stage: "LinearModelResiduals",
+		New: func() (RowAccumulator, error) {
+			w, e := OutputWriteCloserMaker(r.Output).NewWriteCloser()
+			if e != nil { return nil, e }
+			r.w = w
+			return newResidualAccumulator(w, header, cols[0], cols[1], m, b)
+		},
+	}, nil
+}
+
+func (r *RegressionAnalysis) Done(pass int, acc RowAccumulator) error {
+	if pass == 0 {
+		r.Model = acc.(*linearAccumulator).l
+		return nil
+	}
+
+	if e := acc.(*residualAccumulator).Flush(); e != nil {
+		r.w.Close()
+		return e
+	}
+	return r.w.Close()
+}
+
+// The accumulator of a LinearModeler of valcolname ~ indepcolname
+func linearNeed(valcolname, indepcolname string, cols []int) PassNeed {
+	return PassNeed{
+		Key: fmt.Sprintf("FitLinearModel(%v ~ %v)", valcolname, indepcolname),
+		Stage: "FitLinearModel",
+		New: func() (RowAccumulator, error) {
+			return &linearAccumulator{valcol: cols[0], indepcol: cols[1], l: &LinearModeler{}}, nil
+		},
+	}
+}
+
+// A summary file, as written by summarize, of kind SummaryGroups,
+// SummaryMeans or SummaryLinearModel
+type SummaryAnalysis struct {
+	Kind string
+	ValCol string
+	IndepCol string
+	Groups []string
+	Format string
+	Output string
+}
+
+func (s *SummaryAnalysis) Name() string {
+	return "summary > " + outputName(s.Output)
+}
+
+func (s *SummaryAnalysis) Passes() int {
+	return 1
+}
+
+func (s *SummaryAnalysis) Need(pass int, header []string) (PassNeed, error) {
+	switch s.Kind {
+	case SummaryGroups:
+		g := &groupsAnalysis{ValCol: s.ValCol, Groups: s.Groups}
+		return g.Need(pass, header)
+	case SummaryMeans:
+		m := &MeansAnalysis{ValCol: s.ValCol, Groups: s.Groups}
+		return m.Need(pass, header)
+	case SummaryLinearModel:
+		cols, e := headerCols(header, s.ValCol, s.IndepCol)
+		if e != nil { return PassNeed{}, e }
+		return linearNeed(s.ValCol, s.IndepCol, cols), nil
+	}
+	return PassNeed{}, fmt.Errorf("unknown kind %q", s.Kind)
+}
+
+func (s *SummaryAnalysis) Done(pass int, acc RowAccumulator) error {
+	var sum *Summary
+	switch a := acc.(type) {
+	case *tsummaryAccumulator:
+		sum = NewGroupsSummary(s.ValCol, a.tsums)
+	case *meansAccumulator:
+		sum = NewMeansSummary(s.ValCol, a.sets)
+	case *linearAccumulator:
+		sum = NewLinearModelSummary(s.ValCol, s.IndepCol, a.l)
+	}
+
+	format := s.Format
+	if format == "" {
+		format = "json"
+	}
+	return WriteSummaryPath(sum, s.Output, format)
+}
+
+func outputName(path string) string {
+	if path == "" || path == "-" {
+		return "stdout"
+	}
+	return path
+}
+
+// A plan as written in a JSON file:
+//
+//	{
+//		"input": "table.tsv.gz",
+//		"region": "chr1",
+//		"chrom_col": "chrom",
+//		"pos_col": "pos",
+//		"analyses": [
+//			{"type": "ttest", "value": "value", "groups": ["tissue", "indiv_chrom_tissue"], "output": "t.tsv"},
+//			{"type": "regression", "value": "value", "indep": "gc", "output": "resid.tsv.gz"}
+//		]
+//	}
+//
+// The types are ttest, ftest, anova, quantiles, means, regression and
+// summary. ttest and ftest take the control column and then the test column
+// as their groups, and a ttest may set "method", "alternative" and "conf" as
+// the ttest command does, and an ftest "method" as the ftest command does.
+// An anova compares the levels of each of its groups, with "welch": true for
+// Welch's ANOVA, and quantiles may set "quantiles" and "rank_error" as the
+// quantiles command does. A summary takes groups, with "means": true for
+// SummaryMeans, or indep, and "format": "binary" for the binary form.
+type PlanSpec struct {
+	Input string `json:"input"`
+	Region string `json:"region"`
+	ChromCol string `json:"chrom_col"`
+	PosCol string `json:"pos_col"`
+	Analyses []AnalysisSpec `json:"analyses"`
+}
+
+type AnalysisSpec struct {
+	Type string `json:"type"`
+	Value string `json:"value"`
+	Indep string `json:"indep"`
+	Groups []string `json:"groups"`
+	Means bool `json:"means"`
+	Format string `json:"format"`
+	Method string `json:"method"`
+	Alternative string `json:"alternative"`
+	Conf float64 `json:"conf"`
+	Welch bool `json:"welch"`
+	Quantiles []float64 `json:"quantiles"`
+	RankError float64 `json:"rank_error"`
+	Output string `json:"output"`
+}
+
+// Read a PlanSpec from a JSON file
+func ReadPlanSpecPath(path string) (*PlanSpec, error) {
+	h := handle("ReadPlanSpecPath: %w")
+
+	f, e := os.Open(path)
+	if e != nil { return nil, h(e) }
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	var spec PlanSpec
+	if e := dec.Decode(&spec); e != nil { return nil, h(fmt.Errorf("%v: %w", path, e)) }
+	return &spec, nil
+}
+
+// Make the analysis a spec describes
+func (s *AnalysisSpec) Analysis() (Analysis, error) {
+	if s.Value == "" {
+		return nil, fmt.Errorf("%v: missing value column", s.Type)
+	}
+
+	switch s.Type {
+	case "ttest", "ftest":
+		if len(s.Groups) != 2 {
+			return nil, fmt.Errorf("%v: need a control column and a test column in groups, not %v", s.Type, s.Groups)
+		}
+		if s.Type == "ttest" {
+			opts, e := NewTTestOptions(s.Method, s.Alternative, s.Conf)
+			if e != nil { return nil, fmt.Errorf("ttest: %w", e) }
+			if opts.Method == TTestPaired || opts.Method == TTestOneSample {
+				return nil, fmt.Errorf("ttest: the %v method cannot run in a plan", opts.Method)
+			}
+			t := NewTTestAnalysis(s.Value, s.Groups[0], s.Groups[1], s.Output)
+			t.Options = opts
+			return t, nil
+		}
+		f := NewFTestAnalysis(s.Value, s.Groups[0], s.Groups[1], s.Output)
+		if s.Method != "" {
+			if e := CheckFTestMethod(s.Method); e != nil { return nil, e }
+			f.Method = s.Method
+		}
+		return f, nil
+	case "anova":
+		if len(s.Groups) == 0 {
+			return nil, fmt.Errorf("anova: missing groups")
+		}
+		return &AnovaAnalysis{groupsAnalysis{ValCol: s.Value, Groups: s.Groups}, s.Welch, s.Output}, nil
+	case "quantiles":
+		if len(s.Groups) == 0 {
+			return nil, fmt.Errorf("quantiles: missing groups")
+		}
+		a := &QuantilesAnalysis{ValCol: s.Value, Groups: s.Groups, Quantiles: s.Quantiles, RankError: s.RankError, Output: s.Output}
+		if a.Quantiles == nil {
+			a.Quantiles, _ = ParseQuantiles(DefaultQuantiles)
+		}
+		for _, q := range a.Quantiles {
+			if !(q >= 0 && q <= 1) {
+				return nil, fmt.Errorf("quantiles: quantile %v is not between 0 and 1", q)
+			}
+		}
+		if a.RankError == 0 {
+			a.RankError = DefaultQuantileError
+		}
+		if !(a.RankError > 0 && a.RankError < 1) {
+			return nil, fmt.Errorf("quantiles: rank error %v is not between 0 and 1", a.RankError)
+		}
+		return a, nil
+	case "means":
+		if len(s.Groups) == 0 {
+			return nil, fmt.Errorf("means: missing groups")
+		}
+		return &MeansAnalysis{ValCol: s.Value, Groups: s.Groups, Output: s.Output}, nil
+	case "regression":
+		if s.Indep == "" {
+			return nil, fmt.Errorf("regression: missing indep")
+		}
+		return &RegressionAnalysis{ValCol: s.Value, IndepCol: s.Indep, Output: s.Output}, nil
+	case "summary":
+		a := &SummaryAnalysis{ValCol: s.Value, IndepCol: s.Indep, Groups: s.Groups, Format: s.Format, Output: s.Output}
+		switch {
+		case s.Indep != "" && len(s.Groups) == 0:
+			a.Kind = SummaryLinearModel
+		case s.Indep == "" && len(s.Groups) > 0 && s.Means:
+			a.Kind = SummaryMeans
+		case s.Indep == "" && len(s.Groups) > 0:
+			a.Kind = SummaryGroups
+		default:
+			return nil, fmt.Errorf("summary: need one of indep or groups")
+		}
+		return a, nil
+	}
+	return nil, fmt.Errorf("unknown analysis type %q", s.Type)
+}
+
+// Make the plan a spec describes, reading rcm
+func (s *PlanSpec) Plan(rcm ReadCloserMaker) (*Plan, error) {
+	p := &Plan{Input: rcm}
+	outputs := map[string]bool{}
+	for _, as := range s.Analyses {
+		a, e := as.Analysis()
+		if e != nil { return nil, fmt.Errorf("PlanSpec.Plan: %w", e) }
+
+		if as.Output != "" && as.Output != "-" {
+			if outputs[as.Output] {
+				return nil, fmt.Errorf("PlanSpec.Plan: two analyses write %v", as.Output)
+			}
+			outputs[as.Output] = true
+		}
+		p.Analyses = append(p.Analyses, a)
+	}
+	return p, nil
+}
+
+type planFlags struct {
+	Spec string
+	Path string
+	Region string
+	ChromCol string
+	PosCol string
+	DryRun bool
+}
+
+// Run the analyses in a plan file on the command line
+func RunPlan() {
+	var f planFlags
+	flag.StringVar(&f.Spec, "spec", "", "JSON file listing the input and the analyses to run")
+	flag.StringVar(&f.Path, "i", "", "input table, plain or compressed, a comma-separated list or glob of shards, a table from compile, or - for stdin (default the spec's input)")
+	flag.StringVar(&f.Region, "region", "", "only read rows in these regions, like chr1:1000-2000, separated by semicolons (default the spec's region)")
+	flag.StringVar(&f.ChromCol, "chromcol", "", "chromosome column name for -region (default the spec's chrom_col, or \"chrom\")")
+	flag.StringVar(&f.PosCol, "poscol", "", "position column name for -region (default the spec's pos_col, or \"pos\")")
+	flag.BoolVar(&f.DryRun, "n", false, "print the passes the plan needs instead of running it")
+	rowflags := AddRowFlags()
+	flag.Parse()
+	defer rowflags.Exit()
+	if f.Spec == "" {
+		panic(fmt.Errorf("missing -spec"))
+	}
+
+	spec, e := ReadPlanSpecPath(f.Spec)
+	if e != nil { panic(e) }
+	if f.Path != "" {
+		spec.Input = f.Path
+	}
+	if f.Region != "" {
+		spec.Region = f.Region
+	}
+	if f.ChromCol != "" {
+		spec.ChromCol = f.ChromCol
+	}
+	if f.PosCol != "" {
+		spec.PosCol = f.PosCol
+	}
+	if spec.ChromCol == "" {
+		spec.ChromCol = DefaultChromCol
+	}
+	if spec.PosCol == "" {
+		spec.PosCol = DefaultPosCol
+	}
+	if spec.Input == "" {
+		panic(fmt.Errorf("missing -i, and no input in %v", f.Spec))
+	}
+
+	rcm, e := InputRegionReadCloserMaker(spec.Input, spec.Region, spec.ChromCol, spec.PosCol)
+	if e != nil { panic(e) }
+
+	p, e := spec.Plan(rcm)
+	if e != nil { panic(e) }
+
+	if f.DryRun {
+		e = p.Describe(os.Stdout)
+		if e != nil { panic(e) }
+		return
+	}
+
+	ro, e := rowflags.Start()
+	if e != nil { panic(e) }
+
+	e = rowflags.Validate(rcm)
+	if e != nil { panic(e) }
+
+	e = p.Run(ro)
+	if e != nil { panic(e) }
+}
@@ -0,0 +1,51 @@
+package spstat
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlanRun(t *testing.T) {
+	ro := DefaultRowOptions()
+	rcm := chunkTestTable()
+	dir := t.TempDir()
+	out := func(name string) string { return filepath.Join(dir, name) }
+
+	spec := &PlanSpec{Analyses: []AnalysisSpec{
+		{Type: "ttest", Value: "value", Groups: []string{"tissue", "group"}, Output: out("t.tsv")},
+		{Type: "ftest", Value: "value", Groups: []string{"tissue", "group"}, Output: out("f.tsv")},
+		{Type: "means", Value: "value", Groups: []string{"group"}, Output: out("m.tsv")},
+		{Type: "regression", Value: "value", Indep: "x", Output: out("r.tsv")},
+	}}
+	p, e := spec.Plan(rcm)
+	if e != nil { t.Fatal(e) }
+	if p.Passes() != 2 {
+		t.Errorf("plan needs %v passes, want 2", p.Passes())
+	}
+
+	if e := p.Run(ro); e != nil { t.Fatal(e) }
+	var stages []string
+	for _, c := range ro.Rows.Counts() {
+		stages = append(stages, c.Stage)
+	}
+	want := "Pass 1,CalcTSummary,CalcMeans,FitLinearModel,Pass 2,LinearModelResiduals"
+	if got := strings.Join(stages, ","); got != want {
+		t.Errorf("stages %v, want %v", got, want)
+	}
+
+	var b strings.Builder
+	if e := RunLinearModel(rcm, &b, "value", "x", ro); e != nil { t.Fatal(e) }
+	resid, e := os.ReadFile(out("r.tsv"))
+	if e != nil { t.Fatal(e) }
+	if string(resid) != b.String() {
+		t.Errorf("plan residuals differ from RunLinearModel")
+	}
+
+	means, e := os.ReadFile(out("m.tsv"))
+	if e != nil { t.Fatal(e) }
+	if lines := strings.Count(string(means), "\n"); lines != 8 {
+		t.Errorf("%v lines of means, want 8:\n%s", lines, means)
+	}
+}
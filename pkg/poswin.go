@@ -12,7 +12,7 @@ import (
 func PosWinOne(line []string, col int, winsize int) (int, error) {
 	h := handle("CombineOne: %w")
 
-	if len(line) <= col { return 0, h(fmt.Errorf("line too short")) }
+	if len(line) <= col { return 0, h(ErrShortLine) }
 	p, e := strconv.ParseInt(line[col], 0, 64)
 	if e != nil { return 0, h(e) }
 
@@ -20,7 +20,7 @@ func PosWinOne(line []string, col int, winsize int) (int, error) {
 }
 
 // CalculatePosWinOne for all lines in rcm
-func PosWin(rcm ReadCloserMaker, w io.Writer, colf func([]string, []int) (int, error), winsize int) error {
+func PosWin(rcm ReadCloserMaker, w io.Writer, colf func([]string, []int) (int, error), winsize int, ro *RowOptions) error {
 	h := handle("PosWin: %w")
 
 	r, e := rcm.NewReadCloser()
@@ -41,26 +41,35 @@ func PosWin(rcm ReadCloserMaker, w io.Writer, colf func([]string, []int) (int, e
 	e = cw.Write(line)
 	if e != nil { return h(e) }
 
+	rows := ro.Stage("PosWin")
+	defer rows.Done()
+
 	for line, e := cr.Read(); e != io.EOF; line, e = cr.Read() {
 		if e != nil { return h(e) }
 
 		pw, e := PosWinOne(line, col, winsize)
-		if e != nil { continue }
+		if e != nil {
+			if e := rows.RejectCsvErr(e, cr, line); e != nil { return h(e) }
+			continue
+		}
+		rows.Keep()
 		line = append(line, fmt.Sprint(pw))
 		e = cw.Write(line)
-		if e != nil { continue }
+		if e != nil { return h(e) }
 	}
 
+	cw.Flush()
+	if e := cw.Error(); e != nil { return h(e) }
 	return nil
 }
 
 // Run PosWin with a named column
-func RunPosWin(rcm ReadCloserMaker, w io.Writer, colname string, winsize int) error {
+func RunPosWin(rcm ReadCloserMaker, w io.Writer, colname string, winsize int, ro *RowOptions) error {
 	h := handle("RunColCombine: %w")
 
 	colf := ValColFunc(colname)
 
-	if e := PosWin(rcm, w, colf, winsize); e != nil {
+	if e := PosWin(rcm, w, colf, winsize, ro); e != nil {
 		return h(e)
 	}
 
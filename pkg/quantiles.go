@@ -0,0 +1,201 @@
+package spstat
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The quantiles that quantiles reports unless others are asked for: the
+// median, the quartiles and the 1% and 99% tails
+const DefaultQuantiles = "0.01,0.25,0.5,0.75,0.99"
+
+// The rank error of quantiles unless another is asked for
+const DefaultQuantileError = 0.01
+
+// A QuantileSketch of the values in each named category in a particular
+// column, for medians and other quantiles within a known rank error
+type NamedQuantileSet struct {
+	ColName string
+	Idx int
+	K int
+	// The chunk of the table the set gathers, or 0 for the whole table,
+	// which seeds its sketches along with their names
+	Chunk int
+	Sketches map[string]*QuantileSketch
+}
+
+// An empty set whose sketches have accuracy k
+func NewNamedQuantileSet(colname string, idx, k int) *NamedQuantileSet {
+	return &NamedQuantileSet{ColName: colname, Idx: idx, K: k, Sketches: map[string]*QuantileSketch{}}
+}
+
+// Add a value to the sketch of id
+func (s *NamedQuantileSet) Add(val float64, id string) {
+	sketch, ok := s.Sketches[id]
+	if !ok {
+		sketch = NewQuantileSketch(s.K, SketchSeed(id, s.Chunk))
+		s.Sketches[id] = sketch
+	}
+	sketch.Add(val)
+}
+
+// Add all of the values in o to s
+func (s *NamedQuantileSet) Merge(o *NamedQuantileSet) error {
+	for id, os := range o.Sketches {
+		if sketch, ok := s.Sketches[id]; ok {
+			if e := sketch.Merge(os); e != nil { return fmt.Errorf("NamedQuantileSet.Merge: %v: %w", id, e) }
+		} else {
+			s.Sketches[id] = os
+		}
+	}
+	return nil
+}
+
+// The value at quantile q of the values of id, or NaN if there are none
+func (s *NamedQuantileSet) Quantile(id string, q float64) float64 {
+	sketch, ok := s.Sketches[id]
+	if !ok {
+		return math.NaN()
+	}
+	return sketch.Quantile(q)
+}
+
+// The median of the values of id, or NaN if there are none
+func (s *NamedQuantileSet) Median(id string) float64 {
+	return s.Quantile(id, 0.5)
+}
+
+// The count of values of id
+func (s *NamedQuantileSet) Count(id string) float64 {
+	sketch, ok := s.Sketches[id]
+	if !ok {
+		return 0
+	}
+	return sketch.Count()
+}
+
+// The names in s, in sorted order
+func (s *NamedQuantileSet) sortedNames() []string {
+	counts := map[string]float64{}
+	for id, sketch := range s.Sketches {
+		counts[id] = sketch.Count()
+	}
+	return sortedGroups(counts)
+}
+
+// A NamedQuantileSet of the value column for each id column, gathered row
+// by row
+type quantileAccumulator struct {
+	valcol int
+	sets []*NamedQuantileSet
+}
+
+func newQuantileAccumulator(valcol int, idcolsnames []string, idcols []int, k int) *quantileAccumulator {
+	acc := &quantileAccumulator{valcol: valcol}
+	for i, idcol := range idcols {
+		acc.sets = append(acc.sets, NewNamedQuantileSet(idcolsnames[i], idcol, k))
+	}
+	return acc
+}
+
+func (a *quantileAccumulator) Row(rows *RowStage, cr *csv.Reader, line []string) error {
+	val, ok, e := rows.CsvFloat(cr, line, a.valcol)
+	if e != nil || !ok { return e }
+	rows.Keep()
+
+	for _, set := range a.sets {
+		if len(line) <= set.Idx { continue }
+		set.Add(val, line[set.Idx])
+	}
+	return nil
+}
+
+func (a *quantileAccumulator) Empty() RowAccumulator {
+	out := &quantileAccumulator{valcol: a.valcol}
+	for _, set := range a.sets {
+		out.sets = append(out.sets, NewNamedQuantileSet(set.ColName, set.Idx, set.K))
+	}
+	return out
+}
+
+func (a *quantileAccumulator) seedChunk(n int) {
+	for _, set := range a.sets {
+		set.Chunk = n
+	}
+}
+
+func (a *quantileAccumulator) Merge(o RowAccumulator) error {
+	for i, set := range o.(*quantileAccumulator).sets {
+		if e := a.sets[i].Merge(set); e != nil { return e }
+	}
+	return nil
+}
+
+// Parse a comma-separated list of quantiles between 0 and 1
+func ParseQuantiles(list string) ([]float64, error) {
+	var qs []float64
+	for _, field := range strings.Split(list, ",") {
+		q, e := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if e != nil { return nil, fmt.Errorf("ParseQuantiles: %w", e) }
+		if !(q >= 0 && q <= 1) {
+			return nil, fmt.Errorf("ParseQuantiles: quantile %v is not between 0 and 1", q)
+		}
+		qs = append(qs, q)
+	}
+	return qs, nil
+}
+
+// Write each of qs for each group of each of sets, one per line, with the
+// columns column, group, count, quantile, value, rank error and the
+// confidence that the value is within the rank error
+func WriteQuantiles(w io.Writer, sets []*NamedQuantileSet, qs []float64) error {
+	for _, set := range sets {
+		for _, name := range set.sortedNames() {
+			sketch := set.Sketches[name]
+			for _, q := range qs {
+				_, e := fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+					set.ColName, name, sketch.Count(),
+					q, sketch.Quantile(q), sketch.RankError(), sketch.Confidence(),
+				)
+				if e != nil { return fmt.Errorf("WriteQuantiles: %w", e) }
+			}
+		}
+	}
+	return nil
+}
+
+// Gather a NamedQuantileSet of valcol for each of idcols in one pass, with
+// sketches whose rank error is at most eps
+func CalcQuantiles(rcm ReadCloserMaker, valcol int, idcolsnames []string, idcols []int, eps float64, ro *RowOptions) ([]*NamedQuantileSet, error) {
+	h := handle("CalcQuantiles: %w")
+
+	if !(eps > 0 && eps < 1) {
+		return nil, h(fmt.Errorf("rank error %v is not between 0 and 1", eps))
+	}
+
+	acc := newQuantileAccumulator(valcol, idcolsnames, idcols, SketchK(eps))
+	if e := rowPass(rcm, "CalcQuantiles", acc, ro); e != nil { return nil, h(e) }
+	return acc.sets, nil
+}
+
+// Write quantiles qs of valcolname for each group of each of idcolsnames,
+// within rank error eps
+func RunQuantiles(rcm ReadCloserMaker, w io.Writer, valcolname string, idcolsnames []string, qs []float64, eps float64, ro *RowOptions) error {
+	h := handle("RunQuantiles: %w")
+
+	valcol, e := ValCol(rcm, valcolname)
+	if e != nil { return h(e) }
+
+	idcols, e := IdCols(rcm, idcolsnames)
+	if e != nil { return h(e) }
+
+	sets, e := CalcQuantiles(rcm, valcol, idcolsnames, idcols, eps, ro)
+	if e != nil { return h(e) }
+
+	if e := WriteQuantiles(w, sets, qs); e != nil { return h(e) }
+	return nil
+}
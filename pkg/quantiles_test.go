@@ -0,0 +1,111 @@
+package spstat
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestQuantiles(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	vals := map[string][]float64{"small": {3, 1, 2, 4}}
+	var b strings.Builder
+	b.WriteString("group\tvalue\n")
+	for _, v := range vals["small"] {
+		fmt.Fprintf(&b, "small\t%v\n", v)
+	}
+	for i := 0; i < 50000; i++ {
+		v := r.NormFloat64()
+		vals["big"] = append(vals["big"], v)
+		fmt.Fprintf(&b, "big\t%v\n", v)
+	}
+	b.WriteString("big\tNA\n")
+	rcm := stringTable(b.String())
+	for _, vs := range vals {
+		sort.Float64s(vs)
+	}
+
+	qs, e := ParseQuantiles("0.01,0.5,0.99")
+	if e != nil { t.Fatal(e) }
+	eps := 0.005
+
+	var outs []string
+	serialAndParallel(t, func(ro *RowOptions) {
+		var out bytes.Buffer
+		if e := RunQuantiles(rcm, &out, "value", []string{"group"}, qs, eps, ro); e != nil { t.Fatal(e) }
+		outs = append(outs, out.String())
+	})
+
+	for _, out := range outs {
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		if len(lines) != 6 {
+			t.Fatalf("output %q", out)
+		}
+		for _, line := range lines {
+			f := strings.Split(line, "\t")
+			q, _ := strconv.ParseFloat(f[3], 64)
+			v, _ := strconv.ParseFloat(f[4], 64)
+			rankerr, _ := strconv.ParseFloat(f[5], 64)
+			vs := vals[f[1]]
+
+			if f[1] == "small" {
+				want := map[float64]float64{0.01: 1.03, 0.5: 2.5, 0.99: 3.97}[q]
+				if !closeTo(v, want, 1e-12) || rankerr != 0 || f[6] != "1" || f[2] != "4" {
+					t.Errorf("line %q; want %v with no rank error", line, want)
+				}
+				continue
+			}
+			rank := float64(sort.SearchFloat64s(vs, v)) / float64(len(vs))
+			if rankerr <= 0 || rankerr > eps || rank - q > rankerr || q - rank > rankerr || f[6] != "0.99" || f[2] != "50000" {
+				t.Errorf("line %q: rank %v", line, rank)
+			}
+		}
+	}
+
+	// Sketches are seeded by their group and chunk, so runs repeat whatever
+	// the number of threads or the sketches made before
+	wants := []string{outs[0], outs[1], outs[1]}
+	for i, threads := range []int{1, 2, 3} {
+		ro := DefaultRowOptions()
+		ro.Chunks.Threads = threads
+		ro.Chunks.Size = 512
+		var out bytes.Buffer
+		if e := RunQuantiles(rcm, &out, "value", []string{"group"}, qs, eps, ro); e != nil { t.Fatal(e) }
+		if want := wants[i]; out.String() != want {
+			t.Errorf("%v threads:\n%v\nwant\n%v", threads, out.String(), want)
+		}
+	}
+
+	for _, bad := range []string{"0.5,x", "1.5", "-0.1", ""} {
+		if _, e := ParseQuantiles(bad); e == nil {
+			t.Errorf("ParseQuantiles(%q) did not fail", bad)
+		}
+	}
+}
+
+func TestQuantilesPlan(t *testing.T) {
+	ro := DefaultRowOptions()
+	rcm := chunkTestTable()
+	path := filepath.Join(t.TempDir(), "q.tsv")
+
+	spec := &PlanSpec{Analyses: []AnalysisSpec{
+		{Type: "quantiles", Value: "value", Groups: []string{"tissue", "group"}, Quantiles: []float64{0.1, 0.5}, Output: path},
+	}}
+	p, e := spec.Plan(rcm)
+	if e != nil { t.Fatal(e) }
+	if e := p.Run(ro); e != nil { t.Fatal(e) }
+
+	got, e := os.ReadFile(path)
+	if e != nil { t.Fatal(e) }
+	var want bytes.Buffer
+	if e := RunQuantiles(rcm, &want, "value", []string{"tissue", "group"}, []float64{0.1, 0.5}, DefaultQuantileError, ro); e != nil { t.Fatal(e) }
+	if string(got) != want.String() {
+		t.Errorf("plan quantiles\n%s\ndiffer from RunQuantiles\n%s", got, want.String())
+	}
+}
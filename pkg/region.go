@@ -0,0 +1,592 @@
+package spstat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A chromosome interval, 1-based and inclusive at both ends
+type Region struct {
+	Chrom string
+	Start int64
+	End int64
+}
+
+// Parse "chr:start-end", "chr:start" (to the end of chr), or "chr" (all of
+// chr). Commas in the numbers are ignored.
+func ParseRegion(s string) (Region, error) {
+	h := handle("ParseRegion: %w")
+
+	colon := strings.LastIndex(s, ":")
+	if colon < 0 {
+		return Region{s, 1, math.MaxInt64}, nil
+	}
+
+	r := Region{Chrom: s[:colon], End: math.MaxInt64}
+	span := strings.ReplaceAll(s[colon+1:], ",", "")
+	startstr, endstr, hasEnd := strings.Cut(span, "-")
+
+	start, e := strconv.ParseInt(startstr, 10, 64)
+	if e != nil { return Region{}, h(e) }
+	r.Start = start
+
+	if hasEnd {
+		end, e := strconv.ParseInt(endstr, 10, 64)
+		if e != nil { return Region{}, h(e) }
+		r.End = end
+	}
+
+	if r.Start > r.End {
+		return Region{}, h(fmt.Errorf("start %v after end %v in %v", r.Start, r.End, s))
+	}
+	return r, nil
+}
+
+// Parse a list of regions separated by spaces or semicolons.
+func ParseRegions(s string) ([]Region, error) {
+	var regions []Region
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == ' ' }) {
+		r, e := ParseRegion(field)
+		if e != nil { return nil, fmt.Errorf("ParseRegions: %w", e) }
+		regions = append(regions, r)
+	}
+	return regions, nil
+}
+
+// Check whether pos on chrom falls in r
+func (r Region) Contains(chrom string, pos int64) bool {
+	return chrom == r.Chrom && pos >= r.Start && pos <= r.End
+}
+
+// Sort regions by chromosome, in the order given by chroms, then by start,
+// and merge any that overlap. Regions on chromosomes missing from chroms are
+// dropped.
+func mergeRegions(regions []Region, chroms []string) []Region {
+	order := map[string]int{}
+	for i, chrom := range chroms {
+		order[chrom] = i
+	}
+
+	var sorted []Region
+	for _, r := range regions {
+		if _, ok := order[r.Chrom]; ok {
+			sorted = append(sorted, r)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Chrom != sorted[j].Chrom {
+			return order[sorted[i].Chrom] < order[sorted[j].Chrom]
+		}
+		return sorted[i].Start < sorted[j].Start
+	})
+
+	var merged []Region
+	for _, r := range sorted {
+		last := len(merged) - 1
+		if last >= 0 && merged[last].Chrom == r.Chrom && r.Start <= merged[last].End {
+			if r.End > merged[last].End {
+				merged[last].End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// Appended to a table's path to get the path of its region index
+const IndexSuffix = ".spi"
+
+var indexMagic = []byte("SPIDX\x00")
+
+const indexVersion = 2
+
+// The columns that hold each row's chromosome and position unless others are
+// asked for
+const (
+	DefaultChromCol = "chrom"
+	DefaultPosCol = "pos"
+)
+
+// Rows are indexed in windows of 1 << IndexWindowShift base pairs
+const IndexWindowShift = 14
+
+// A tabix-style linear index over a BGZF table sorted by chromosome and
+// position. For each chromosome, Windows holds the virtual offset of the
+// first row in each window of positions, or 0 if the window has no rows.
+// TableSize and TableModTime (in Unix nanoseconds) record the table as it
+// was indexed, so that a stale index is not used.
+type RegionIndex struct {
+	ChromCol string
+	PosCol string
+	TableSize int64
+	TableModTime int64
+	Chroms []string
+	Windows map[string][]int64
+}
+
+// Split one raw line from a table into fields, without the trailing newline
+func splitTsvLine(line []byte, buf []string) []string {
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	return append(buf[:0], strings.Split(string(line), "\t")...)
+}
+
+// Find the chromosome and position of one row, given the column indices
+func chromPos(fields []string, chromidx, posidx int) (string, int64, error) {
+	if len(fields) <= chromidx || len(fields) <= posidx {
+		return "", 0, fmt.Errorf("line too short")
+	}
+	pos, e := strconv.ParseInt(fields[posidx], 10, 64)
+	if e != nil {
+		return "", 0, e
+	}
+	return fields[chromidx], pos, nil
+}
+
+// Read lines from a BgzfReader, along with the virtual offset of the start
+// of each line
+type bgzfLines struct {
+	b *BgzfReader
+	line []byte
+}
+
+func (l *bgzfLines) next() (line []byte, voff int64, err error) {
+	for len(l.b.buf) == 0 {
+		if e := l.b.nextBlock(); e != nil {
+			return nil, 0, e
+		}
+	}
+	voff = l.b.VirtualOffset()
+
+	l.line = l.line[:0]
+	for {
+		if i := bytes.IndexByte(l.b.buf, '\n'); i >= 0 {
+			l.line = append(l.line, l.b.buf[:i+1]...)
+			l.b.buf = l.b.buf[i+1:]
+			return l.line, voff, nil
+		}
+		l.line = append(l.line, l.b.buf...)
+		l.b.buf = l.b.buf[:0]
+
+		if e := l.b.nextBlock(); e != nil {
+			if e == io.EOF && len(l.line) > 0 {
+				return l.line, voff, nil
+			}
+			return nil, 0, e
+		}
+	}
+}
+
+// Read a whole BGZF table, sorted by chromosome and then position, and index
+// the virtual offset of the first row in each window of positions.
+func BuildRegionIndex(path string, chromcol, poscol string, threads int) (*RegionIndex, error) {
+	h := handle("BuildRegionIndex: %w")
+
+	f, e := os.Open(path)
+	if e != nil { return nil, h(e) }
+	defer f.Close()
+
+	info, e := f.Stat()
+	if e != nil { return nil, h(e) }
+
+	magic := make([]byte, bgzfHeaderLen)
+	if _, e := io.ReadFull(f, magic); e != nil || !IsBgzf(magic) {
+		return nil, h(fmt.Errorf("%v is not BGZF compressed", path))
+	}
+	if _, e := f.Seek(0, io.SeekStart); e != nil { return nil, h(e) }
+
+	br := NewBgzfReader(bufio.NewReaderSize(f, 1 << 16), threads)
+	defer br.Close()
+	lines := &bgzfLines{b: br}
+
+	line, _, e := lines.next()
+	if e != nil { return nil, h(e) }
+	header := splitTsvLine(line, nil)
+	cols, e := NamedColsFunc([]string{chromcol, poscol})(header, nil)
+	if e != nil { return nil, h(e) }
+	chromidx, posidx := cols[0], cols[1]
+
+	x := &RegionIndex{
+		ChromCol: chromcol,
+		PosCol: poscol,
+		TableSize: info.Size(),
+		TableModTime: info.ModTime().UnixNano(),
+		Windows: map[string][]int64{},
+	}
+	var fields []string
+	chrom := ""
+	var lastpos int64
+
+	for line, voff, e := lines.next(); e != io.EOF; line, voff, e = lines.next() {
+		if e != nil { return nil, h(e) }
+
+		fields = splitTsvLine(line, fields)
+		c, pos, e := chromPos(fields, chromidx, posidx)
+		if e != nil { continue }
+
+		if c != chrom || len(x.Chroms) == 0 {
+			if _, seen := x.Windows[c]; seen {
+				return nil, h(fmt.Errorf("chromosome %v is not contiguous; sort the table by chromosome and position", c))
+			}
+			x.Chroms = append(x.Chroms, c)
+			x.Windows[c] = nil
+			chrom = c
+			lastpos = pos
+		}
+		if pos < lastpos {
+			return nil, h(fmt.Errorf("position %v follows %v on %v; sort the table by chromosome and position", pos, lastpos, c))
+		}
+		lastpos = pos
+
+		win := int(pos >> IndexWindowShift)
+		wins := x.Windows[c]
+		for len(wins) <= win {
+			wins = append(wins, 0)
+		}
+		if wins[win] == 0 {
+			wins[win] = voff
+		}
+		x.Windows[c] = wins
+	}
+
+	return x, nil
+}
+
+// The virtual offset to start reading rows in r from, or false if no row
+// can be in r.
+func (x *RegionIndex) Start(r Region) (int64, bool) {
+	wins := x.Windows[r.Chrom]
+	for win := int(r.Start >> IndexWindowShift); win < len(wins); win++ {
+		if wins[win] != 0 {
+			return wins[win], true
+		}
+	}
+	return 0, false
+}
+
+func writeUvarint(w *bufio.Writer, x uint64) error {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	_, e := w.Write(buf[:n])
+	return e
+}
+
+func writeVarint(w *bufio.Writer, x int64) error {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], x)
+	_, e := w.Write(buf[:n])
+	return e
+}
+
+func writeIndexString(w *bufio.Writer, s string) error {
+	if e := writeUvarint(w, uint64(len(s))); e != nil {
+		return e
+	}
+	_, e := w.WriteString(s)
+	return e
+}
+
+// Check that the table at path has the size and modification time it had
+// when it was indexed
+func (x *RegionIndex) Check(path string) error {
+	info, e := os.Stat(path)
+	if e != nil { return fmt.Errorf("RegionIndex.Check: %w", e) }
+	if info.Size() != x.TableSize || info.ModTime().UnixNano() != x.TableModTime {
+		return fmt.Errorf("RegionIndex.Check: %v has changed since it was indexed; run index on it again", path)
+	}
+	return nil
+}
+
+// Write the index in binary form: magic bytes and version, the chromosome
+// and position column names, the table's size and modification time, then
+// each chromosome's name, window count, and window offsets, all as
+// uvarint-prefixed strings, uvarints and varints.
+func (x *RegionIndex) Write(w io.Writer) error {
+	h := handle("RegionIndex.Write: %w")
+	bw := bufio.NewWriter(w)
+
+	if _, e := bw.Write(indexMagic); e != nil { return h(e) }
+	if e := bw.WriteByte(indexVersion); e != nil { return h(e) }
+	if e := writeIndexString(bw, x.ChromCol); e != nil { return h(e) }
+	if e := writeIndexString(bw, x.PosCol); e != nil { return h(e) }
+	if e := writeUvarint(bw, uint64(x.TableSize)); e != nil { return h(e) }
+	if e := writeVarint(bw, x.TableModTime); e != nil { return h(e) }
+	if e := writeUvarint(bw, uint64(len(x.Chroms))); e != nil { return h(e) }
+
+	for _, chrom := range x.Chroms {
+		if e := writeIndexString(bw, chrom); e != nil { return h(e) }
+		wins := x.Windows[chrom]
+		if e := writeUvarint(bw, uint64(len(wins))); e != nil { return h(e) }
+		for _, voff := range wins {
+			if e := writeUvarint(bw, uint64(voff)); e != nil { return h(e) }
+		}
+	}
+
+	if e := bw.Flush(); e != nil { return h(e) }
+	return nil
+}
+
+// Read an index written by RegionIndex.Write
+func ReadRegionIndex(r io.Reader) (*RegionIndex, error) {
+	h := handle("ReadRegionIndex: %w")
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(indexMagic) + 1)
+	if _, e := io.ReadFull(br, magic); e != nil { return nil, h(e) }
+	if !bytes.Equal(magic[:len(indexMagic)], indexMagic) {
+		return nil, h(fmt.Errorf("not a region index"))
+	}
+	if magic[len(indexMagic)] != indexVersion {
+		return nil, h(fmt.Errorf("unsupported version %v; run index on the table again", magic[len(indexMagic)]))
+	}
+
+	var buf []byte
+	var e error
+	x := &RegionIndex{Windows: map[string][]int64{}}
+	if x.ChromCol, buf, e = readColumnarString(br, buf); e != nil { return nil, h(e) }
+	if x.PosCol, buf, e = readColumnarString(br, buf); e != nil { return nil, h(e) }
+
+	size, e := binary.ReadUvarint(br)
+	if e != nil { return nil, h(e) }
+	x.TableSize = int64(size)
+	if x.TableModTime, e = binary.ReadVarint(br); e != nil { return nil, h(e) }
+
+	nchroms, e := readUvarint(br)
+	if e != nil { return nil, h(e) }
+	for i := 0; i < nchroms; i++ {
+		var chrom string
+		if chrom, buf, e = readColumnarString(br, buf); e != nil { return nil, h(e) }
+		nwins, e := readUvarint(br)
+		if e != nil { return nil, h(e) }
+
+		wins := make([]int64, nwins)
+		for j := range wins {
+			voff, e := binary.ReadUvarint(br)
+			if e != nil { return nil, h(e) }
+			wins[j] = int64(voff)
+		}
+		x.Chroms = append(x.Chroms, chrom)
+		x.Windows[chrom] = wins
+	}
+	return x, nil
+}
+
+// Read the index for the table at path, if there is one
+func ReadRegionIndexPath(path string) (*RegionIndex, error) {
+	f, e := os.Open(path + IndexSuffix)
+	if e != nil { return nil, fmt.Errorf("ReadRegionIndexPath: %w", e) }
+	defer f.Close()
+	return ReadRegionIndex(f)
+}
+
+// A ReadCloserMaker that yields the header of a table and only the rows that
+// fall in Regions. If Index is set, Path must be the indexed BGZF table, and
+// only the parts of it that can hold those rows are read. Otherwise, every
+// row of Source is read and filtered.
+type RegionReadCloserMaker struct {
+	Path string
+	Source ReadCloserMaker
+	Index *RegionIndex
+	Regions []Region
+	ChromCol string
+	PosCol string
+	Threads int
+}
+
+// Restrict the input at path to regions, finding positions in the columns
+// chromcol and poscol. Uses the index at path + IndexSuffix if there is one
+// on the same columns, and otherwise filters the whole input. An index that
+// cannot be read, or is older than the table, is an error.
+func NewRegionReadCloserMaker(path string, regions []Region, chromcol, poscol string) (*RegionReadCloserMaker, error) {
+	h := handle("NewRegionReadCloserMaker: %w")
+
+	m := &RegionReadCloserMaker{
+		Path: path,
+		Regions: regions,
+		ChromCol: chromcol,
+		PosCol: poscol,
+	}
+
+	x, e := ReadRegionIndexPath(path)
+	if errors.Is(e, fs.ErrNotExist) || (e == nil && (x.ChromCol != chromcol || x.PosCol != poscol)) {
+		m.Source = InputReadCloserMaker(path)
+		return m, nil
+	}
+	if e != nil { return nil, h(e) }
+	if e := x.Check(path); e != nil { return nil, h(e) }
+
+	m.Index = x
+	return m, nil
+}
+
+func (m *RegionReadCloserMaker) String() string {
+	return fmt.Sprintf("%v restricted to %v", m.Path, m.Regions)
+}
+
+func (m *RegionReadCloserMaker) NewReadCloser() (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		var e error
+		if m.Index != nil {
+			e = m.writeIndexed(pw)
+		} else {
+			e = m.writeFiltered(pw)
+		}
+		pw.CloseWithError(e)
+	}()
+	return pr, nil
+}
+
+// Find the chromosome and position columns in a header line
+func (m *RegionReadCloserMaker) headerCols(line []byte) (chromidx, posidx int, err error) {
+	cols, e := NamedColsFunc([]string{m.ChromCol, m.PosCol})(splitTsvLine(line, nil), nil)
+	if e != nil { return 0, 0, e }
+	return cols[0], cols[1], nil
+}
+
+// Copy the header and the rows in m.Regions from a full scan of m.Source
+func (m *RegionReadCloserMaker) writeFiltered(w io.Writer) error {
+	h := handle("writeFiltered: %w")
+
+	r, e := m.Source.NewReadCloser()
+	if e != nil { return h(e) }
+	defer r.Close()
+	br := bufio.NewReader(r)
+
+	line, e := br.ReadBytes('\n')
+	if e != nil && !(e == io.EOF && len(line) > 0) { return h(e) }
+	chromidx, posidx, e := m.headerCols(line)
+	if e != nil { return h(e) }
+	if _, e := w.Write(line); e != nil { return h(e) }
+
+	var fields []string
+	for {
+		line, e := br.ReadBytes('\n')
+		if len(line) > 0 {
+			fields = splitTsvLine(line, fields)
+			chrom, pos, perr := chromPos(fields, chromidx, posidx)
+			if perr == nil && inRegions(m.Regions, chrom, pos) {
+				if _, e := w.Write(line); e != nil { return h(e) }
+			}
+		}
+		if e == io.EOF { return nil }
+		if e != nil { return h(e) }
+	}
+}
+
+func inRegions(regions []Region, chrom string, pos int64) bool {
+	for _, r := range regions {
+		if r.Contains(chrom, pos) {
+			return true
+		}
+	}
+	return false
+}
+
+// Copy the header, then seek to each region in turn and copy its rows
+func (m *RegionReadCloserMaker) writeIndexed(w io.Writer) error {
+	h := handle("writeIndexed: %w")
+
+	r, e := OpenBgzfAt(m.Path, 0, 1)
+	if e != nil { return h(e) }
+	line, e := bufio.NewReader(r).ReadBytes('\n')
+	r.Close()
+	if e != nil { return h(e) }
+
+	chromidx, posidx, e := m.headerCols(line)
+	if e != nil { return h(e) }
+	if _, e := w.Write(line); e != nil { return h(e) }
+
+	for _, region := range mergeRegions(m.Regions, m.Index.Chroms) {
+		voff, ok := m.Index.Start(region)
+		if !ok { continue }
+		if e := m.writeRegion(w, region, voff, chromidx, posidx); e != nil { return h(e) }
+	}
+	return nil
+}
+
+// Copy the rows of one region, starting from virtual offset voff and
+// stopping at the first row past the end of the region
+func (m *RegionReadCloserMaker) writeRegion(w io.Writer, region Region, voff int64, chromidx, posidx int) error {
+	r, e := OpenBgzfAt(m.Path, voff, m.Threads)
+	if e != nil { return e }
+	defer r.Close()
+	br := bufio.NewReader(r)
+
+	var fields []string
+	for {
+		line, e := br.ReadBytes('\n')
+		if len(line) > 0 {
+			fields = splitTsvLine(line, fields)
+			chrom, pos, perr := chromPos(fields, chromidx, posidx)
+			if perr == nil {
+				if chrom != region.Chrom || pos > region.End {
+					return nil
+				}
+				if pos >= region.Start {
+					if _, e := w.Write(line); e != nil { return e }
+				}
+			}
+		}
+		if e == io.EOF { return nil }
+		if e != nil { return e }
+	}
+}
+
+// Interpret an input path and the -region, -chromcol and -poscol flags from
+// the command line. With no regions, this is the same as
+// InputReadCloserMaker.
+func InputRegionReadCloserMaker(path, regions, chromcol, poscol string) (ReadCloserMaker, error) {
+	h := handle("InputRegionReadCloserMaker: %w")
+
+	if regions == "" {
+		return InputReadCloserMaker(path), nil
+	}
+	rs, e := ParseRegions(regions)
+	if e != nil { return nil, h(e) }
+	m, e := NewRegionReadCloserMaker(path, rs, chromcol, poscol)
+	if e != nil { return nil, h(e) }
+	return m, nil
+}
+
+type indexFlags struct {
+	Path string
+	ChromCol string
+	PosCol string
+}
+
+// Index a BGZF table for region queries on the command line
+func RunIndex() {
+	var f indexFlags
+	flag.StringVar(&f.Path, "i", "", "input BGZF table, sorted by chromosome and position")
+	flag.StringVar(&f.ChromCol, "chromcol", DefaultChromCol, "chromosome column name")
+	flag.StringVar(&f.PosCol, "poscol", DefaultPosCol, "position column name")
+	flag.Parse()
+	if f.Path == "" {
+		panic(fmt.Errorf("missing -i"))
+	}
+
+	x, e := BuildRegionIndex(f.Path, f.ChromCol, f.PosCol, 0)
+	if e != nil { panic(e) }
+
+	w, e := OutPath(f.Path + IndexSuffix).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	e = x.Write(w)
+	if e != nil { panic(e) }
+
+	e = w.Close()
+	if e != nil { panic(e) }
+}
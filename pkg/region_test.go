@@ -0,0 +1,166 @@
+package spstat
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A sorted table over several chromosomes and many index windows, with a
+// gap of empty windows on chr2
+func regionTestTable() []byte {
+	var b strings.Builder
+	b.WriteString("value\tchrom\tpos\n")
+	for c, chrom := range []string{"chr1", "chr2", "chrX"} {
+		for pos := 1 + c * 5; pos < 150000; pos += 37 {
+			if chrom == "chr2" && pos > 40000 && pos < 90000 {
+				continue
+			}
+			fmt.Fprintf(&b, "%v\t%v\t%v\n", pos % 11, chrom, pos)
+		}
+	}
+	return []byte(b.String())
+}
+
+// The header and rows of table in any of regions, by checking every row
+func bruteRegions(table []byte, regions []Region) []byte {
+	lines := strings.SplitAfter(string(table), "\n")
+	out := lines[0]
+	for _, line := range lines[1:] {
+		fields := strings.Split(strings.TrimSuffix(line, "\n"), "\t")
+		if len(fields) < 3 { continue }
+		pos, _ := strconv.ParseInt(fields[2], 10, 64)
+		if inRegions(regions, fields[1], pos) {
+			out += line
+		}
+	}
+	return []byte(out)
+}
+
+func writeIndexedTable(t *testing.T, dir string, table []byte) string {
+	path := filepath.Join(dir, "table.tsv.bgz")
+	if e := os.WriteFile(path, bgzfBytes(t, table), 0644); e != nil { t.Fatal(e) }
+
+	x, e := BuildRegionIndex(path, "chrom", "pos", 2)
+	if e != nil { t.Fatal(e) }
+	f, e := os.Create(path + IndexSuffix)
+	if e != nil { t.Fatal(e) }
+	if e := x.Write(f); e != nil { t.Fatal(e) }
+	if e := f.Close(); e != nil { t.Fatal(e) }
+	return path
+}
+
+func TestRegionIndex(t *testing.T) {
+	table := regionTestTable()
+	path := writeIndexedTable(t, t.TempDir(), table)
+
+	x, e := BuildRegionIndex(path, "chrom", "pos", 1)
+	if e != nil { t.Fatal(e) }
+	if fmt.Sprint(x.Chroms) != "[chr1 chr2 chrX]" || len(x.Windows["chr1"]) != 10 {
+		t.Errorf("chroms %v, %v windows on chr1", x.Chroms, len(x.Windows["chr1"]))
+	}
+	if w := x.Windows["chr2"]; w[2] == 0 || w[3] != 0 || w[4] != 0 || w[5] == 0 {
+		t.Errorf("chr2 windows %v", x.Windows["chr2"])
+	}
+
+	read, e := ReadRegionIndexPath(path)
+	if e != nil { t.Fatal(e) }
+	if !reflect.DeepEqual(x, read) {
+		t.Errorf("index read back as %+v, want %+v", read, x)
+	}
+
+	regionLists := []string{
+		"chr1",
+		"chr1:1000-2000",
+		"chr1:16000-17000",
+		"chr1:16384-16384;chr1:16385-16421",
+		"chr2:30000-100000",
+		"chr2:50000-60000",
+		"chrX:100;chr1:140000-150000;chr1:145000-146000",
+		"chr1:1-20000;chrX:1-20000;chr1:10000-40000",
+		"chr3",
+		"chrX:149000",
+	}
+	for _, list := range regionLists {
+		regions, e := ParseRegions(list)
+		if e != nil { t.Fatal(e) }
+		want := bruteRegions(table, regions)
+
+		indexed, e := NewRegionReadCloserMaker(path, regions, "chrom", "pos")
+		if e != nil { t.Fatal(e) }
+		if indexed.Index == nil {
+			t.Fatalf("%v: index not used", list)
+		}
+		filtered := &RegionReadCloserMaker{Path: path, Source: AutoDecompressPath(path), Regions: regions, ChromCol: "chrom", PosCol: "pos"}
+
+		for _, m := range []*RegionReadCloserMaker{indexed, filtered} {
+			got, e := readAll(m)
+			if e != nil { t.Fatal(e) }
+			if !bytes.Equal(got, want) {
+				t.Errorf("%v with index %v: %v lines, want %v", list, m.Index != nil, bytes.Count(got, []byte{'\n'}), bytes.Count(want, []byte{'\n'}))
+			}
+		}
+	}
+}
+
+func TestRegionIndexFallback(t *testing.T) {
+	dir := t.TempDir()
+	table := regionTestTable()
+	path := writeIndexedTable(t, dir, table)
+	regions, e := ParseRegions("chr2:30000-100000")
+	if e != nil { t.Fatal(e) }
+
+	// Other columns than the index's, or no index at all, are filtered
+	renamed := bytes.Replace(table, []byte("value\tchrom\tpos"), []byte("value\tchr\tstart"), 1)
+	plain := filepath.Join(dir, "plain.tsv")
+	if e := os.WriteFile(plain, renamed, 0644); e != nil { t.Fatal(e) }
+	cases := []struct {
+		path string
+		chromcol string
+		poscol string
+	}{
+		{path, "value", "pos"},
+		{plain, "chr", "start"},
+	}
+	for _, c := range cases {
+		m, e := NewRegionReadCloserMaker(c.path, regions, c.chromcol, c.poscol)
+		if e != nil { t.Fatal(e) }
+		if m.Index != nil {
+			t.Errorf("%v: index used for columns %v and %v", c.path, c.chromcol, c.poscol)
+		}
+	}
+	got, e := readAll(&RegionReadCloserMaker{Path: plain, Source: AutoDecompressPath(plain), Regions: regions, ChromCol: "chr", PosCol: "start"})
+	if e != nil { t.Fatal(e) }
+	want := bruteRegions(renamed, regions)
+	if !bytes.Equal(got, want) {
+		t.Errorf("filtered on other columns: %v lines, want %v", bytes.Count(got, []byte{'\n'}), bytes.Count(want, []byte{'\n'}))
+	}
+	if m, e := InputRegionReadCloserMaker(plain, "chr2:30000-100000", "chr", "start"); e != nil {
+		t.Fatal(e)
+	} else if got, e := readAll(m); e != nil || !bytes.Equal(got, want) {
+		t.Errorf("InputRegionReadCloserMaker on other columns: %v", e)
+	}
+
+	// A table changed since it was indexed is refused
+	later := time.Now().Add(time.Hour)
+	if e := os.Chtimes(path, later, later); e != nil { t.Fatal(e) }
+	if _, e := NewRegionReadCloserMaker(path, regions, "chrom", "pos"); e == nil || !strings.Contains(e.Error(), "run index") {
+		t.Errorf("stale index: %v", e)
+	}
+	if e := os.WriteFile(path, bgzfBytes(t, table[:len(table) / 2]), 0644); e != nil { t.Fatal(e) }
+	if _, e := NewRegionReadCloserMaker(path, regions, "chrom", "pos"); e == nil {
+		t.Errorf("index of a rewritten table used")
+	}
+
+	// A corrupt index is an error, not a reason to filter
+	if e := os.WriteFile(path + IndexSuffix, []byte("SPIDX\x00\x01"), 0644); e != nil { t.Fatal(e) }
+	if _, e := NewRegionReadCloserMaker(path, regions, "chrom", "pos"); e == nil {
+		t.Errorf("old index version used")
+	}
+}
@@ -2,17 +2,20 @@ package spstat
 
 import (
 	"github.com/jgbaldwinbrown/csvh"
-	"log"
+	"bytes"
 	"encoding/csv"
 	"fmt"
 	"io"
-	"strconv"
 )
 
 // Calculate the T summary needed for a linear regression for each of the specified columns
-func CalcFullColTSummary(rcm ReadCloserMaker, cols []int) ([]*TSummary, error) {
+func CalcFullColTSummary(rcm ReadCloserMaker, cols []int, ro *RowOptions) ([]*TSummary, error) {
 	h := handle("CalcTSummary: %w")
 
+	if cm, ok := rcm.(ColumnarReaderMaker); ok {
+		return CalcFullColTSummaryColumnar(cm, cols, ro)
+	}
+
 	var tsums []*TSummary
 	for _, col := range cols {
 		tsum := NewTSummary()
@@ -25,18 +28,31 @@ func CalcFullColTSummary(rcm ReadCloserMaker, cols []int) ([]*TSummary, error) {
 	defer r.Close()
 	cr := csvh.CsvIn(r)
 
+	if e := skipHeader(cr); e != nil { return tsums, h(e) }
+	rows := ro.Stage("CalcFullColTSummary")
+	defer rows.Done()
+
 	for line, e := cr.Read(); e != io.EOF; line, e = cr.Read() {
 		if e != nil { return tsums, h(e) }
 
-
+		var valerr error
+		used := false
 		for i, tsum := range tsums {
 			valcol := cols[i]
-			if len(line) <= valcol { continue }
-			val, e := strconv.ParseFloat(line[valcol], 64)
-			if e != nil { continue }
+			val, e := parseCol(line, valcol, rows.values)
+			if e != nil {
+				if valerr == nil { valerr = e }
+				continue
+			}
 
-			if len(line) <= tsum.Idx { continue }
 			tsum.Add(val, "")
+			used = true
+		}
+
+		if used {
+			rows.Keep()
+		} else if e := rows.RejectCsvErr(valerr, cr, line); e != nil {
+			return tsums, h(e)
 		}
 	}
 
@@ -44,22 +60,52 @@ func CalcFullColTSummary(rcm ReadCloserMaker, cols []int) ([]*TSummary, error) {
 }
 
 // All the intermediate statistics needed to calculate a simple linear model of
-// y ~ x
+// y ~ x, kept as running means and co-moments so that the model can be fit
+// in one pass. XDiffSqSum, YDiffSqSum and XDiffYDiffSum are the sums of
+// squared and cross deviations from the current means.
 type LinearModeler struct {
-	XDiffYDiffSum float64
-	XDiffSqSum float64
-	Count float64
-	XMean float64
-	YMean float64
+	XDiffYDiffSum float64 `json:"x_diff_y_diff_sum"`
+	XDiffSqSum float64 `json:"x_diff_sq_sum"`
+	YDiffSqSum float64 `json:"y_diff_sq_sum"`
+	Count float64 `json:"count"`
+	XMean float64 `json:"x_mean"`
+	YMean float64 `json:"y_mean"`
 }
 
-// Add another data point to the linear model
+// Add another data point to the linear model, updating the means and
+// co-moments with Welford's method
 func (m *LinearModeler) Add(y, x float64) {
+	m.Count++
 	xdiff := x - m.XMean
 	ydiff := y - m.YMean
-	m.XDiffSqSum += xdiff * xdiff
-	m.XDiffYDiffSum += xdiff * ydiff
-	m.Count++
+	m.XMean += xdiff / m.Count
+	m.YMean += ydiff / m.Count
+	m.XDiffSqSum += xdiff * (x - m.XMean)
+	m.YDiffSqSum += ydiff * (y - m.YMean)
+	m.XDiffYDiffSum += xdiff * (y - m.YMean)
+}
+
+// Combine the data points of o into m, as if they had all been added to m
+func (m *LinearModeler) Merge(o *LinearModeler) {
+	if o.Count == 0 {
+		return
+	}
+	if m.Count == 0 {
+		*m = *o
+		return
+	}
+
+	n := m.Count + o.Count
+	xdiff := o.XMean - m.XMean
+	ydiff := o.YMean - m.YMean
+	weight := m.Count * o.Count / n
+
+	m.XDiffSqSum += o.XDiffSqSum + xdiff * xdiff * weight
+	m.YDiffSqSum += o.YDiffSqSum + ydiff * ydiff * weight
+	m.XDiffYDiffSum += o.XDiffYDiffSum + xdiff * ydiff * weight
+	m.XMean += xdiff * o.Count / n
+	m.YMean += ydiff * o.Count / n
+	m.Count = n
 }
 
 // Calculate the m and b coefficients from the model
@@ -69,52 +115,73 @@ func (l *LinearModeler) MB() (m float64, b float64) {
 	return m, b
 }
 
-// Calculate the linear model using the pre-calculated means for each column
-func LinearModelCore(rcm ReadCloserMaker, valcol, indepcol int, vmean, imean float64) (m, b float64, err error) {
-	h := handle("LinearModelCore: %w")
+// Fit y ~ x, using valcol as y and indepcol as x, in one pass over a table
+func FitLinearModel(rcm ReadCloserMaker, valcol, indepcol int, ro *RowOptions) (*LinearModeler, error) {
+	h := handle("FitLinearModel: %w")
 
-	l := &LinearModeler{XMean: imean, YMean: vmean}
+	if cm, ok := rcm.(ColumnarReaderMaker); ok {
+		return FitLinearModelColumnar(cm, valcol, indepcol, ro)
+	}
 
-	r, e := rcm.NewReadCloser()
-	if e != nil { return 0, 0, h(e) }
-	defer r.Close()
-	cr := csvh.CsvIn(r)
+	acc := &linearAccumulator{valcol: valcol, indepcol: indepcol, l: &LinearModeler{}}
+	if e := rowPass(rcm, "FitLinearModel", acc, ro); e != nil { return nil, h(e) }
 
-	for line, e := cr.Read(); e != io.EOF; line, e = cr.Read() {
-		if e != nil { return 0, 0, h(e) }
+	return acc.l, nil
+}
 
+// A LinearModeler of the value column against the independent column,
+// gathered row by row
+type linearAccumulator struct {
+	valcol int
+	indepcol int
+	l *LinearModeler
+}
 
-		if len(line) <= valcol { continue }
-		val, e := strconv.ParseFloat(line[valcol], 64)
-		if e != nil { continue }
+func (a *linearAccumulator) Row(rows *RowStage, cr *csv.Reader, line []string) error {
+	val, ok, e := rows.CsvFloat(cr, line, a.valcol)
+	if e != nil || !ok { return e }
 
-		if len(line) <= indepcol { continue }
-		indep, e := strconv.ParseFloat(line[indepcol], 64)
-		if e != nil { continue }
+	indep, ok, e := rows.CsvFloat(cr, line, a.indepcol)
+	if e != nil || !ok { return e }
 
-		l.Add(val, indep)
-	}
+	rows.Keep()
+	a.l.Add(val, indep)
+	return nil
+}
 
-	log.Print(l, *l)
-	m_out, b_out := l.MB()
-	return m_out, b_out, nil
+func (a *linearAccumulator) Empty() RowAccumulator {
+	return &linearAccumulator{valcol: a.valcol, indepcol: a.indepcol, l: &LinearModeler{}}
+}
 
+func (a *linearAccumulator) Merge(o RowAccumulator) error {
+	a.l.Merge(o.(*linearAccumulator).l)
+	return nil
 }
 
 // Calculate the linear model coefficients for a table
-func LinearModel(rcm ReadCloserMaker, valcol, indepcol int) (m, b float64, err error) {
-	h := handle("LinearModel: %w")
-
-	tsums, e := CalcFullColTSummary(rcm, []int{valcol, indepcol})
-	if e != nil { return 0, 0, h(e) }
-	vmean := tsums[0].Mean("")
-	imean := tsums[1].Mean("")
+func LinearModel(rcm ReadCloserMaker, valcol, indepcol int, ro *RowOptions) (m, b float64, err error) {
+	l, e := FitLinearModel(rcm, valcol, indepcol, ro)
+	if e != nil { return 0, 0, fmt.Errorf("LinearModel: %w", e) }
 
-	m, b, e = LinearModelCore(rcm, valcol, indepcol, vmean, imean)
-	if e != nil { return 0, 0, h(e) }
+	m, b = l.MB()
 	return m, b, nil
 }
 
+// Parse the value in one column of a row with Values
+func parseCol(line []string, col int, values *ValueParser) (float64, error) {
+	if len(line) <= col { return 0, ErrShortLine }
+	return values.Parse(line[col])
+}
+
+// Parse the y and x values of one row
+func parseXY(line []string, valcol, indepcol int, values *ValueParser) (y, x float64, err error) {
+	y, e := parseCol(line, valcol, values)
+	if e != nil { return 0, 0, e }
+	x, e = parseCol(line, indepcol, values)
+	if e != nil { return 0, 0, e }
+	return y, x, nil
+}
+
 // Get the residuals for a pair of y and x values, given the m and b coefficients of a linear model
 func OneLinearModelResidual(y, x, m, b float64) float64 {
 	predict := (x * m) + b
@@ -122,59 +189,83 @@ func OneLinearModelResidual(y, x, m, b float64) float64 {
 }
 
 // Calculate and append all of the residuals for a linear model
-func LinearModelResiduals(rcm ReadCloserMaker, w io.Writer, valcol, indepcol int, m, b float64) (err error) {
+func LinearModelResiduals(rcm ReadCloserMaker, w io.Writer, valcol, indepcol int, m, b float64, ro *RowOptions) (err error) {
 	h := handle("LinearModelResiduals: %w")
 
-	r, e := rcm.NewReadCloser()
+	header, e := ReadHeader(rcm)
 	if e != nil { return h(e) }
-	defer r.Close()
-	cr := csvh.CsvIn(r)
 
-	cw := csv.NewWriter(w)
-	cw.Comma = rune('\t')
-	defer cw.Flush()
-
-	line, e := cr.Read()
-	if e != nil { return h(e) }
-	line = append(line, "residual")
-	e = cw.Write(line)
+	acc, e := newResidualAccumulator(w, header, valcol, indepcol, m, b)
 	if e != nil { return h(e) }
 
-	for line, e := cr.Read(); e != io.EOF; line, e = cr.Read() {
-		if e != nil { return h(e) }
-
+	if e := rowPass(rcm, "LinearModelResiduals", acc, ro); e != nil { return h(e) }
+	if e := acc.Flush(); e != nil { return h(e) }
+	return nil
+}
 
-		if len(line) <= valcol { continue }
-		val, e := strconv.ParseFloat(line[valcol], 64)
-		if e != nil { continue }
+// Writes each row of a table with its residual from a linear model appended.
+// The rows of a chunk are held in memory until the chunk is merged.
+type residualAccumulator struct {
+	valcol int
+	indepcol int
+	m, b float64
+	w io.Writer
+	buf *bytes.Buffer
+	cw *csv.Writer
+}
 
-		if len(line) <= indepcol { continue }
-		indep, e := strconv.ParseFloat(line[indepcol], 64)
-		if e != nil { continue }
+// Start writing rows with residuals to w, beginning with header
+func newResidualAccumulator(w io.Writer, header []string, valcol, indepcol int, m, b float64) (*residualAccumulator, error) {
+	a := &residualAccumulator{valcol: valcol, indepcol: indepcol, m: m, b: b, w: w, cw: csvh.CsvOut(w)}
+	e := a.cw.Write(append(append([]string(nil), header...), "residual"))
+	if e != nil { return nil, fmt.Errorf("newResidualAccumulator: %w", e) }
+	return a, nil
+}
 
-		resid := OneLinearModelResidual(val, indep, m, b)
-		line = append(line, fmt.Sprint(resid))
-		e = cw.Write(line)
-		if e != nil { continue }
+func (a *residualAccumulator) Row(rows *RowStage, cr *csv.Reader, line []string) error {
+	val, indep, e := parseXY(line, a.valcol, a.indepcol, rows.values)
+	if e != nil {
+		return rows.RejectCsvErr(e, cr, line)
 	}
+	rows.Keep()
 
-	return nil
+	resid := OneLinearModelResidual(val, indep, a.m, a.b)
+	return a.cw.Write(append(line, fmt.Sprint(resid)))
+}
+
+func (a *residualAccumulator) Empty() RowAccumulator {
+	buf := &bytes.Buffer{}
+	return &residualAccumulator{valcol: a.valcol, indepcol: a.indepcol, m: a.m, b: a.b, w: buf, buf: buf, cw: csvh.CsvOut(buf)}
+}
+
+// Write out the rows of o's chunk
+func (a *residualAccumulator) Merge(o RowAccumulator) error {
+	oa := o.(*residualAccumulator)
+	if e := oa.Flush(); e != nil { return e }
+	if e := a.Flush(); e != nil { return e }
+
+	_, e := oa.buf.WriteTo(a.w)
+	return e
+}
+
+// Flush the rows written so far
+func (a *residualAccumulator) Flush() error {
+	a.cw.Flush()
+	return a.cw.Error()
 }
 
 // Run the whole linear model pipeline (get the named columns, find the linear model coefficients, then append residuals)
-func RunLinearModel(rcm ReadCloserMaker, w io.Writer, valcolname, indepcolname string) error {
+func RunLinearModel(rcm ReadCloserMaker, w io.Writer, valcolname, indepcolname string, ro *RowOptions) error {
 	h := handle("RunLinearModel: %w")
 
-	valcol, e := ValCol(rcm, valcolname)
-	if e != nil { return h(e) }
-
-	indepcol, e := ValCol(rcm, indepcolname)
+	cols, e := NamedCols(rcm, []string{valcolname, indepcolname})
 	if e != nil { return h(e) }
+	valcol, indepcol := cols[0], cols[1]
 
-	m, b, e := LinearModel(rcm, valcol, indepcol)
+	m, b, e := LinearModel(rcm, valcol, indepcol, ro)
 	if e != nil { return h(e) }
 
-	e = LinearModelResiduals(rcm, w, valcol, indepcol, m, b)
+	e = LinearModelResiduals(rcm, w, valcol, indepcol, m, b, ro)
 	if e != nil { return h(e) }
 
 	return nil
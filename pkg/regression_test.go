@@ -0,0 +1,54 @@
+package spstat
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLinearModelerMerge(t *testing.T) {
+	xs := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	ys := []float64{2.1, 3.9, 6.2, 7.8, 10.1, 12.2, 13.8, 16.1, 18.0, 20.2}
+
+	var all LinearModeler
+	for i, x := range xs {
+		all.Add(ys[i], x)
+	}
+
+	var a, b LinearModeler
+	for i, x := range xs {
+		if i < 3 {
+			a.Add(ys[i], x)
+		} else {
+			b.Add(ys[i], x)
+		}
+	}
+	a.Merge(&b)
+
+	m, c := all.MB()
+	mm, mc := a.MB()
+	if math.Abs(m - mm) > 1e-12 || math.Abs(c - mc) > 1e-12 {
+		t.Errorf("merged fit %v, %v != %v, %v", mm, mc, m, c)
+	}
+	if math.Abs(m - 2.0072727272727273) > 1e-9 {
+		t.Errorf("slope %v", m)
+	}
+
+	var empty LinearModeler
+	empty.Merge(&all)
+	if empty != all {
+		t.Errorf("merge into empty %v != %v", empty, all)
+	}
+}
+
+func TestLinearModelTransform(t *testing.T) {
+	ro := DefaultRowOptions()
+	rcm := stringTable("chrom\tcov\n1\t10\n2\t10\nX\t5\nY\t5\n3\n")
+	m, b, e := LinearModelTransform(rcm, 0, 1, ChrToExpectation, ro)
+	if e != nil { t.Fatal(e) }
+	if !closeTo(m, 0.1, 1e-12) || !closeTo(b, 0, 1e-12) {
+		t.Errorf("m %v, b %v; want 0.1, 0", m, b)
+	}
+	if counts := ro.Rows.Counts(); len(counts) != 1 || counts[0].Kept() != 4 || counts[0].Rejected[ShortLine] != 1 {
+		t.Errorf("counts %v", counts)
+	}
+}
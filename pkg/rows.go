@@ -0,0 +1,487 @@
+package spstat
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Why a row was left out of a pass over a table
+type RejectReason int
+
+const (
+	ShortLine RejectReason = iota
+	Unparseable
+	NaNValue
+	MissingKey
+	MissingValue
+	InfValue
+	BadCount
+	BadFraction
+	numRejectReasons
+)
+
+var rejectReasonNames = [numRejectReasons]string{
+	"short line",
+	"unparseable value",
+	"NaN",
+	"missing key",
+	"missing value",
+	"infinite value",
+	"hits not between 0 and count",
+	"fraction not between 0 and 1",
+}
+
+func (r RejectReason) String() string {
+	if r < 0 || r >= numRejectReasons {
+		return fmt.Sprintf("RejectReason(%d)", int(r))
+	}
+	return rejectReasonNames[r]
+}
+
+// Returned when a row does not have the columns a calculation needs
+var ErrShortLine = errors.New("line too short")
+
+// Returned when a row's key has no summary to look up
+var ErrMissingKey = errors.New("missing key")
+
+// Find the RejectReason for an error from one of the row functions, such as
+// NormOne or SubOne. Anything other than the errors for short lines, missing
+// keys, and missing, NaN or infinite values is a value that could not be
+// parsed.
+func ReasonFor(e error) RejectReason {
+	switch {
+	case errors.Is(e, ErrShortLine):
+		return ShortLine
+	case errors.Is(e, ErrMissingKey):
+		return MissingKey
+	case errors.Is(e, ErrMissingValue):
+		return MissingValue
+	case errors.Is(e, ErrNaN):
+		return NaNValue
+	case errors.Is(e, ErrInfinite):
+		return InfValue
+	}
+	return Unparseable
+}
+
+// The error from a rejected row in strict mode
+type RejectError struct {
+	Stage string
+	Line int
+	Reason RejectReason
+	Row []string
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("%v: line %v: %v: %q", e.Stage, e.Line, e.Reason, strings.Join(e.Row, "\t"))
+}
+
+// Counts of the rows read by one stage of a run
+type RowCounts struct {
+	Stage string
+	Rows int64
+	Rejected [numRejectReasons]int64
+}
+
+// The number of rows rejected for any reason
+func (c *RowCounts) TotalRejected() int64 {
+	var total int64
+	for _, n := range c.Rejected {
+		total += n
+	}
+	return total
+}
+
+// The number of rows used
+func (c *RowCounts) Kept() int64 {
+	return c.Rows - c.TotalRejected()
+}
+
+func (c *RowCounts) add(o *RowCounts) {
+	c.Rows += o.Rows
+	for i, n := range o.Rejected {
+		c.Rejected[i] += n
+	}
+}
+
+// Keeps track of the rows each stage of a run uses and rejects. With Strict
+// set, the first rejected row is an error. If Rejects is set, every rejected
+// row is written to it, after its stage, line number and reason. Safe for
+// use by several goroutines.
+type RowAccountant struct {
+	Strict bool
+	Rejects io.Writer
+
+	mu sync.Mutex
+	stages []*RowCounts
+	cw *csv.Writer
+}
+
+// The counts for each stage so far, in the order the stages finished
+func (a *RowAccountant) Counts() []RowCounts {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make([]RowCounts, 0, len(a.stages))
+	for _, c := range a.stages {
+		out = append(out, *c)
+	}
+	return out
+}
+
+// Forget all counts
+func (a *RowAccountant) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stages = nil
+}
+
+func (a *RowAccountant) finish(c *RowCounts) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, s := range a.stages {
+		if s.Stage == c.Stage {
+			s.add(c)
+			return
+		}
+	}
+	cp := *c
+	a.stages = append(a.stages, &cp)
+}
+
+func (a *RowAccountant) reject(stage string, reason RejectReason, lineno int, row []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.Rejects != nil {
+		if a.cw == nil {
+			a.cw = csv.NewWriter(a.Rejects)
+			a.cw.Comma = rune('\t')
+		}
+		rec := append([]string{stage, fmt.Sprint(lineno), reason.String()}, row...)
+		if e := a.cw.Write(rec); e != nil {
+			return fmt.Errorf("RowAccountant.reject: %w", e)
+		}
+	}
+
+	if a.Strict {
+		// The run stops here, so the rejected row must reach the file
+		if a.cw != nil {
+			a.cw.Flush()
+			if e := a.cw.Error(); e != nil {
+				return fmt.Errorf("RowAccountant.reject: %w", e)
+			}
+		}
+		return &RejectError{stage, lineno, reason, append([]string(nil), row...)}
+	}
+	return nil
+}
+
+// Write any buffered rejected rows to Rejects
+func (a *RowAccountant) Flush() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cw == nil {
+		return nil
+	}
+	a.cw.Flush()
+	return a.cw.Error()
+}
+
+// Write one line per stage saying how many rows it used and rejected, and why
+func (a *RowAccountant) WriteSummary(w io.Writer) error {
+	for _, c := range a.Counts() {
+		var reasons []string
+		for i, n := range c.Rejected {
+			if n > 0 {
+				reasons = append(reasons, fmt.Sprintf("%v %v", n, RejectReason(i)))
+			}
+		}
+
+		line := fmt.Sprintf("%v: %v rows, %v kept, %v rejected", c.Stage, c.Rows, c.Kept(), c.TotalRejected())
+		if len(reasons) > 0 {
+			line += " (" + strings.Join(reasons, ", ") + ")"
+		}
+		if _, e := fmt.Fprintln(w, line); e != nil {
+			return fmt.Errorf("RowAccountant.WriteSummary: %w", e)
+		}
+	}
+	return nil
+}
+
+// The rows read by one pass over a table. Not safe for concurrent use; each
+// goroutine should have its own stage. Call Done at the end of the pass.
+type RowStage struct {
+	a *RowAccountant
+	values *ValueParser
+	counts RowCounts
+
+	// For the stage of one chunk of a table, whose line numbers are not
+	// known until the chunks before it are read, rejected rows are held
+	// here instead of being reported
+	chunk bool
+	held []heldReject
+}
+
+// A row rejected in one chunk of a table, with its line number in the chunk
+type heldReject struct {
+	reason RejectReason
+	line int
+	row []string
+}
+
+// A fatal error from one chunk of a table, with its line number in the chunk
+type heldError struct {
+	line int
+	err error
+}
+
+func (e *heldError) Error() string {
+	return fmt.Sprintf("line %v of chunk: %v", e.line, e.err)
+}
+
+func (e *heldError) Unwrap() error {
+	return e.err
+}
+
+// Count a row that was used
+func (s *RowStage) Keep() {
+	s.counts.Rows++
+}
+
+// Count a row that was left out. Returns an error in strict mode, or if the
+// row cannot be written to the reject file.
+func (s *RowStage) Reject(reason RejectReason, lineno int, row []string) error {
+	s.counts.Rows++
+	s.counts.Rejected[reason]++
+	if s.chunk {
+		s.held = append(s.held, heldReject{reason, lineno, append([]string(nil), row...)})
+		return nil
+	}
+	return s.a.reject(s.counts.Stage, reason, lineno, row)
+}
+
+// Reject the row cr just read
+func (s *RowStage) RejectCsv(reason RejectReason, cr *csv.Reader, row []string) error {
+	return s.Reject(reason, csvLine(cr, row), row)
+}
+
+// Reject a row because of the error from a row function. Errors that stop
+// the run, such as infinite values under InfError, are returned instead.
+func (s *RowStage) RejectErr(e error, lineno int, row []string) error {
+	if isFatalRowError(e) {
+		if s.chunk {
+			return &heldError{lineno, e}
+		}
+		return fmt.Errorf("%v: line %v: %w", s.counts.Stage, lineno, e)
+	}
+	return s.Reject(ReasonFor(e), lineno, row)
+}
+
+// RejectErr for the row cr just read
+func (s *RowStage) RejectCsvErr(e error, cr *csv.Reader, row []string) error {
+	return s.RejectErr(e, csvLine(cr, row), row)
+}
+
+func csvLine(cr *csv.Reader, row []string) int {
+	if len(row) == 0 {
+		return 0
+	}
+	lineno, _ := cr.FieldPos(0)
+	return lineno
+}
+
+// Parse the value in column col of the row cr just read with the stage's
+// ValueParser, for a pass that summarizes values. Rows that are too short, or
+// whose value is missing, unparseable, NaN or skipped infinite, are rejected,
+// and ok is false.
+func (s *RowStage) CsvFloat(cr *csv.Reader, row []string, col int) (val float64, ok bool, err error) {
+	if len(row) <= col {
+		return 0, false, s.RejectCsv(ShortLine, cr, row)
+	}
+	val, e := s.values.Parse(row[col])
+	if e != nil {
+		return 0, false, s.RejectCsvErr(e, cr, row)
+	}
+	return val, true, nil
+}
+
+// A stage for one chunk of the rows of s
+func (s *RowStage) chunkStage() *RowStage {
+	return &RowStage{a: s.a, values: s.values, counts: RowCounts{Stage: s.counts.Stage}, chunk: true}
+}
+
+// Add the counts of c, a stage from chunkStage, to s, and report the rows
+// it rejected, now that the chunk is known to start after line offset
+func (s *RowStage) addChunk(c *RowStage, offset int) error {
+	s.counts.add(&c.counts)
+	for _, r := range c.held {
+		if e := s.a.reject(s.counts.Stage, r.reason, offset + r.line, r.row); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Add this stage's counts to its accountant
+func (s *RowStage) Done() {
+	s.a.finish(&s.counts)
+}
+
+// Skip the header of a table. An empty table is not an error.
+func skipHeader(cr *csv.Reader) error {
+	_, e := cr.Read()
+	if e != nil && e != io.EOF {
+		return e
+	}
+	return nil
+}
+
+// How a run reads the rows of its tables: the accountant that counts and
+// rejects them, how their values are parsed, how passes over them are split
+// among goroutines, and how paired t tests join them
+type RowOptions struct {
+	Rows *RowAccountant
+	Values *ValueParser
+	Chunks ChunkOptions
+	Pairing PairOptions
+}
+
+// Options that count rows without writing rejects, parse values with
+// DefaultValueParser, and read serially
+func DefaultRowOptions() *RowOptions {
+	return &RowOptions{
+		Rows: &RowAccountant{},
+		Values: DefaultValueParser(),
+		Chunks: DefaultChunkOptions(),
+		Pairing: DefaultPairOptions(),
+	}
+}
+
+// Start counting rows for one pass over a table. Passes with the same stage
+// name are added together.
+func (o *RowOptions) Stage(name string) *RowStage {
+	return &RowStage{a: o.Rows, values: o.Values, counts: RowCounts{Stage: name}}
+}
+
+// Command line settings for a run's RowOptions
+type RowFlags struct {
+	Strict bool
+	RejectPath string
+	Missing string
+	Inf string
+	MissingOut string
+	Schema string
+	Threads int
+	opts *RowOptions
+	rejects io.WriteCloser
+	finished bool
+}
+
+// Register -strict, -rejects, -na, -inf, -na-out, -schema and -threads on
+// the command line
+func AddRowFlags() *RowFlags {
+	f := new(RowFlags)
+	flag.BoolVar(&f.Strict, "strict", false, "fail on the first row that cannot be used")
+	flag.StringVar(&f.RejectPath, "rejects", "", "write rows that cannot be used, with their line numbers, to this path")
+	flag.StringVar(&f.Missing, "na", strings.Join(DefaultMissingTokens, ","), "comma-separated tokens that mean a value is missing")
+	flag.StringVar(&f.Inf, "inf", InfSkip.String(), "what to do with infinite values: skip, error or keep")
+	flag.StringVar(&f.MissingOut, "na-out", DefaultMissingOut, "token to write for missing output values")
+	flag.StringVar(&f.Schema, "schema", "", "check the input against this schema file before running")
+	flag.IntVar(&f.Threads, "threads", 1, "goroutines parsing rows of text input, or 0 for one per core")
+	return f
+}
+
+// Make the options for a run from the command line, opening the reject file
+// if there is one
+func (f *RowFlags) Start() (*RowOptions, error) {
+	h := handle("RowFlags.Start: %w")
+
+	ro := DefaultRowOptions()
+	inf, e := ParseInfPolicy(f.Inf)
+	if e != nil { return nil, h(e) }
+	ro.Values.Inf = inf
+	ro.Values.SetMissing(f.Missing)
+	ro.Values.MissingOut = f.MissingOut
+	ro.Chunks.Threads = f.Threads
+
+	ro.Rows.Strict = f.Strict
+	if f.RejectPath != "" {
+		w, e := OutputWriteCloserMaker(f.RejectPath).NewWriteCloser()
+		if e != nil { return nil, h(e) }
+		f.rejects = w
+		ro.Rows.Rejects = w
+	}
+	f.opts = ro
+	return ro, nil
+}
+
+// Check the input against the schema given to -schema, if any. Violations
+// are written to stderr, and make this return an error.
+func (f *RowFlags) Validate(rcm ReadCloserMaker) error {
+	if f.Schema == "" {
+		return nil
+	}
+	values := DefaultValueParser()
+	if f.opts != nil {
+		values = f.opts.Values
+	}
+	if e := ValidateSchemaPath(rcm, f.Schema, values); e != nil {
+		return fmt.Errorf("RowFlags.Validate: %w", e)
+	}
+	return nil
+}
+
+// Close the reject file and print the summary of rows used on stderr. Only
+// the first call does anything.
+func (f *RowFlags) Finish() error {
+	h := handle("RowFlags.Finish: %w")
+
+	if f.finished {
+		return nil
+	}
+	f.finished = true
+	if f.opts == nil {
+		return nil
+	}
+	if e := f.opts.Rows.Flush(); e != nil { return h(e) }
+	if f.rejects != nil {
+		if e := f.rejects.Close(); e != nil { return h(e) }
+	}
+	if e := f.opts.Rows.WriteSummary(os.Stderr); e != nil { return h(e) }
+	return nil
+}
+
+// Deferred by a command's main right after flag.Parse. Calls Finish however
+// main ends, so the reject file and the summary are written even when the
+// run fails. If main panicked with an error, the error is printed on stderr
+// and the command exits with status 1 instead of printing a stack trace.
+// Runtime errors, which are bugs, still panic.
+func (f *RowFlags) Exit() {
+	r := recover()
+	finishErr := f.Finish()
+
+	if r == nil {
+		if finishErr != nil {
+			fmt.Fprintln(os.Stderr, finishErr)
+			os.Exit(1)
+		}
+		return
+	}
+
+	e, ok := r.(error)
+	if _, bug := r.(runtime.Error); !ok || bug {
+		panic(r)
+	}
+	fmt.Fprintln(os.Stderr, e)
+	if finishErr != nil {
+		fmt.Fprintln(os.Stderr, finishErr)
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,84 @@
+package spstat
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Run as a command by TestRowFlagsExit: a pass over chunkTestTable with the
+// row flags from the environment, ending like a command's main
+func rowFlagsExitMain() {
+	threads, _ := strconv.Atoi(os.Getenv("SPSTAT_EXIT_THREADS"))
+	f := &RowFlags{
+		Strict: os.Getenv("SPSTAT_EXIT_STRICT") != "",
+		RejectPath: os.Getenv("SPSTAT_EXIT_REJECTS"),
+		Missing: "NA",
+		Inf: "skip",
+		MissingOut: "NA",
+		Threads: threads,
+	}
+	defer f.Exit()
+
+	ro, e := f.Start()
+	if e != nil { panic(e) }
+	if e := RunQuantiles(chunkTestTable(), io.Discard, "value", []string{"tissue"}, []float64{0.5}, 0.01, ro); e != nil { panic(e) }
+}
+
+func TestRowFlagsExit(t *testing.T) {
+	if os.Getenv("SPSTAT_EXIT_REJECTS") != "" {
+		rowFlagsExitMain()
+		return
+	}
+
+	cases := []struct {
+		strict bool
+		threads int
+		status int
+		rejects int
+	}{
+		{false, 1, 0, 33},
+		{true, 1, 1, 1},
+		{true, 4, 1, 1},
+	}
+	for _, c := range cases {
+		rejects := filepath.Join(t.TempDir(), "rejects.tsv")
+		cmd := exec.Command(os.Args[0], "-test.run=^TestRowFlagsExit$")
+		cmd.Env = append(os.Environ(), "SPSTAT_EXIT_REJECTS=" + rejects, "SPSTAT_EXIT_THREADS=" + strconv.Itoa(c.threads))
+		if c.strict {
+			cmd.Env = append(cmd.Env, "SPSTAT_EXIT_STRICT=1")
+		}
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		e := cmd.Run()
+
+		status := 0
+		var exit *exec.ExitError
+		if errors.As(e, &exit) {
+			status = exit.ExitCode()
+		} else if e != nil {
+			t.Fatal(e)
+		}
+		if status != c.status || strings.Contains(stderr.String(), "goroutine ") {
+			t.Errorf("strict %v, threads %v: status %v, stderr:\n%v", c.strict, c.threads, status, stderr.String())
+		}
+		if !strings.Contains(stderr.String(), "CalcQuantiles: ") || !strings.Contains(stderr.String(), "rejected (") {
+			t.Errorf("strict %v, threads %v: no summary on stderr:\n%v", c.strict, c.threads, stderr.String())
+		}
+		if c.strict && !strings.Contains(stderr.String(), "line 2: unparseable value") {
+			t.Errorf("strict %v, threads %v: error not printed:\n%v", c.strict, c.threads, stderr.String())
+		}
+
+		data, e := os.ReadFile(rejects)
+		if e != nil { t.Fatal(e) }
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		if len(lines) != c.rejects || !strings.Contains(lines[0], "\t2\tunparseable value\tsperm\tg0\toops") {
+			t.Errorf("strict %v, threads %v: %v rejects, first %q", c.strict, c.threads, len(lines), lines[0])
+		}
+	}
+}
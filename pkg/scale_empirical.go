@@ -5,10 +5,8 @@ import (
 	"os"
 	"encoding/csv"
 	"flag"
-	"bufio"
 	"io"
 	"fmt"
-	"strconv"
 )
 
 // Predict a y value based on an x value and coefficients for the model y ~ x
@@ -17,7 +15,7 @@ func Predict(x, m, b float64) float64 {
 }
 
 // Predict y for based on an x column for the linear model y ~ x
-func LinearModelPredict(rcm ReadCloserMaker, w io.Writer, indepcol int, m, b float64) (err error) {
+func LinearModelPredict(rcm ReadCloserMaker, w io.Writer, indepcol int, m, b float64, ro *RowOptions) (err error) {
 	h := handle("LinearModelResiduals: %w")
 
 	r, e := rcm.NewReadCloser()
@@ -35,19 +33,27 @@ func LinearModelPredict(rcm ReadCloserMaker, w io.Writer, indepcol int, m, b flo
 	e = cw.Write(line)
 	if e != nil { return h(e) }
 
+	rows := ro.Stage("LinearModelPredict")
+	defer rows.Done()
+
 	for line, e := cr.Read(); e != io.EOF; line, e = cr.Read() {
 		if e != nil { return h(e) }
 
-		if len(line) <= indepcol { continue }
-		indep, e := strconv.ParseFloat(line[indepcol], 64)
-		if e != nil { continue }
+		indep, e := parseCol(line, indepcol, rows.values)
+		if e != nil {
+			if e := rows.RejectCsvErr(e, cr, line); e != nil { return h(e) }
+			continue
+		}
+		rows.Keep()
 
 		pred := Predict(indep, m, b)
 		line = append(line, fmt.Sprint(pred))
 		e = cw.Write(line)
-		if e != nil { continue }
+		if e != nil { return h(e) }
 	}
 
+	cw.Flush()
+	if e := cw.Error(); e != nil { return h(e) }
 	return nil
 }
 
@@ -62,19 +68,17 @@ func WriteModelPath(path string, m, b float64) (err error) {
 }
 
 // Rescale a set of data by generating a linear model val ~ indep, then writing the predictions of that linear model
-func RescaleData(rcm ReadCloserMaker, w io.Writer, modelOutPath string, valcolname, indepcolname string) error {
+func RescaleData(rcm ReadCloserMaker, w io.Writer, modelOutPath string, valcolname, indepcolname string, ro *RowOptions) error {
 	h := handle("RescaleData: %w")
 
-	valcol, e := ValCol(rcm, valcolname)
+	cols, e := NamedCols(rcm, []string{valcolname, indepcolname})
 	if e != nil { return h(e) }
+	valcol, indepcol := cols[0], cols[1]
 
-	indepcol, e := ValCol(rcm, indepcolname)
+	m, b, e := LinearModel(rcm, valcol, indepcol, ro)
 	if e != nil { return h(e) }
 
-	m, b, e := LinearModel(rcm, valcol, indepcol)
-	if e != nil { return h(e) }
-
-	e = LinearModelPredict(rcm, w, indepcol, m, b)
+	e = LinearModelPredict(rcm, w, indepcol, m, b, ro)
 	if e != nil { return h(e) }
 
 	if modelOutPath != "" {
@@ -86,13 +90,13 @@ func RescaleData(rcm ReadCloserMaker, w io.Writer, modelOutPath string, valcolna
 }
 
 // Like RescaleData, but for numbered columns
-func RescaleDataResultFile(rcm ReadCloserMaker, w io.Writer, modelOutPath string, valcol, indepcol int) error {
+func RescaleDataResultFile(rcm ReadCloserMaker, w io.Writer, modelOutPath string, valcol, indepcol int, ro *RowOptions) error {
 	h := handle("RescaleDataResultFile: %w")
 
-	m, b, e := LinearModel(rcm, valcol, indepcol)
+	m, b, e := LinearModel(rcm, valcol, indepcol, ro)
 	if e != nil { return h(e) }
 
-	e = LinearModelPredict(rcm, w, indepcol, m, b)
+	e = LinearModelPredict(rcm, w, indepcol, m, b, ro)
 	if e != nil { return h(e) }
 
 	if modelOutPath != "" {
@@ -109,6 +113,7 @@ type scaleEmpiricalFlags struct {
 	Path string
 	ResultFile bool
 	ModelOutPath string
+	OutPath string
 }
 
 // Scale data to match empirical results
@@ -116,27 +121,41 @@ func RunScaleEmpirical() {
 	var f scaleEmpiricalFlags
 	flag.StringVar(&f.Valcolname, "v", "", "Name of column with empirical, known values, i.e., 100% x representation for females")
 	flag.StringVar(&f.Indepcolname, "i", "", "Name of column with estimated values")
-	flag.StringVar(&f.Path, "p", "", "Input path")
+	flag.StringVar(&f.Path, "p", "", "Input path, or - for stdin")
 	flag.BoolVar(&f.ResultFile, "r", false, "Interpret input file as results, not data")
 	flag.StringVar(&f.ModelOutPath, "mo", "", "path to output model parameters")
+	flag.StringVar(&f.OutPath, "o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
+	rowflags := AddRowFlags()
 	flag.Parse()
+	defer rowflags.Exit()
 
 	h := handle("RunLinearModel: %w")
 
-	stdout := bufio.NewWriter(os.Stdout)
+	stdout, e := OutputWriteCloserMaker(f.OutPath).NewWriteCloser()
+	if e != nil {
+		panic(h(e))
+	}
+	ro, e := rowflags.Start()
+	if e != nil {
+		panic(h(e))
+	}
+	rcm := InputReadCloserMaker(f.Path)
+	if e := rowflags.Validate(rcm); e != nil {
+		panic(h(e))
+	}
 	defer func() {
-		if e := stdout.Flush(); e != nil {
+		if e := stdout.Close(); e != nil {
 			panic(h(e))
 		}
 	}()
 
 	if !f.ResultFile {
-		e := RescaleData(MaybeGzPath(f.Path), stdout, f.ModelOutPath, f.Valcolname, f.Indepcolname)
+		e := RescaleData(rcm, stdout, f.ModelOutPath, f.Valcolname, f.Indepcolname, ro)
 		if e != nil {
 			panic(h(e))
 		}
 	} else {
-		e := RescaleDataResultFile(MaybeGzPath(f.Path), stdout, f.ModelOutPath, 19, 12)
+		e := RescaleDataResultFile(rcm, stdout, f.ModelOutPath, 19, 12, ro)
 		if e != nil {
 			panic(h(e))
 		}
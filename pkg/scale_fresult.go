@@ -216,8 +216,8 @@ func ScaleSdDiff(ftest FTestResult, slope float64) float64 {
 	return diff * scaleFactor
 }
 
-func ScaleMeanDiff(ftest FTestResult, slope float64) float64 {
-	diff := ftest.Mean2 - ftest.Mean1
+func ScaleMeanDiff(ttest TTestResult, slope float64) float64 {
+	diff := ttest.Mean2 - ttest.Mean1
 	return diff * slope
 }
 
@@ -331,6 +331,34 @@ func WriteScaled2(w io.Writer, ftests []FTestResult, scaled []ScaledFTest) error
 	return nil
 }
 
+// Like WriteScaled2, for t tests
+func WriteScaledT2(w io.Writer, ttests []TTestResult, scaled []ScaledFTest) error {
+	h := handle("WriteScaledT2: %w")
+	if len(ttests) != len(scaled) {
+		return h(fmt.Errorf("len(ttests) %v != len(scaled) %v", len(ttests), len(scaled)))
+	}
+	for i, s := range scaled {
+		r := ttests[i]
+		if r.Name1 != s.Name1 {
+			return h(fmt.Errorf("r.Name1 %v != s.Name1 %v", r.Name1, s.Name1))
+		}
+		if r.Name2 != s.Name2 {
+			return h(fmt.Errorf("r.Name2 %v != s.Name2 %v", r.Name2, s.Name2))
+		}
+		_, e := fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			r.Name1, r.Name2,
+			r.Count1, r.Count2,
+			r.Mean1, r.Mean2,
+			r.Sd1, r.Sd2,
+			r.T, r.Df, r.P,
+			r.Diff, r.Low, r.High,
+			s.ScaledSdDiff,
+		)
+		if e != nil { return h(e) }
+	}
+	return nil
+}
+
 func GetName2(winsize int) string {
 	name2 := "indiv_chrom_tissue"
 	if winsize != -1 {
@@ -378,6 +406,19 @@ func PrintHeadOf2(w io.Writer, ttest bool, winsize int) error {
 	stat := GetStat(ttest)
 	diff := GetDiff(ttest)
 
+	if ttest {
+		_, e := fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v",
+			"control_tissue", name2,
+			"count1", "count2",
+			"mean1", "mean2",
+			"sd1", "sd2",
+			stat, "df", "p",
+			"mean_diff", "conf_low", "conf_high",
+			diff,
+		)
+		return e
+	}
+
 	_, e := fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v",
 		"control_tissue", name2,
 		"count1", "count2",
@@ -399,10 +440,19 @@ func RunScaleFTests() {
 	winsizep := flag.Int("w", -1, "window size if using chrpos")
 	ttestp := flag.Bool("t", false, "Do per-chromosome t-test instead of f-test")
 	ofp := flag.String("of", "", "output format (currently supporting 1 or 2, default 1)")
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
 	flag.Parse()
 
+	stdout, e := OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+	defer func() {
+		if e := stdout.Close(); e != nil {
+			panic(e)
+		}
+	}()
+
 	if *pheadp {
-		PrintHead(os.Stdout, *ttestp, *ofp, *winsizep)
+		PrintHead(stdout, *ttestp, *ofp, *winsizep)
 		return
 	}
 
@@ -413,29 +463,43 @@ func RunScaleFTests() {
 		panic(fmt.Errorf("missing -m"))
 	}
 
-	ftests, e := ReadFTestResults(os.Stdin)
-	if e != nil { panic(e) }
-
-	models, e := ReadModelPath(MaybeGzPath(*modelpp))
+	models, e := ReadModelPath(AutoDecompressPath(*modelpp))
 	if e != nil { panic(e) }
 
-	var scaled []ScaledFTest
-
 	if *ttestp {
 		if len(models) != 1 {
 			panic(fmt.Errorf("len(models) %v != 1)", len(models)))
 		}
-		scaled, e = ScaleTTestsPerChrom(ftests, models[0].Coeffs[1])
+
+		ttests, e := ReadTTestResults(os.Stdin)
 		if e != nil { panic(e) }
-	} else if *winsizep == -1 {
-		probeset, e := ReadProbeChrPos(MaybeGzPath(*probepp))
+
+		scaled, e := ScaleTTestsPerChrom(ttests, models[0].Coeffs[1])
+		if e != nil { panic(e) }
+
+		if *ofp == "2" {
+			e = WriteScaledT2(stdout, ttests, scaled)
+		} else {
+			e = WriteScaled(stdout, scaled)
+		}
+		if e != nil { panic(e) }
+		return
+	}
+
+	ftests, e := ReadFTestResults(os.Stdin)
+	if e != nil { panic(e) }
+
+	var scaled []ScaledFTest
+
+	if *winsizep == -1 {
+		probeset, e := ReadProbeChrPos(AutoDecompressPath(*probepp))
 		if e != nil { panic(e) }
 
 		chrtoprobe := MapChrToProbes(probeset)
 		scaled, e = ScaleFTestsPerChrom(ftests, chrtoprobe, MapProbeToCoeffs(models))
 		if e != nil { panic(e) }
 	} else {
-		probeset, e := ReadProbeChrPos(MaybeGzPath(*probepp))
+		probeset, e := ReadProbeChrPos(AutoDecompressPath(*probepp))
 		if e != nil { panic(e) }
 
 		chrpostoprobe := MapChrPosWinToProbe(probeset, *winsizep)
@@ -443,9 +507,6 @@ func RunScaleFTests() {
 		if e != nil { panic(e) }
 	}
 
-	stdout := bufio.NewWriter(os.Stdout)
-	defer stdout.Flush()
-
 	if *ofp == "2" {
 		e = WriteScaled2(stdout, ftests, scaled)
 	} else {
@@ -454,20 +515,20 @@ func RunScaleFTests() {
 	if e != nil { panic(e) }
 }
 
-func ScaleTTestPerChrom(ftest FTestResult, slope float64) (ScaledFTest, error) {
+func ScaleTTestPerChrom(ttest TTestResult, slope float64) (ScaledFTest, error) {
 	return ScaledFTest {
-		ftest.Name1,
-		ftest.Name2,
-		ScaleMeanDiff(ftest, slope),
+		ttest.Name1,
+		ttest.Name2,
+		ScaleMeanDiff(ttest, slope),
 	}, nil
 }
 
-func ScaleTTestsPerChrom(ftests []FTestResult, slope float64) ([]ScaledFTest, error) {
-	h := handle("ScaleFTestsPerChrom: %w")
+func ScaleTTestsPerChrom(ttests []TTestResult, slope float64) ([]ScaledFTest, error) {
+	h := handle("ScaleTTestsPerChrom: %w")
 	scaled := []ScaledFTest{}
 
-	for _, ftest := range ftests {
-		scaledone, e := ScaleTTestPerChrom(ftest, slope)
+	for _, ttest := range ttests {
+		scaledone, e := ScaleTTestPerChrom(ttest, slope)
 		if e != nil { return nil, h(e) }
 		scaled = append(scaled, scaledone)
 	}
@@ -0,0 +1,550 @@
+package spstat
+
+import (
+	"github.com/jgbaldwinbrown/csvh"
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The kind of values a column holds
+type SchemaType int
+
+const (
+	SchemaText SchemaType = iota
+	SchemaCategorical
+	SchemaInt
+	SchemaFloat
+)
+
+var schemaTypeNames = []string{"text", "categorical", "int", "float"}
+
+func (t SchemaType) String() string {
+	if t < 0 || int(t) >= len(schemaTypeNames) {
+		return fmt.Sprintf("SchemaType(%d)", int(t))
+	}
+	return schemaTypeNames[t]
+}
+
+// Columns with at most this many distinct values are categorical, unless
+// set otherwise
+const DefaultMaxCategories = 100
+
+// What one scan found in one column
+type ColumnProfile struct {
+	Name string
+	// Rows that have this column
+	Count int64
+	// Rows too short to have this column
+	Absent int64
+	Missing int64
+	// Values, not counting missing ones, that parse as integers and as floats
+	Ints int64
+	Floats int64
+	Min float64
+	Max float64
+	// Counts of each value, or nil if there were more than the category limit
+	Distinct map[string]int64
+	maxcat int
+	values *ValueParser
+}
+
+func newColumnProfile(name string, maxcat int, values *ValueParser) *ColumnProfile {
+	return &ColumnProfile{
+		Name: name,
+		Min: math.Inf(1),
+		Max: math.Inf(-1),
+		Distinct: map[string]int64{},
+		maxcat: maxcat,
+		values: values,
+	}
+}
+
+func (p *ColumnProfile) add(field string) {
+	p.Count++
+	if p.values.isMissing(field) {
+		p.Missing++
+		return
+	}
+
+	if p.Distinct != nil {
+		p.Distinct[field]++
+		if len(p.Distinct) > p.maxcat {
+			p.Distinct = nil
+		}
+	}
+
+	if _, e := strconv.ParseInt(field, 10, 64); e == nil {
+		p.Ints++
+	}
+	if v, e := strconv.ParseFloat(field, 64); e == nil {
+		p.Floats++
+		if v < p.Min { p.Min = v }
+		if v > p.Max { p.Max = v }
+	}
+}
+
+// The number of values that are not missing
+func (p *ColumnProfile) Present() int64 {
+	return p.Count - p.Missing
+}
+
+// The inferred type: int or float if every value that is not missing parses
+// as one, categorical if there are few distinct values, and otherwise text.
+func (p *ColumnProfile) Type() SchemaType {
+	present := p.Present()
+	switch {
+	case present > 0 && p.Ints == present: return SchemaInt
+	case present > 0 && p.Floats == present: return SchemaFloat
+	case p.Distinct != nil: return SchemaCategorical
+	}
+	return SchemaText
+}
+
+// The fraction of rows with a missing value or no field at all
+func (p *ColumnProfile) MissingRate() float64 {
+	return float64(p.Missing + p.Absent) / float64(p.Count + p.Absent)
+}
+
+// What one scan found in a whole table
+type TableProfile struct {
+	Columns []*ColumnProfile
+	Rows int64
+	// Rows with fewer or more fields than the header
+	ShortRows int64
+	LongRows int64
+}
+
+// Scan the first sample rows of a table, or all of it if sample is 0, and
+// profile each of its columns. Values missing by values are counted apart.
+func ProfileTable(rcm ReadCloserMaker, sample int64, maxcat int, values *ValueParser) (*TableProfile, error) {
+	h := handle("ProfileTable: %w")
+
+	r, e := rcm.NewReadCloser()
+	if e != nil { return nil, h(e) }
+	defer r.Close()
+	cr := csvh.CsvIn(r)
+
+	header, e := cr.Read()
+	if e != nil { return nil, h(e) }
+
+	t := &TableProfile{}
+	for _, name := range header {
+		t.Columns = append(t.Columns, newColumnProfile(name, maxcat, values))
+	}
+
+	for line, e := cr.Read(); e != io.EOF; line, e = cr.Read() {
+		if e != nil { return nil, h(e) }
+		if sample > 0 && t.Rows >= sample { break }
+
+		t.Rows++
+		if len(line) < len(t.Columns) { t.ShortRows++ }
+		if len(line) > len(t.Columns) { t.LongRows++ }
+
+		for i, col := range t.Columns {
+			if len(line) <= i {
+				col.Absent++
+				continue
+			}
+			col.add(line[i])
+		}
+	}
+	return t, nil
+}
+
+// Write one line per column with its type, number of distinct values (NA
+// when there are too many to count), missing values, missing rate, rows too
+// short to have it, and numeric minimum and maximum.
+func (t *TableProfile) Write(w io.Writer) error {
+	h := handle("TableProfile.Write: %w")
+	bw := bufio.NewWriter(w)
+
+	_, e := fmt.Fprintln(bw, "column\ttype\tdistinct\tmissing\tmissing_rate\tabsent\tmin\tmax")
+	if e != nil { return h(e) }
+
+	for _, c := range t.Columns {
+		distinct := "NA"
+		if c.Distinct != nil {
+			distinct = fmt.Sprint(len(c.Distinct))
+		}
+		min, max := "NA", "NA"
+		if typ := c.Type(); typ == SchemaInt || typ == SchemaFloat {
+			min = strconv.FormatFloat(c.Min, 'g', -1, 64)
+			max = strconv.FormatFloat(c.Max, 'g', -1, 64)
+		}
+
+		_, e = fmt.Fprintf(bw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			c.Name, c.Type(), distinct, c.Missing, c.MissingRate(), c.Absent, min, max)
+		if e != nil { return h(e) }
+	}
+
+	if e := bw.Flush(); e != nil { return h(e) }
+	return nil
+}
+
+// Write the number of rows scanned and how many were ragged
+func (t *TableProfile) WriteRagged(w io.Writer) error {
+	_, e := fmt.Fprintf(w, "%v rows, %v short, %v long\n", t.Rows, t.ShortRows, t.LongRows)
+	return e
+}
+
+// A declared column. Type is SchemaText for columns that can hold anything.
+type ColumnRule struct {
+	Name string
+	Type SchemaType
+	HasRange bool
+	Min float64
+	Max float64
+	MinOpen bool
+	MaxOpen bool
+	OneOf []string
+	Required bool
+	oneOf map[string]bool
+}
+
+// A declared schema: one rule for each column it covers
+type Schema struct {
+	Rules []*ColumnRule
+}
+
+// Parse a schema file. Each line declares one column, like
+//
+//	value: float in [0,1]
+//	pos: int in [1,inf) required
+//	tissue: one of blood,sperm
+//
+// The type is int, float, text or categorical, and may be left out. A range
+// uses brackets for inclusive bounds and parentheses for exclusive ones; an
+// empty or infinite bound is open-ended. "required" means the value may not
+// be missing. Blank lines and lines starting with '#' are ignored.
+func ParseSchema(r io.Reader) (*Schema, error) {
+	h := handle("ParseSchema: %w")
+
+	s := &Schema{}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") { continue }
+
+		rule, e := parseColumnRule(line)
+		if e != nil { return nil, h(fmt.Errorf("line %v: %w", n, e)) }
+		s.Rules = append(s.Rules, rule)
+	}
+	if e := sc.Err(); e != nil { return nil, h(e) }
+	return s, nil
+}
+
+// Read a schema file from path
+func ReadSchemaPath(path string) (*Schema, error) {
+	f, e := os.Open(path)
+	if e != nil { return nil, fmt.Errorf("ReadSchemaPath: %w", e) }
+	defer f.Close()
+	return ParseSchema(f)
+}
+
+func parseColumnRule(line string) (*ColumnRule, error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok {
+		return nil, fmt.Errorf("missing ':' in %q", line)
+	}
+	rule := &ColumnRule{Name: strings.TrimSpace(name)}
+	rest = strings.TrimSpace(rest)
+
+	if rest == "required" || strings.HasSuffix(rest, " required") {
+		rule.Required = true
+		rest = strings.TrimSpace(strings.TrimSuffix(rest, "required"))
+	}
+
+	word, after, _ := strings.Cut(rest, " ")
+	for i, tname := range schemaTypeNames {
+		if word == tname {
+			rule.Type = SchemaType(i)
+			rest = strings.TrimSpace(after)
+			break
+		}
+	}
+
+	switch {
+	case rest == "":
+	case strings.HasPrefix(rest, "in "):
+		if e := rule.parseRange(strings.TrimSpace(strings.TrimPrefix(rest, "in "))); e != nil {
+			return nil, e
+		}
+		if rule.Type != SchemaInt {
+			rule.Type = SchemaFloat
+		}
+	case strings.HasPrefix(rest, "one of "):
+		rule.oneOf = map[string]bool{}
+		for _, v := range strings.Split(strings.TrimPrefix(rest, "one of "), ",") {
+			v = strings.TrimSpace(v)
+			rule.OneOf = append(rule.OneOf, v)
+			rule.oneOf[v] = true
+		}
+		if rule.Type == SchemaText {
+			rule.Type = SchemaCategorical
+		}
+	default:
+		return nil, fmt.Errorf("cannot parse %q", rest)
+	}
+	return rule, nil
+}
+
+func parseBound(s string, inf float64) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return inf, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func (c *ColumnRule) parseRange(s string) error {
+	if len(s) < 3 || !strings.ContainsAny(s[:1], "[(") || !strings.ContainsAny(s[len(s)-1:], "])") {
+		return fmt.Errorf("bad range %q", s)
+	}
+	lo, hi, ok := strings.Cut(s[1:len(s)-1], ",")
+	if !ok {
+		return fmt.Errorf("bad range %q", s)
+	}
+
+	var e error
+	if c.Min, e = parseBound(lo, math.Inf(-1)); e != nil { return e }
+	if c.Max, e = parseBound(hi, math.Inf(1)); e != nil { return e }
+	c.MinOpen = s[0] == '('
+	c.MaxOpen = s[len(s)-1] == ')'
+	c.HasRange = true
+	return nil
+}
+
+// Describe why field breaks the rule, or return "" if it does not. Fields
+// missing by values are only checked against Required.
+func (c *ColumnRule) Check(field string, values *ValueParser) string {
+	if values.isMissing(field) {
+		if c.Required {
+			return "missing"
+		}
+		return ""
+	}
+
+	var v float64
+	switch c.Type {
+	case SchemaInt:
+		i, e := strconv.ParseInt(field, 10, 64)
+		if e != nil { return "not an int" }
+		v = float64(i)
+	case SchemaFloat:
+		f, e := strconv.ParseFloat(field, 64)
+		if e != nil { return "not a number" }
+		v = f
+	}
+
+	if c.HasRange {
+		if v < c.Min || v > c.Max || (c.MinOpen && v == c.Min) || (c.MaxOpen && v == c.Max) || math.IsNaN(v) {
+			return "out of range"
+		}
+	}
+	if c.oneOf != nil && !c.oneOf[field] {
+		return "not one of " + strings.Join(c.OneOf, ",")
+	}
+	return ""
+}
+
+// Examples of rule violations kept per column
+const violationExamples = 5
+
+// The rows in one column that broke its rule
+type SchemaViolation struct {
+	Column string
+	Count int64
+	Examples []string
+}
+
+func (v *SchemaViolation) add(example string) {
+	v.Count++
+	if len(v.Examples) < violationExamples {
+		v.Examples = append(v.Examples, example)
+	}
+}
+
+// The result of checking a table against a schema
+type ValidationReport struct {
+	Rows int64
+	Violations []*SchemaViolation
+}
+
+// Check whether the table followed the schema
+func (v *ValidationReport) OK() bool {
+	return len(v.Violations) == 0
+}
+
+// Write each violation, with its count and some examples
+func (v *ValidationReport) Write(w io.Writer) error {
+	h := handle("ValidationReport.Write: %w")
+
+	if v.OK() {
+		_, e := fmt.Fprintf(w, "ok: %v rows follow the schema\n", v.Rows)
+		if e != nil { return h(e) }
+		return nil
+	}
+	for _, viol := range v.Violations {
+		_, e := fmt.Fprintf(w, "%v: %v rows break the schema; %v\n", viol.Column, viol.Count, strings.Join(viol.Examples, "; "))
+		if e != nil { return h(e) }
+	}
+	return nil
+}
+
+// The error for a table that does not follow its schema
+func (v *ValidationReport) Err() error {
+	if v.OK() {
+		return nil
+	}
+	var cols []string
+	for _, viol := range v.Violations {
+		cols = append(cols, viol.Column)
+	}
+	return fmt.Errorf("table does not follow the schema in columns %v", strings.Join(cols, ", "))
+}
+
+// Check the first sample rows of a table, or all of it if sample is 0,
+// against s, with missing values found by values.
+func (s *Schema) Validate(rcm ReadCloserMaker, sample int64, values *ValueParser) (*ValidationReport, error) {
+	h := handle("Schema.Validate: %w")
+
+	r, e := rcm.NewReadCloser()
+	if e != nil { return nil, h(e) }
+	defer r.Close()
+	cr := csvh.CsvIn(r)
+
+	header, e := cr.Read()
+	if e != nil { return nil, h(e) }
+
+	viols := map[string]*SchemaViolation{}
+	violation := func(col string) *SchemaViolation {
+		if viols[col] == nil {
+			viols[col] = &SchemaViolation{Column: col}
+		}
+		return viols[col]
+	}
+
+	var rules []*ColumnRule
+	var cols []int
+	for _, rule := range s.Rules {
+		found := false
+		for i, name := range header {
+			if name == rule.Name {
+				rules = append(rules, rule)
+				cols = append(cols, i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			violation(rule.Name).add("column is not in the table")
+		}
+	}
+
+	report := &ValidationReport{}
+	for line, e := cr.Read(); e != io.EOF; line, e = cr.Read() {
+		if e != nil { return nil, h(e) }
+		if sample > 0 && report.Rows >= sample { break }
+		report.Rows++
+
+		lineno, _ := cr.FieldPos(0)
+		for i, rule := range rules {
+			if len(line) <= cols[i] {
+				violation(rule.Name).add(fmt.Sprintf("line %v: absent", lineno))
+				continue
+			}
+			if why := rule.Check(line[cols[i]], values); why != "" {
+				violation(rule.Name).add(fmt.Sprintf("line %v: %q %v", lineno, line[cols[i]], why))
+			}
+		}
+	}
+
+	for _, viol := range viols {
+		report.Violations = append(report.Violations, viol)
+	}
+	sort.Slice(report.Violations, func(i, j int) bool {
+		return report.Violations[i].Column < report.Violations[j].Column
+	})
+	return report, nil
+}
+
+// Check rcm against the schema at path, writing any violations to stderr.
+// Returns an error if the table does not follow the schema.
+func ValidateSchemaPath(rcm ReadCloserMaker, path string, values *ValueParser) error {
+	h := handle("ValidateSchemaPath: %w")
+
+	s, e := ReadSchemaPath(path)
+	if e != nil { return h(e) }
+
+	report, e := s.Validate(rcm, 0, values)
+	if e != nil { return h(e) }
+
+	if !report.OK() {
+		if e := report.Write(os.Stderr); e != nil { return h(e) }
+	}
+	if e := report.Err(); e != nil { return h(e) }
+	return nil
+}
+
+type schemaFlags struct {
+	Path string
+	OutPath string
+	Sample int64
+	MaxCategories int
+	Check string
+}
+
+// Profile a table, or check it against a schema, on the command line
+func RunSchema() {
+	var f schemaFlags
+	flag.StringVar(&f.Path, "i", "", "input table, plain or compressed, a comma-separated list or glob of shards, a table from compile, or - for stdin")
+	flag.StringVar(&f.OutPath, "o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
+	flag.Int64Var(&f.Sample, "n", 0, "only scan the first n rows (default all)")
+	flag.IntVar(&f.MaxCategories, "maxcat", DefaultMaxCategories, "most distinct values in a categorical column")
+	flag.StringVar(&f.Check, "check", "", "check the table against this schema file instead of profiling it")
+	flag.Parse()
+	if f.Path == "" {
+		panic(fmt.Errorf("missing -i"))
+	}
+
+	w, e := OutputWriteCloserMaker(f.OutPath).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	rcm := InputReadCloserMaker(f.Path)
+	var failed error
+	if f.Check != "" {
+		s, e := ReadSchemaPath(f.Check)
+		if e != nil { panic(e) }
+
+		report, e := s.Validate(rcm, f.Sample, DefaultValueParser())
+		if e != nil { panic(e) }
+
+		e = report.Write(w)
+		if e != nil { panic(e) }
+		failed = report.Err()
+	} else {
+		t, e := ProfileTable(rcm, f.Sample, f.MaxCategories, DefaultValueParser())
+		if e != nil { panic(e) }
+
+		e = t.Write(w)
+		if e != nil { panic(e) }
+
+		e = t.WriteRagged(os.Stderr)
+		if e != nil { panic(e) }
+	}
+
+	e = w.Close()
+	if e != nil { panic(e) }
+
+	if failed != nil {
+		fmt.Fprintln(os.Stderr, failed)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,223 @@
+package spstat
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Columns of each type, with missing values and ragged rows
+func schemaTestTable() stringTable {
+	var b strings.Builder
+	b.WriteString("pos\tvalue\ttissue\tname\n")
+	for i := 0; i < 300; i++ {
+		tissue := "blood"
+		if i % 3 == 0 {
+			tissue = "sperm"
+		}
+		value := fmt.Sprint(float64(i % 11) / 10)
+		if i % 50 == 7 {
+			value = "NA"
+		}
+		fmt.Fprintf(&b, "%v\t%v\t%v\tread%v\n", i + 1, value, tissue, i)
+	}
+	b.WriteString("301\t2\tliver\n")
+	b.WriteString("302\t.\tblood\tread302\textra\n")
+	return stringTable(b.String())
+}
+
+func TestProfileTable(t *testing.T) {
+	p, e := ProfileTable(schemaTestTable(), 0, DefaultMaxCategories, DefaultValueParser())
+	if e != nil { t.Fatal(e) }
+	if p.Rows != 302 || p.ShortRows != 1 || p.LongRows != 1 {
+		t.Errorf("%v rows, %v short, %v long", p.Rows, p.ShortRows, p.LongRows)
+	}
+
+	cases := []struct {
+		typ SchemaType
+		missing int64
+		absent int64
+		distinct int
+		min float64
+		max float64
+	}{
+		{SchemaInt, 0, 0, -1, 1, 302},
+		{SchemaFloat, 7, 0, 12, 0, 2},
+		{SchemaCategorical, 0, 0, 3, 0, 0},
+		{SchemaText, 0, 1, -1, 0, 0},
+	}
+	for i, c := range cases {
+		col := p.Columns[i]
+		distinct := -1
+		if col.Distinct != nil {
+			distinct = len(col.Distinct)
+		}
+		if col.Type() != c.typ || col.Missing != c.missing || col.Absent != c.absent || distinct != c.distinct {
+			t.Errorf("%v: type %v, %v missing, %v absent, %v distinct", col.Name, col.Type(), col.Missing, col.Absent, distinct)
+		}
+		if (c.typ == SchemaInt || c.typ == SchemaFloat) && (col.Min != c.min || col.Max != c.max) {
+			t.Errorf("%v: range %v to %v", col.Name, col.Min, col.Max)
+		}
+	}
+	if r := p.Columns[3].MissingRate(); !closeTo(r, 1.0 / 302, 1e-12) {
+		t.Errorf("missing rate of an absent field %v", r)
+	}
+
+	var out bytes.Buffer
+	if e := p.Write(&out); e != nil { t.Fatal(e) }
+	lines := strings.Split(out.String(), "\n")
+	if lines[1] != "pos\tint\tNA\t0\t0\t0\t1\t302" || !strings.HasPrefix(lines[3], "tissue\tcategorical\t3\t0\t0\t0\tNA\tNA") {
+		t.Errorf("profile:\n%v", out.String())
+	}
+	out.Reset()
+	if e := p.WriteRagged(&out); e != nil { t.Fatal(e) }
+	if out.String() != "302 rows, 1 short, 1 long\n" {
+		t.Errorf("ragged counts %q", out.String())
+	}
+
+	// A sample, and a lower limit on categories
+	p, e = ProfileTable(schemaTestTable(), 10, 2, DefaultValueParser())
+	if e != nil { t.Fatal(e) }
+	if p.Rows != 10 || p.Columns[1].Type() != SchemaFloat || p.Columns[2].Type() != SchemaCategorical || p.Columns[3].Type() != SchemaText {
+		t.Errorf("sample of %v rows: types %v %v %v", p.Rows, p.Columns[1].Type(), p.Columns[2].Type(), p.Columns[3].Type())
+	}
+	p, e = ProfileTable(stringTable("a\tb\nx\t1\ny\t2\nz\t3.5\n"), 0, 2, DefaultValueParser())
+	if e != nil { t.Fatal(e) }
+	if p.Columns[0].Type() != SchemaText || p.Columns[1].Type() != SchemaFloat {
+		t.Errorf("types %v %v", p.Columns[0].Type(), p.Columns[1].Type())
+	}
+}
+
+func TestParseSchema(t *testing.T) {
+	s, e := ParseSchema(strings.NewReader(`# a comment
+value: float in [0,1]
+pos: int in [1,inf) required
+
+tissue: one of blood,sperm
+depth: in (0,]
+name:
+note: text required
+`))
+	if e != nil { t.Fatal(e) }
+
+	inf := math.Inf(1)
+	want := []ColumnRule{
+		{Name: "value", Type: SchemaFloat, HasRange: true, Min: 0, Max: 1},
+		{Name: "pos", Type: SchemaInt, HasRange: true, Min: 1, Max: inf, MaxOpen: true, Required: true},
+		{Name: "tissue", Type: SchemaCategorical, OneOf: []string{"blood", "sperm"}},
+		{Name: "depth", Type: SchemaFloat, HasRange: true, Min: 0, Max: inf, MinOpen: true},
+		{Name: "name", Type: SchemaText},
+		{Name: "note", Type: SchemaText, Required: true},
+	}
+	if len(s.Rules) != len(want) {
+		t.Fatalf("%v rules, want %v", len(s.Rules), len(want))
+	}
+	for i, w := range want {
+		r := *s.Rules[i]
+		r.oneOf = nil
+		if fmt.Sprint(r) != fmt.Sprint(w) {
+			t.Errorf("rule %v: %+v, want %+v", i, r, w)
+		}
+	}
+
+	for _, bad := range []string{"value float", "value: float in [0,1", "value: in [a,1]", "value: in [0;1]", "value: sometimes"} {
+		if _, e := ParseSchema(strings.NewReader(bad)); e == nil {
+			t.Errorf("parsed %q", bad)
+		}
+	}
+}
+
+func TestColumnRuleCheck(t *testing.T) {
+	s, e := ParseSchema(strings.NewReader("value: float in [0,1]\npos: int in (0,10) required\ntissue: one of blood,sperm\n"))
+	if e != nil { t.Fatal(e) }
+	value, pos, tissue := s.Rules[0], s.Rules[1], s.Rules[2]
+	values := DefaultValueParser()
+
+	cases := []struct {
+		rule *ColumnRule
+		field string
+		want string
+	}{
+		{value, "0", ""},
+		{value, "1", ""},
+		{value, "0.5", ""},
+		{value, "NA", ""},
+		{value, "1.5", "out of range"},
+		{value, "-0.1", "out of range"},
+		{value, "NaN", ""},
+		{value, "nan", "out of range"},
+		{value, "high", "not a number"},
+		{pos, "5", ""},
+		{pos, "0", "out of range"},
+		{pos, "10", "out of range"},
+		{pos, "5.5", "not an int"},
+		{pos, ".", "missing"},
+		{tissue, "sperm", ""},
+		{tissue, "liver", "not one of blood,sperm"},
+		{tissue, "", ""},
+	}
+	for _, c := range cases {
+		if got := c.rule.Check(c.field, values); got != c.want {
+			t.Errorf("%v %q: %q, want %q", c.rule.Name, c.field, got, c.want)
+		}
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	s, e := ParseSchema(strings.NewReader("pos: int in [1,inf) required\nvalue: float in [0,1]\ntissue: one of blood,sperm\nname: text\ndepth: int\n"))
+	if e != nil { t.Fatal(e) }
+	values := DefaultValueParser()
+
+	report, e := s.Validate(schemaTestTable(), 0, values)
+	if e != nil { t.Fatal(e) }
+	if report.Rows != 302 || report.OK() {
+		t.Fatalf("%v rows, ok %v", report.Rows, report.OK())
+	}
+
+	want := map[string]int64{"depth": 1, "name": 1, "tissue": 1, "value": 1}
+	got := map[string]int64{}
+	for _, v := range report.Violations {
+		got[v.Column] = v.Count
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("violations %v, want %v", got, want)
+	}
+	var out bytes.Buffer
+	if e := report.Write(&out); e != nil { t.Fatal(e) }
+	for _, line := range []string{
+		"depth: 1 rows break the schema; column is not in the table",
+		"name: 1 rows break the schema; line 302: absent",
+		`tissue: 1 rows break the schema; line 302: "liver" not one of blood,sperm`,
+		`value: 1 rows break the schema; line 302: "2" out of range`,
+	} {
+		if !strings.Contains(out.String(), line + "\n") {
+			t.Errorf("report missing %q:\n%v", line, out.String())
+		}
+	}
+	if e := report.Err(); e == nil || !strings.Contains(e.Error(), "depth, name, tissue, value") {
+		t.Errorf("error %v", e)
+	}
+
+	// The first rows follow it
+	s.Rules = s.Rules[:4]
+	report, e = s.Validate(schemaTestTable(), 100, values)
+	if e != nil { t.Fatal(e) }
+	if !report.OK() || report.Rows != 100 || report.Err() != nil {
+		t.Errorf("sample of %v rows: %v", report.Rows, report.Err())
+	}
+
+	// As -schema checks it
+	path := filepath.Join(t.TempDir(), "schema.txt")
+	if e := os.WriteFile(path, []byte("value: float in [0,1]\n"), 0644); e != nil { t.Fatal(e) }
+	if e := ValidateSchemaPath(schemaTestTable(), path, values); e == nil {
+		t.Errorf("ValidateSchemaPath passed a value out of range")
+	}
+	if e := os.WriteFile(path, []byte("value: float in [0,2]\ntissue: categorical\n"), 0644); e != nil { t.Fatal(e) }
+	if e := ValidateSchemaPath(schemaTestTable(), path, values); e != nil {
+		t.Errorf("ValidateSchemaPath: %v", e)
+	}
+}
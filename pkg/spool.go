@@ -0,0 +1,133 @@
+package spstat
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"sync"
+)
+
+// A ReadCloserMaker around a one-shot io.Reader, such as stdin, a pipe, or
+// the output of a process. The first call to NewReadCloser copies everything
+// in the reader to a temporary file, and every call (including the first)
+// replays that copy, so multi-pass pipelines can run on streams.
+type Spool struct {
+	// Directory for the temporary file; "" means os.TempDir()
+	Dir string
+	// Compress the temporary file with gzip
+	Gzip bool
+	// Decompress the input with AutoDecompress before spooling it
+	Decompress bool
+
+	r io.Reader
+	once sync.Once
+	f *os.File
+	size int64
+	err error
+}
+
+// Create a Spool that will read r once, on the first call to NewReadCloser.
+func NewSpool(r io.Reader, dir string, gz bool) *Spool {
+	return &Spool{Dir: dir, Gzip: gz, r: r}
+}
+
+// Copy all of s.r into a temporary file. The file is unlinked as soon as it
+// is created, so it disappears when the process exits, even on a panic.
+func (s *Spool) spool() (err error) {
+	h := handle("spool: %w")
+
+	f, e := os.CreateTemp(s.Dir, "spstat_spool_*")
+	if e != nil { return h(e) }
+	os.Remove(f.Name())
+	defer func() {
+		if err != nil {
+			f.Close()
+		}
+	}()
+
+	bw := bufio.NewWriter(f)
+	var w io.Writer = bw
+	var gw *gzip.Writer
+	if s.Gzip {
+		gw, e = gzip.NewWriterLevel(bw, gzip.BestSpeed)
+		if e != nil { return h(e) }
+		w = gw
+	}
+
+	r := s.r
+	if s.Decompress {
+		dr, e := AutoDecompress(r)
+		if e != nil { return h(e) }
+		defer dr.Close()
+		r = dr
+	}
+
+	_, e = io.Copy(w, r)
+	if e != nil { return h(e) }
+
+	if gw != nil {
+		e = gw.Close()
+		if e != nil { return h(e) }
+	}
+	e = bw.Flush()
+	if e != nil { return h(e) }
+
+	size, e := f.Seek(0, io.SeekCurrent)
+	if e != nil { return h(e) }
+
+	s.f = f
+	s.size = size
+	return nil
+}
+
+// Spool the input if this is the first call, then open a new reader at the
+// start of the spooled copy. Readers are independent and may be used
+// concurrently.
+func (s *Spool) NewReadCloser() (io.ReadCloser, error) {
+	h := handle("Spool.NewReadCloser: %w")
+
+	s.once.Do(func() { s.err = s.spool() })
+	if s.err != nil { return nil, h(s.err) }
+
+	sr := io.NewSectionReader(s.f, 0, s.size)
+	if !s.Gzip {
+		return ReadCloser{sr}, nil
+	}
+
+	gr, e := gzip.NewReader(sr)
+	if e != nil { return nil, h(e) }
+	return gr, nil
+}
+
+// Release the spooled copy. Readers opened earlier stop working.
+func (s *Spool) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	return s.f.Close()
+}
+
+func (s *Spool) String() string {
+	return "spooled stream"
+}
+
+// Interpret an input path from the command line. "-" means stdin, which is
+// spooled so that it can be read more than once. A comma-separated list or
+// glob of shards opens as a MultiPath. Tables compiled with Compile open as a
+// ColumnarPath, and anything else is opened with AutoDecompressPath. Either
+// way, compressed input is detected from its magic bytes.
+func InputReadCloserMaker(path string) ReadCloserMaker {
+	if path == "-" {
+		s := NewSpool(os.Stdin, "", false)
+		s.Decompress = true
+		return s
+	}
+	if IsMultiPath(path) {
+		return NewMultiPath(path)
+	}
+	if IsColumnarPath(path) {
+		return ColumnarPath(path)
+	}
+	return AutoDecompressPath(path)
+}
@@ -0,0 +1,597 @@
+package spstat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The kinds of Summary
+const (
+	// One TSummary per id column, for t tests and F tests
+	SummaryGroups = "groups"
+	// One NamedValSet per id column, for normalizing
+	SummaryMeans = "means"
+	// One LinearModeler, for regression
+	SummaryLinearModel = "linear_model"
+)
+
+// The version of the summary file formats written by this package
+const SummaryVersion = 1
+
+var summaryMagic = []byte("SPSUM\x00")
+
+// The statistics from one pass over a table, which can be written to a file,
+// merged with the summaries of other shards of the same table, and used to
+// finish a test without reading the table again. Sets holds one summary per
+// id column for SummaryGroups, and Means holds them for SummaryMeans.
+type Summary struct {
+	Version int
+	Kind string
+	ValCol string
+	IndepCol string
+	Sets []*TSummary
+	Means []*NamedValSet
+	LinearModel *LinearModeler
+}
+
+type summaryJSON struct {
+	Version int `json:"version"`
+	Kind string `json:"kind"`
+	ValCol string `json:"value_column"`
+	IndepCol string `json:"indep_column,omitempty"`
+	Sets []summarySetJSON `json:"sets,omitempty"`
+	LinearModel *LinearModeler `json:"linear_model,omitempty"`
+}
+
+type summarySetJSON struct {
+	Column string `json:"column"`
+	Groups []summaryGroupJSON `json:"groups"`
+}
+
+// One group's statistics. Mean, M2, M3 and M4 are only kept for
+// SummaryGroups.
+type summaryGroupJSON struct {
+	Name string `json:"name"`
+	Count float64 `json:"count"`
+	Sum float64 `json:"sum"`
+	Mean float64 `json:"mean,omitempty"`
+	M2 float64 `json:"m2,omitempty"`
+	M3 float64 `json:"m3,omitempty"`
+	M4 float64 `json:"m4,omitempty"`
+}
+
+// Summarize the values of valcolname in the groups of each TSummary
+func NewGroupsSummary(valcolname string, tsums []*TSummary) *Summary {
+	return &Summary{Version: SummaryVersion, Kind: SummaryGroups, ValCol: valcolname, Sets: tsums}
+}
+
+// Summarize the sums of valcolname in the groups of each NamedValSet
+func NewMeansSummary(valcolname string, sets []*NamedValSet) *Summary {
+	return &Summary{Version: SummaryVersion, Kind: SummaryMeans, ValCol: valcolname, Means: sets}
+}
+
+// Summarize a fit of valcolname ~ indepcolname
+func NewLinearModelSummary(valcolname, indepcolname string, l *LinearModeler) *Summary {
+	return &Summary{Version: SummaryVersion, Kind: SummaryLinearModel, ValCol: valcolname, IndepCol: indepcolname, LinearModel: l}
+}
+
+// The names of the groups in a set, in sorted order, so that output is
+// the same from run to run
+func sortedGroups(counts map[string]float64) []string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Summary) toJSON() *summaryJSON {
+	out := &summaryJSON{
+		Version: s.Version,
+		Kind: s.Kind,
+		ValCol: s.ValCol,
+		IndepCol: s.IndepCol,
+		LinearModel: s.LinearModel,
+	}
+
+	for _, tsum := range s.Sets {
+		set := summarySetJSON{Column: tsum.ColName, Groups: []summaryGroupJSON{}}
+		for _, name := range sortedGroups(tsum.Counts) {
+			m := tsum.MomentsOf(name)
+			set.Groups = append(set.Groups, summaryGroupJSON{
+				Name: name,
+				Count: tsum.Counts[name],
+				Sum: tsum.Sums[name],
+				Mean: m.mean.Value(),
+				M2: m.m2.Value(),
+				M3: m.m3,
+				M4: m.m4,
+			})
+		}
+		out.Sets = append(out.Sets, set)
+	}
+
+	for _, vs := range s.Means {
+		set := summarySetJSON{Column: vs.ColName, Groups: []summaryGroupJSON{}}
+		for _, name := range sortedGroups(vs.Counts) {
+			set.Groups = append(set.Groups, summaryGroupJSON{
+				Name: name,
+				Count: vs.Counts[name],
+				Sum: vs.Sums[name],
+			})
+		}
+		out.Sets = append(out.Sets, set)
+	}
+	return out
+}
+
+func summaryFromJSON(in *summaryJSON) (*Summary, error) {
+	s := &Summary{
+		Version: in.Version,
+		Kind: in.Kind,
+		ValCol: in.ValCol,
+		IndepCol: in.IndepCol,
+		LinearModel: in.LinearModel,
+	}
+
+	switch in.Kind {
+	case SummaryGroups:
+		for _, set := range in.Sets {
+			tsum := NewTSummary()
+			tsum.ColName = set.Column
+			for _, g := range set.Groups {
+				tsum.Sums[g.Name] = g.Sum
+				tsum.Counts[g.Name] = g.Count
+				tsum.Moments[g.Name] = &Moments{
+					n: g.Count,
+					mean: compensatedSum{sum: g.Mean},
+					m2: compensatedSum{sum: g.M2},
+					m3: g.M3,
+					m4: g.M4,
+				}
+			}
+			s.Sets = append(s.Sets, tsum)
+		}
+	case SummaryMeans:
+		for _, set := range in.Sets {
+			vs := NewNamedValSet()
+			vs.ColName = set.Column
+			for _, g := range set.Groups {
+				vs.Sums[g.Name] = g.Sum
+				vs.Counts[g.Name] = g.Count
+			}
+			s.Means = append(s.Means, vs)
+		}
+	case SummaryLinearModel:
+		if s.LinearModel == nil {
+			return nil, fmt.Errorf("no linear model")
+		}
+	default:
+		return nil, fmt.Errorf("unknown kind %q", in.Kind)
+	}
+	return s, nil
+}
+
+// Write s as indented JSON. JSON cannot hold infinite values, so a summary
+// of a table read with InfKeep may need the binary form.
+func (s *Summary) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	if e := enc.Encode(s.toJSON()); e != nil {
+		return fmt.Errorf("Summary.WriteJSON: %w", e)
+	}
+	return nil
+}
+
+func writeFloat(w *bufio.Writer, v float64) error {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	_, e := w.Write(buf[:])
+	return e
+}
+
+func readFloat(r *bufio.Reader) (float64, error) {
+	var buf [8]byte
+	if _, e := io.ReadFull(r, buf[:]); e != nil {
+		if e == io.EOF { e = io.ErrUnexpectedEOF }
+		return 0, e
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf[:])), nil
+}
+
+// Write s in binary form: magic bytes and version, the kind and column names
+// as uvarint-prefixed strings, then each set's column name, group count, and
+// groups, each a name followed by count, sum, mean, m2, m3 and m4 as
+// little-endian float64s. A linear model follows as its six fields.
+func (s *Summary) WriteBinary(w io.Writer) error {
+	h := handle("Summary.WriteBinary: %w")
+	bw := bufio.NewWriter(w)
+
+	if _, e := bw.Write(summaryMagic); e != nil { return h(e) }
+	if e := bw.WriteByte(byte(s.Version)); e != nil { return h(e) }
+
+	js := s.toJSON()
+	if e := writeIndexString(bw, js.Kind); e != nil { return h(e) }
+	if e := writeIndexString(bw, js.ValCol); e != nil { return h(e) }
+	if e := writeIndexString(bw, js.IndepCol); e != nil { return h(e) }
+
+	if e := writeUvarint(bw, uint64(len(js.Sets))); e != nil { return h(e) }
+	for _, set := range js.Sets {
+		if e := writeIndexString(bw, set.Column); e != nil { return h(e) }
+		if e := writeUvarint(bw, uint64(len(set.Groups))); e != nil { return h(e) }
+		for _, g := range set.Groups {
+			if e := writeIndexString(bw, g.Name); e != nil { return h(e) }
+			for _, v := range []float64{g.Count, g.Sum, g.Mean, g.M2, g.M3, g.M4} {
+				if e := writeFloat(bw, v); e != nil { return h(e) }
+			}
+		}
+	}
+
+	if l := js.LinearModel; l != nil {
+		for _, v := range []float64{l.XDiffYDiffSum, l.XDiffSqSum, l.YDiffSqSum, l.Count, l.XMean, l.YMean} {
+			if e := writeFloat(bw, v); e != nil { return h(e) }
+		}
+	}
+
+	if e := bw.Flush(); e != nil { return h(e) }
+	return nil
+}
+
+func readSummaryBinary(br *bufio.Reader) (*Summary, error) {
+	magic := make([]byte, len(summaryMagic) + 1)
+	if _, e := io.ReadFull(br, magic); e != nil { return nil, e }
+	js := &summaryJSON{Version: int(magic[len(summaryMagic)])}
+	if js.Version != SummaryVersion {
+		return nil, fmt.Errorf("unsupported version %v", js.Version)
+	}
+
+	var buf []byte
+	var e error
+	if js.Kind, buf, e = readColumnarString(br, buf); e != nil { return nil, e }
+	if js.ValCol, buf, e = readColumnarString(br, buf); e != nil { return nil, e }
+	if js.IndepCol, buf, e = readColumnarString(br, buf); e != nil { return nil, e }
+
+	nsets, e := readUvarint(br)
+	if e != nil { return nil, e }
+	for i := 0; i < nsets; i++ {
+		var set summarySetJSON
+		if set.Column, buf, e = readColumnarString(br, buf); e != nil { return nil, e }
+		ngroups, e := readUvarint(br)
+		if e != nil { return nil, e }
+
+		for j := 0; j < ngroups; j++ {
+			var g summaryGroupJSON
+			if g.Name, buf, e = readColumnarString(br, buf); e != nil { return nil, e }
+			for _, p := range []*float64{&g.Count, &g.Sum, &g.Mean, &g.M2, &g.M3, &g.M4} {
+				if *p, e = readFloat(br); e != nil { return nil, e }
+			}
+			set.Groups = append(set.Groups, g)
+		}
+		js.Sets = append(js.Sets, set)
+	}
+
+	if js.Kind == SummaryLinearModel {
+		l := &LinearModeler{}
+		for _, p := range []*float64{&l.XDiffYDiffSum, &l.XDiffSqSum, &l.YDiffSqSum, &l.Count, &l.XMean, &l.YMean} {
+			if *p, e = readFloat(br); e != nil { return nil, e }
+		}
+		js.LinearModel = l
+	}
+
+	return summaryFromJSON(js)
+}
+
+// Read a summary written by WriteJSON or WriteBinary
+func ReadSummary(r io.Reader) (*Summary, error) {
+	h := handle("ReadSummary: %w")
+	br := bufio.NewReader(r)
+
+	magic, e := br.Peek(len(summaryMagic))
+	if e == nil && bytes.Equal(magic, summaryMagic) {
+		s, e := readSummaryBinary(br)
+		if e != nil { return nil, h(e) }
+		return s, nil
+	}
+
+	var js summaryJSON
+	if e := json.NewDecoder(br).Decode(&js); e != nil { return nil, h(e) }
+	if js.Version != SummaryVersion {
+		return nil, h(fmt.Errorf("unsupported version %v", js.Version))
+	}
+	s, e := summaryFromJSON(&js)
+	if e != nil { return nil, h(e) }
+	return s, nil
+}
+
+// Read the summary file at path, which may be compressed
+func ReadSummaryPath(path string) (*Summary, error) {
+	r, e := InputReadCloserMaker(path).NewReadCloser()
+	if e != nil { return nil, fmt.Errorf("ReadSummaryPath: %w", e) }
+	defer r.Close()
+
+	s, e := ReadSummary(r)
+	if e != nil { return nil, fmt.Errorf("ReadSummaryPath: %v: %w", path, e) }
+	return s, nil
+}
+
+// Write s to path in format, which is "json" or "binary"
+func WriteSummaryPath(s *Summary, path, format string) error {
+	h := handle("WriteSummaryPath: %w")
+
+	w, e := OutputWriteCloserMaker(path).NewWriteCloser()
+	if e != nil { return h(e) }
+
+	switch format {
+	case "json":
+		e = s.WriteJSON(w)
+	case "binary":
+		e = s.WriteBinary(w)
+	default:
+		e = fmt.Errorf("unknown format %q", format)
+	}
+	if e != nil {
+		w.Close()
+		return h(e)
+	}
+
+	if e := w.Close(); e != nil { return h(e) }
+	return nil
+}
+
+func (s *Summary) setNames() []string {
+	var names []string
+	for _, tsum := range s.Sets {
+		names = append(names, tsum.ColName)
+	}
+	for _, vs := range s.Means {
+		names = append(names, vs.ColName)
+	}
+	return names
+}
+
+// Add the statistics in o to s. Both must be the same kind of summary, of the
+// same columns.
+func (s *Summary) Merge(o *Summary) error {
+	h := handle("Summary.Merge: %w")
+
+	if s.Kind != o.Kind {
+		return h(fmt.Errorf("cannot merge %v summary with %v summary", o.Kind, s.Kind))
+	}
+	if s.ValCol != o.ValCol || s.IndepCol != o.IndepCol {
+		return h(fmt.Errorf("summaries of different columns: %v ~ %v and %v ~ %v", s.ValCol, s.IndepCol, o.ValCol, o.IndepCol))
+	}
+	snames, onames := s.setNames(), o.setNames()
+	if strings.Join(snames, "\t") != strings.Join(onames, "\t") {
+		return h(fmt.Errorf("summaries grouped by different columns: %v and %v", snames, onames))
+	}
+
+	for i, tsum := range s.Sets {
+		tsum.Merge(o.Sets[i])
+	}
+	for i, vs := range s.Means {
+		vs.Merge(o.Means[i])
+	}
+	if s.LinearModel != nil {
+		s.LinearModel.Merge(o.LinearModel)
+	}
+	return nil
+}
+
+// Read and merge the summary files at paths
+func MergeSummaryPaths(paths []string) (*Summary, error) {
+	h := handle("MergeSummaryPaths: %w")
+
+	if len(paths) == 0 {
+		return nil, h(fmt.Errorf("no summaries"))
+	}
+
+	s, e := ReadSummaryPath(paths[0])
+	if e != nil { return nil, h(e) }
+
+	for _, path := range paths[1:] {
+		o, e := ReadSummaryPath(path)
+		if e != nil { return nil, h(e) }
+		if e := s.Merge(o); e != nil { return nil, h(fmt.Errorf("%v: %w", path, e)) }
+	}
+	return s, nil
+}
+
+// The summary of the sets in s, after checking that it is a SummaryGroups
+// summary with a control column and a test column
+func (s *Summary) testSets() ([]*TSummary, []TTestSet, error) {
+	if s.Kind != SummaryGroups {
+		return nil, nil, fmt.Errorf("need a %v summary, not %v", SummaryGroups, s.Kind)
+	}
+	if len(s.Sets) != 2 {
+		return nil, nil, fmt.Errorf("need a control column and a test column, not %v columns", len(s.Sets))
+	}
+	return s.Sets, TTestSets(s.Sets, s.setNames(), 0, 1), nil
+}
+
+// Run the t tests for a summary of a control column and a test column
+func TTestSummary(w io.Writer, s *Summary, opts TTestOptions) error {
+	h := handle("TTestSummary: %w")
+
+	tsums, testsets, e := s.testSets()
+	if e != nil { return h(e) }
+
+	if e := TTests(w, tsums, testsets, opts); e != nil { return h(e) }
+	return nil
+}
+
+// Run the F tests for a summary of a control column and a test column
+func FTestSummary(w io.Writer, s *Summary) error {
+	h := handle("FTestSummary: %w")
+
+	tsums, testsets, e := s.testSets()
+	if e != nil { return h(e) }
+
+	if e := FTests(w, tsums, testsets); e != nil { return h(e) }
+	return nil
+}
+
+// The linear model in a SummaryLinearModel summary
+func (s *Summary) Model() (*LinearModeler, error) {
+	if s.Kind != SummaryLinearModel {
+		return nil, fmt.Errorf("Summary.Model: need a %v summary, not %v", SummaryLinearModel, s.Kind)
+	}
+	return s.LinearModel, nil
+}
+
+// Write the coefficients of the linear model in a summary as a table
+func LinearModelSummary(w io.Writer, s *Summary) error {
+	h := handle("LinearModelSummary: %w")
+
+	l, e := s.Model()
+	if e != nil { return h(e) }
+
+	m, b := l.MB()
+	_, e = fmt.Fprintf(w, "value\tindep\tcount\tslope\tintercept\n%v\t%v\t%v\t%v\t%v\n", s.ValCol, s.IndepCol, l.Count, m, b)
+	if e != nil { return h(e) }
+	return nil
+}
+
+// Append residuals to a table using the linear model in a summary, which may
+// have been fit to more data than the table holds
+func RunLinearModelFromSummary(rcm ReadCloserMaker, w io.Writer, s *Summary, ro *RowOptions) error {
+	h := handle("RunLinearModelFromSummary: %w")
+
+	l, e := s.Model()
+	if e != nil { return h(e) }
+
+	cols, e := NamedCols(rcm, []string{s.ValCol, s.IndepCol})
+	if e != nil { return h(e) }
+
+	m, b := l.MB()
+	if e := LinearModelResiduals(rcm, w, cols[0], cols[1], m, b, ro); e != nil { return h(e) }
+	return nil
+}
+
+// Make the summary of a table for SummaryGroups, SummaryMeans, or
+// SummaryLinearModel. idcolsnames is used for the first two, and
+// indepcolname for the last.
+func SummarizeTable(rcm ReadCloserMaker, kind, valcolname, indepcolname string, idcolsnames []string, ro *RowOptions) (*Summary, error) {
+	h := handle("SummarizeTable: %w")
+
+	if kind == SummaryLinearModel {
+		cols, e := NamedCols(rcm, []string{valcolname, indepcolname})
+		if e != nil { return nil, h(e) }
+
+		l, e := FitLinearModel(rcm, cols[0], cols[1], ro)
+		if e != nil { return nil, h(e) }
+		return NewLinearModelSummary(valcolname, indepcolname, l), nil
+	}
+
+	if len(idcolsnames) == 0 {
+		return nil, h(fmt.Errorf("no id columns"))
+	}
+	valcol, e := ValCol(rcm, valcolname)
+	if e != nil { return nil, h(e) }
+	idcols, e := IdCols(rcm, idcolsnames)
+	if e != nil { return nil, h(e) }
+
+	switch kind {
+	case SummaryGroups:
+		tsums, _, e := CalcTSummary(rcm, valcol, idcolsnames, idcols, 0, 0, ro)
+		if e != nil { return nil, h(e) }
+		return NewGroupsSummary(valcolname, tsums), nil
+	case SummaryMeans:
+		sets, e := CalcMeans(rcm, valcol, idcolsnames, idcols, ro)
+		if e != nil { return nil, h(e) }
+		return NewMeansSummary(valcolname, sets), nil
+	}
+	return nil, h(fmt.Errorf("unknown kind %q", kind))
+}
+
+type summarizeFlags struct {
+	Path string
+	OutPath string
+	Region string
+	ChromCol string
+	PosCol string
+	ValCol string
+	IndepCol string
+	IdCols string
+	Means bool
+	Format string
+}
+
+// Summarize a table on the command line
+func RunSummarize() {
+	var f summarizeFlags
+	flag.StringVar(&f.Path, "i", "", "input table, plain or compressed, a comma-separated list or glob of shards, a table from compile, or - for stdin")
+	flag.StringVar(&f.OutPath, "o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
+	flag.StringVar(&f.Region, "region", "", "only read rows in these regions, like chr1:1000-2000, separated by semicolons")
+	flag.StringVar(&f.ChromCol, "chromcol", DefaultChromCol, "chromosome column name for -region")
+	flag.StringVar(&f.PosCol, "poscol", DefaultPosCol, "position column name for -region")
+	flag.StringVar(&f.ValCol, "v", "", "value column name")
+	flag.StringVar(&f.IndepCol, "indep", "", "independent predictor column name, to summarize a linear model")
+	flag.StringVar(&f.IdCols, "g", "", "comma-separated id columns to group values by; the control column, then the test column, for ttest and ftest")
+	flag.BoolVar(&f.Means, "means", false, "only keep the sums and counts needed for normalizing")
+	flag.StringVar(&f.Format, "format", "json", "output format: json or binary")
+	rowflags := AddRowFlags()
+	flag.Parse()
+	defer rowflags.Exit()
+	if f.Path == "" {
+		panic(fmt.Errorf("missing -i"))
+	}
+	if f.ValCol == "" {
+		panic(fmt.Errorf("missing -v"))
+	}
+	if (f.IndepCol == "") == (f.IdCols == "") {
+		panic(fmt.Errorf("need one of -indep or -g"))
+	}
+
+	kind := SummaryGroups
+	var idcolsnames []string
+	if f.IndepCol != "" {
+		kind = SummaryLinearModel
+	} else {
+		idcolsnames = strings.Split(f.IdCols, ",")
+		if f.Means {
+			kind = SummaryMeans
+		}
+	}
+
+	rcm, e := InputRegionReadCloserMaker(f.Path, f.Region, f.ChromCol, f.PosCol)
+	if e != nil { panic(e) }
+
+	ro, e := rowflags.Start()
+	if e != nil { panic(e) }
+
+	e = rowflags.Validate(rcm)
+	if e != nil { panic(e) }
+
+	s, e := SummarizeTable(rcm, kind, f.ValCol, f.IndepCol, idcolsnames, ro)
+	if e != nil { panic(e) }
+
+	e = WriteSummaryPath(s, f.OutPath, f.Format)
+	if e != nil { panic(e) }
+}
+
+// Merge summary files on the command line
+func RunMerge() {
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
+	formatp := flag.String("format", "json", "output format: json or binary")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: merge [-o out] [-format json|binary] summary...")
+		os.Exit(1)
+	}
+
+	s, e := MergeSummaryPaths(flag.Args())
+	if e != nil { panic(e) }
+
+	e = WriteSummaryPath(s, *outp, *formatp)
+	if e != nil { panic(e) }
+}
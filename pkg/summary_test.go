@@ -0,0 +1,66 @@
+package spstat
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testSummary() *Summary {
+	tsum := NewTSummary()
+	tsum.ColName = "tissue"
+	for i, v := range []float64{0.1, 0.4, 0.35, 0.8, 0.5, 0.45} {
+		id := "blood"
+		if i % 2 == 1 {
+			id = "sperm"
+		}
+		tsum.Add(v, id)
+	}
+	return NewGroupsSummary("value", []*TSummary{tsum})
+}
+
+func TestSummaryRoundTrip(t *testing.T) {
+	s := testSummary()
+	want := s.Sets[0]
+
+	for _, format := range []string{"json", "binary"} {
+		var b bytes.Buffer
+		var e error
+		if format == "json" {
+			e = s.WriteJSON(&b)
+		} else {
+			e = s.WriteBinary(&b)
+		}
+		if e != nil { t.Fatal(e) }
+
+		got, e := ReadSummary(&b)
+		if e != nil { t.Fatal(e) }
+		if got.Kind != SummaryGroups || got.ValCol != "value" || len(got.Sets) != 1 {
+			t.Fatalf("%v: read %v", format, got)
+		}
+
+		for _, id := range []string{"blood", "sperm"} {
+			g := got.Sets[0]
+			if g.Counts[id] != want.Counts[id] || g.Mean(id) != want.Mean(id) || g.SampleVar(id) != want.SampleVar(id) {
+				t.Errorf("%v: %v: read %v, %v, %v; want %v, %v, %v", format, id,
+					g.Counts[id], g.Mean(id), g.SampleVar(id),
+					want.Counts[id], want.Mean(id), want.SampleVar(id))
+			}
+		}
+	}
+}
+
+func TestSummaryMerge(t *testing.T) {
+	s := testSummary()
+	e := s.Merge(testSummary())
+	if e != nil { t.Fatal(e) }
+
+	tsum := s.Sets[0]
+	if tsum.Counts["blood"] != 6 || !closeTo(tsum.Mean("blood"), 0.95 / 3, 1e-12) {
+		t.Errorf("merged blood: %v values, mean %v", tsum.Counts["blood"], tsum.Mean("blood"))
+	}
+
+	l := NewLinearModelSummary("value", "gc", &LinearModeler{})
+	if e := s.Merge(l); e == nil {
+		t.Errorf("merged a linear model into a groups summary")
+	}
+}
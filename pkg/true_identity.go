@@ -159,8 +159,16 @@ func TrueIdentity(r io.Reader, w io.Writer, experimentSexInfoPath string) error
 
 func RunTrueIdentities() {
 	ipathp := flag.String("si", "", "path to sample exp sex identities, columns should be plate id num, plate letter, plate col num, exp, sex, indiv")
+	outp := flag.String("o", "", "output path, compressed if it ends in .gz or .bgz (default stdout)")
 	flag.Parse()
-	e := TrueIdentity(os.Stdin, os.Stdout, *ipathp)
+
+	w, e := OutputWriteCloserMaker(*outp).NewWriteCloser()
+	if e != nil { panic(e) }
+
+	e = TrueIdentity(os.Stdin, w, *ipathp)
+	if e != nil { panic(e) }
+
+	e = w.Close()
 	if e != nil { panic(e) }
 }
 
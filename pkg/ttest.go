@@ -3,97 +3,186 @@ package spstat
 import (
 	"github.com/jgbaldwinbrown/csvh"
 	"encoding/csv"
+	"bufio"
 	"regexp"
-	"strconv"
 	"io"
 	"fmt"
 	"math"
 	"gonum.org/v1/gonum/stat/distuv"
 )
 
-// Collection of sums of squares for each of the named categories, plus sums
-// and counts, allowing for t tests, means, variances, and standard deviations
+// Collection of the moments of each of the named categories, plus sums and
+// counts, allowing for t tests, means, variances, and standard deviations
 type TSummary struct {
 	NamedValSet
-	SumSqs map[string]float64
+	Moments map[string]*Moments
 }
 
 // Add a value to the TSummary
 func (s *TSummary) Add(val float64, id string) {
 	if !math.IsNaN(val) {
 		s.NamedValSet.Add(val, id)
-		s.SumSqs[id] += val * val
+		m, ok := s.Moments[id]
+		if !ok {
+			m = &Moments{}
+			s.Moments[id] = m
+		}
+		m.Add(val)
+	}
+}
+
+// The moments for id, which are empty if id has no values
+func (s *TSummary) MomentsOf(id string) *Moments {
+	if m, ok := s.Moments[id]; ok {
+		return m
 	}
+	return &Moments{}
 }
 
-// Get variance
+// Get mean
+func (s *TSummary) Mean(id string) float64 {
+	return s.MomentsOf(id).Mean()
+}
+
+// Get population variance
 func (s *TSummary) Var(id string) float64 {
-	mean := s.Mean(id)
-	vari := (s.SumSqs[id] / s.Counts[id]) - (mean * mean)
-	return vari
+	return s.MomentsOf(id).PopVar()
 }
 
-// Get standard deviation
+// Get population standard deviation
 func (s *TSummary) Sd(id string) float64 {
 	vari := s.Var(id)
 	return math.Sqrt(vari)
 }
 
+// Get sample variance
+func (s *TSummary) SampleVar(id string) float64 {
+	return s.MomentsOf(id).SampleVar()
+}
+
+// Get sample standard deviation
+func (s *TSummary) SampleSd(id string) float64 {
+	return math.Sqrt(s.SampleVar(id))
+}
+
+// Add all of the values in o to s
+func (s *TSummary) Merge(o *TSummary) {
+	for id, om := range o.Moments {
+		s.Sums[id] += o.Sums[id]
+		s.Counts[id] += o.Counts[id]
+
+		m, ok := s.Moments[id]
+		if !ok {
+			m = &Moments{}
+			s.Moments[id] = m
+		}
+		m.Merge(om)
+	}
+}
+
 func NewTSummary() *TSummary {
 	s := &TSummary{}
 	s.Sums = make(map[string]float64)
 	s.Counts = make(map[string]float64)
-	s.SumSqs = make(map[string]float64)
+	s.Moments = make(map[string]*Moments)
 	return s
 }
 
-func CalcTSummary(rcm ReadCloserMaker, valcol int, idcolsnames []string, idcols []int, controlsetidx, testsetidx int) ([]*TSummary, []TTestSet, error) {
+func CalcTSummary(rcm ReadCloserMaker, valcol int, idcolsnames []string, idcols []int, controlsetidx, testsetidx int, ro *RowOptions) ([]*TSummary, []TTestSet, error) {
 	h := handle("CalcTSummary: %w")
 
-	r, e := rcm.NewReadCloser()
-	if e != nil { return nil, nil, h(e) }
-	defer r.Close()
-	cr := csvh.CsvIn(r)
+	if cm, ok := rcm.(ColumnarReaderMaker); ok {
+		return CalcTSummaryColumnar(cm, valcol, idcolsnames, idcols, controlsetidx, testsetidx, ro)
+	}
+
+	acc := newTSummaryAccumulator(valcol, idcolsnames, idcols)
+	if e := rowPass(rcm, "CalcTSummary", acc, ro); e != nil { return acc.tsums, nil, h(e) }
 
-	return CalcTSummaryFromCsvReader(cr, valcol, idcolsnames, idcols, controlsetidx, testsetidx)
+	return acc.tsums, TTestSets(acc.tsums, idcolsnames, controlsetidx, testsetidx), nil
 }
 
-func CalcTSummaryFromCsvReader(cr *csv.Reader, valcol int, idcolsnames []string, idcols []int, controlsetidx, testsetidx int) ([]*TSummary, []TTestSet, error) {
+func CalcTSummaryFromCsvReader(cr *csv.Reader, valcol int, idcolsnames []string, idcols []int, controlsetidx, testsetidx int, ro *RowOptions) ([]*TSummary, []TTestSet, error) {
 	h := handle("CalcTSummaryFromCsvReader: %w")
 
-	var tsums []*TSummary
+	acc := newTSummaryAccumulator(valcol, idcolsnames, idcols)
+	tsums := acc.tsums
+
+	if e := skipHeader(cr); e != nil { return tsums, nil, h(e) }
+	rows := ro.Stage("CalcTSummary")
+	defer rows.Done()
+
+	if e := eachRow(cr, rows, acc); e != nil { return tsums, nil, h(e) }
+
+	return tsums, TTestSets(tsums, idcolsnames, controlsetidx, testsetidx), nil
+}
+
+// A TSummary of the value column for each id column, gathered row by row
+type tsummaryAccumulator struct {
+	valcol int
+	tsums []*TSummary
+}
+
+func newTSummaryAccumulator(valcol int, idcolsnames []string, idcols []int) *tsummaryAccumulator {
+	acc := &tsummaryAccumulator{valcol: valcol}
 	for i, idcol := range idcols {
 		tsum := NewTSummary()
 		tsum.ColName = idcolsnames[i]
 		tsum.Idx = idcol
-		tsums = append(tsums, tsum)
+		acc.tsums = append(acc.tsums, tsum)
 	}
+	return acc
+}
 
-	for line, e := cr.Read(); e != io.EOF; line, e = cr.Read() {
-		if e != nil { return tsums, nil, h(e) }
+func (a *tsummaryAccumulator) Row(rows *RowStage, cr *csv.Reader, line []string) error {
+	val, ok, e := rows.CsvFloat(cr, line, a.valcol)
+	if e != nil || !ok { return e }
+	rows.Keep()
 
-		if len(line) <= valcol { continue }
-		val, e := strconv.ParseFloat(line[valcol], 64)
-		if e != nil { continue }
+	for _, tsum := range a.tsums {
+		if len(line) <= tsum.Idx { continue }
+		tsum.Add(val, line[tsum.Idx])
+	}
+	return nil
+}
 
-		for _, tsum := range tsums {
-			if len(line) <= tsum.Idx { continue }
-			tsum.Add(val, line[tsum.Idx])
-		}
+func (a *tsummaryAccumulator) Empty() RowAccumulator {
+	out := &tsummaryAccumulator{valcol: a.valcol}
+	for _, tsum := range a.tsums {
+		empty := NewTSummary()
+		empty.ColName = tsum.ColName
+		empty.Idx = tsum.Idx
+		out.tsums = append(out.tsums, empty)
 	}
+	return out
+}
 
+func (a *tsummaryAccumulator) Merge(o RowAccumulator) error {
+	for i, tsum := range o.(*tsummaryAccumulator).tsums {
+		a.tsums[i].Merge(tsum)
+	}
+	return nil
+}
+
+// Contrast "blood" in the control column against every name found in the
+// test column
+func TTestSets(tsums []*TSummary, idcolsnames []string, controlsetidx, testsetidx int) []TTestSet {
+	return testSetsOf(tsums[testsetidx].Counts, idcolsnames, controlsetidx, testsetidx)
+}
+
+// Contrast "blood" in the control column against each of names, the counts
+// of the names found in the test column
+func testSetsOf(names map[string]float64, idcolsnames []string, controlsetidx, testsetidx int) []TTestSet {
 	tsets := []TTestSet{}
-	for name, _ := range tsums[testsetidx].Counts {
+	for name, _ := range names {
 		tsets = append(tsets, TTestSet{
 			TTestItem{idcolsnames[controlsetidx], "blood"},
 			TTestItem{idcolsnames[testsetidx], name},
 		})
 	}
-
-	return tsums, tsets, nil
+	return tsets
 }
 
-func CalcTSummaryVsBlood(rcm ReadCloserMaker, valcol int, idcolsnames []string, idcols []int, bloodcol int, bloodcolname string) (idtsums []*TSummary, bloodtsum *TSummary, err error) {
+func CalcTSummaryVsBlood(rcm ReadCloserMaker, valcol int, idcolsnames []string, idcols []int, bloodcol int, bloodcolname string, ro *RowOptions) (idtsums []*TSummary, bloodtsum *TSummary, err error) {
 	h := handle("CalcTSummaryVsBlood: %w")
 
 	var tsums []*TSummary
@@ -114,12 +203,17 @@ func CalcTSummaryVsBlood(rcm ReadCloserMaker, valcol int, idcolsnames []string,
 	defer r.Close()
 	cr := csvh.CsvIn(r)
 
+	if e := skipHeader(cr); e != nil { return tsums, nil, h(e) }
+	rows := ro.Stage("CalcTSummaryVsBlood")
+	defer rows.Done()
+
 	for line, e := cr.Read(); e != io.EOF; line, e = cr.Read() {
 		if e != nil { return tsums, nil, h(e) }
 
-		if len(line) <= valcol { continue }
-		val, e := strconv.ParseFloat(line[valcol], 64)
-		if e != nil { continue }
+		val, ok, e := rows.CsvFloat(cr, line, valcol)
+		if e != nil { return tsums, nil, h(e) }
+		if !ok { continue }
+		rows.Keep()
 
 		for _, tsum := range tsums {
 			if len(line) <= tsum.Idx { continue }
@@ -147,25 +241,224 @@ type TTestSet struct {
 	Exp TTestItem
 }
 
-// Calculate the T value based on the means, SDs, and counts
+// The variants of t test
+const (
+	// Welch's test, which does not assume equal variances, with
+	// Welch–Satterthwaite degrees of freedom
+	TTestWelch = "welch"
+	// Student's test, which pools the variances of the two groups
+	TTestStudent = "student"
+	// The paired test of RunPairedTTest, on the differences between rows
+	// paired by key
+	TTestPaired = "paired"
+	// The one-sample test of RunOneSampleTTest, on the differences between
+	// values and their expectations
+	TTestOneSample = "one-sample"
+)
+
+// The alternatives to equal means, as the sign of mean1 - mean2
+const (
+	TwoSided = "two-sided"
+	Less = "less"
+	Greater = "greater"
+)
+
+// Which t test to run, against which alternative, and the confidence level
+// of the interval for the difference in means
+type TTestOptions struct {
+	Method string
+	Alternative string
+	Conf float64
+}
+
+// Welch's two-sided test with a 95% confidence interval
+func DefaultTTestOptions() TTestOptions {
+	return TTestOptions{TTestWelch, TwoSided, 0.95}
+}
+
+// TTestOptions with the defaults filled in for an empty method or
+// alternative, or a conf of 0, and checked
+func NewTTestOptions(method, alternative string, conf float64) (TTestOptions, error) {
+	o := DefaultTTestOptions()
+	if method != "" {
+		o.Method = method
+	}
+	if alternative != "" {
+		o.Alternative = alternative
+	}
+	if conf != 0 {
+		o.Conf = conf
+	}
+	if e := o.Validate(); e != nil { return o, e }
+	return o, nil
+}
+
+// Check that the options name a known method and alternative, and that
+// Conf is between 0 and 1
+func (o TTestOptions) Validate() error {
+	switch o.Method {
+	case TTestWelch, TTestStudent, TTestPaired, TTestOneSample:
+	default:
+		return fmt.Errorf("TTestOptions: method %q is not %v, %v, %v or %v", o.Method, TTestWelch, TTestStudent, TTestPaired, TTestOneSample)
+	}
+	switch o.Alternative {
+	case TwoSided, Less, Greater:
+	default:
+		return fmt.Errorf("TTestOptions: alternative %q is not %v, %v or %v", o.Alternative, TwoSided, Less, Greater)
+	}
+	if !(o.Conf > 0 && o.Conf < 1) {
+		return fmt.Errorf("TTestOptions: conf %v is not between 0 and 1", o.Conf)
+	}
+	return nil
+}
+
+// The outcome of a t test between two groups. Diff is Mean1 - Mean2, and
+// Low and High bound its confidence interval; one of them is infinite for a
+// one-sided test.
+type TTestResult struct {
+	Name1 string
+	Name2 string
+	Count1 float64
+	Count2 float64
+	Mean1 float64
+	Mean2 float64
+	Sd1 float64
+	Sd2 float64
+	T float64
+	Df float64
+	P float64
+	Diff float64
+	Low float64
+	High float64
+}
+
+// Calculate Welch's T value based on the means, SDs, and counts
 func TTestCore(mean1, mean2, sd1, sd2, count1, count2 float64) float64 {
-	num := mean1 - mean2
-	rt := ((sd1 * sd1) / count1) * ((sd2 * sd2) / count2)
-	den := math.Sqrt(rt)
-	return num / den
+	return (mean1 - mean2) / WelchSe(sd1, sd2, count1, count2)
+}
+
+// The standard error of the difference in means without assuming equal
+// variances
+func WelchSe(sd1, sd2, count1, count2 float64) float64 {
+	return math.Sqrt((sd1 * sd1) / count1 + (sd2 * sd2) / count2)
 }
 
-// The P value associated with the t and df
+// The Welch–Satterthwaite degrees of freedom
+func WelchDf(sd1, sd2, count1, count2 float64) float64 {
+	a := (sd1 * sd1) / count1
+	b := (sd2 * sd2) / count2
+	return (a + b) * (a + b) / (a * a / (count1 - 1) + b * b / (count2 - 1))
+}
+
+// The standard error of the difference in means using the pooled variance
+func PooledSe(sd1, sd2, count1, count2 float64) float64 {
+	pooled := ((count1 - 1) * sd1 * sd1 + (count2 - 1) * sd2 * sd2) / (count1 + count2 - 2)
+	return math.Sqrt(pooled * (1 / count1 + 1 / count2))
+}
+
+// The degrees of freedom of Student's test
+func StudentDf(count1, count2 float64) float64 {
+	return count1 + count2 - 2
+}
+
+// The two-sided P value associated with the t and df
 func TTestP(t float64, df float64) float64 {
+	return TTestPAlt(t, df, TwoSided)
+}
+
+// The P value associated with the t and df under an alternative
+func TTestPAlt(t, df float64, alternative string) float64 {
 	if BadDF(df) {
 		return math.NaN()
 	}
 	tdist := distuv.StudentsT{Mu: 0, Sigma: 1, Nu: df}
-	cdf := tdist.CDF(t)
-	if cdf > 0.5 {
-		return 2 * (1 - cdf)
+	switch alternative {
+	case Less:
+		return tdist.CDF(t)
+	case Greater:
+		return tdist.Survival(t)
+	}
+	return 2 * math.Min(tdist.CDF(t), tdist.Survival(t))
+}
+
+// The confidence interval for a difference with this standard error and df
+func TTestInterval(diff, se, df float64, opts TTestOptions) (low, high float64) {
+	if BadDF(df) {
+		return math.NaN(), math.NaN()
+	}
+	tdist := distuv.StudentsT{Mu: 0, Sigma: 1, Nu: df}
+	switch opts.Alternative {
+	case Less:
+		return math.Inf(-1), diff + tdist.Quantile(opts.Conf) * se
+	case Greater:
+		return diff - tdist.Quantile(opts.Conf) * se, math.Inf(1)
 	}
-	return 2 * cdf
+	q := tdist.Quantile(1 - (1 - opts.Conf) / 2)
+	return diff - q * se, diff + q * se
+}
+
+// Run a t test between two groups given their counts, means, and sample
+// standard deviations
+func CalcTTest(name1, name2 string, count1, count2, mean1, mean2, sd1, sd2 float64, opts TTestOptions) TTestResult {
+	r := TTestResult{
+		Name1: name1, Name2: name2,
+		Count1: count1, Count2: count2,
+		Mean1: mean1, Mean2: mean2,
+		Sd1: sd1, Sd2: sd2,
+		Diff: mean1 - mean2,
+	}
+
+	se := WelchSe(sd1, sd2, count1, count2)
+	r.Df = WelchDf(sd1, sd2, count1, count2)
+	if opts.Method == TTestStudent {
+		se = PooledSe(sd1, sd2, count1, count2)
+		r.Df = StudentDf(count1, count2)
+	}
+
+	r.T = r.Diff / se
+	r.P = TTestPAlt(r.T, r.Df, opts.Alternative)
+	r.Low, r.High = TTestInterval(r.Diff, se, r.Df, opts)
+	return r
+}
+
+// Write r as one line of ttest output
+func WriteTTestResult(w io.Writer, r TTestResult) error {
+	_, e := fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+		r.Name1, r.Name2,
+		r.Count1, r.Count2,
+		r.Mean1, r.Mean2,
+		r.Sd1, r.Sd2,
+		r.T, r.Df, r.P,
+		r.Diff, r.Low, r.High,
+	)
+	return e
+}
+
+func ParseTTestResult(line string) (TTestResult, error) {
+	r := TTestResult{}
+	_, e := fmt.Sscanf(line, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+		&r.Name1, &r.Name2,
+		&r.Count1, &r.Count2,
+		&r.Mean1, &r.Mean2,
+		&r.Sd1, &r.Sd2,
+		&r.T, &r.Df, &r.P,
+		&r.Diff, &r.Low, &r.High,
+	)
+	return r, e
+}
+
+func ReadTTestResults(r io.Reader) ([]TTestResult, error) {
+	h := handle("ReadTTestResults: %w")
+	results := []TTestResult{}
+	s := bufio.NewScanner(r)
+	s.Buffer([]byte{}, 1e12)
+
+	for s.Scan() {
+		result, e := ParseTTestResult(s.Text())
+		if e != nil { return nil, h(e) }
+		results = append(results, result)
+	}
+	return results, nil
 }
 
 // The summary associated with the T Test item specified here
@@ -176,41 +469,35 @@ func TsumsSet(tsums []*TSummary, item TTestItem) (int, string) {
 		}
 	}
 	panic(fmt.Errorf("TsumsSet: missing set %v", item))
-	return 0, ""
 }
 
-// The degrees of fredom for a T test between these summaries
-func TTestDf(ts1 *TSummary, id1 string, ts2 *TSummary, id2 string) float64 {
-	return ts1.Counts[id1] + ts2.Counts[id2] - 2
-}
-
-// Perform a T test contrasting the control and experimental sets
-func TTest(w io.Writer, tsums []*TSummary, testset TTestSet) error {
+// Calculate the T test contrasting the control and experimental sets
+func TTestSetResult(tsums []*TSummary, testset TTestSet, opts TTestOptions) TTestResult {
 	i1, name1 := TsumsSet(tsums, testset.Control)
 	i2, name2 := TsumsSet(tsums, testset.Exp)
 
-	mean1 := tsums[i1].Mean(name1)
-	mean2 := tsums[i2].Mean(name2)
-
-	sd1 := tsums[i1].Sd(name1)
-	sd2 := tsums[i2].Sd(name2)
-
-	count1 := tsums[i1].Counts[name1]
-	count2 := tsums[i2].Counts[name2]
-
-	df := TTestDf(tsums[i1], name1, tsums[i2], name2)
-
-	t := TTestCore(mean1, mean2, sd1, sd2, count1, count2)
-	p := TTestP(t, df)
-
-	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", name1, name2, count1, count2, mean1, mean2, sd1, sd2, t, df, df, p)
+	return CalcTTest(name1, name2,
+		tsums[i1].Counts[name1], tsums[i2].Counts[name2],
+		tsums[i1].Mean(name1), tsums[i2].Mean(name2),
+		tsums[i1].SampleSd(name1), tsums[i2].SampleSd(name2),
+		opts,
+	)
+}
 
-	return nil
+// Perform a T test contrasting the control and experimental sets
+func TTest(w io.Writer, tsums []*TSummary, testset TTestSet, opts TTestOptions) error {
+	return WriteTTestResult(w, TTestSetResult(tsums, testset, opts))
 }
 
-func TTests(w io.Writer, tsums []*TSummary, testsets []TTestSet) error {
+func TTests(w io.Writer, tsums []*TSummary, testsets []TTestSet, opts TTestOptions) error {
+	if e := opts.Validate(); e != nil {
+		return fmt.Errorf("TTests: %w", e)
+	}
+	if opts.Method == TTestPaired || opts.Method == TTestOneSample {
+		return fmt.Errorf("TTests: the %v test needs the rows of the table, not summaries", opts.Method)
+	}
 	for _, tset := range testsets {
-		e := TTest(w, tsums, tset)
+		e := TTest(w, tsums, tset, opts)
 		if e != nil {
 			return fmt.Errorf("TTests: %w", e)
 		}
@@ -218,19 +505,21 @@ func TTests(w io.Writer, tsums []*TSummary, testsets []TTestSet) error {
 	return nil
 }
 
-func RunTTest(rcm ReadCloserMaker, w io.Writer, valcolname string, idcolsnames []string, controlsetidx, testsetidx int) error {
+func RunTTest(rcm ReadCloserMaker, w io.Writer, valcolname string, idcolsnames []string, controlsetidx, testsetidx int, opts TTestOptions, ro *RowOptions) error {
 	h := handle("Run: %w")
 
+	if e := opts.Validate(); e != nil { return h(e) }
+
 	valcol, e := ValCol(rcm, valcolname)
 	if e != nil { return h(e) }
 
 	idcols, e := IdCols(rcm, idcolsnames)
 	if e != nil { return h(e) }
 
-	tsummaries, testsets, e := CalcTSummary(rcm, valcol, idcolsnames, idcols, controlsetidx, testsetidx)
+	tsummaries, testsets, e := CalcTSummary(rcm, valcol, idcolsnames, idcols, controlsetidx, testsetidx, ro)
 	if e != nil { return h(e) }
 
-	e = TTests(w, tsummaries, testsets)
+	e = TTests(w, tsummaries, testsets, opts)
 	if e != nil { return h(e) }
 
 	return nil
@@ -239,7 +528,7 @@ func RunTTest(rcm ReadCloserMaker, w io.Writer, valcolname string, idcolsnames [
 // Run a T test on all values. Bloodcolname is the name of the column that
 // differentiates control ("blood") samples from experimental samples.
 // Testcolname is the column that differentiates the chromosome or region of interest from all other (control) regions.
-func RunFullTTest(rcm ReadCloserMaker, w io.Writer, valcolname, bloodcolname, testcolname string) error {
+func RunFullTTest(rcm ReadCloserMaker, w io.Writer, valcolname, bloodcolname, testcolname string, opts TTestOptions, ro *RowOptions) error {
 	idcolsnames := []string{bloodcolname, testcolname}
-	return RunTTest(rcm, w, valcolname, idcolsnames, 0, 1)
+	return RunTTest(rcm, w, valcolname, idcolsnames, 0, 1, opts, ro)
 }
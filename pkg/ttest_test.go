@@ -0,0 +1,70 @@
+package spstat
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// The two groups of R's sleep data
+var sleep1 = []float64{0.7, -1.6, -0.2, -1.2, -0.1, 3.4, 3.7, 0.8, 0.0, 2.0}
+var sleep2 = []float64{1.9, 0.8, 1.1, 0.1, -0.1, 4.4, 5.5, 1.6, 4.6, 3.4}
+
+func sleepTTest(t *testing.T, method, alternative string) TTestResult {
+	var m1, m2 Moments
+	for i := range sleep1 {
+		m1.Add(sleep1[i])
+		m2.Add(sleep2[i])
+	}
+	opts, e := NewTTestOptions(method, alternative, 0)
+	if e != nil { t.Fatal(e) }
+	return CalcTTest("a", "b", m1.Count(), m2.Count(), m1.Mean(), m2.Mean(), math.Sqrt(m1.SampleVar()), math.Sqrt(m2.SampleVar()), opts)
+}
+
+func TestTTest(t *testing.T) {
+	// Expected values from R's t.test(extra ~ group, data = sleep)
+	type want struct {
+		method, alternative string
+		t, df, p, low, high float64
+	}
+	wants := []want{
+		{TTestWelch, TwoSided, -1.860813, 17.77647, 0.07939414, -3.3654832, 0.2054832},
+		{TTestStudent, TwoSided, -1.860813, 18, 0.07918671, -3.363874, 0.203874},
+		{TTestWelch, Less, -1.860813, 17.77647, 0.03969707, math.Inf(-1), -0.1066185},
+		{TTestWelch, Greater, -1.860813, 17.77647, 0.9603029, -3.0533815, math.Inf(1)},
+	}
+
+	for _, w := range wants {
+		r := sleepTTest(t, w.method, w.alternative)
+		if !closeTo(r.T, w.t, 1e-5) || !closeTo(r.Df, w.df, 1e-4) || !closeTo(r.P, w.p, 1e-6) {
+			t.Errorf("%v %v: t %v, df %v, p %v; want %v, %v, %v", w.method, w.alternative, r.T, r.Df, r.P, w.t, w.df, w.p)
+		}
+		if !closeTo(r.Low, w.low, 1e-5) && r.Low != w.low || !closeTo(r.High, w.high, 1e-5) && r.High != w.high {
+			t.Errorf("%v %v: interval %v, %v; want %v, %v", w.method, w.alternative, r.Low, r.High, w.low, w.high)
+		}
+	}
+}
+
+func TestTTestResultRoundTrip(t *testing.T) {
+	r := sleepTTest(t, TTestWelch, Less)
+	var buf bytes.Buffer
+	if e := WriteTTestResult(&buf, r); e != nil { t.Fatal(e) }
+
+	rs, e := ReadTTestResults(&buf)
+	if e != nil { t.Fatal(e) }
+	if len(rs) != 1 || rs[0] != r {
+		t.Errorf("read %v; want %v", rs, r)
+	}
+}
+
+func TestTTestOptions(t *testing.T) {
+	if _, e := NewTTestOptions("wilcoxon", "", 0); e == nil {
+		t.Errorf("accepted method wilcoxon")
+	}
+	if _, e := NewTTestOptions("", "both", 0); e == nil {
+		t.Errorf("accepted alternative both")
+	}
+	if _, e := NewTTestOptions("", "", 1.5); e == nil {
+		t.Errorf("accepted conf 1.5")
+	}
+}
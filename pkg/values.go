@@ -0,0 +1,156 @@
+package spstat
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// What to do with infinite values in a numeric column
+type InfPolicy int
+
+const (
+	// Leave the row out, like a missing value
+	InfSkip InfPolicy = iota
+	// Stop the run
+	InfError
+	// Use the value as is
+	InfKeep
+)
+
+var infPolicyNames = []string{"skip", "error", "keep"}
+
+func (p InfPolicy) String() string {
+	if p < 0 || int(p) >= len(infPolicyNames) {
+		return fmt.Sprintf("InfPolicy(%d)", int(p))
+	}
+	return infPolicyNames[p]
+}
+
+// Parse "skip", "error" or "keep"
+func ParseInfPolicy(s string) (InfPolicy, error) {
+	for i, name := range infPolicyNames {
+		if s == name {
+			return InfPolicy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("ParseInfPolicy: unknown policy %q", s)
+}
+
+// Returned for a field holding one of the missing value tokens
+var ErrMissingValue = errors.New("missing value")
+
+// Returned for a field that parses to NaN, but is not a missing value token
+var ErrNaN = errors.New("NaN value")
+
+// Returned for an infinite value, unless the InfPolicy is InfKeep
+var ErrInfinite = errors.New("infinite value")
+
+// An error that stops a run, rather than leaving out one row
+type fatalRowError struct {
+	error
+}
+
+func (e fatalRowError) Unwrap() error {
+	return e.error
+}
+
+// Check whether a row function's error should stop the run
+func isFatalRowError(e error) bool {
+	var f fatalRowError
+	return errors.As(e, &f)
+}
+
+// The tokens that mean a value is missing, unless set otherwise
+var DefaultMissingTokens = []string{"NA", ".", "", "NaN"}
+
+// The token written in place of missing output values, unless set otherwise
+const DefaultMissingOut = "NaN"
+
+// How numeric fields are read from tables, and how missing values are
+// written. Missing lists the tokens that mean a value is missing; Inf says
+// what to do with infinite values; MissingOut is written in place of any
+// output value that is missing, NaN, or infinite and not kept.
+type ValueParser struct {
+	Missing []string
+	Inf InfPolicy
+	MissingOut string
+}
+
+// A parser with the default missing tokens, that skips infinite values
+func DefaultValueParser() *ValueParser {
+	return &ValueParser{
+		Missing: DefaultMissingTokens,
+		Inf: InfSkip,
+		MissingOut: DefaultMissingOut,
+	}
+}
+
+func (p *ValueParser) isMissing(s string) bool {
+	for _, tok := range p.Missing {
+		if s == tok {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse one field. Missing tokens give ErrMissingValue, other NaNs give
+// ErrNaN, and infinite values are handled according to p.Inf.
+func (p *ValueParser) Parse(s string) (float64, error) {
+	if p.isMissing(s) {
+		return math.NaN(), ErrMissingValue
+	}
+	v, e := strconv.ParseFloat(s, 64)
+	if e != nil {
+		return 0, e
+	}
+	return p.Check(v)
+}
+
+// Apply p's rules for NaN and infinite values to a value that has already
+// been parsed
+func (p *ValueParser) Check(v float64) (float64, error) {
+	if math.IsNaN(v) {
+		return v, ErrNaN
+	}
+	if math.IsInf(v, 0) {
+		switch p.Inf {
+		case InfError:
+			return v, fatalRowError{fmt.Errorf("%w %v not allowed", ErrInfinite, v)}
+		case InfSkip:
+			return v, ErrInfinite
+		}
+	}
+	return v, nil
+}
+
+// Format an output value with format, or write p.MissingOut if the value is
+// NaN, or infinite and not kept.
+func (p *ValueParser) Format(v float64, format func(float64) string) string {
+	if math.IsNaN(v) || (math.IsInf(v, 0) && p.Inf != InfKeep) {
+		return p.MissingOut
+	}
+	return format(v)
+}
+
+// Check whether a row function's error means its output value is missing,
+// rather than that the row is unusable
+func isMissingValueError(e error) bool {
+	if isFatalRowError(e) {
+		return false
+	}
+	return errors.Is(e, ErrMissingValue) || errors.Is(e, ErrNaN) || errors.Is(e, ErrInfinite)
+}
+
+// Format like "%f"
+func formatFixed(v float64) string {
+	return strconv.FormatFloat(v, 'f', 6, 64)
+}
+
+// Set p.Missing from a comma-separated list of tokens
+func (p *ValueParser) SetMissing(list string) {
+	p.Missing = strings.Split(list, ",")
+}
@@ -0,0 +1,183 @@
+package spstat
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValueParser(t *testing.T) {
+	inf := math.Inf(1)
+	unparseable := errors.New("unparseable")
+	cases := []struct {
+		in string
+		missing string
+		policy InfPolicy
+		want float64
+		err error
+		fatal bool
+	}{
+		{"1.5", "NA", InfSkip, 1.5, nil, false},
+		{"-2e3", "NA", InfSkip, -2000, nil, false},
+		{"NA", "NA,.", InfSkip, 0, ErrMissingValue, false},
+		{".", "NA,.", InfSkip, 0, ErrMissingValue, false},
+		{"", "NA,,.", InfSkip, 0, ErrMissingValue, false},
+		{"-", "-", InfSkip, 0, ErrMissingValue, false},
+		{"NaN", "NA", InfSkip, 0, ErrNaN, false},
+		{"nan", "NA,NaN", InfSkip, 0, ErrNaN, false},
+		{"NaN", "NA,NaN", InfSkip, 0, ErrMissingValue, false},
+		{"inf", "NA", InfSkip, inf, ErrInfinite, false},
+		{"-Inf", "NA", InfSkip, -inf, ErrInfinite, false},
+		{"inf", "NA", InfError, inf, ErrInfinite, true},
+		{"+Inf", "NA", InfKeep, inf, nil, false},
+		{"oops", "NA", InfSkip, 0, unparseable, false},
+		{"", "NA", InfSkip, 0, unparseable, false},
+	}
+
+	for _, c := range cases {
+		p := &ValueParser{Inf: c.policy}
+		p.SetMissing(c.missing)
+		got, e := p.Parse(c.in)
+
+		switch {
+		case c.err == unparseable:
+			if e == nil || ReasonFor(e) != Unparseable {
+				t.Errorf("%q: %v, %v, want a parse error", c.in, got, e)
+			}
+		case c.err == nil:
+			if e != nil || got != c.want {
+				t.Errorf("%q under %v: %v, %v, want %v", c.in, c.policy, got, e, c.want)
+			}
+		default:
+			if !errors.Is(e, c.err) || isFatalRowError(e) != c.fatal || isMissingValueError(e) == c.fatal {
+				t.Errorf("%q with missing %q under %v: error %v, want %v, fatal %v", c.in, c.missing, c.policy, e, c.err, c.fatal)
+			}
+			if c.err == ErrInfinite && got != c.want {
+				t.Errorf("%q: %v, want %v", c.in, got, c.want)
+			}
+		}
+	}
+
+	if p, e := ParseInfPolicy("keep"); e != nil || p != InfKeep || p.String() != "keep" {
+		t.Errorf("ParseInfPolicy(keep): %v, %v", p, e)
+	}
+	if _, e := ParseInfPolicy("drop"); e == nil {
+		t.Errorf("ParseInfPolicy accepted drop")
+	}
+}
+
+func TestValueFormat(t *testing.T) {
+	cases := []struct {
+		v float64
+		policy InfPolicy
+		want string
+	}{
+		{1.5, InfSkip, "1.500000"},
+		{math.NaN(), InfSkip, "."},
+		{math.NaN(), InfKeep, "."},
+		{math.Inf(1), InfSkip, "."},
+		{math.Inf(-1), InfError, "."},
+		{math.Inf(1), InfKeep, "+Inf"},
+		{math.Inf(-1), InfKeep, "-Inf"},
+	}
+	for _, c := range cases {
+		p := &ValueParser{Inf: c.policy, MissingOut: "."}
+		if got := p.Format(c.v, formatFixed); got != c.want {
+			t.Errorf("%v under %v: %q, want %q", c.v, c.policy, got, c.want)
+		}
+	}
+}
+
+// The last column of each row after the header of a table
+func lastColumn(table string) []string {
+	var out []string
+	for _, line := range strings.Split(strings.TrimSuffix(table, "\n"), "\n")[1:] {
+		fields := strings.Split(line, "\t")
+		out = append(out, fields[len(fields) - 1])
+	}
+	return out
+}
+
+func TestMissingOutput(t *testing.T) {
+	rcm := stringTable("id\tvalue\tb\n" +
+		"x\t1\t0\n" +
+		"x\t3\t1\n" +
+		"x\tNA\t1\n" +
+		"x\t-\t1\n" +
+		"x\tinf\t1\n" +
+		"x\toops\t1\n" +
+		"y\t5\t-\n" +
+		"y\t7\t2\n")
+
+	cases := []struct {
+		name string
+		policy InfPolicy
+		run func(*bytes.Buffer, *RowOptions) error
+		want string
+	}{
+		{"Norm", InfSkip, func(w *bytes.Buffer, ro *RowOptions) error { return Run(rcm, w, "value", []string{"id"}, ro) },
+			"-1.000000 1.000000 NA NA NA -1.000000 1.000000"},
+		{"NormVar", InfSkip, func(w *bytes.Buffer, ro *RowOptions) error { return RunNormVar(rcm, w, "value", "id", ro) },
+			"-1.000000 1.000000 NA NA NA -1.000000 1.000000"},
+		{"ColSub", InfSkip, func(w *bytes.Buffer, ro *RowOptions) error { return RunColSub(rcm, w, "value", "b", ro) },
+			"1.000000 2.000000 NA NA NA NA 5.000000"},
+		{"ColSub keeping Inf", InfKeep, func(w *bytes.Buffer, ro *RowOptions) error { return RunColSub(rcm, w, "value", "b", ro) },
+			"1.000000 2.000000 NA NA +Inf NA 5.000000"},
+	}
+	for _, c := range cases {
+		ro := DefaultRowOptions()
+		ro.Values.SetMissing("NA,-")
+		ro.Values.Inf = c.policy
+		ro.Values.MissingOut = "NA"
+		var out bytes.Buffer
+		if e := c.run(&out, ro); e != nil { t.Fatalf("%v: %v", c.name, e) }
+		if got := strings.Join(lastColumn(out.String()), " "); got != c.want {
+			t.Errorf("%v: %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	ro := DefaultRowOptions()
+	ro.Values.SetMissing("NA,-")
+	ro.Values.Inf = InfError
+	if e := RunColSub(rcm, &bytes.Buffer{}, "value", "b", ro); e == nil || !errors.Is(e, ErrInfinite) {
+		t.Errorf("ColSub under InfError: %v", e)
+	}
+}
+
+// The normalizer_var command, with the token for missing output values and
+// the missing value tokens from the command line
+func TestNormVarMissingOut(t *testing.T) {
+	if args := os.Getenv("SPSTAT_NORMVAR_ARGS"); args != "" {
+		os.Args = append([]string{"normalizer_var"}, strings.Split(args, " ")...)
+		RunFullNormVar()
+		return
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "table.tsv")
+	outpath := filepath.Join(dir, "out.tsv")
+	table := "id\tvalue\nx\t1\nx\t.\nx\t3\nx\tinf\ny\t2\ny\t-\ny\t4\n"
+	if e := os.WriteFile(path, []byte(table), 0644); e != nil { t.Fatal(e) }
+
+	args := []string{"-i", path, "-o", outpath, "-v", "value", "-id", "id", "-na", ".,-", "-na-out", "missing"}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestNormVarMissingOut$")
+	cmd.Env = append(os.Environ(), "SPSTAT_NORMVAR_ARGS=" + strings.Join(args, " "))
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if e := cmd.Run(); e != nil { t.Fatalf("%v: %v", e, stderr.String()) }
+	out, e := os.ReadFile(outpath)
+	if e != nil { t.Fatal(e) }
+
+	want := "-1.000000 missing 1.000000 missing -1.000000 missing 1.000000"
+	if got := strings.Join(lastColumn(string(out)), " "); got != want {
+		t.Errorf("normalizer_var wrote %v, want %v", got, want)
+	}
+	if !strings.Contains(stderr.String(), "NormVar: 7 rows, 7 kept") {
+		t.Errorf("summary:\n%v", stderr.String())
+	}
+}
@@ -0,0 +1,310 @@
+package spstat
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// A reusable object that opens an io.WriteCloser; the output counterpart of
+// ReadCloserMaker. Closing the writer flushes everything written to it.
+type WriteCloserMaker interface {
+	NewWriteCloser() (io.WriteCloser, error)
+}
+
+// Wrapper that makes Close() flush a bufio.Writer, then close the
+// underlying Closer if there is one
+type bufWriteCloser struct {
+	*bufio.Writer
+	c io.Closer
+}
+
+func (b bufWriteCloser) Close() error {
+	err := b.Writer.Flush()
+	if b.c != nil {
+		if e := b.c.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Standard output. Closing the writer flushes it, but leaves os.Stdout open.
+type Stdout struct{}
+
+func (Stdout) NewWriteCloser() (io.WriteCloser, error) {
+	return bufWriteCloser{bufio.NewWriter(os.Stdout), nil}, nil
+}
+
+// A path to create uncompressed.
+type OutPath string
+
+func (p OutPath) NewWriteCloser() (io.WriteCloser, error) {
+	f, e := os.Create(string(p))
+	if e != nil { return nil, fmt.Errorf("OutPath.NewWriteCloser: %w", e) }
+	return bufWriteCloser{bufio.NewWriter(f), f}, nil
+}
+
+// A path to create with gzip compression. The output is a series of gzip
+// members compressed in parallel on Threads goroutines (0 means
+// runtime.GOMAXPROCS), which any gzip reader decompresses as one stream.
+type GzOutPath struct {
+	Path string
+	Threads int
+}
+
+func (p GzOutPath) NewWriteCloser() (io.WriteCloser, error) {
+	f, e := os.Create(p.Path)
+	if e != nil { return nil, fmt.Errorf("GzOutPath.NewWriteCloser: %w", e) }
+	return &fileCloser{NewParallelGzWriter(f, p.Threads), f}, nil
+}
+
+// A path to create with BGZF compression, compressing blocks in parallel on
+// Threads goroutines (0 means runtime.GOMAXPROCS).
+type BgzfOutPath struct {
+	Path string
+	Threads int
+}
+
+func (p BgzfOutPath) NewWriteCloser() (io.WriteCloser, error) {
+	f, e := os.Create(p.Path)
+	if e != nil { return nil, fmt.Errorf("BgzfOutPath.NewWriteCloser: %w", e) }
+	return &fileCloser{NewBgzfWriter(f, p.Threads), f}, nil
+}
+
+// Closes a compressing writer, then the file under it
+type fileCloser struct {
+	io.WriteCloser
+	f *os.File
+}
+
+func (c *fileCloser) Close() error {
+	err := c.WriteCloser.Close()
+	if e := c.f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// Interpret an output path from the command line. "" and "-" mean stdout.
+// Paths ending in ".bgz" or ".bgzf" are BGZF compressed, paths ending in
+// ".gz" are gzip compressed, and anything else is uncompressed.
+func OutputWriteCloserMaker(path string) WriteCloserMaker {
+	switch {
+	case path == "" || path == "-":
+		return Stdout{}
+	case strings.HasSuffix(path, ".bgz") || strings.HasSuffix(path, ".bgzf"):
+		return BgzfOutPath{Path: path}
+	case strings.HasSuffix(path, ".gz"):
+		return GzOutPath{Path: path}
+	}
+	return OutPath(path)
+}
+
+// The most uncompressed data that goes in one BGZF block, chosen so that even
+// incompressible data fits in the 64 KiB block limit
+const bgzfBlockDataLen = 0xff00
+
+// Uncompressed size of each member written by a ParallelGzWriter
+const gzMemberLen = 1 << 20
+
+// The standard empty block that marks the end of a BGZF file
+var bgzfEOF = []byte{
+	0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x06, 0x00, 0x42, 0x43,
+	0x02, 0x00, 0x1b, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+// One block of input, from buffering through compression
+type deflateJob struct {
+	in []byte
+	out bytes.Buffer
+	err error
+	done chan struct{}
+}
+
+// An io.WriteCloser that cuts its input into blocks, compresses each block
+// into its own gzip member on a pool of goroutines, and writes the members
+// in order. Used by both ParallelGzWriter and BgzfWriter.
+type blockWriter struct {
+	w io.Writer
+	blockLen int
+	bgzf bool
+
+	buf []byte
+	order chan *deflateJob
+	work chan *deflateJob
+	jobs sync.Pool
+	written chan struct{}
+	closeOnce sync.Once
+	closeErr error
+
+	mu sync.Mutex
+	err error
+}
+
+func newBlockWriter(w io.Writer, threads, blockLen int, bgzf bool) *blockWriter {
+	if threads < 1 {
+		threads = runtime.GOMAXPROCS(0)
+	}
+
+	b := &blockWriter{
+		w: w,
+		blockLen: blockLen,
+		bgzf: bgzf,
+		buf: make([]byte, 0, blockLen),
+		order: make(chan *deflateJob, threads * 4),
+		work: make(chan *deflateJob, threads * 4),
+		written: make(chan struct{}),
+	}
+	b.jobs.New = func() any { return new(deflateJob) }
+
+	for i := 0; i < threads; i++ {
+		go b.compressBlocks()
+	}
+	go b.writeBlocks()
+
+	return b
+}
+
+// Create a gzip writer that compresses on threads goroutines; 0 means
+// runtime.GOMAXPROCS. Closing it does not close w.
+func NewParallelGzWriter(w io.Writer, threads int) io.WriteCloser {
+	return newBlockWriter(w, threads, gzMemberLen, false)
+}
+
+// Create a BGZF writer that compresses on threads goroutines; 0 means
+// runtime.GOMAXPROCS. Closing it writes the BGZF end-of-file block, but does
+// not close w.
+func NewBgzfWriter(w io.Writer, threads int) io.WriteCloser {
+	return newBlockWriter(w, threads, bgzfBlockDataLen, true)
+}
+
+func (b *blockWriter) setErr(e error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil {
+		b.err = e
+	}
+}
+
+func (b *blockWriter) getErr() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+func (b *blockWriter) compressBlocks() {
+	gw, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+	for j := range b.work {
+		j.err = deflateMember(gw, &j.out, j.in, b.bgzf)
+		close(j.done)
+	}
+}
+
+// Compress in as one gzip member. BGZF members carry a "BC" extra subfield
+// holding the total member size minus one.
+func deflateMember(gw *gzip.Writer, out *bytes.Buffer, in []byte, bgzf bool) error {
+	out.Reset()
+	gw.Reset(out)
+	if bgzf {
+		gw.Header.Extra = []byte{'B', 'C', 2, 0, 0, 0}
+	}
+	if _, e := gw.Write(in); e != nil {
+		return e
+	}
+	if e := gw.Close(); e != nil {
+		return e
+	}
+
+	if bgzf {
+		member := out.Bytes()
+		if len(member) > 1 << 16 {
+			return fmt.Errorf("deflateMember: BGZF block of %v bytes too large", len(member))
+		}
+		binary.LittleEndian.PutUint16(member[16:18], uint16(len(member) - 1))
+	}
+	return nil
+}
+
+func (b *blockWriter) writeBlocks() {
+	defer close(b.written)
+	for j := range b.order {
+		<-j.done
+		if b.getErr() == nil {
+			if j.err != nil {
+				b.setErr(j.err)
+			} else if _, e := b.w.Write(j.out.Bytes()); e != nil {
+				b.setErr(e)
+			}
+		}
+		b.jobs.Put(j)
+	}
+}
+
+// Hand the buffered data to the compressors.
+func (b *blockWriter) dispatch() {
+	j := b.jobs.Get().(*deflateJob)
+	j.in = append(j.in[:0], b.buf...)
+	j.err = nil
+	j.done = make(chan struct{})
+	b.buf = b.buf[:0]
+
+	b.order <- j
+	b.work <- j
+}
+
+func (b *blockWriter) Write(p []byte) (int, error) {
+	if e := b.getErr(); e != nil {
+		return 0, e
+	}
+
+	n := len(p)
+	for len(p) > 0 {
+		space := b.blockLen - len(b.buf)
+		if space > len(p) {
+			space = len(p)
+		}
+		b.buf = append(b.buf, p[:space]...)
+		p = p[space:]
+		if len(b.buf) == b.blockLen {
+			b.dispatch()
+		}
+	}
+	return n, nil
+}
+
+// Compress and write any buffered data, wait for all blocks to be written,
+// and write the BGZF end-of-file block if needed. Later calls return the
+// result of the first, and later writes fail.
+func (b *blockWriter) Close() error {
+	b.closeOnce.Do(func() { b.closeErr = b.close() })
+	return b.closeErr
+}
+
+func (b *blockWriter) close() error {
+	if len(b.buf) > 0 {
+		b.dispatch()
+	}
+	close(b.order)
+	close(b.work)
+	<-b.written
+
+	e := b.getErr()
+	b.setErr(os.ErrClosed)
+	if e != nil {
+		return fmt.Errorf("blockWriter.Close: %w", e)
+	}
+	if b.bgzf {
+		if _, e := b.w.Write(bgzfEOF); e != nil {
+			return fmt.Errorf("blockWriter.Close: %w", e)
+		}
+	}
+	return nil
+}